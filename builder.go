@@ -0,0 +1,224 @@
+package datok
+
+import (
+	"log"
+	"strconv"
+)
+
+// Builder creates an Automaton from Go code,
+// e.g. for small special-purpose tokenizers.
+// The start state is 1, further states are
+// created using AddState.
+//
+// Characters on arcs are consumed and are either part
+// of the token or, in case of nontoken arcs, dropped.
+// Token bounds end the current token without consuming
+// a character. Identity and unknown arcs match all
+// characters, that are not used on any arc of the
+// automaton.
+type Builder struct {
+	stateCount int
+	sigma      map[rune]int
+	sigmaRev   map[int]rune
+	arcs       []map[int]*edge
+	final      []bool
+	errors     []string
+}
+
+// Symbols in the sigma of built automata,
+// following the numbering of foma files
+const (
+	bEpsilon = iota + 1
+	bUnknown
+	bIdentity
+	bTokenEnd
+	bFirstChar
+)
+
+// NewBuilder creates a new builder with a start state.
+func NewBuilder() *Builder {
+	b := &Builder{
+		sigma:    make(map[rune]int),
+		sigmaRev: make(map[int]rune),
+		arcs:     []map[int]*edge{nil},
+		final:    []bool{false},
+	}
+	b.AddState()
+	return b
+}
+
+// AddState adds a new state and returns its number.
+func (b *Builder) AddState() int {
+	b.stateCount++
+	b.arcs = append(b.arcs, make(map[int]*edge))
+	b.final = append(b.final, false)
+	return b.stateCount
+}
+
+// SetFinal marks a state as final.
+func (b *Builder) SetFinal(s int) {
+	if b.check(s, s) {
+		b.final[s] = true
+	}
+}
+
+// AddArc adds an arc consuming a character,
+// that is part of the token.
+func (b *Builder) AddArc(from, to int, char rune) {
+	b.addArc(from, to, b.symbol(char), false, false)
+}
+
+// AddNonTokenArc adds an arc consuming a character,
+// that is not part of any token (e.g. whitespace).
+func (b *Builder) AddNonTokenArc(from, to int, char rune) {
+	b.addArc(from, to, b.symbol(char), true, false)
+}
+
+// AddIdentityArc adds an arc consuming all characters
+// not used on any arc, that are part of the token.
+func (b *Builder) AddIdentityArc(from, to int) {
+	b.addArc(from, to, bIdentity, false, false)
+}
+
+// AddUnknownArc adds an arc consuming all characters
+// not used on any arc, that are not part of any token.
+func (b *Builder) AddUnknownArc(from, to int) {
+	b.addArc(from, to, bUnknown, true, false)
+}
+
+// AddTokenBound adds an arc marking the end of a token
+// without consuming a character. Two subsequent token
+// bounds mark the end of a sentence.
+func (b *Builder) AddTokenBound(from, to int) {
+	b.addArc(from, to, bEpsilon, false, true)
+}
+
+// Get the sigma id of a character
+func (b *Builder) symbol(char rune) int {
+	sym, ok := b.sigma[char]
+	if !ok {
+		sym = bFirstChar + len(b.sigma)
+		b.sigma[char] = sym
+		b.sigmaRev[sym] = char
+	}
+	return sym
+}
+
+// Check the existence of states
+func (b *Builder) check(from, to int) bool {
+	for _, s := range []int{from, to} {
+		if s < 1 || s > b.stateCount {
+			b.fail("Unknown state", s)
+			return false
+		}
+	}
+	return true
+}
+
+// Remember a violation of the tokenizer's conventions
+func (b *Builder) fail(msg string, s int) {
+	b.errors = append(b.errors, msg+" "+strconv.Itoa(s))
+}
+
+// Add an arc to the automaton, in case it doesn't conflict
+// with an existing arc for the same symbol
+func (b *Builder) addArc(from, to, sym int, nontoken, tokenend bool) {
+	if !b.check(from, to) {
+		return
+	}
+	e := &edge{
+		inSym:    sym,
+		outSym:   sym,
+		end:      to,
+		nontoken: nontoken,
+		tokenend: tokenend,
+	}
+	if nontoken {
+		e.outSym = bEpsilon
+	} else if tokenend {
+		e.outSym = bTokenEnd
+	}
+	if old, ok := b.arcs[from][sym]; ok && *old != *e {
+		b.fail("Ambiguous transitions in state", from)
+		return
+	}
+	b.arcs[from][sym] = e
+}
+
+// Automaton returns the built automaton, that can be
+// converted to a tokenizer. In case the arcs violate
+// the tokenizer's conventions, the violations are
+// logged and nil is returned.
+func (b *Builder) Automaton() *Automaton {
+	b.checkLoops()
+	if len(b.sigma) == 0 {
+		b.errors = append(b.errors, "No characters defined")
+	}
+	if len(b.errors) > 0 {
+		for _, msg := range b.errors {
+			log.Println(msg)
+		}
+		b.errors = nil
+		return nil
+	}
+
+	auto := &Automaton{
+		sigmaRev:    make(map[int]rune, len(b.sigmaRev)),
+		stateCount:  b.stateCount,
+		transitions: make([]map[int]*edge, b.stateCount+1),
+		epsilon:     bEpsilon,
+		unknown:     bUnknown,
+		identity:    bIdentity,
+		tokenend:    bTokenEnd,
+	}
+	for sym, char := range b.sigmaRev {
+		auto.sigmaRev[sym] = char
+	}
+	auto.sigmaCount = bFirstChar + len(b.sigma)
+	auto.final = auto.sigmaCount
+
+	for s := 1; s <= b.stateCount; s++ {
+		trans := make(map[int]*edge, len(b.arcs[s])+1)
+		for sym, e := range b.arcs[s] {
+			c := *e
+			trans[sym] = &c
+		}
+		auto.arcCount += len(trans)
+
+		if b.final[s] {
+			trans[auto.final] = &edge{}
+		}
+		auto.transitions[s] = trans
+	}
+	return auto
+}
+
+// Check for cycles of token bounds,
+// that would never consume any input
+func (b *Builder) checkLoops() {
+	const (
+		unvisited = iota
+		active
+		done
+	)
+	mark := make([]int, b.stateCount+1)
+	var visit func(s int) bool
+	visit = func(s int) bool {
+		mark[s] = active
+		if e, ok := b.arcs[s][bEpsilon]; ok {
+			if mark[e.end] == active {
+				return false
+			}
+			if mark[e.end] == unvisited && !visit(e.end) {
+				return false
+			}
+		}
+		mark[s] = done
+		return true
+	}
+	for s := 1; s <= b.stateCount; s++ {
+		if mark[s] == unvisited && !visit(s) {
+			b.fail("Endless token bound loop in state", s)
+		}
+	}
+}
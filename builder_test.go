@@ -0,0 +1,80 @@
+package datok
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Build the equivalent of simpletok.fst
+func tbuildSimpleTokenizer(punctuation string) *Builder {
+	b := NewBuilder()
+	token := b.AddState()
+	bound := b.AddState()
+	punct := b.AddState()
+
+	b.AddIdentityArc(1, token)
+	b.AddIdentityArc(token, token)
+	b.AddTokenBound(token, bound)
+	b.AddTokenBound(punct, 1)
+	for _, s := range []int{1, bound} {
+		for _, c := range punctuation {
+			b.AddArc(s, punct, c)
+		}
+		for _, c := range " \t\n" {
+			b.AddNonTokenArc(s, 1, c)
+		}
+	}
+	b.SetFinal(bound)
+	return b
+}
+
+func TestBuilderSimpleTokenizer(t *testing.T) {
+	assert := assert.New(t)
+
+	auto := tbuildSimpleTokenizer(".?!").Automaton()
+	assert.NotNil(auto)
+	ref := LoadFomaFile("testdata/simpletok.fst").ToMatrix()
+
+	for _, tok := range []Tokenizer{auto.ToMatrix(), auto.ToDoubleArray()} {
+		for _, str := range []string{
+			"bau",
+			"wald gehen",
+			"  wald   gehen Da kann\t man was \"erleben\"!",
+			" In den Wald gehen? -- Da kann\t man was \"erleben\"!",
+		} {
+			assert.Equal(ttokenizeStr(ref, str), ttokenizeStr(tok, str))
+		}
+	}
+	assert.Equal("wald\ngehen", ttokenizeStr(auto.ToMatrix(), "wald gehen"))
+}
+
+func TestBuilderConventions(t *testing.T) {
+	assert := assert.New(t)
+
+	b := NewBuilder()
+	s := b.AddState()
+	b.AddArc(1, s, 'a')
+	b.AddNonTokenArc(1, s, 'a')
+	assert.Nil(b.Automaton())
+
+	b = NewBuilder()
+	b.AddArc(1, 3, 'a')
+	assert.Nil(b.Automaton())
+
+	b = NewBuilder()
+	s = b.AddState()
+	b.AddArc(1, s, 'a')
+	b.AddTokenBound(s, 1)
+	b.AddTokenBound(1, s)
+	assert.Nil(b.Automaton())
+
+	assert.Nil(NewBuilder().Automaton())
+
+	// Identical arcs are no conflict
+	b = NewBuilder()
+	b.AddArc(1, 1, 'a')
+	b.AddArc(1, 1, 'a')
+	b.SetFinal(1)
+	assert.NotNil(b.Automaton())
+}
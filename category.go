@@ -0,0 +1,115 @@
+package datok
+
+import (
+	"io"
+	"unicode"
+)
+
+// Unicode categories of characters not in sigma
+const (
+	catNone = iota
+	catLetter
+	catDigit
+	catPunct
+	catSpace
+	catSymbol
+	catCount
+)
+
+// Reserved multi-character symbols for the categories
+var categoryNames = [catCount]string{
+	"",
+	"@_LETTER_@",
+	"@_DIGIT_@",
+	"@_PUNCT_@",
+	"@_SPACE_@",
+	"@_SYMBOL_@",
+}
+
+// Symbols used as a fallback for characters not in sigma
+// per category, before the identity symbol is used.
+// Categories without a symbol are 0.
+type categories [catCount]int
+
+// Get the category of a reserved multi-character symbol
+func categoryOf(name string) int {
+	for cat := catLetter; cat < catCount; cat++ {
+		if categoryNames[cat] == name {
+			return cat
+		}
+	}
+	return catNone
+}
+
+// Get the Unicode category of a character.
+// Marks are treated as letters, as they modify letters.
+func category(char rune) int {
+	switch {
+	case unicode.IsLetter(char) || unicode.IsMark(char):
+		return catLetter
+	case unicode.IsNumber(char):
+		return catDigit
+	case unicode.IsSpace(char):
+		return catSpace
+	case unicode.IsPunct(char):
+		return catPunct
+	case unicode.IsSymbol(char):
+		return catSymbol
+	}
+	return catNone
+}
+
+// Check if any category has a symbol
+func (cats *categories) defined() bool {
+	return *cats != categories{}
+}
+
+// Get the category symbol for a character not in sigma,
+// or the identity symbol, if the category has no symbol
+func (cats *categories) fallback(char rune, identity int) int {
+	if !cats.defined() {
+		return identity
+	}
+	if a := cats[category(char)]; a != 0 {
+		return a
+	}
+	return identity
+}
+
+// Check if a symbol is a category symbol
+func (cats *categories) has(a int) bool {
+	return cats.of(a) != catNone
+}
+
+// Get the category of a category symbol
+func (cats *categories) of(a int) int {
+	for cat := catLetter; cat < catCount; cat++ {
+		if cats[cat] == a && a != 0 {
+			return cat
+		}
+	}
+	return catNone
+}
+
+// Write the category symbols as part of the header
+// of a tokenizer file
+func (cats *categories) writeTo(w io.Writer) (int, error) {
+	buf := make([]byte, (catCount-1)*2)
+	for cat := catLetter; cat < catCount; cat++ {
+		bo.PutUint16(buf[(cat-1)*2:cat*2], uint16(cats[cat]))
+	}
+	return w.Write(buf)
+}
+
+// Read the category symbols from the header
+// of a tokenizer file
+func (cats *categories) readFrom(r io.Reader) error {
+	buf := make([]byte, (catCount-1)*2)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return err
+	}
+	for cat := catLetter; cat < catCount; cat++ {
+		cats[cat] = int(bo.Uint16(buf[(cat-1)*2 : cat*2]))
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package datok
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCategory(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(catLetter, category('a'))
+	assert.Equal(catLetter, category('Ж'))
+	assert.Equal(catLetter, category('語'))
+	assert.Equal(catLetter, category('́'))
+	assert.Equal(catDigit, category('7'))
+	assert.Equal(catDigit, category('٣'))
+	assert.Equal(catDigit, category('²'))
+	assert.Equal(catPunct, category('¿'))
+	assert.Equal(catPunct, category('«'))
+	assert.Equal(catSpace, category(' '))
+	assert.Equal(catSpace, category('\n'))
+	assert.Equal(catSymbol, category('€'))
+	assert.Equal(catSymbol, category('+'))
+	assert.Equal(catNone, category(EOT))
+	assert.Equal(catNone, category(''))
+
+	assert.Equal(catPunct, categoryOf("@_PUNCT_@"))
+	assert.Equal(catNone, categoryOf("@_TOKEN_BOUND_@"))
+}
+
+func TestCategoryFallback(t *testing.T) {
+	assert := assert.New(t)
+
+	auto := LoadFomaFile("testdata/categories.fst")
+	assert.NotNil(auto)
+	assert.True(auto.categories.defined())
+	assert.NotZero(auto.categories[catLetter])
+	assert.Zero(auto.categories[catSymbol])
+
+	for _, tok := range []Tokenizer{
+		auto.ToMatrix(),
+		auto.ToDoubleArray(),
+		auto.toDoubleArray(nil, true),
+	} {
+		// Unknown letters and digits are part of words
+		assert.Equal("abc\nПривет\n123\n٣٤", ttokenizeStr(tok, "abc Привет 123 ٣٤"))
+
+		// Unknown punctuation is split
+		assert.Equal("ab\n-\nc\n¿\nx\n?", ttokenizeStr(tok, "ab-c ¿x?"))
+
+		// Symbols without category symbol use identity
+		assert.Equal("€\n5", ttokenizeStr(tok, "€5"))
+
+		// Failing categories fall back to identity
+		assert.Equal("abЖ\n٣", ttokenizeStr(tok, "abЖ٣"))
+	}
+
+	// Without category symbols, identity is used
+	mat := LoadMatrixFile("testdata/simpletok.matok")
+	assert.False(mat.categories.defined())
+	assert.Equal("Привет", ttokenizeStr(mat, "Привет"))
+}
+
+func TestCategoryReadWrite(t *testing.T) {
+	assert := assert.New(t)
+
+	tokenize := func(tok Tokenizer) string {
+		return ttokenizeStr(tok, "abc Привет €5")
+	}
+
+	auto, mat, rep := treadWrite(t, "testdata/categories.fst", tokenize, "abc\nПривет\n€\n5")
+	assert.True(mat.categories.defined())
+
+	// Categories are compared
+	ok, ex := Equivalent(auto, LoadMatrixFile("testdata/simpletok.matok"))
+	assert.False(ok)
+	assert.NotEqual("", ex)
+
+	// Category symbols are no unknown multi-character symbols
+	assert.Equal(0, len(rep.Messages))
+}
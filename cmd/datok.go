@@ -4,10 +4,13 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strconv"
+	"strings"
 
 	"log"
 
 	datok "github.com/KorAP/datok"
+	"github.com/KorAP/datok/xfst"
 	"github.com/alecthomas/kong"
 )
 
@@ -17,21 +20,117 @@ import (
 
 var cli struct {
 	Convert struct {
-		Foma        string `kong:"required,short='i',help='The Foma FST file'"`
+		Foma        string `kong:"optional,short='i',xor='input',help='The Foma FST file'"`
+		From        string `kong:"optional,type='existingfile',xor='input',help='A Matrix or Double Array Tokenizer file to convert instead of a foma file'"`
 		Tokenizer   string `kong:"required,short='o',help='The Tokenizer file'"`
 		DoubleArray bool   `kong:"optional,short='d',help='Convert to Double Array instead of Matrix representation'"`
-	} `kong:"cmd, help='Convert a compiled foma FST file to a Matrix or Double Array tokenizer'"`
+		Minimize    bool   `kong:"optional,help='Minimize the automaton before conversion'"`
+		Layout      string `kong:"optional,enum='symbol,state',default='symbol',help='Layout of the Matrix representation (symbol or state major, defaults to ${default})'"`
+		Profile     string `kong:"optional,type='existingfile',help='Renumber Matrix states by visit frequency based on a sample corpus'"`
+		Verify      bool   `kong:"optional,help='Reload and verify the converted file'"`
+		Corpus      string `kong:"optional,type='existingfile',help='Sample corpus for verification'"`
+	} `kong:"cmd, help='Convert a compiled foma FST file or a tokenizer to a Matrix or Double Array tokenizer'"`
+	Build struct {
+		Xfst        string `kong:"required,short='i',help='The xfst script to compile (relative to the base directory)'"`
+		Tokenizer   string `kong:"required,short='o',help='The Tokenizer file'"`
+		DoubleArray bool   `kong:"optional,short='d',help='Build a Double Array instead of Matrix representation'"`
+		Dir         string `kong:"optional,type='existingdir',help='Base directory for sourced scripts and word lists (defaults to the working directory)'"`
+		Foma        string `kong:"optional,help='Additionally save the compiled FST as a foma file'"`
+	} `kong:"cmd, help='Compile an xfst script to a Matrix or Double Array tokenizer without foma'"`
+	Lint struct {
+		Foma string `kong:"required,arg='',type='existingfile',help='The Foma FST file'"`
+	} `kong:"cmd, help='Check a compiled foma FST file for violations of the tokenizer conventions'"`
+	Test struct {
+		Tokenizer string   `kong:"required,short='t',help='The Matrix or Double Array Tokenizer file'"`
+		Tests     []string `kong:"required,arg='',type='existingfile',help='Grammar test files'"`
+		Tags      []string `kong:"optional,help='Only run tests with one of the tags'"`
+		Junit     string   `kong:"optional,help='Write a JUnit XML report to the file'"`
+	} `kong:"cmd, help='Run grammar regression tests against a tokenizer'"`
+	Coverage struct {
+		Tokenizer string   `kong:"required,short='t',help='The Matrix or Double Array Tokenizer file'"`
+		Corpus    []string `kong:"required,arg='',type='existingfile',help='Corpus files to tokenize'"`
+		Top       int      `kong:"optional,default=20,help='Number of listed transitions and states (defaults to ${default})'"`
+		Examples  bool     `kong:"optional,help='List the shortest input reaching each transition and state'"`
+		Output    string   `kong:"optional,short='o',help='Write the report to the file instead of STDOUT'"`
+	} `kong:"cmd, help='Report the coverage of a tokenizer by a corpus'"`
+	Equiv struct {
+		First  string `kong:"required,arg='',type='existingfile',help='The first Foma FST, Matrix or Double Array Tokenizer file'"`
+		Second string `kong:"required,arg='',type='existingfile',help='The second Foma FST, Matrix or Double Array Tokenizer file'"`
+	} `kong:"cmd, help='Check two tokenizers for identical tokenization behaviour'"`
 	Tokenize struct {
-		Tokenizer         string `kong:"required,short='t',help='The Matrix or Double Array Tokenizer file'"`
-		Input             string `kong:"required,arg='',type='existingfile',help='Input file to tokenize (use - for STDIN)'"`
-		Tokens            bool   `kong:"optional,negatable,default=true,help='Print token surfaces (defaults to ${default})'"`
-		Sentences         bool   `kong:"optional,negatable,default=true,help='Print sentence boundaries (defaults to ${default})'"`
-		TokenPositions    bool   `kong:"optional,default=false,short='p',help='Print token offsets (defaults to ${default})'"`
-		SentencePositions bool   `kong:"optional,default=false,help='Print sentence offsets (defaults to ${default})'"`
-		NewlineAfterEOT   bool   `kong:"optional,default=false,help='Ignore newline after EOT (defaults to ${default})'"`
+		Tokenizer         string   `kong:"required,short='t',help='The Matrix or Double Array Tokenizer file'"`
+		Input             string   `kong:"required,arg='',type='existingfile',help='Input file to tokenize (use - for STDIN)'"`
+		Tokens            bool     `kong:"optional,negatable,default=true,help='Print token surfaces (defaults to ${default})'"`
+		Sentences         bool     `kong:"optional,negatable,default=true,help='Print sentence boundaries (defaults to ${default})'"`
+		TokenPositions    bool     `kong:"optional,default=false,short='p',help='Print token offsets (defaults to ${default})'"`
+		SentencePositions bool     `kong:"optional,default=false,help='Print sentence offsets (defaults to ${default})'"`
+		NewlineAfterEOT   bool     `kong:"optional,default=false,help='Ignore newline after EOT (defaults to ${default})'"`
+		Graphemes         bool     `kong:"optional,default=false,help='Never split extended grapheme clusters (defaults to ${default})'"`
+		Normalized        bool     `kong:"optional,default=false,short='n',help='Print normalized token forms after a tab (defaults to ${default})'"`
+		Conllu            bool     `kong:"optional,default=false,name='conllu',help='Print tokens in the CoNLL-U format (defaults to ${default})'"`
+		Lossless          bool     `kong:"optional,default=false,help='Print the characters skipped after each token after a tab (defaults to ${default})'"`
+		Encoding          string   `kong:"optional,help='Encoding of the input (auto, utf-8, utf-16, utf-16le, utf-16be, iso-8859-1 or windows-1252)'"`
+		ByteOffsets       bool     `kong:"optional,default=false,help='Count offsets in bytes of the input encoding instead of characters (defaults to ${default})'"`
+		XML               bool     `kong:"optional,default=false,name='xml',help='Tokenize only the character data of XML or HTML input (defaults to ${default})'"`
+		XMLSentences      bool     `kong:"optional,default=false,name='xml-sentences',help='Force sentence boundaries at block-level elements (requires --xml)'"`
+		Filter            []string `kong:"optional,help='Comma separated filters to apply to the input with offsets into the original input (crlf, entities, soft-hyphens, wiki)'"`
+		Normalize         string   `kong:"optional,help='Unicode normalization form of the input with offsets into the original input (nfc or nfkc)'"`
+		SoftBounds        []string `kong:"optional,name='soft-bounds',help='Comma separated categories of soft bounds to split tokens at (e.g. gender,hyphen)'"`
+		Protect           string   `kong:"optional,type='existingfile',help='Lexicon of protected tokens, one per line (optionally followed by a tab and the space separated parts to split into)'"`
+		Segment           string   `kong:"optional,type='existingfile',help='Word list for the segmentation of tokens of Han, Kana and Hangul characters, one word per line'"`
+		Expand            string   `kong:"optional,type='existingfile',help='Table of multi-word tokens, one per line followed by a tab and the space separated words (requires --conllu)'"`
 	} `kong:"cmd, help='Tokenize a text'"`
 }
 
+// Load a foma file or decompile a tokenizer file
+func loadAutomaton(file string) *datok.Automaton {
+	if strings.HasSuffix(file, ".fst") {
+		auto := datok.LoadFomaFile(file)
+		if auto == nil {
+			log.Fatalln("Unable to load foma file")
+		}
+		return auto
+	}
+
+	switch tok := datok.LoadTokenizerFile(file).(type) {
+	case *datok.MatrixTokenizer:
+		if tok == nil {
+			log.Fatalln("Unable to load tokenizer file")
+		}
+		return tok.Automaton()
+	case *datok.DaTokenizer:
+		if tok == nil {
+			log.Fatalln("Unable to load tokenizer file")
+		}
+		return tok.Automaton()
+	}
+	log.Fatalln("Unable to load tokenizer file")
+	return nil
+}
+
+// Load a foma file or a tokenizer file,
+// keeping the tokenizer for the comparison of tokenizations
+func loadAutomatable(file string) datok.Automatable {
+	if strings.HasSuffix(file, ".fst") {
+		return loadAutomaton(file)
+	}
+
+	switch tok := datok.LoadTokenizerFile(file).(type) {
+	case *datok.MatrixTokenizer:
+		if tok == nil {
+			log.Fatalln("Unable to load tokenizer file")
+		}
+		return tok
+	case *datok.DaTokenizer:
+		if tok == nil {
+			log.Fatalln("Unable to load tokenizer file")
+		}
+		return tok
+	}
+	log.Fatalln("Unable to load tokenizer file")
+	return nil
+}
+
 // Main method for command line handling
 func main() {
 
@@ -48,28 +147,236 @@ func main() {
 	parser.FatalIfErrorf(err)
 
 	if ctx.Command() == "convert" {
-		tok := datok.LoadFomaFile(cli.Convert.Foma)
-		if tok == nil {
-			log.Fatalln("Unable to load foma file")
+		var tok *datok.Automaton
+		if cli.Convert.From != "" {
+			tok = loadAutomaton(cli.Convert.From)
+		} else if cli.Convert.Foma != "" {
+			tok = datok.LoadFomaFile(cli.Convert.Foma)
+			if tok == nil {
+				log.Fatalln("Unable to load foma file")
+			}
+		} else {
+			log.Fatalln("Either a foma file or a tokenizer file is required")
+		}
+		if cli.Convert.Minimize {
+			before := tok.StateCount()
+			tok = tok.Minimize()
+			fmt.Println("Minimized from", before, "to", tok.StateCount(), "states")
 		}
+		var conv datok.Tokenizer
 		if cli.Convert.DoubleArray {
-			dat := tok.ToDoubleArray()
+			dat := tok.ToDoubleArrayProgress(func(done, total int) {
+				fmt.Fprintf(os.Stderr, "\rConverted %d of %d states", done, total)
+			})
+			fmt.Fprintln(os.Stderr)
 			fmt.Println("Load factor", dat.LoadFactor())
 			_, err := dat.Save(cli.Convert.Tokenizer)
 			if err != nil {
 				log.Fatalln(err)
 			}
+			conv = dat
 		} else {
 			mat := tok.ToMatrix()
+
+			// Renumber states based on a sample corpus
+			if cli.Convert.Profile != "" {
+				f, err := os.Open(cli.Convert.Profile)
+				if err != nil {
+					log.Fatalln(err)
+				}
+				mat.RenumberStates(mat.StateVisits(f))
+				f.Close()
+			}
+
+			if cli.Convert.Layout == "state" {
+				mat.SetLayout(datok.LAYOUT_STATE_MAJOR)
+			}
+
 			_, err := mat.Save(cli.Convert.Tokenizer)
 			if err != nil {
 				log.Fatalln(err)
 			}
+			conv = mat
+		}
+
+		// Check the written file
+		if cli.Convert.Verify {
+			var corpus io.Reader
+			if cli.Convert.Corpus != "" {
+				f, err := os.Open(cli.Convert.Corpus)
+				if err != nil {
+					log.Fatalln(err)
+				}
+				defer f.Close()
+				corpus = f
+			}
+			if !datok.VerifyTokenizerFile(cli.Convert.Tokenizer, tok, conv, corpus) {
+				log.Fatalln("Verification of", cli.Convert.Tokenizer, "failed")
+			}
+			fmt.Println("File successfully verified.")
 		}
 		fmt.Println("File successfully converted.")
 		os.Exit(0)
 	}
 
+	if ctx.Command() == "lint <foma>" {
+		rep := datok.LintFomaFile(cli.Lint.Foma)
+		if rep == nil {
+			log.Fatalln("Unable to load foma file")
+		}
+		if _, err := rep.WriteTo(os.Stdout); err != nil {
+			log.Fatalln(err)
+		}
+		if rep.Errors() > 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if ctx.Command() == "test <tests>" {
+		tok := datok.LoadTokenizerFile(cli.Test.Tokenizer)
+		if tok == nil {
+			log.Fatalln("Unable to load tokenizer file")
+		}
+
+		var results []*datok.GrammarTestResult
+		failures, known := 0, 0
+		for _, file := range cli.Test.Tests {
+			tests := datok.LoadGrammarTestFile(file)
+			if tests == nil {
+				log.Fatalln("Unable to load test file", file)
+			}
+			for _, test := range tests {
+				if len(cli.Test.Tags) > 0 && !test.HasTag(cli.Test.Tags...) {
+					continue
+				}
+				res := test.Run(tok)
+				results = append(results, res)
+				if !res.Passed && test.KnownFailure != "" {
+					known++
+					fmt.Printf("KNOWN FAILURE: %s (%s:%d): %s\n", test.Description, test.File, test.Line, test.KnownFailure)
+				} else if res.Passed && test.KnownFailure != "" {
+					failures++
+					fmt.Printf("UNEXPECTED PASS: %s (%s:%d): %s\n", test.Description, test.File, test.Line, test.KnownFailure)
+				} else if !res.Passed {
+					failures++
+					fmt.Printf("FAIL: %s (%s:%d)\n", test.Description, test.File, test.Line)
+					fmt.Printf("  input: %q\n", test.Input)
+					for _, line := range strings.Split(strings.TrimSuffix(res.Diff(), "\n"), "\n") {
+						fmt.Println("  " + line)
+					}
+				}
+			}
+		}
+		fmt.Println(len(results), "tests,", failures, "failures,", known, "known failures")
+
+		if cli.Test.Junit != "" {
+			f, err := os.Create(cli.Test.Junit)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			if err = datok.WriteJUnit(f, results); err != nil {
+				log.Fatalln(err)
+			}
+			f.Close()
+		}
+
+		if failures > 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if ctx.Command() == "coverage <corpus>" {
+		tok := datok.LoadTokenizerFile(cli.Coverage.Tokenizer)
+		if tok == nil {
+			log.Fatalln("Unable to load tokenizer file")
+		}
+
+		// Enable counting
+		cov := &datok.Coverage{}
+		tw := datok.NewTokenWriter(io.Discard, datok.SIMPLE)
+		tw.Coverage = cov
+
+		for _, file := range cli.Coverage.Corpus {
+			f, err := os.Open(file)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			tok.TransduceTokenWriter(f, tw)
+			f.Close()
+		}
+
+		var w io.Writer = os.Stdout
+		if cli.Coverage.Output != "" {
+			f, err := os.Create(cli.Coverage.Output)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			defer f.Close()
+			w = f
+		}
+		if _, err := cov.WriteReport(w, tok, cli.Coverage.Top, cli.Coverage.Examples); err != nil {
+			log.Fatalln(err)
+		}
+		os.Exit(0)
+	}
+
+	if ctx.Command() == "equiv <first> <second>" {
+		first := loadAutomatable(cli.Equiv.First)
+		second := loadAutomatable(cli.Equiv.Second)
+		ok, ex := datok.Equivalent(first, second)
+		if !ok {
+			if datok.Differs(first, second, ex) {
+				fmt.Println("Tokenizers differ, e.g. for", strconv.Quote(ex))
+			} else {
+				fmt.Println("Tokenizers differ in the transitions for", strconv.Quote(ex)+",",
+					"but tokenize it identically")
+			}
+			os.Exit(1)
+		}
+		fmt.Println("Tokenizers are equivalent.")
+		os.Exit(0)
+	}
+
+	if ctx.Command() == "build" {
+		net := xfst.Compile(cli.Build.Xfst, cli.Build.Dir)
+		if net == nil {
+			log.Fatalln("Unable to compile xfst script")
+		}
+		fmt.Println("Compiled", net.StateCount(), "states and", net.ArcCount(), "arcs")
+		if cli.Build.Foma != "" {
+			if _, err := net.Save(cli.Build.Foma); err != nil {
+				log.Fatalln(err)
+			}
+		}
+		tok := net.Automaton()
+		if tok == nil {
+			log.Fatalln("Unable to convert the compiled FST")
+		}
+		var err error
+		if cli.Build.DoubleArray {
+			_, err = tok.ToDoubleArray().Save(cli.Build.Tokenizer)
+		} else {
+			_, err = tok.ToMatrix().Save(cli.Build.Tokenizer)
+		}
+		if err != nil {
+			log.Fatalln(err)
+		}
+		fmt.Println("File successfully built.")
+		os.Exit(0)
+	}
+
+	// Multi-word tokens are only expanded in CoNLL-U
+	if cli.Tokenize.Expand != "" && !cli.Tokenize.Conllu {
+		log.Fatalln("Expansions require the CoNLL-U format (--conllu)")
+	}
+
+	// Block-level elements are only known in XML mode
+	if cli.Tokenize.XMLSentences && !cli.Tokenize.XML {
+		log.Fatalln("Sentence boundaries at elements require XML mode (--xml)")
+	}
+
 	// Load the Datok or Matrix file
 	dat := datok.LoadTokenizerFile(cli.Tokenize.Tokenizer)
 
@@ -101,9 +408,17 @@ func main() {
 		flags |= datok.NEWLINE_AFTER_EOT
 	}
 
-	// Create token writer based on the options defined
-	tw := datok.NewTokenWriter(os.Stdout, flags)
-	defer os.Stdout.Close()
+	if cli.Tokenize.Normalized {
+		flags |= datok.NORMALIZED
+	}
+
+	if cli.Tokenize.Conllu {
+		flags |= datok.CONLLU
+	}
+
+	if cli.Tokenize.Lossless {
+		flags |= datok.LOSSLESS
+	}
 
 	var r io.Reader
 
@@ -129,6 +444,118 @@ func main() {
 		r = f
 	}
 
-	dat.TransduceTokenWriter(r, tw)
+	// Decode the input
+	var offsets datok.Offsets
+	if cli.Tokenize.Encoding != "" || cli.Tokenize.ByteOffsets {
+		enc := cli.Tokenize.Encoding
+		if enc == "" {
+			enc = datok.ENC_UTF8
+		}
+		dec := datok.NewDecoder(r, enc)
+		if dec == nil {
+			log.Fatalln("Unable to decode input")
+		}
+		r = dec
+
+		// Count offsets in bytes of the input
+		if cli.Tokenize.ByteOffsets {
+			offsets = dec.Offsets()
+		}
+	}
+
+	// Create token writer based on the options defined
+	tw := datok.NewTokenWriterOffsets(os.Stdout, flags, offsets)
+	defer os.Stdout.Close()
+
+	// Never end tokens inside of grapheme clusters
+	tw.Graphemes = cli.Tokenize.Graphemes
+
+	// Split tokens at the selected soft bounds
+	if len(cli.Tokenize.SoftBounds) > 0 {
+		var known []string
+		switch t := dat.(type) {
+		case *datok.MatrixTokenizer:
+			known = t.SoftBounds()
+		case *datok.DaTokenizer:
+			known = t.SoftBounds()
+		}
+	SOFTBOUNDS:
+		for _, name := range cli.Tokenize.SoftBounds {
+			for _, k := range known {
+				if strings.EqualFold(k, name) {
+					continue SOFTBOUNDS
+				}
+			}
+			log.Fatalln("Unknown soft bound category:", name)
+		}
+		tw.SoftBounds = cli.Tokenize.SoftBounds
+	}
+
+	// Expand multi-word tokens
+	if cli.Tokenize.Expand != "" {
+		exp := datok.LoadExpansionsFile(cli.Tokenize.Expand)
+		if exp == nil {
+			log.Fatalln("Unable to load expansion table")
+		}
+		tw = exp.TokenWriter(tw)
+	}
+
+	// Apply the lexicon of protected tokens
+	if cli.Tokenize.Protect != "" {
+		lex := datok.LoadLexiconFile(cli.Tokenize.Protect)
+		if lex == nil {
+			log.Fatalln("Unable to load lexicon")
+		}
+		tw = lex.TokenWriter(tw)
+	}
+
+	// Segment CJK tokens
+	if cli.Tokenize.Segment != "" {
+		seg := datok.LoadMaxMatchSegmenterFile(cli.Tokenize.Segment)
+		if seg == nil {
+			log.Fatalln("Unable to load word list")
+		}
+		tw = datok.NewSegmenterTokenWriter(tw, seg)
+	}
+
+	// Apply filters to the input
+	filters := make([]datok.Filter, 0, len(cli.Tokenize.Filter)+1)
+
+	// Remove markup in XML mode
+	if cli.Tokenize.XML {
+		filters = append(filters, datok.NewXMLFilter(cli.Tokenize.XMLSentences))
+	}
+
+	for _, name := range cli.Tokenize.Filter {
+		switch name {
+		case "crlf":
+			filters = append(filters, datok.NewCRLFFilter())
+		case "entities":
+			filters = append(filters, datok.NewEntityFilter())
+		case "soft-hyphens":
+			filters = append(filters, datok.NewSoftHyphenFilter())
+		case "wiki":
+			filters = append(filters, datok.NewWikiFilter())
+		default:
+			log.Fatalln("Unknown filter:", name)
+		}
+	}
+
+	// Normalize the filtered input
+	switch strings.ToLower(cli.Tokenize.Normalize) {
+	case "":
+	case "nfc":
+		filters = append(filters, datok.NewNFCFilter())
+	case "nfkc":
+		filters = append(filters, datok.NewNFKCFilter())
+	default:
+		log.Fatalln("Unknown normalization form:", cli.Tokenize.Normalize)
+	}
+
+	if len(filters) > 0 {
+		datok.NewPreprocessor(filters...).TransduceTokenWriter(dat, r, tw)
+	} else {
+		dat.TransduceTokenWriter(r, tw)
+	}
 	tw.Flush()
 }
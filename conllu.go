@@ -0,0 +1,145 @@
+package datok
+
+import (
+	"bufio"
+	"strconv"
+)
+
+// Create a token writer for the CoNLL-U format with one
+// token per line and sentences separated by empty lines.
+// Only the ID, FORM and MISC (SpaceAfter=No) fields are set.
+// Multi-word tokens are written as a range of IDs followed
+// by their words.
+// In lossless mode, whitespace other than a single space
+// is written as SpacesBefore and SpacesAfter in MISC.
+func conlluTokenWriter(writer *bufio.Writer, lossless bool) *TokenWriter {
+	id := 1
+
+	// The last token is kept until it's clear,
+	// whether it is followed by a space
+	form := make([]rune, 0, 64)
+	var words [][]rune
+	var before string
+	hasPending := false
+
+	// The sentence end following the last token
+	sentPending := false
+
+	// Characters skipped since the last token
+	skipped := make([]rune, 0, 64)
+
+	// The next token is the first of a text
+	textStart := true
+
+	// Write a line with all unknown fields set to '_'
+	writeLine := func(id string, form []rune, misc string) {
+		writer.WriteString(id)
+		writer.WriteByte('\t')
+		writer.WriteString(string(form))
+		writer.WriteString("\t_\t_\t_\t_\t_\t_\t_\t")
+		writer.WriteString(misc)
+		writer.WriteByte('\n')
+	}
+
+	// Write the last token with the information about
+	// the characters following
+	flush := func(spaceAfter bool, following []rune) {
+		if !hasPending {
+			return
+		}
+		hasPending = false
+
+		misc := "_"
+		if lossless {
+			if len(following) == 0 {
+				misc = "SpaceAfter=No"
+			} else if len(following) != 1 || following[0] != ' ' {
+				misc = "SpacesAfter=" + escapeSkipped(following)
+			}
+			if before != "" {
+				if misc == "_" {
+					misc = before
+				} else {
+					misc = before + "|" + misc
+				}
+			}
+		} else if !spaceAfter {
+			misc = "SpaceAfter=No"
+		}
+
+		if words == nil {
+			writeLine(strconv.Itoa(id), form, misc)
+			id++
+		} else {
+			writeLine(strconv.Itoa(id)+"-"+strconv.Itoa(id+len(words)-1), form, misc)
+			for _, word := range words {
+				writeLine(strconv.Itoa(id), word, "_")
+				id++
+			}
+		}
+
+		if sentPending {
+			sentPending = false
+			writer.WriteByte('\n')
+			id = 1
+		}
+	}
+
+	token := func(offset int, buf []rune, w [][]rune) {
+		skipped = append(skipped, buf[:offset]...)
+		flush(offset > 0, skipped)
+
+		before = ""
+		if lossless && textStart && len(skipped) > 0 {
+			before = "SpacesBefore=" + escapeSkipped(skipped)
+		}
+		textStart = false
+		skipped = skipped[:0]
+
+		form = append(form[:0], buf[offset:]...)
+		words = w
+		hasPending = true
+	}
+
+	// Sentences are separated by empty lines,
+	// written after the last token is known
+	sentenceEnd := func() {
+		if hasPending {
+			sentPending = true
+		} else if id > 1 {
+			writer.WriteByte('\n')
+			id = 1
+		}
+	}
+
+	tw := &TokenWriter{
+		Token: func(offset int, buf []rune) {
+			token(offset, buf, nil)
+		},
+		MultiWordToken: func(offset int, buf []rune, w [][]rune) {
+			token(offset, buf, w)
+		},
+		SentenceEnd: func(_ int) {
+			sentenceEnd()
+		},
+		TextEnd: func(_ int) {
+			sentenceEnd()
+			flush(true, skipped)
+			skipped = skipped[:0]
+			textStart = true
+			writer.Flush()
+		},
+		Flush: func() error {
+			flush(true, skipped)
+			skipped = skipped[:0]
+			return writer.Flush()
+		},
+	}
+
+	if lossless {
+		tw.Skip = func(_ SkipKind, buf []rune) {
+			skipped = append(skipped, buf...)
+		}
+	}
+	return tw
+}
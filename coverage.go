@@ -0,0 +1,330 @@
+package datok
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Coverage counts the usage of the automaton of
+// a tokenizer during transduction.
+// Counting is enabled per transduction by passing the
+// coverage with the token writer. The counts are collected
+// for a single tokenizer and are not safe for concurrent
+// transductions.
+type Coverage struct {
+	// Transitions taken per element of the array
+	Transitions []uint64
+
+	// Backtracks to the last token end
+	Backtracks uint64
+
+	// Transitions retried with the unknown symbol
+	// after failing with the identity symbol
+	Unknown uint64
+
+	// Failures of the automaton, dropping
+	// the buffer as a token
+	Fails uint64
+}
+
+// An arc of the automaton of a tokenizer, identified
+// by the index of the array element counting it
+type coverageArc struct {
+	from, to int
+	sym      int
+	index    int
+}
+
+// The automaton of a tokenizer as arcs per state
+type coverageGraph struct {
+	start      int
+	states     []int
+	arcs       map[int][]coverageArc
+	sigma      map[int]rune
+	epsilon    int
+	unknown    int
+	identity   int
+	categories categories
+	softBounds softBounds
+}
+
+// Get all arcs of the matrix
+func (mat *MatrixTokenizer) coverageGraph() *coverageGraph {
+	g := newCoverageGraph(mat.sigma, mat.epsilon, mat.unknown, mat.identity, mat.categories, mat.softBounds)
+	cols := mat.columns()
+	for s := 1; s <= mat.stateCount; s++ {
+		g.states = append(g.states, s)
+		for _, a := range g.symbols() {
+			if a >= cols {
+				continue
+			}
+			i := (a-1)*mat.symStride + s*mat.stateStride
+			if t := mat.array[i]; t != 0 {
+				g.arcs[s] = append(g.arcs[s], coverageArc{s, int(t &^ FIRSTBIT), a, i})
+			}
+		}
+	}
+	return g
+}
+
+// Get all arcs of the double array reachable
+// from the start state
+func (dat *DaTokenizer) coverageGraph() *coverageGraph {
+	g := newCoverageGraph(dat.sigma, dat.epsilon, dat.unknown, dat.identity, dat.categories, dat.softBounds)
+	size := uint64(dat.GetSize())
+	seen := map[uint64]bool{1: true}
+	queue := []uint64{1}
+	for i := 0; i < len(queue); i++ {
+		t := queue[i]
+		g.states = append(g.states, int(t))
+		base := dat.cell(t).base
+		for _, a := range g.symbols() {
+			t1 := base + uint64(a)
+			if t1 > size {
+				continue
+			}
+			c := dat.cell(t1)
+			if c.check != t {
+				continue
+			}
+			target := t1
+			if c.separate {
+				target = c.base
+			}
+			if !seen[target] {
+				seen[target] = true
+				queue = append(queue, target)
+			}
+			g.arcs[int(t)] = append(g.arcs[int(t)], coverageArc{int(t), int(target), a, int(t1)})
+		}
+	}
+	return g
+}
+
+func newCoverageGraph(sigma map[rune]int, epsilon, unknown, identity int, cats categories, sbs softBounds) *coverageGraph {
+	g := &coverageGraph{
+		start:      1,
+		arcs:       make(map[int][]coverageArc),
+		sigma:      make(map[int]rune, len(sigma)),
+		epsilon:    epsilon,
+		unknown:    unknown,
+		identity:   identity,
+		categories: cats,
+		softBounds: sbs,
+	}
+	for char, num := range sigma {
+		g.sigma[num] = char
+	}
+	return g
+}
+
+// All symbols in ascending order
+func (g *coverageGraph) symbols() []int {
+	syms := make([]int, 0, len(g.sigma)+3+catCount+len(g.softBounds))
+	for num := range g.sigma {
+		syms = append(syms, num)
+	}
+	for _, num := range append([]int{g.epsilon, g.unknown, g.identity}, g.categories[:]...) {
+		if num > 0 {
+			syms = append(syms, num)
+		}
+	}
+	for _, sb := range g.softBounds {
+		syms = append(syms, sb.sym)
+	}
+	sort.Ints(syms)
+	return syms
+}
+
+// Describe a symbol
+func (g *coverageGraph) symbol(a int) string {
+	switch a {
+	case g.epsilon:
+		return "TOKEN"
+	case g.unknown:
+		return "UNKNOWN"
+	case g.identity:
+		return "IDENTITY"
+	}
+	if cat := g.categories.of(a); cat != catNone {
+		return categoryNames[cat]
+	}
+	if g.softBounds.has(a) {
+		return "SOFT:" + g.softBounds.name(a)
+	}
+	return strconv.QuoteRuneToGraphic(g.sigma[a])
+}
+
+// Shortest inputs reaching each state.
+// Characters not in sigma are shown as {?}.
+func (g *coverageGraph) examples() map[int]string {
+	ex := map[int]string{g.start: ""}
+	queue := []int{g.start}
+	for i := 0; i < len(queue); i++ {
+		s := queue[i]
+		for _, arc := range g.arcs[s] {
+			if _, ok := ex[arc.to]; ok {
+				continue
+			}
+			ex[arc.to] = ex[s] + g.input(arc.sym)
+			queue = append(queue, arc.to)
+		}
+	}
+	return ex
+}
+
+// Example input for a symbol
+func (g *coverageGraph) input(a int) string {
+	switch a {
+	case g.epsilon:
+		return ""
+	case g.unknown, g.identity:
+		return "{?}"
+	}
+	if cat := g.categories.of(a); cat != catNone {
+		return "{" + strings.Trim(categoryNames[cat], "@_") + "}"
+	}
+	if g.softBounds.has(a) {
+		return ""
+	}
+	return string(g.sigma[a])
+}
+
+// The automaton of tokenizers supporting coverage reports
+type coverable interface {
+	coverageGraph() *coverageGraph
+}
+
+// WriteReport writes a coverage report for the tokenizer
+// the counts were collected with, listing the top most used
+// transitions and states, and the top unvisited states.
+// With examples, each listed transition or state is
+// accompanied by the shortest input reaching it.
+func (cov *Coverage) WriteReport(w io.Writer, tok Tokenizer, top int, examples bool) (n int64, err error) {
+	c, ok := tok.(coverable)
+	if !ok {
+		return 0, fmt.Errorf("coverage is not supported by %s", tok.Type())
+	}
+	g := c.coverageGraph()
+
+	// Nothing was counted yet
+	if cov.Transitions == nil {
+		n := 0
+		for _, arcs := range g.arcs {
+			for _, arc := range arcs {
+				if arc.index >= n {
+					n = arc.index + 1
+				}
+			}
+		}
+		cov = &Coverage{Transitions: make([]uint64, n)}
+	}
+
+	var ex map[int]string
+	if examples {
+		ex = g.examples()
+	}
+
+	// Collect counts
+	var arcs []coverageArc
+	visits := make(map[int]uint64, len(g.states))
+	var taken, identity, usedArcs uint64
+	for _, s := range g.states {
+		for _, arc := range g.arcs[s] {
+			arcs = append(arcs, arc)
+			count := cov.Transitions[arc.index]
+			if count == 0 {
+				continue
+			}
+			usedArcs++
+			taken += count
+			visits[arc.to] += count
+			if arc.sym == g.identity {
+				identity += count
+			}
+		}
+	}
+	visited := 0
+	for _, s := range g.states {
+		if visits[s] > 0 || s == g.start {
+			visited++
+		}
+	}
+
+	wb := bufio.NewWriter(w)
+	write := func(format string, a ...interface{}) {
+		if err == nil {
+			var m int
+			m, err = fmt.Fprintf(wb, format, a...)
+			n += int64(m)
+		}
+	}
+	example := func(s int, suffix string) string {
+		if ex == nil {
+			return ""
+		}
+		if e, ok := ex[s]; ok {
+			return "  " + strconv.Quote(e+suffix)
+		}
+		return "  (unreachable)"
+	}
+
+	write("%-21s %d\n", "Transitions taken:", taken)
+	write("%-21s %d of %d (%s)\n", "Arcs used:", usedArcs, len(arcs), percent(usedArcs, uint64(len(arcs))))
+	write("%-21s %d of %d (%s)\n", "States visited:", visited, len(g.states), percent(uint64(visited), uint64(len(g.states))))
+	write("%-21s %d\n", "Identity transitions:", identity)
+	write("%-21s %d\n", "Unknown fallbacks:", cov.Unknown)
+	write("%-21s %d\n", "Epsilon backtracks:", cov.Backtracks)
+	write("%-21s %d\n", "Failures:", cov.Fails)
+
+	// Hot transitions
+	sort.SliceStable(arcs, func(i, j int) bool {
+		return cov.Transitions[arcs[i].index] > cov.Transitions[arcs[j].index]
+	})
+	write("\nHot transitions:\n")
+	for i := 0; i < top && i < len(arcs) && cov.Transitions[arcs[i].index] > 0; i++ {
+		arc := arcs[i]
+		write("  %10d  %d -> %d (%s)%s\n",
+			cov.Transitions[arc.index], arc.from, arc.to, g.symbol(arc.sym),
+			example(arc.from, g.input(arc.sym)))
+	}
+
+	// Hot states
+	states := append([]int(nil), g.states...)
+	sort.SliceStable(states, func(i, j int) bool {
+		return visits[states[i]] > visits[states[j]]
+	})
+	write("\nHot states:\n")
+	for i := 0; i < top && i < len(states) && visits[states[i]] > 0; i++ {
+		write("  %10d  %d%s\n", visits[states[i]], states[i], example(states[i], ""))
+	}
+
+	// Unvisited states
+	var unvisited []int
+	for _, s := range g.states {
+		if visits[s] == 0 && s != g.start {
+			unvisited = append(unvisited, s)
+		}
+	}
+	write("\nUnvisited states: %d\n", len(unvisited))
+	for i := 0; i < top && i < len(unvisited); i++ {
+		write("  %d%s\n", unvisited[i], example(unvisited[i], ""))
+	}
+
+	if err == nil {
+		err = wb.Flush()
+	}
+	return n, err
+}
+
+// Format a ratio as a percentage
+func percent(a, b uint64) string {
+	if b == 0 {
+		return "0%"
+	}
+	return strings.TrimRight(strings.TrimRight(strconv.FormatFloat(float64(a)*100/float64(b), 'f', 2, 64), "0"), ".") + "%"
+}
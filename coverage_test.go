@@ -0,0 +1,66 @@
+package datok
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoverage(t *testing.T) {
+	assert := assert.New(t)
+
+	auto := LoadFomaFile("testdata/simpletok.fst")
+	mat := auto.ToMatrix()
+	dat := auto.ToDoubleArray()
+
+	var reports []string
+	for _, tok := range []Tokenizer{mat, dat} {
+		cov := &Coverage{}
+		tw := NewTokenWriter(bytes.NewBuffer(nil), SIMPLE)
+		tw.Coverage = cov
+		assert.True(tok.TransduceTokenWriter(strings.NewReader("Der Wald!"), tw))
+
+		// Counting is only enabled for the transduction
+		// the coverage is passed to
+		assert.True(tok.Transduce(strings.NewReader("Der Wald!"), bytes.NewBuffer(nil)))
+
+		assert.Equal(uint64(0), cov.Fails)
+		assert.Equal(uint64(0), cov.Unknown)
+
+		w := bytes.NewBuffer(nil)
+		_, err := cov.WriteReport(w, tok, 3, true)
+		assert.Nil(err)
+		reports = append(reports, w.String())
+	}
+
+	for _, report := range reports {
+		assert.Contains(report, "Transitions taken:    12\n")
+		assert.Contains(report, "Identity transitions: 7\n")
+		assert.Contains(report, "Epsilon backtracks:   2\n")
+		assert.Contains(report, "Unvisited states: 0\n")
+	}
+
+	// Hot transitions of the matrix with examples
+	assert.Contains(reports[0], "\nHot transitions:\n           5  2 -> 2 (IDENTITY)  \"{?}{?}\"\n")
+	assert.Contains(reports[0], "\nHot states:\n           7  2  \"{?}\"\n")
+}
+
+func TestCoverageFullTokenizer(t *testing.T) {
+	assert := assert.New(t)
+
+	if mat_de == nil {
+		mat_de = LoadMatrixFile("testdata/tokenizer_de.matok")
+	}
+	cov := &Coverage{}
+	tw := NewTokenWriter(bytes.NewBuffer(nil), SIMPLE)
+	tw.Coverage = cov
+	mat_de.TransduceTokenWriter(strings.NewReader("Der Vorsitzende der Abk. hat gewählt. Das ist 日本."), tw)
+
+	w := bytes.NewBuffer(nil)
+	_, err := cov.WriteReport(w, mat_de, 5, false)
+	assert.Nil(err)
+	assert.Contains(w.String(), "Identity transitions: 2\n")
+	assert.NotContains(w.String(), "\"")
+}
@@ -9,16 +9,15 @@ package datok
 // The maximum number of states is 1.073.741.823 (30bit),
 // with a loadfactor of ~70, this means roughly 70 million
 // states in the FSA, which is sufficient for the current
-// job.
+// job. Larger FSAs are represented by a 64 bit double array
+// (see datok64.go), that is only used in case the 32 bit
+// representation overflows.
 //
 // Serialization is little endian.
 
 // TODO:
 // - replace maxSize with the check value
 // - Check if final states can be optional.
-// - Introduce ELM (Morita et al. 2001) to speed
-//   up construction. Can be ignored on serialization
-//   to improve compression.
 // - Add checksum to serialization.
 // - Replace/Enhance table with a map
 // - Provide a bufio.Scanner compatible interface.
@@ -43,19 +42,46 @@ const (
 	FIRSTBIT  uint32 = 1 << 31
 	SECONDBIT uint32 = 1 << 30
 	RESTBIT   uint32 = ^uint32(0) &^ (FIRSTBIT | SECONDBIT)
+
+	// Version of files with category symbols or rewrites
+	EXTVERSION = uint16(2)
 )
 
+// Maximum value in a 32 bit double array,
+// as the two highest bits are used as flags
+var maxValue32 = uint64(RESTBIT)
+
 // Serialization is always little endian
 var bo binary.ByteOrder = binary.LittleEndian
 
 type mapping struct {
 	source int
-	target uint32
+	target uint64
+}
+
+// Element of the double array, either with 32 bit
+// or with 64 bit values (see datok64.go).
+// The two highest bits of the values are used as flags.
+type element[U uint32 | uint64] struct {
+	base  U
+	check U
+}
+
+type bc = element[uint32]
+
+// The highest bit of an element value
+func firstBit[U uint32 | uint64]() U {
+	return ^(^U(0) >> 1)
+}
+
+// The second highest bit of an element value
+func secondBit[U uint32 | uint64]() U {
+	return firstBit[U]() >> 1
 }
 
-type bc struct {
-	base  uint32
-	check uint32
+// The bits of an element value without the flags
+func restBits[U uint32 | uint64]() U {
+	return ^U(0) >> 2
 }
 
 // DaTokenizer represents a tokenizer implemented as a
@@ -67,12 +93,26 @@ type DaTokenizer struct {
 	transCount int
 	array      []bc
 
+	// The 64 bit representation of the double array,
+	// in case the double array is too large
+	// to be represented by 32 bit values
+	array64 []bc64
+
 	// Special symbols in sigma
 	epsilon  int
 	unknown  int
 	identity int
 	final    int
 	tokenend int
+
+	// Symbols for categories of characters not in sigma
+	categories categories
+
+	// Outputs of transitions rewriting characters
+	rewrites rewrites
+
+	// Symbols for optional token boundaries
+	softBounds softBounds
 }
 
 // ToDoubleArray turns the intermediate tokenizer representation
@@ -80,6 +120,24 @@ type DaTokenizer struct {
 //
 // This is based on Mizobuchi et al (2000), p.128
 func (auto *Automaton) ToDoubleArray() *DaTokenizer {
+	return auto.ToDoubleArrayProgress(nil)
+}
+
+// ToDoubleArrayProgress turns the intermediate tokenizer representation
+// into a double array representation and reports the number of
+// converted states and the number of all states to the
+// progress function, if given.
+//
+// The double array is constructed with 32 bit elements and
+// extended to 64 bit elements, in case the values don't fit
+// in 30 bit.
+func (auto *Automaton) ToDoubleArrayProgress(progress func(int, int)) *DaTokenizer {
+	return auto.toDoubleArray(progress, false)
+}
+
+// Construct the double array - optionally using
+// the 64 bit representation in any case.
+func (auto *Automaton) toDoubleArray(progress func(int, int), wide bool) *DaTokenizer {
 
 	dat := &DaTokenizer{
 		sigma:      make(map[rune]int),
@@ -89,14 +147,20 @@ func (auto *Automaton) ToDoubleArray() *DaTokenizer {
 		identity:   auto.identity,
 		epsilon:    auto.epsilon,
 		tokenend:   auto.tokenend,
+		categories: auto.categories,
+		softBounds: auto.softBounds,
+	}
+
+	if wide {
+		dat.array64 = make([]bc64, 0, dat.final+1)
 	}
 
 	dat.resize(dat.final)
 
-	// Init with identity
+	// Init with identity or category symbols
 	if dat.identity != -1 {
 		for i := 0; i < 256; i++ {
-			dat.sigmaASCII[i] = dat.identity
+			dat.sigmaASCII[i] = dat.categories.fallback(rune(i), dat.identity)
 		}
 	}
 
@@ -109,36 +173,40 @@ func (auto *Automaton) ToDoubleArray() *DaTokenizer {
 
 	mark := 0
 	size := 0
-	var base uint32
+	var base uint64
 	var atrans *edge
 	var s, s1 int
-	var t, t1 uint32
+	var t, t1 uint64
 	var diff int
 
 	// Create a mapping from s (in Ms aka Intermediate FSA)
 	// to t (in Mt aka Double Array FSA)
-	table := make([]*mapping, auto.arcCount+1)
-	// tableQueue := make([]int, tok.arcCount+1)
+	table := make([]*mapping, auto.stateCount+1)
+
+	// Lookup representatives of states in Ms
+	tableLookup := make([]uint64, auto.stateCount+1)
 
 	// Initialize with the start state
 	table[size] = &mapping{source: 1, target: 1}
-	// tableQueue[size] = 1
+	tableLookup[1] = 1
 	size++
 
 	// Allocate space for the outgoing symbol range
 	A := make([]int, 0, auto.sigmaCount)
-	// tableLookup := make([]uint32, tok.arcCount+2) // make(map[int]uint32)
-	// tableLookup[1] = 1
 
-	// block_begin_pos := uint32(1)
+	// Initialize the list of empty elements
+	free := &elm{}
+	free.extend(dat.length())
 
 	for mark < size {
 		s = table[mark].source // This is a state in Ms
 		t = table[mark].target // This is a state in Mt
-		// s = tableQueue[mark]
-		// t = tableLookup[s]
 		mark++
 
+		if progress != nil && mark&0xff == 0 {
+			progress(mark, auto.stateCount)
+		}
+
 		// Following the paper, here the state t can be remembered
 		// in the set of states St
 		A = A[:0]
@@ -147,9 +215,9 @@ func (auto *Automaton) ToDoubleArray() *DaTokenizer {
 		// Set base to the first free slot in the double array
 		// base = dat.xCheck(A)
 		// base = dat.xCheckSkip(A)
-		// base = dat.xCheckNiu(A, &block_begin_pos)
-		base = dat.xCheckSkipNiu(A)
-		dat.array[t].setBase(base)
+		// base = dat.xCheckSkipNiu(A)
+		base = dat.xCheckELM(A, free)
+		dat.setBase(t, base)
 
 		// TODO:
 		//   Sort the outgoing transitions based on the
@@ -166,8 +234,9 @@ func (auto *Automaton) ToDoubleArray() *DaTokenizer {
 				s1 = atrans.end
 
 				// Store the transition
-				t1 = base + uint32(a)
-				dat.array[t1].setCheck(t)
+				t1 = base + uint64(a)
+				dat.setCheck(t1, t)
+				free.remove(int(t1))
 
 				// Set maxSize
 				// New: dat.maxSize = max(dat.maxSize, int(t1))
@@ -182,7 +251,7 @@ func (auto *Automaton) ToDoubleArray() *DaTokenizer {
 
 				// Mark the state as being the target of a nontoken transition
 				if atrans.nontoken {
-					dat.array[t1].setNonToken(true)
+					dat.setNonToken(t1, true)
 					if DEBUG {
 						log.Println("Set", t1, "to nontoken")
 					}
@@ -190,31 +259,38 @@ func (auto *Automaton) ToDoubleArray() *DaTokenizer {
 
 				// Mark the state as being the target of a tokenend transition
 				if atrans.tokenend {
-					dat.array[t1].setTokenEnd(true)
+					dat.setTokenEnd(t1, true)
 					if DEBUG {
 						log.Println("Set", t1, "to tokenend")
 					}
 				}
 
+				// Remember rewrites
+				if atrans.rewrite != "" {
+					if dat.rewrites == nil {
+						dat.rewrites = make(rewrites)
+					}
+					dat.rewrites[t1] = []rune(atrans.rewrite)
+				}
+
 				// Check for representative states
-				r := stateAlreadyInTable(s1, table, size)
-				// r := tableLookup[s1]
+				r := tableLookup[s1]
 
 				// No representative found
 				if r == 0 {
 					// Remember the mapping
 					table[size] = &mapping{source: s1, target: t1}
-					// tableQueue[size] = s1
-					// tableLookup[s1] = t1
+					tableLookup[s1] = t1
 					size++
 				} else {
 					// Overwrite with the representative state
-					dat.array[t1].setBase(r)
-					dat.array[t1].setSeparate(true)
+					dat.setBase(t1, r)
+					dat.setSeparate(t1, true)
 				}
 			} else {
 				// Store a final transition
-				dat.array[base+uint32(dat.final)].setCheck(t)
+				dat.setCheck(base+uint64(dat.final), t)
+				free.remove(int(base) + dat.final)
 
 				// Find max
 				// see https://dev.to/jobinrjohnson/branchless-programming-does-it-really-matter-20j4
@@ -224,32 +300,28 @@ func (auto *Automaton) ToDoubleArray() *DaTokenizer {
 		}
 	}
 
+	if progress != nil {
+		progress(size, auto.stateCount)
+	}
+
 	// Following Mizobuchi et al (2000) the size of the
 	// FSA should be stored in check(1).
 	// We make the size a bit larger so we never have to check for boundaries.
+	dat.resize(dat.maxSize + dat.final)
 	dat.setSize(dat.maxSize + dat.final)
-	if len(dat.array) < dat.maxSize+dat.final {
-		dat.array = append(dat.array, make([]bc, dat.final)...)
+	if dat.array64 != nil {
+		dat.array64 = dat.array64[:dat.maxSize+dat.final]
+	} else {
+		dat.array = dat.array[:dat.maxSize+dat.final]
 	}
-	dat.array = dat.array[:dat.maxSize+dat.final]
 	return dat
 }
 
-// Check the table if a mapping of s
-// exists and return this as a representative.
-// Currently iterates through the whole table
-// in a bruteforce manner.
-func stateAlreadyInTable(s int, table []*mapping, size int) uint32 {
-	for x := 0; x < size; x++ {
-		if table[x].source == s {
-			return table[x].target
-		}
-	}
-	return 0
-}
-
 // Type of tokenizer
-func (DaTokenizer) Type() string {
+func (dat DaTokenizer) Type() string {
+	if dat.array64 != nil {
+		return DA64MAGIC
+	}
 	return DAMAGIC
 }
 
@@ -257,81 +329,191 @@ func (DaTokenizer) Type() string {
 func (dat *DaTokenizer) resize(l int) {
 	// TODO:
 	//   This is a bit too aggressive atm and should be calmed down.
+	if dat.array64 != nil {
+		if len(dat.array64) <= l {
+			dat.array64 = append(dat.array64, make([]bc64, l)...)
+		}
+		return
+	}
+
 	if len(dat.array) <= l {
 		dat.array = append(dat.array, make([]bc, l)...)
 	}
 }
 
 // Set base value in double array
-func (bc *bc) setBase(v uint32) {
+func (bc *element[U]) setBase(v U) {
 	bc.base = v
 }
 
 // Get base value in double array
-func (bc *bc) getBase() uint32 {
-	return bc.base & RESTBIT
+func (bc *element[U]) getBase() U {
+	return bc.base & restBits[U]()
 }
 
 // Set check value in double array
-func (bc *bc) setCheck(v uint32) {
+func (bc *element[U]) setCheck(v U) {
 	bc.check = v
 }
 
 // Get check value in double array
-func (bc *bc) getCheck() uint32 {
-	return bc.check & RESTBIT
+func (bc *element[U]) getCheck() U {
+	return bc.check & restBits[U]()
 }
 
 // Returns true if a state is separate pointing to a representative
-func (bc *bc) isSeparate() bool {
-	return bc.base&FIRSTBIT != 0
+func (bc *element[U]) isSeparate() bool {
+	return bc.base&firstBit[U]() != 0
 }
 
 // Mark a state as separate pointing to a representative
-func (bc *bc) setSeparate(sep bool) {
+func (bc *element[U]) setSeparate(sep bool) {
 	if sep {
-		bc.base |= FIRSTBIT
+		bc.base |= firstBit[U]()
 	} else {
-		bc.base &= (RESTBIT | SECONDBIT)
+		bc.base &= (restBits[U]() | secondBit[U]())
 	}
 }
 
 // Returns true if a state is the target of a nontoken transition
-func (bc *bc) isNonToken() bool {
-	return bc.check&FIRSTBIT != 0
+func (bc *element[U]) isNonToken() bool {
+	return bc.check&firstBit[U]() != 0
 }
 
 // Mark a state as being the target of a nontoken transition
-func (bc *bc) setNonToken(sep bool) {
+func (bc *element[U]) setNonToken(sep bool) {
 	if sep {
-		bc.check |= FIRSTBIT
+		bc.check |= firstBit[U]()
 	} else {
-		bc.check &= (RESTBIT | SECONDBIT)
+		bc.check &= (restBits[U]() | secondBit[U]())
 	}
 }
 
 // Returns true if a state is the target of a tokenend transition
-func (bc *bc) isTokenEnd() bool {
-	return bc.check&SECONDBIT != 0
+func (bc *element[U]) isTokenEnd() bool {
+	return bc.check&secondBit[U]() != 0
 }
 
 // Mark a state as being the target of a tokenend transition
-func (bc *bc) setTokenEnd(sep bool) {
+func (bc *element[U]) setTokenEnd(sep bool) {
 	if sep {
-		bc.check |= SECONDBIT
+		bc.check |= secondBit[U]()
 	} else {
-		bc.check &= (RESTBIT | FIRSTBIT)
+		bc.check &= (restBits[U]() | firstBit[U]())
+	}
+}
+
+// Get base value of an element in the double array
+func (dat *DaTokenizer) getBase(t uint64) uint64 {
+	if dat.array64 != nil {
+		return dat.array64[t].getBase()
+	}
+	return uint64(dat.array[t].getBase())
+}
+
+// Set base value of an element in the double array.
+// Switches to the 64 bit representation, in case the
+// value doesn't fit in 30 bit.
+func (dat *DaTokenizer) setBase(t uint64, v uint64) {
+	if dat.array64 == nil && v > maxValue32 {
+		dat.widen()
+	}
+	if dat.array64 != nil {
+		dat.array64[t].setBase(v)
+		return
+	}
+	dat.array[t].setBase(uint32(v))
+}
+
+// Get check value of an element in the double array
+func (dat *DaTokenizer) getCheck(t uint64) uint64 {
+	if dat.array64 != nil {
+		return dat.array64[t].getCheck()
+	}
+	return uint64(dat.array[t].getCheck())
+}
+
+// Set check value of an element in the double array.
+// Switches to the 64 bit representation, in case the
+// value doesn't fit in 30 bit.
+func (dat *DaTokenizer) setCheck(t uint64, v uint64) {
+	if dat.array64 == nil && v > maxValue32 {
+		dat.widen()
+	}
+	if dat.array64 != nil {
+		dat.array64[t].setCheck(v)
+		return
+	}
+	dat.array[t].setCheck(uint32(v))
+}
+
+// Returns true if an element is separate pointing to a representative
+func (dat *DaTokenizer) isSeparate(t uint64) bool {
+	if dat.array64 != nil {
+		return dat.array64[t].isSeparate()
+	}
+	return dat.array[t].isSeparate()
+}
+
+// Mark an element as separate pointing to a representative
+func (dat *DaTokenizer) setSeparate(t uint64, sep bool) {
+	if dat.array64 != nil {
+		dat.array64[t].setSeparate(sep)
+		return
+	}
+	dat.array[t].setSeparate(sep)
+}
+
+// Returns true if an element is the target of a nontoken transition
+func (dat *DaTokenizer) isNonToken(t uint64) bool {
+	if dat.array64 != nil {
+		return dat.array64[t].isNonToken()
+	}
+	return dat.array[t].isNonToken()
+}
+
+// Mark an element as being the target of a nontoken transition
+func (dat *DaTokenizer) setNonToken(t uint64, sep bool) {
+	if dat.array64 != nil {
+		dat.array64[t].setNonToken(sep)
+		return
+	}
+	dat.array[t].setNonToken(sep)
+}
+
+// Returns true if an element is the target of a tokenend transition
+func (dat *DaTokenizer) isTokenEnd(t uint64) bool {
+	if dat.array64 != nil {
+		return dat.array64[t].isTokenEnd()
+	}
+	return dat.array[t].isTokenEnd()
+}
+
+// Mark an element as being the target of a tokenend transition
+func (dat *DaTokenizer) setTokenEnd(t uint64, sep bool) {
+	if dat.array64 != nil {
+		dat.array64[t].setTokenEnd(sep)
+		return
 	}
+	dat.array[t].setTokenEnd(sep)
 }
 
 // Set size of double array
 func (dat *DaTokenizer) setSize(v int) {
-	dat.array[1].setCheck(uint32(v))
+	dat.setCheck(1, uint64(v))
 }
 
 // Get size of double array
 func (dat *DaTokenizer) GetSize() int {
-	return int(dat.array[1].getCheck())
+	return int(dat.getCheck(1))
+}
+
+// Number of elements in the double array
+func (dat *DaTokenizer) length() int {
+	if dat.array64 != nil {
+		return len(dat.array64)
+	}
+	return len(dat.array)
 }
 
 // Based on Mizobuchi et al (2000), p. 124
@@ -339,16 +521,16 @@ func (dat *DaTokenizer) GetSize() int {
 // structure until it finds a gap that fits all outgoing transitions
 // of the state. This is extremely slow, but is only necessary in the
 // construction phase of the tokenizer.
-func (dat *DaTokenizer) xCheck(symbols []int) uint32 {
+func (dat *DaTokenizer) xCheck(symbols []int) uint64 {
 
 	// Start at the first entry of the double array list
-	base := uint32(1)
+	base := uint64(1)
 
 OVERLAP:
 	// Resize the array if necessary
 	dat.resize(int(base) + dat.final)
 	for _, a := range symbols {
-		if dat.array[int(base)+a].getCheck() != 0 {
+		if dat.getCheck(base+uint64(a)) != 0 {
 			base++
 			goto OVERLAP
 		}
@@ -358,16 +540,16 @@ OVERLAP:
 
 // This is an implementation of xCheck with the skip-improvement
 // proposed by Morita et al. (2001)
-func (dat *DaTokenizer) xCheckSkip(symbols []int) uint32 {
+func (dat *DaTokenizer) xCheckSkip(symbols []int) uint64 {
 
 	// Start at the first entry of the double array list
-	base := uint32(math.Abs(float64(dat.maxSize-1) * .9))
+	base := uint64(math.Abs(float64(dat.maxSize-1) * .9))
 
 OVERLAP:
 	// Resize the array if necessary
 	dat.resize(int(base) + dat.final)
 	for _, a := range symbols {
-		if dat.array[int(base)+a].getCheck() != 0 {
+		if dat.getCheck(base+uint64(a)) != 0 {
 			base++
 			goto OVERLAP
 		}
@@ -378,21 +560,21 @@ OVERLAP:
 // This is an implementation of xCheck with the skip-improvement
 // proposed by Morita et al. (2001) for higher outdegrees as
 // proposed by Niu et al. (2013)
-func (dat *DaTokenizer) xCheckSkipNiu(symbols []int) uint32 {
+func (dat *DaTokenizer) xCheckSkipNiu(symbols []int) uint64 {
 
 	// Start at the first entry of the double array list
-	base := uint32(1)
+	base := uint64(1)
 
 	// Or skip the first few entries
 	if len(symbols) >= 3 {
-		base = uint32(math.Abs(float64(dat.maxSize-1)*.9)) + 1
+		base = uint64(math.Abs(float64(dat.maxSize-1)*.9)) + 1
 	}
 
 OVERLAP:
 	// Resize the array if necessary
 	dat.resize(int(base) + dat.final + 1)
 	for _, a := range symbols {
-		if dat.array[int(base)+a].getCheck() != 0 {
+		if dat.getCheck(base+uint64(a)) != 0 {
 			base++
 			goto OVERLAP
 		}
@@ -402,17 +584,17 @@ OVERLAP:
 
 // This is an implementation of xCheck wit an improvement
 // proposed by Niu et al. (2013)
-func (dat *DaTokenizer) xCheckNiu(symbols []int, block_begin_pos *uint32) uint32 {
+func (dat *DaTokenizer) xCheckNiu(symbols []int, block_begin_pos *uint64) uint64 {
 
 	// Start at the first entry of the double array list
-	base := uint32(1)
+	base := uint64(1)
 
 	if len(symbols) > 3 {
 		sort.Ints(symbols)
-		if *block_begin_pos > uint32(symbols[0]) {
+		if *block_begin_pos > uint64(symbols[0]) {
 			dat.resize(int(*block_begin_pos) + dat.final)
-			*block_begin_pos += uint32(symbols[len(symbols)-1] + 1)
-			return *block_begin_pos - uint32(symbols[0])
+			*block_begin_pos += uint64(symbols[len(symbols)-1] + 1)
+			return *block_begin_pos - uint64(symbols[0])
 		}
 	}
 
@@ -420,7 +602,7 @@ OVERLAP:
 	// Resize the array if necessary
 	dat.resize(int(base) + dat.final)
 	for _, a := range symbols {
-		if dat.array[int(base)+a].getCheck() != 0 {
+		if dat.getCheck(base+uint64(a)) != 0 {
 			base++
 			goto OVERLAP
 		}
@@ -428,26 +610,158 @@ OVERLAP:
 	return base
 }
 
+// The empty link method (ELM) proposed by Morita et al. (2001)
+// keeps all empty elements of the double array in a doubly linked
+// list, so the search for a free base only needs to check
+// positions that are guaranteed to be empty for the first symbol.
+// The list is only necessary in the construction phase and
+// is therefore not serialized.
+type elm struct {
+	next []int
+	prev []int
+
+	// An empty element to skip the dense beginning
+	// of the double array for states with
+	// high outdegrees (see xCheckSkipNiu)
+	skip int
+}
+
+// Extend the list of empty elements to cover l elements.
+// The element 0 serves as the head of the cyclic list,
+// the element 1 is reserved for the initial state.
+func (free *elm) extend(l int) {
+	if len(free.next) == 0 {
+		free.next = append(free.next, 0, 0)
+		free.prev = append(free.prev, 0, 0)
+	}
+
+	for x := len(free.next); x < l; x++ {
+		last := free.prev[0]
+		free.next = append(free.next, 0)
+		free.prev = append(free.prev, last)
+		free.next[last] = x
+		free.prev[0] = x
+	}
+}
+
+// Return the first empty element at or after position p.
+// As the list is ordered by position and p is expected to
+// grow monotonically, the search continues at the last
+// returned element.
+func (free *elm) from(p int) int {
+	e := free.skip
+	if e == 0 {
+		e = free.next[0]
+	}
+	for e != 0 && e < p {
+		e = free.next[e]
+	}
+	free.skip = e
+	return e
+}
+
+// Remove an element from the list of empty elements
+func (free *elm) remove(x int) {
+	if free.next[x] == 0 && free.prev[x] == 0 && free.next[0] != x {
+		return
+	}
+	if free.skip == x {
+		free.skip = free.prev[x]
+	}
+	free.next[free.prev[x]] = free.next[x]
+	free.prev[free.next[x]] = free.prev[x]
+	free.next[x] = 0
+	free.prev[x] = 0
+}
+
+// This is an implementation of xCheck based on the
+// empty link method (ELM) proposed by Morita et al. (2001).
+// Instead of checking every position in the double array,
+// only the empty elements are tried for the smallest symbol.
+// Like in xCheckSkipNiu, states with higher outdegrees
+// skip the first entries of the double array.
+func (dat *DaTokenizer) xCheckELM(symbols []int, free *elm) uint64 {
+
+	if len(symbols) == 0 {
+		return 1
+	}
+
+	// Find the smallest symbol
+	min := symbols[0]
+	for _, a := range symbols[1:] {
+		if a < min {
+			min = a
+		}
+	}
+
+	e := free.next[0]
+	if len(symbols) >= 3 {
+		e = free.from(int(float64(dat.maxSize-1) * .9))
+	}
+
+OVERLAP:
+	// No more empty elements - so resize the array
+	if e == 0 {
+		l := len(free.next)
+		dat.resize(l + dat.final + 1)
+		free.extend(dat.length())
+		e = l
+	}
+
+	// Bases need to be positive
+	if e <= min {
+		e = free.next[e]
+		goto OVERLAP
+	}
+
+	base := e - min
+
+	// Resize the array if necessary
+	if base+dat.final+1 >= dat.length() {
+		dat.resize(base + dat.final + 1)
+		free.extend(dat.length())
+	}
+
+	for _, a := range symbols {
+		if dat.getCheck(uint64(base+a)) != 0 {
+			e = free.next[e]
+			goto OVERLAP
+		}
+	}
+
+	return uint64(base)
+}
+
 // List all outgoing transitions for a state
 // for testing purposes
-func (dat *DaTokenizer) outgoing(t uint32) []int {
+func (dat *DaTokenizer) outgoing(t uint64) []int {
 
 	valid := make([]int, 0, len(dat.sigma))
 
+	// Check if a transition is valid in the double array
+	transition := func(a int) bool {
+		t1 := dat.getBase(t) + uint64(a)
+		return t1 <= dat.getCheck(1) && dat.getCheck(t1) == t
+	}
+
 	for _, a := range dat.sigma {
-		t1 := dat.array[t].getBase() + uint32(a)
-		if t1 <= dat.array[1].getCheck() && dat.array[t1].getCheck() == t {
+		if transition(a) {
 			valid = append(valid, a)
 		}
 	}
 
-	for _, a := range []int{dat.epsilon, dat.unknown, dat.identity, dat.final} {
-		t1 := dat.array[t].getBase() + uint32(a)
-		if t1 <= dat.array[1].getCheck() && dat.array[t1].getCheck() == t {
+	for _, a := range append([]int{dat.epsilon, dat.unknown, dat.identity, dat.final}, dat.categories[1:]...) {
+		if a != 0 && transition(a) {
 			valid = append(valid, -1*a)
 		}
 	}
 
+	for _, sb := range dat.softBounds {
+		if transition(sb.sym) {
+			valid = append(valid, -1*sb.sym)
+		}
+	}
+
 	sort.Ints(valid)
 
 	return valid
@@ -462,10 +776,11 @@ func (dat *DaTokenizer) TransCount() int {
 	}
 
 	dat.transCount = 0
-	for x := 1; x < len(dat.array); x++ {
+
+	for x := 1; x < dat.length(); x++ {
 
 		// Hopefully branchless
-		if dat.array[x].getBase() != 0 {
+		if dat.getBase(uint64(x)) != 0 {
 			dat.transCount++
 		}
 	}
@@ -476,7 +791,7 @@ func (dat *DaTokenizer) TransCount() int {
 // LoadFactor as defined in Kanda et al (2018),
 // i.e. the proportion of non-empty elements to all elements.
 func (dat *DaTokenizer) LoadFactor() float64 {
-	return float64(dat.TransCount()) / float64(len(dat.array)) * 100
+	return float64(dat.TransCount()) / float64(dat.length()) * 100
 }
 
 // Save stores the double array data in a file
@@ -505,7 +820,7 @@ func (dat *DaTokenizer) WriteTo(w io.Writer) (n int64, err error) {
 	defer wb.Flush()
 
 	// Store magical header
-	all, err := wb.Write([]byte(DAMAGIC))
+	all, err := wb.Write([]byte(dat.Type()))
 	if err != nil {
 		log.Println(err)
 		return int64(all), err
@@ -526,15 +841,26 @@ func (dat *DaTokenizer) WriteTo(w io.Writer) (n int64, err error) {
 
 	sigmalist = sigmalist[:max+1]
 
-	buf := make([]byte, 0, 16)
-	bo.PutUint16(buf[0:2], VERSION)
+	version := VERSION
+	if dat.categories.defined() || len(dat.rewrites) > 0 || len(dat.softBounds) > 0 {
+		version = EXTVERSION
+	}
+
+	buf := make([]byte, 0, 20)
+	bo.PutUint16(buf[0:2], version)
 	bo.PutUint16(buf[2:4], uint16(dat.epsilon))
 	bo.PutUint16(buf[4:6], uint16(dat.unknown))
 	bo.PutUint16(buf[6:8], uint16(dat.identity))
 	bo.PutUint16(buf[8:10], uint16(dat.final))
 	bo.PutUint16(buf[10:12], uint16(len(sigmalist)))
-	bo.PutUint32(buf[12:16], uint32(len(dat.array)*2)) // Legacy support
-	more, err := wb.Write(buf[0:16])
+	headerSize := 16
+	if dat.array64 != nil {
+		bo.PutUint64(buf[12:20], uint64(len(dat.array64)))
+		headerSize = 20
+	} else {
+		bo.PutUint32(buf[12:16], uint32(len(dat.array)*2)) // Legacy support
+	}
+	more, err := wb.Write(buf[0:headerSize])
 	if err != nil {
 		log.Println(err)
 		return int64(all), err
@@ -542,6 +868,29 @@ func (dat *DaTokenizer) WriteTo(w io.Writer) (n int64, err error) {
 
 	all += more
 
+	if version == EXTVERSION {
+		more, err = dat.categories.writeTo(wb)
+		if err != nil {
+			log.Println(err)
+			return int64(all), err
+		}
+		all += more
+
+		more, err = dat.rewrites.writeTo(wb)
+		if err != nil {
+			log.Println(err)
+			return int64(all), err
+		}
+		all += more
+
+		more, err = dat.softBounds.writeTo(wb)
+		if err != nil {
+			log.Println(err)
+			return int64(all), err
+		}
+		all += more
+	}
+
 	// Write sigma
 	for _, sym := range sigmalist {
 
@@ -566,6 +915,25 @@ func (dat *DaTokenizer) WriteTo(w io.Writer) (n int64, err error) {
 	}
 	all += more
 
+	// Write 64 bit elements
+	if dat.array64 != nil {
+		for _, bc := range dat.array64 {
+			bo.PutUint64(buf[0:8], bc.base)
+			bo.PutUint64(buf[8:16], bc.check)
+			more, err = wb.Write(buf[0:16])
+			if err != nil {
+				log.Println(err)
+				return int64(all), err
+			}
+			all += more
+			if more != 16 {
+				log.Println("Can not write uint64 elements")
+				return int64(all), err
+			}
+		}
+		return int64(all), err
+	}
+
 	// for x := 0; x < len(dat.array); x++ {
 	for _, bc := range dat.array {
 		bo.PutUint32(buf[0:4], bc.base)
@@ -642,25 +1010,32 @@ func ParseDatok(ior io.Reader) *DaTokenizer {
 		return nil
 	}
 
-	if string(DAMAGIC) != string(buf) {
+	wide := string(DA64MAGIC) == string(buf)
+
+	if string(DAMAGIC) != string(buf) && !wide {
 		log.Println("Not a datok file")
 		return nil
 	}
 
-	more, err := io.ReadFull(r, buf[0:16])
+	headerSize := 16
+	if wide {
+		headerSize = 20
+	}
+
+	more, err := io.ReadFull(r, buf[0:headerSize])
 	if err != nil {
 		log.Println(err)
 		return nil
 	}
 
-	if more != 16 {
+	if more != headerSize {
 		log.Println("Read bytes do not fit")
 		return nil
 	}
 
 	version := bo.Uint16(buf[0:2])
 
-	if version != VERSION {
+	if version != VERSION && version != EXTVERSION {
 		log.Println("Version not compatible")
 		return nil
 	}
@@ -671,15 +1046,35 @@ func ParseDatok(ior io.Reader) *DaTokenizer {
 	dat.final = int(bo.Uint16(buf[8:10]))
 
 	sigmaCount := int(bo.Uint16(buf[10:12]))
-	arraySize := int(bo.Uint32(buf[12:16])) / 2 // Legacy support
+	var arraySize int
+	if wide {
+		arraySize = int(bo.Uint64(buf[12:20]))
+	} else {
+		arraySize = int(bo.Uint32(buf[12:16])) / 2 // Legacy support
+	}
 
 	// Shouldn't be relevant though
 	dat.maxSize = arraySize - 1
 
-	// Init with identity
+	if version == EXTVERSION {
+		if err = dat.categories.readFrom(r); err != nil {
+			log.Println(err)
+			return nil
+		}
+		if err = dat.rewrites.readFrom(r); err != nil {
+			log.Println(err)
+			return nil
+		}
+		if err = dat.softBounds.readFrom(r); err != nil {
+			log.Println(err)
+			return nil
+		}
+	}
+
+	// Init with identity or category symbols
 	if dat.identity != -1 {
 		for i := 0; i < 256; i++ {
-			dat.sigmaASCII[i] = dat.identity
+			dat.sigmaASCII[i] = dat.categories.fallback(rune(i), dat.identity)
 		}
 	}
 
@@ -705,9 +1100,6 @@ func ParseDatok(ior io.Reader) *DaTokenizer {
 		return nil
 	}
 
-	// Read based on length
-	dat.array = make([]bc, arraySize)
-
 	dataArray, err := io.ReadAll(r)
 
 	if err == io.EOF {
@@ -715,6 +1107,24 @@ func ParseDatok(ior io.Reader) *DaTokenizer {
 		return nil
 	}
 
+	// Read 64 bit elements
+	if wide {
+		if len(dataArray) < arraySize*16 {
+			log.Println("Not enough bytes read")
+			return nil
+		}
+
+		dat.array64 = make([]bc64, arraySize)
+		for x := 0; x < arraySize; x++ {
+			dat.array64[x].base = bo.Uint64(dataArray[x*16 : (x*16)+8])
+			dat.array64[x].check = bo.Uint64(dataArray[(x*16)+8 : (x*16)+16])
+		}
+		return dat
+	}
+
+	// Read based on length
+	dat.array = make([]bc, arraySize)
+
 	if len(dataArray) < arraySize*8 {
 		log.Println("Not enough bytes read")
 		return nil
@@ -779,16 +1189,60 @@ func (dat *DaTokenizer) Transduce(r io.Reader, w io.Writer) bool {
 // with additional support for IDENTITY, UNKNOWN
 // and EPSILON transitions and NONTOKEN and TOKENEND handling.
 func (dat *DaTokenizer) TransduceTokenWriter(r io.Reader, w *TokenWriter) bool {
+	if dat.array64 != nil {
+		return transduce(dat, dat.array64, r, w)
+	}
+	return transduce(dat, dat.array, r, w)
+}
+
+// Transduce the input against the elements of the double array,
+// instantiated for 32 and 64 bit elements, so the transduction
+// doesn't need to check the representation on every access.
+func transduce[U uint32 | uint64](dat *DaTokenizer, array []element[U], r io.Reader, w *TokenWriter) bool {
 	var a int
-	var t0 uint32
-	t := uint32(1) // Initial state
+	var t0 U
+	t := U(1) // Initial state
+
+	// The size of the double array is stored in check(1)
+	size := array[1].getCheck()
 	var ok, rewindBuffer bool
 
 	// Remember the last position of a possible tokenend,
 	// in case the automaton fails.
-	epsilonState := uint32(0)
+	epsilonState := U(0)
 	epsilonOffset := 0
 
+	// Remember the last position of a soft bound,
+	// in case the automaton fails.
+	softState := U(0)
+	softOffset := 0
+	softSplits := 0
+	softNext := 0
+	softSym := -1
+	softActive := false
+
+	// The soft bounds ending tokens, selected per transduction
+	softSelected, valid := dat.softBounds.activate(w.SoftBounds)
+	if !valid {
+		return false
+	}
+
+	// Keep the per character loop free of unused features
+	if len(dat.softBounds) == 0 && w.NormalizedToken == nil && !w.Graphemes && w.Coverage == nil && w.Skip == nil {
+		return transducePlain(dat, array, r, w)
+	}
+
+	// Counts for coverage reports, if requested
+	cov := w.Coverage
+	if cov != nil && cov.Transitions == nil {
+		cov.Transitions = make([]uint64, len(array))
+	}
+
+	// Remember the positions of active soft bounds
+	// passed in the current token
+	var splits []int
+	epsilonSplits := 0
+
 	// Remember if the last transition was epsilon
 	sentenceEnd := false
 
@@ -814,6 +1268,23 @@ func (dat *DaTokenizer) TransduceTokenWriter(r io.Reader, w *TokenWriter) bool {
 	buffc := 0 // Buffer current symbol
 	buffi := 0 // Buffer length
 
+	// Remember the outputs of the characters in the buffer,
+	// in case the normalized forms are requested
+	normalized := w.NormalizedToken != nil
+	var outs [][]rune
+	var norm []rune
+	if normalized {
+		outs = make([][]rune, 1024)
+		norm = make([]rune, 0, 1024)
+	}
+
+	// Remember the boundaries of grapheme clusters,
+	// in case tokens should not end inside of clusters
+	var clusters *clusterBuffer
+	if w.Graphemes {
+		clusters = newClusterBuffer(len(buffer))
+	}
+
 	// The buffer is organized as follows:
 	// [   t[....c..]..i]
 
@@ -844,6 +1315,9 @@ PARSECHAR:
 					break
 				}
 				buffer[buffi] = char
+				if clusters != nil {
+					clusters.read(buffi, char)
+				}
 				buffi++
 			}
 
@@ -864,47 +1338,64 @@ PARSECHAR:
 			} else {
 				a, ok = dat.sigma[char]
 
-				// Use identity symbol if character is not in sigma
+				// Use category or identity symbol if character is not in sigma
 				if !ok && dat.identity != -1 {
-					a = dat.identity
+					a = dat.categories.fallback(char, dat.identity)
 				}
 			}
 
 			t0 = t
 
 			// Check for epsilon transitions and remember
-			if dat.array[dat.array[t0].getBase()+uint32(dat.epsilon)].getCheck() == t0 {
+			if array[array[t0].getBase()+U(dat.epsilon)].getCheck() == t0 {
 
 				// Remember state for backtracking to last tokenend state
 				epsilonState = t0
 				epsilonOffset = buffc
+				epsilonSplits = len(splits)
 
 				if DEBUG {
 					log.Println("epsilonOffset is set to", buffc)
 				}
 			}
+
+			// Check for soft bound transitions and remember
+			for i, sb := range dat.softBounds {
+				t1 := array[t0].getBase() + U(sb.sym)
+				if t1 <= size && array[t1].getCheck() == t0 {
+					softState = t0
+					softOffset = buffc
+					softSplits = len(splits)
+					softNext = i
+					break
+				}
+			}
 		}
 
 		// Checks a transition based on t0, a and buffo
-		t = dat.array[t0].getBase() + uint32(a)
-		ta := dat.array[t]
+		t = array[t0].getBase() + U(a)
 
 		if DEBUG {
 			// Char is only relevant if set
 			log.Println("Check", t0, "-", a, "(", string(char), ")", "->", t)
 			if false {
-				log.Println(dat.outgoing(t0))
+				log.Println(dat.outgoing(uint64(t0)))
 			}
 		}
 
 		// Check if the transition is invalid according to the double array
-		if t > dat.array[1].getCheck() || ta.getCheck() != t0 {
+		if t > size || array[t].getCheck() != t0 {
 
 			if DEBUG {
-				log.Println("Match is not fine!", t, "and", ta.getCheck(), "vs", t0)
+				log.Println("Match is not fine!", t, "and", array[t].getCheck(), "vs", t0)
 			}
 
-			if !ok && a == dat.identity {
+			if dat.categories.has(a) {
+
+				// Try again with identity symbol, in case the category failed
+				a = dat.identity
+
+			} else if !ok && a == dat.identity {
 
 				// Try again with unknown symbol, in case identity failed
 				// Char is only relevant when set
@@ -913,14 +1404,48 @@ PARSECHAR:
 				}
 				a = dat.unknown
 
+				if cov != nil {
+					cov.Unknown++
+				}
+
+			} else if a != dat.epsilon && softState != 0 && (epsilonState == 0 || softOffset >= epsilonOffset) {
+
+				// Try again with the soft bound symbols, in case everything
+				// else failed, before the token is ended at the same position
+				t0 = softState
+				buffc = softOffset
+				splits = splits[:softSplits]
+				softSym = dat.softBounds[softNext].sym
+				softActive = softSelected[softNext]
+				a = softSym
+
+				// Try further soft bounds of the state, if this fails
+				softNext++
+				if softNext == len(dat.softBounds) {
+					softState = 0 // reset
+				}
+
+				if cov != nil {
+					cov.Backtracks++
+				}
+
+				if DEBUG {
+					log.Println("Get from soft bound and set buffo!", showBufferNew(buffer, bufft, buffc, buffi))
+				}
+
 			} else if a != dat.epsilon && epsilonState != 0 {
 
 				// Try again with epsilon symbol, in case everything else failed
 				t0 = epsilonState
 				epsilonState = 0 // reset
 				buffc = epsilonOffset
+				splits = splits[:epsilonSplits]
 				a = dat.epsilon
 
+				if cov != nil {
+					cov.Backtracks++
+				}
+
 				if DEBUG {
 					log.Println("Get from epsilon stack and set buffo!", showBufferNew(buffer, bufft, buffc, buffi))
 				}
@@ -931,6 +1456,10 @@ PARSECHAR:
 					log.Println("Fail!")
 				}
 
+				if cov != nil {
+					cov.Fails++
+				}
+
 				// w.Fail(bufft)
 
 				// The following procedure means the automaton fails to consume a certain character.
@@ -945,12 +1474,25 @@ PARSECHAR:
 						eof = true
 						break
 					}
+					if normalized {
+						outs[buffc-1] = nil
+					}
+				}
+
+				// Never end the token inside of a grapheme cluster
+				if clusters != nil {
+					bufft, buffc, buffi, eof = clusters.extend(reader, buffer, outs, bufft, buffc, buffi, eof)
 				}
 
 				if DEBUG {
 					log.Println("-> Flush buffer: [", string(buffer[bufft:buffc]), "]", showBufferNew(buffer, bufft, buffc, buffi))
 				}
-				w.Token(bufft, buffer[:buffc])
+				if normalized {
+					norm = normalize(norm, buffer[bufft:buffc], outs[bufft:buffc])
+					w.NormalizedToken(bufft, buffer[:buffc], norm)
+				} else {
+					w.Token(bufft, buffer[:buffc])
+				}
 
 				sentenceEnd = false
 				textEnd = false
@@ -960,9 +1502,14 @@ PARSECHAR:
 				}
 
 				copy(buffer[0:], buffer[buffc:buffi])
+				if clusters != nil {
+					clusters.rewind(buffc, buffi)
+				}
 
 				buffi -= buffc
 				epsilonState = 0
+				softState = 0
+				splits = splits[:0]
 
 				buffc = 0
 				bufft = 0
@@ -970,7 +1517,7 @@ PARSECHAR:
 				a = dat.epsilon
 
 				// Restart from root state
-				t = uint32(1)
+				t = 1
 				newchar = true
 				// goto PARSECHARM
 				continue
@@ -984,14 +1531,27 @@ PARSECHAR:
 		// Transition was successful
 		rewindBuffer = false
 
+		if cov != nil {
+			cov.Transitions[t]++
+		}
+
 		// Transition consumes a character
-		if a != dat.epsilon {
+		if a != dat.epsilon && a != softSym {
 
 			buffc++
 
+			// Remember the output of the character
+			if normalized {
+				if array[t].isNonToken() {
+					outs[buffc-1] = deleted
+				} else {
+					outs[buffc-1] = dat.rewrites[uint64(t)]
+				}
+			}
+
 			// Transition does not produce a character
 			// Hopefully this is branchless
-			if buffc-bufft == 1 && ta.isNonToken() {
+			if buffc-bufft == 1 && array[t].isNonToken() {
 				if DEBUG {
 					log.Println("Nontoken forward", showBufferNew(buffer, bufft, buffc, buffi))
 				}
@@ -999,14 +1559,35 @@ PARSECHAR:
 				// rewindBuffer = true
 			}
 
+		} else if a == softSym {
+
+			// Active soft bounds split the token,
+			// once the token is ended by a token bound
+			if softActive && buffc > bufft && (clusters == nil || clusters.breaks[buffc]) {
+				splits = append(splits, buffc)
+			}
+
 		} else {
 
 			// Transition marks the end of a token - so flush the buffer
 			if buffc-bufft > 0 {
+				// Never end the token inside of a grapheme cluster
+				if clusters != nil {
+					bufft, buffc, buffi, eof = clusters.extend(reader, buffer, outs, bufft, buffc, buffi, eof)
+				}
+
 				if DEBUG {
 					log.Println("-> Flush buffer: [", string(buffer[bufft:buffc]), "]", showBuffer(buffer, buffc, buffi))
 				}
-				w.Token(bufft, buffer[:buffc])
+				if len(splits) > 0 {
+					norm = splitToken(w, buffer, bufft, buffc, splits, outs, norm)
+					splits = splits[:0]
+				} else if normalized {
+					norm = normalize(norm, buffer[bufft:buffc], outs[bufft:buffc])
+					w.NormalizedToken(bufft, buffer[:buffc], norm)
+				} else {
+					w.Token(bufft, buffer[:buffc])
+				}
 				rewindBuffer = true
 				sentenceEnd = false
 				textEnd = false
@@ -1018,6 +1599,12 @@ PARSECHAR:
 
 		if eot {
 			eot = false
+
+			// Pass the skipped characters including EOT in lossless mode
+			if w.Skip != nil {
+				passSkipped(w, buffer[:buffc])
+				rewindBuffer = true
+			}
 			if !sentenceEnd {
 				sentenceEnd = true
 				w.SentenceEnd(buffc)
@@ -1038,11 +1625,16 @@ PARSECHAR:
 
 			// TODO: Better as a ring buffer
 			copy(buffer[0:], buffer[buffc:buffi])
+			if clusters != nil {
+				clusters.rewind(buffc, buffi)
+			}
 
 			buffi -= buffc
 			// epsilonOffset -= buffo
 			epsilonOffset = 0
 			epsilonState = 0
+			softState = 0
+			splits = splits[:0]
 
 			buffc = 0
 			bufft = 0
@@ -1053,9 +1645,8 @@ PARSECHAR:
 		}
 
 		// Move to representative state
-		if ta.isSeparate() {
-			t = ta.getBase()
-			ta = dat.array[t]
+		if array[t].isSeparate() {
+			t = array[t].getBase()
 
 			if DEBUG {
 				log.Println("Representative pointing to", t)
@@ -1072,7 +1663,358 @@ PARSECHAR:
 	// Input reader is not yet finished
 	if !eof {
 		if DEBUG {
-			log.Println("Not at the end - problem", t0, ":", dat.outgoing(t0))
+			log.Println("Not at the end - problem", t0, ":", dat.outgoing(uint64(t0)))
+		}
+		// This should never happen
+		return false
+	}
+
+	if DEBUG {
+		log.Println("Entering final check")
+	}
+
+	// Check epsilon transitions as long as possible
+	t0 = t
+	t = array[t0].getBase() + U(dat.epsilon)
+	a = dat.epsilon
+	newchar = false
+
+	if array[t].getCheck() == t0 {
+		// Remember state for backtracking to last tokenend state
+		goto PARSECHAR
+
+	} else if epsilonState != 0 {
+		t0 = epsilonState
+		epsilonState = 0 // reset
+		buffc = epsilonOffset
+		splits = splits[:epsilonSplits]
+		if cov != nil {
+			cov.Backtracks++
+		}
+		if DEBUG {
+			log.Println("Get from epsilon stack and set buffo!", showBufferNew(buffer, bufft, buffc, buffi))
+		}
+		goto PARSECHAR
+	}
+
+	// something left in buffer
+	if buffc-bufft > 0 {
+		// Never end the token inside of a grapheme cluster
+		if clusters != nil {
+			bufft, buffc, buffi, eof = clusters.extend(reader, buffer, outs, bufft, buffc, buffi, eof)
+		}
+
+		if DEBUG {
+			log.Println("-> Flush buffer: [", string(buffer[bufft:buffc]), "]", showBufferNew(buffer, bufft, buffc, buffi))
+		}
+		if normalized {
+			norm = normalize(norm, buffer[bufft:buffc], outs[bufft:buffc])
+			w.NormalizedToken(bufft, buffer[:buffc], norm)
+		} else {
+			w.Token(bufft, buffer[:buffc])
+		}
+		sentenceEnd = false
+		textEnd = false
+
+		// Pass the remaining skipped characters in lossless mode
+	} else if w.Skip != nil && buffc > 0 {
+		passSkipped(w, buffer[:buffc])
+	}
+
+	// Add an additional sentence ending, if the file is over but no explicit
+	// sentence split was reached. This may be controversial and therefore
+	// optional via parameter.
+	if !sentenceEnd {
+		w.SentenceEnd(0)
+
+		if DEBUG {
+			log.Println("Sentence end")
+		}
+	}
+
+	if !textEnd {
+		w.TextEnd(0)
+
+		if DEBUG {
+			log.Println("Text end")
+		}
+	}
+
+	return true
+}
+
+// Transduce the input against the elements of the double array
+// without soft bounds, normalized forms, grapheme clusters,
+// coverage counts or skipped characters.
+// This keeps the features out of the most common path.
+func transducePlain[U uint32 | uint64](dat *DaTokenizer, array []element[U], r io.Reader, w *TokenWriter) bool {
+	var a int
+	var t0 U
+	t := U(1) // Initial state
+
+	// The size of the double array is stored in check(1)
+	size := array[1].getCheck()
+	var ok, rewindBuffer bool
+
+	// Remember the last position of a possible tokenend,
+	// in case the automaton fails.
+	epsilonState := U(0)
+	epsilonOffset := 0
+
+	// Remember if the last transition was epsilon
+	sentenceEnd := false
+
+	// Remember if a text end was already set
+	textEnd := false
+
+	// The buffer is organized as follows
+	// (see transduce):
+	// [   t[....c..]..i]
+	buffer := make([]rune, 1024)
+	bufft := 0 // Buffer token offset
+	buffc := 0 // Buffer current symbol
+	buffi := 0 // Buffer length
+
+	reader := bufio.NewReader(r)
+	defer w.Flush()
+
+	var char rune
+
+	var err error
+	eof := false
+	eot := false
+	newchar := true
+
+PARSECHAR:
+	for {
+
+		if newchar {
+			// Get from reader if buffer is empty
+			if buffc >= buffi {
+				if eof {
+					break
+				}
+				char, _, err = reader.ReadRune()
+
+				// No more runes to read
+				if err != nil {
+					eof = true
+					break
+				}
+				buffer[buffi] = char
+				buffi++
+			}
+
+			char = buffer[buffc]
+
+			if DEBUG {
+				log.Println("Current char", string(char), int(char), showBufferNew(buffer, bufft, buffc, buffi))
+			}
+
+			eot = false
+
+			if int(char) < 256 {
+				eot = int(char) == EOT
+				a = dat.sigmaASCII[int(char)]
+			} else {
+				a, ok = dat.sigma[char]
+
+				// Use category or identity symbol if character is not in sigma
+				if !ok && dat.identity != -1 {
+					a = dat.categories.fallback(char, dat.identity)
+				}
+			}
+
+			t0 = t
+
+			// Check for epsilon transitions and remember
+			if array[array[t0].getBase()+U(dat.epsilon)].getCheck() == t0 {
+
+				// Remember state for backtracking to last tokenend state
+				epsilonState = t0
+				epsilonOffset = buffc
+
+				if DEBUG {
+					log.Println("epsilonOffset is set to", buffc)
+				}
+			}
+		}
+
+		// Checks a transition based on t0, a and buffo
+		t = array[t0].getBase() + U(a)
+
+		if DEBUG {
+			// Char is only relevant if set
+			log.Println("Check", t0, "-", a, "(", string(char), ")", "->", t)
+		}
+
+		// Check if the transition is invalid according to the double array
+		if t > size || array[t].getCheck() != t0 {
+
+			if DEBUG {
+				log.Println("Match is not fine!", t, "and", array[t].getCheck(), "vs", t0)
+			}
+
+			if dat.categories.has(a) {
+
+				// Try again with identity symbol, in case the category failed
+				a = dat.identity
+
+			} else if !ok && a == dat.identity {
+
+				// Try again with unknown symbol, in case identity failed
+				// Char is only relevant when set
+				if DEBUG {
+					log.Println("UNKNOWN symbol", string(char), "->", dat.unknown)
+				}
+				a = dat.unknown
+
+			} else if a != dat.epsilon && epsilonState != 0 {
+
+				// Try again with epsilon symbol, in case everything else failed
+				t0 = epsilonState
+				epsilonState = 0 // reset
+				buffc = epsilonOffset
+				a = dat.epsilon
+
+				if DEBUG {
+					log.Println("Get from epsilon stack and set buffo!", showBufferNew(buffer, bufft, buffc, buffi))
+				}
+
+			} else {
+
+				if DEBUG {
+					log.Println("Fail!")
+				}
+
+				// The automaton fails to consume a certain character,
+				// so the old or current data is dropped as a token
+				// (see transduce)
+				if buffc-bufft <= 0 {
+					buffc++
+					if buffc == 0 {
+						eof = true
+						break
+					}
+				}
+
+				if DEBUG {
+					log.Println("-> Flush buffer: [", string(buffer[bufft:buffc]), "]", showBufferNew(buffer, bufft, buffc, buffi))
+				}
+				w.Token(bufft, buffer[:buffc])
+
+				sentenceEnd = false
+				textEnd = false
+
+				if DEBUG {
+					log.Println("-> Rewind buffer", bufft, buffc, buffi, epsilonOffset)
+				}
+
+				copy(buffer[0:], buffer[buffc:buffi])
+
+				buffi -= buffc
+				epsilonState = 0
+
+				buffc = 0
+				bufft = 0
+
+				a = dat.epsilon
+
+				// Restart from root state
+				t = 1
+				newchar = true
+				continue
+			}
+
+			newchar = false
+			eot = false
+			continue
+		}
+
+		// Transition was successful
+		rewindBuffer = false
+
+		// Transition consumes a character
+		if a != dat.epsilon {
+
+			buffc++
+
+			// Transition does not produce a character
+			// Hopefully this is branchless
+			if buffc-bufft == 1 && array[t].isNonToken() {
+				if DEBUG {
+					log.Println("Nontoken forward", showBufferNew(buffer, bufft, buffc, buffi))
+				}
+				bufft++
+			}
+
+		} else {
+
+			// Transition marks the end of a token - so flush the buffer
+			if buffc-bufft > 0 {
+				if DEBUG {
+					log.Println("-> Flush buffer: [", string(buffer[bufft:buffc]), "]", showBuffer(buffer, buffc, buffi))
+				}
+				w.Token(bufft, buffer[:buffc])
+				rewindBuffer = true
+				sentenceEnd = false
+				textEnd = false
+			} else {
+				sentenceEnd = true
+				w.SentenceEnd(0)
+			}
+		}
+
+		if eot {
+			eot = false
+			if !sentenceEnd {
+				sentenceEnd = true
+				w.SentenceEnd(buffc)
+			}
+			textEnd = true
+			w.TextEnd(0)
+			if DEBUG {
+				log.Println("END OF TEXT")
+			}
+		}
+
+		// Rewind the buffer if necessary
+		if rewindBuffer {
+
+			if DEBUG {
+				log.Println("-> Rewind buffer", bufft, buffc, buffi, epsilonOffset)
+			}
+
+			copy(buffer[0:], buffer[buffc:buffi])
+
+			buffi -= buffc
+			epsilonOffset = 0
+			epsilonState = 0
+
+			buffc = 0
+			bufft = 0
+
+			if DEBUG {
+				log.Println("Remaining:", showBufferNew(buffer, bufft, buffc, buffi))
+			}
+		}
+
+		// Move to representative state
+		if array[t].isSeparate() {
+			t = array[t].getBase()
+
+			if DEBUG {
+				log.Println("Representative pointing to", t)
+			}
+		}
+
+		newchar = true
+	}
+
+	// Input reader is not yet finished
+	if !eof {
+		if DEBUG {
+			log.Println("Not at the end - problem", t0, ":", dat.outgoing(uint64(t0)))
 		}
 		// This should never happen
 		return false
@@ -1084,11 +2026,11 @@ PARSECHAR:
 
 	// Check epsilon transitions as long as possible
 	t0 = t
-	t = dat.array[t0].getBase() + uint32(dat.epsilon)
+	t = array[t0].getBase() + U(dat.epsilon)
 	a = dat.epsilon
 	newchar = false
 
-	if dat.array[t].getCheck() == t0 {
+	if array[t].getCheck() == t0 {
 		// Remember state for backtracking to last tokenend state
 		goto PARSECHAR
 
@@ -0,0 +1,30 @@
+package datok
+
+// The 64 bit double array supports more than
+// 1.073.741.823 elements (30bit) at the cost of doubled
+// memory requirements. It is used, in case the
+// 32 bit representation would overflow.
+// The transducer is instantiated for both representations.
+
+const (
+	DA64MAGIC          = "DAT64"
+	FIRSTBIT64  uint64 = 1 << 63
+	SECONDBIT64 uint64 = 1 << 62
+	RESTBIT64   uint64 = ^uint64(0) &^ (FIRSTBIT64 | SECONDBIT64)
+)
+
+type bc64 = element[uint64]
+
+// Extend the 32 bit double array to a 64 bit double array,
+// in case the values don't fit in 30 bit
+func (dat *DaTokenizer) widen() {
+	dat.array64 = make([]bc64, len(dat.array))
+	for x, bc := range dat.array {
+		dat.array64[x].setBase(uint64(bc.getBase()))
+		dat.array64[x].setCheck(uint64(bc.getCheck()))
+		dat.array64[x].setSeparate(bc.isSeparate())
+		dat.array64[x].setNonToken(bc.isNonToken())
+		dat.array64[x].setTokenEnd(bc.isTokenEnd())
+	}
+	dat.array = nil
+}
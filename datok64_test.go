@@ -0,0 +1,124 @@
+package datok
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDoubleArray64SimpleTokenizer(t *testing.T) {
+	assert := assert.New(t)
+	tok := LoadFomaFile("testdata/simpletok.fst")
+
+	// Small double arrays are reduced to 32 bit
+	dat := tok.ToDoubleArray()
+	assert.Nil(dat.array64)
+	assert.Equal("DATOK", dat.Type())
+
+	dat64 := tok.toDoubleArray(nil, true)
+	assert.NotNil(dat64.array64)
+	assert.Nil(dat64.array)
+	assert.Equal("DAT64", dat64.Type())
+	assert.Equal(dat.GetSize(), dat64.GetSize())
+	assert.Equal(dat.TransCount(), dat64.TransCount())
+	assert.Equal(dat.LoadFactor(), dat64.LoadFactor())
+
+	assert.Equal(ttokenizeStr(dat64, "bau"), "bau")
+	assert.Equal(ttokenizeStr(dat64, "bad"), "bad")
+	assert.Equal(ttokenizeStr(dat64, "wald gehen"), "wald\ngehen")
+
+	str := " In den Wald gehen? -- Da kann\t man was \"erleben\"!"
+	assert.Equal(ttokenizeStr(dat, str), ttokenizeStr(dat64, str))
+
+	// Widening keeps all values and flags
+	array := dat.array
+	dat.widen()
+	assert.Nil(dat.array)
+	assert.Equal(len(array), len(dat.array64))
+	for x, bc := range array {
+		assert.Equal(uint64(bc.getBase()), dat.array64[x].getBase())
+		assert.Equal(uint64(bc.getCheck()), dat.array64[x].getCheck())
+		assert.Equal(bc.isSeparate(), dat.array64[x].isSeparate())
+		assert.Equal(bc.isNonToken(), dat.array64[x].isNonToken())
+		assert.Equal(bc.isTokenEnd(), dat.array64[x].isTokenEnd())
+	}
+	assert.Equal(ttokenizeStr(dat64, str), ttokenizeStr(dat, str))
+}
+
+func TestDoubleArray64Overflow(t *testing.T) {
+	assert := assert.New(t)
+	tok := LoadFomaFile("testdata/simpletok.fst")
+	dat := tok.ToDoubleArray()
+
+	// Simulate an overflow of the 32 bit representation
+	defer func(max uint64) { maxValue32 = max }(maxValue32)
+	maxValue32 = 10
+
+	dat64 := tok.ToDoubleArray()
+	assert.NotNil(dat64.array64)
+	assert.Nil(dat64.array)
+	assert.Equal(dat.GetSize(), dat64.GetSize())
+	assert.Equal(dat.TransCount(), dat64.TransCount())
+
+	str := " In den Wald gehen? -- Da kann\t man was \"erleben\"!"
+	assert.Equal(ttokenizeStr(dat, str), ttokenizeStr(dat64, str))
+}
+
+func TestDoubleArray64ReadWriteTokenizer(t *testing.T) {
+	assert := assert.New(t)
+	tok := LoadFomaFile("testdata/simpletok.fst")
+	dat := tok.toDoubleArray(nil, true)
+
+	b := make([]byte, 0, 1024)
+	buf := bytes.NewBuffer(b)
+	n, err := dat.WriteTo(buf)
+	assert.Nil(err)
+	assert.Equal(int64(n), int64(buf.Len()))
+
+	dat2 := ParseDatok(buf)
+	assert.NotNil(dat2)
+	assert.Equal(dat.array64, dat2.array64)
+	assert.Nil(dat2.array)
+	assert.Equal(dat.sigma, dat2.sigma)
+	assert.Equal(dat.epsilon, dat2.epsilon)
+	assert.Equal(dat.unknown, dat2.unknown)
+	assert.Equal(dat.identity, dat2.identity)
+	assert.Equal(dat.final, dat2.final)
+	assert.Equal(ttokenizeStr(dat2, "wald gehen"), "wald\ngehen")
+
+	// Load via the general tokenizer loader
+	file := filepath.Join(t.TempDir(), "simpletok64.datok")
+	_, err = dat.Save(file)
+	assert.Nil(err)
+
+	tok2 := LoadTokenizerFile(file)
+	assert.NotNil(tok2)
+	assert.Equal("DAT64", tok2.Type())
+	assert.Equal(ttokenizeStr(tok2, "wald gehen"), "wald\ngehen")
+
+	dat3 := LoadDatokFile(file)
+	assert.NotNil(dat3)
+	assert.Equal(dat.array64, dat3.array64)
+	os.Remove(file)
+}
+
+func TestDoubleArray64FullTokenizer(t *testing.T) {
+	assert := assert.New(t)
+	tok := LoadFomaFile("testdata/abbr_bench.fst")
+	dat := tok.ToDoubleArray()
+	dat64 := tok.toDoubleArray(nil, true)
+
+	b := make([]byte, 0, 2048)
+	w := bytes.NewBuffer(b)
+
+	assert.True(dat.Transduce(strings.NewReader(s), w))
+	datStr := w.String()
+
+	w.Reset()
+	assert.True(dat64.Transduce(strings.NewReader(s), w))
+	assert.Equal(datStr, w.String())
+}
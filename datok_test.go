@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -183,7 +184,9 @@ func TestDoubleArrayReadWriteTokenizer(t *testing.T) {
 	buf := bytes.NewBuffer(b)
 	n, err := dat.WriteTo(buf)
 	assert.Nil(err)
-	assert.Equal(int64(296), n)
+	// The empty link method places the states more densely than
+	// the skip method, so the array is one element (8 bytes) shorter
+	assert.Equal(int64(288), n)
 
 	dat2 := ParseDatok(buf)
 	assert.NotNil(dat2)
@@ -260,11 +263,34 @@ func TestDoubleArrayTokenizerBranch(t *testing.T) {
 	assert.Equal(tok.Type(), "MATOK")
 }
 
-func XTestDoubleArrayFullTokenizerBuild(t *testing.T) {
+func TestDoubleArrayFullTokenizerBuild(t *testing.T) {
+	// The construction of the full tokenizer takes several seconds
+	if testing.Short() {
+		t.Skip("skipping construction of the full tokenizer")
+	}
+
 	assert := assert.New(t)
 	tok := LoadFomaFile("testdata/tokenizer_de.fst")
-	dat := tok.ToDoubleArray()
+
+	calls := 0
+	dat := tok.ToDoubleArrayProgress(func(done, total int) {
+		assert.True(done <= total)
+		calls++
+	})
 	assert.NotNil(dat)
+	assert.True(calls > 1)
+	assert.True(dat.LoadFactor() >= 60)
+
+	b := make([]byte, 0, 2048)
+	w := bytes.NewBuffer(b)
+
+	assert.True(tok.ToMatrix().Transduce(strings.NewReader(s), w))
+	matStr := w.String()
+
+	w.Reset()
+	assert.True(dat.Transduce(strings.NewReader(s), w))
+	assert.Equal(matStr, w.String())
+
 	// n, err := dat.Save("testdata/tokenizer_de.datok")
 	// assert.Nil(err)
 	// assert.True(n > 500)
@@ -1285,6 +1311,58 @@ func TestDoubleArrayFullTokenizerXML(t *testing.T) {
 	assert.Equal(10, len(tokens))
 }
 
+// Record all token writer events
+func teventWriter(sb *strings.Builder) *TokenWriter {
+	return &TokenWriter{
+		Token: func(offset int, buf []rune) {
+			sb.WriteString(strconv.Itoa(offset) + ":" + string(buf) + "|")
+		},
+		SentenceEnd: func(_ int) {
+			sb.WriteString("<s>")
+		},
+		TextEnd: func(_ int) {
+			sb.WriteString("<t>")
+		},
+		Flush: func() error { return nil },
+	}
+}
+
+func TestPlainTransduce(t *testing.T) {
+	assert := assert.New(t)
+
+	if dat == nil {
+		dat = LoadDatokFile("testdata/tokenizer_de.datok")
+	}
+	if mat_de == nil {
+		mat_de = LoadMatrixFile("testdata/tokenizer_de.matok")
+	}
+	cats := LoadFomaFile("testdata/categories.fst")
+
+	inputs := append([]string{
+		s,
+		"abc Привет €5 😀",
+		"Der Mann.\x04\nEr ging.\x04",
+		"\x04\x04 :-)) https://x. a@b.c\n\n",
+	}, losslessStr...)
+
+	// Without the features, the plain transduction is used,
+	// that needs to give the same results
+	for _, tok := range []Tokenizer{
+		dat, mat_de, cats.ToMatrix(), cats.ToDoubleArray(),
+		LoadFomaFile("testdata/simpletok.fst").toDoubleArray(nil, true),
+	} {
+		for _, str := range inputs {
+			var plain, full strings.Builder
+			tok.TransduceTokenWriter(strings.NewReader(str), teventWriter(&plain))
+
+			tw := teventWriter(&full)
+			tw.Coverage = &Coverage{}
+			tok.TransduceTokenWriter(strings.NewReader(str), tw)
+			assert.Equal(full.String(), plain.String())
+		}
+	}
+}
+
 func BenchmarkDoubleArrayTransduce(b *testing.B) {
 	bu := make([]byte, 0, 2048)
 	w := bytes.NewBuffer(bu)
@@ -1351,6 +1429,20 @@ func BenchmarkDoubleArrayLarger(b *testing.B) {
 	}
 }
 
+func BenchmarkDoubleArrayFullTokenizerBuild(b *testing.B) {
+	tok := LoadFomaFile("testdata/tokenizer_de.fst")
+	b.ResetTimer()
+	var dat *DaTokenizer
+	for i := 0; i < b.N; i++ {
+		dat = tok.ToDoubleArray()
+		if dat == nil {
+			fmt.Println("Fail!")
+			os.Exit(1)
+		}
+	}
+	b.ReportMetric(dat.LoadFactor(), "loadfactor")
+}
+
 // 2021-08-11 (go 1.16)
 // go test -bench=. -test.benchmem
 //   BenchmarkTransduce-4         19069             60609 ns/op           11048 B/op        137 allocs/op
@@ -1449,3 +1541,22 @@ func BenchmarkDoubleArrayLarger(b *testing.B) {
 //   BenchmarkDoubleArrayConstruction-4         80342             14504 ns/op           10703 B/op         29 allocs/op
 //   BenchmarkDoubleArrayLarger-4                  19          60343253 ns/op         6357789 B/op       2575 allocs/op
 //   BenchmarkMatrixTransduce-4                 34029             30238 ns/op           28944 B/op         17 allocs/op
+// 2026-10-19 - Matrix layouts and profile guided state renumbering (go 1.27)
+//   BenchmarkMatrixTransduce-1                      29305             47665 ns/op           30208 B/op        148 allocs/op
+//   BenchmarkMatrixTransduceStateMajor-1            22477             54205 ns/op           30208 B/op        148 allocs/op
+//   BenchmarkMatrixTransduceProfiled-1              28968             46593 ns/op           30208 B/op        148 allocs/op
+//   BenchmarkMatrixTransduceStateMajorProfiled-1    31666             40387 ns/op           30208 B/op        148 allocs/op
+// 2026-10-19 - Before empty link method (go 1.27)
+//   BenchmarkDoubleArrayConstruction-1         38479             29787 ns/op           10952 B/op         31 allocs/op
+//   BenchmarkDoubleArrayLarger-1                   9         120066997 ns/op         6631256 B/op       2574 allocs/op
+//   BenchmarkDoubleArrayFullTokenizerBuild-1       1       15082700419 ns/op                                          (69.53 loadfactor)
+// 2026-10-19 - Empty link method for double array construction (go 1.27)
+//   BenchmarkDoubleArrayConstruction-1         27290             43212 ns/op           26120 B/op         50 allocs/op
+//   BenchmarkDoubleArrayLarger-1                  19          61018996 ns/op        32723768 B/op       2639 allocs/op
+//   BenchmarkDoubleArrayFullTokenizerBuild-1       1        5988235316 ns/op        308873544 B/op     18382 allocs/op  (69.52 loadfactor)
+// 2026-10-19 - Before plain transduction without unused features (go 1.27)
+//   BenchmarkDoubleArrayTransduce-1            21396             55968 ns/op           30416 B/op        153 allocs/op
+//   BenchmarkMatrixTransduce-1                 21633             51199 ns/op           30416 B/op        153 allocs/op
+// 2026-10-19 - Plain transduction without unused features (go 1.27)
+//   BenchmarkDoubleArrayTransduce-1            28551             41941 ns/op           30416 B/op        153 allocs/op
+//   BenchmarkMatrixTransduce-1                 38652             36880 ns/op           30416 B/op        153 allocs/op
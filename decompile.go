@@ -0,0 +1,182 @@
+package datok
+
+import (
+	"sort"
+)
+
+// Create an automaton without states for a sigma
+// of a tokenizer
+func newDecompiled(sigma map[rune]int, epsilon, unknown, identity int, cats categories, sbs softBounds) *Automaton {
+	auto := &Automaton{
+		sigmaRev:    make(map[int]rune, len(sigma)),
+		transitions: []map[int]*edge{nil},
+		epsilon:     epsilon,
+		unknown:     unknown,
+		identity:    identity,
+		tokenend:    -1,
+		categories:  cats,
+		softBounds:  sbs,
+	}
+
+	max := 0
+	for sym, num := range sigma {
+		auto.sigmaRev[num] = sym
+		if num > max {
+			max = num
+		}
+	}
+	for _, num := range append([]int{epsilon, unknown, identity}, cats[:]...) {
+		if num > max {
+			max = num
+		}
+	}
+	for _, sb := range sbs {
+		if sb.sym > max {
+			max = sb.sym
+		}
+	}
+	auto.sigmaCount = max + 1
+	auto.final = auto.sigmaCount
+	return auto
+}
+
+// All symbols of the automaton, that may be
+// used as input symbols of arcs
+func (auto *Automaton) symbols() []int {
+	syms := make([]int, 0, len(auto.sigmaRev)+3+catCount+len(auto.softBounds))
+	for num := range auto.sigmaRev {
+		syms = append(syms, num)
+	}
+	for _, num := range append([]int{auto.epsilon, auto.unknown, auto.identity}, auto.categories[:]...) {
+		if num > 0 {
+			syms = append(syms, num)
+		}
+	}
+	for _, sb := range auto.softBounds {
+		syms = append(syms, sb.sym)
+	}
+	sort.Ints(syms)
+	return syms
+}
+
+// Create an arc for a symbol of the automaton
+func (auto *Automaton) newEdge(a, end int, nontoken bool) *edge {
+	e := &edge{
+		inSym:    a,
+		outSym:   a,
+		end:      end,
+		nontoken: nontoken,
+	}
+	if a == auto.epsilon {
+		e.outSym = auto.tokenend
+		e.tokenend = true
+	} else if nontoken {
+		e.outSym = auto.epsilon
+	}
+	return e
+}
+
+// Automaton reconstructs the intermediate representation
+// of the tokenizer, e.g. to convert it to a double array.
+// As final states are not part of the matrix, the automaton
+// has no final states.
+func (mat *MatrixTokenizer) Automaton() *Automaton {
+	auto := newDecompiled(mat.sigma, mat.epsilon, mat.unknown, mat.identity, mat.categories, mat.softBounds)
+	auto.stateCount = mat.stateCount
+	cols := mat.columns()
+
+	syms := auto.symbols()
+	for s := 1; s <= mat.stateCount; s++ {
+		trans := make(map[int]*edge)
+		for _, a := range syms {
+			if a >= cols {
+				break
+			}
+			t := mat.array[(a-1)*mat.symStride+s*mat.stateStride]
+			if t == 0 {
+				continue
+			}
+			trans[a] = auto.newEdge(a, int(t&^FIRSTBIT), t&FIRSTBIT != 0)
+			if out, ok := mat.rewrites[matrixKey(uint32(s), a)]; ok {
+				trans[a].rewrite = string(out)
+			}
+		}
+		auto.arcCount += len(trans)
+		auto.transitions = append(auto.transitions, trans)
+	}
+	return auto
+}
+
+// Automaton reconstructs the intermediate representation
+// of the tokenizer, e.g. to convert it to a matrix.
+// States are numbered in breadth first order.
+func (dat *DaTokenizer) Automaton() *Automaton {
+	auto := newDecompiled(dat.sigma, dat.epsilon, dat.unknown, dat.identity, dat.categories, dat.softBounds)
+	if dat.final > auto.final {
+		auto.final = dat.final
+		auto.sigmaCount = dat.final
+	}
+
+	size := uint64(dat.GetSize())
+	syms := auto.symbols()
+
+	num := map[uint64]int{1: 1}
+	queue := []uint64{1}
+	for i := 0; i < len(queue); i++ {
+		t := queue[i]
+		base := dat.cell(t).base
+		trans := make(map[int]*edge)
+
+		for _, a := range syms {
+			t1 := base + uint64(a)
+			if t1 > size {
+				break
+			}
+			c := dat.cell(t1)
+			if c.check != t {
+				continue
+			}
+
+			// Follow separate states to their representative
+			target := t1
+			if c.separate {
+				target = c.base
+			}
+			n, ok := num[target]
+			if !ok {
+				n = len(queue) + 1
+				num[target] = n
+				queue = append(queue, target)
+			}
+			e := auto.newEdge(a, n, c.nontoken)
+			e.tokenend = c.tokenend
+			if out, ok := dat.rewrites[t1]; ok {
+				e.rewrite = string(out)
+			}
+			trans[a] = e
+		}
+		auto.arcCount += len(trans)
+
+		if t1 := base + uint64(dat.final); t1 <= size && dat.cell(t1).check == t {
+			trans[auto.final] = &edge{}
+		}
+		auto.transitions = append(auto.transitions, trans)
+	}
+	auto.stateCount = len(queue)
+	return auto
+}
+
+// An element of the double array independent
+// of the representation
+type daCell struct {
+	base, check                  uint64
+	separate, nontoken, tokenend bool
+}
+
+// Get an element of the double array
+func (dat *DaTokenizer) cell(t uint64) daCell {
+	return daCell{
+		dat.getBase(t), dat.getCheck(t),
+		dat.isSeparate(t), dat.isNonToken(t), dat.isTokenEnd(t),
+	}
+}
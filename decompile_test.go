@@ -0,0 +1,83 @@
+package datok
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var decompileSamples = []string{
+	"bau",
+	"wald gehen",
+	"  wald   gehen Da kann\t man was \"erleben\"!",
+	" In den Wald gehen? -- Da kann\t man was \"erleben\"!",
+	"Der Vorsitzende der Abk. hat gewählt. Er sagt: \"Ja!\"\n\nDas war's.",
+	"Mach's ggf. bis z.B. 3.5.2021 um 12:30 Uhr http://korap.ids-mannheim.de/ :-)",
+}
+
+// Transduce a string including sentence boundaries
+func ttransduce(tok Tokenizer, str string) string {
+	w := bytes.NewBuffer(make([]byte, 0, 2048))
+	if !tok.Transduce(strings.NewReader(str), w) {
+		return ""
+	}
+	return w.String()
+}
+
+func TestDecompileMatrix(t *testing.T) {
+	assert := assert.New(t)
+
+	mat := LoadFomaFile("testdata/simpletok.fst").ToMatrix()
+	auto := mat.Automaton()
+	assert.Equal(mat.stateCount, auto.stateCount)
+
+	for _, tok := range []Tokenizer{auto.ToMatrix(), auto.ToDoubleArray()} {
+		for _, str := range decompileSamples {
+			assert.Equal(ttransduce(mat, str), ttransduce(tok, str))
+		}
+	}
+
+	// Flags survive the round trip
+	mat2 := auto.ToMatrix()
+	assert.Equal(mat.array, mat2.array)
+}
+
+func TestDecompileDoubleArray(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, file := range []string{
+		"testdata/simpletok.fst",
+		"testdata/clitic_test.fst",
+	} {
+		dat := LoadFomaFile(file).ToDoubleArray()
+		auto := dat.Automaton()
+		assert.Equal(dat.TransCount(), auto.ToDoubleArray().TransCount())
+
+		for _, tok := range []Tokenizer{auto.ToMatrix(), auto.ToDoubleArray()} {
+			for _, str := range decompileSamples {
+				assert.Equal(ttransduce(dat, str), ttransduce(tok, str))
+			}
+		}
+	}
+}
+
+func TestDecompileFullTokenizer(t *testing.T) {
+	assert := assert.New(t)
+
+	mat := LoadMatrixFile("testdata/tokenizer_de.matok")
+	assert.NotNil(mat)
+	dat := mat.Automaton().ToDoubleArray()
+
+	for _, str := range decompileSamples {
+		assert.Equal(ttransduce(mat, str), ttransduce(dat, str))
+	}
+
+	// Unreachable states are not part of the double array
+	mat2 := dat.Automaton().ToMatrix()
+	assert.True(mat2.stateCount <= mat.stateCount)
+	for _, str := range decompileSamples {
+		assert.Equal(ttransduce(mat, str), ttransduce(mat2, str))
+	}
+}
@@ -0,0 +1,171 @@
+package datok
+
+import (
+	"encoding/binary"
+	"log"
+	"sort"
+	"strconv"
+)
+
+// nfa is the non-deterministic intermediate representation
+// of an FST, that may contain multiple arcs with the same
+// input symbol per state as well as epsilon transitions
+// (i.e. transitions that neither consume nor produce
+// a symbol).
+type nfa struct {
+	arcs  [][]*edge
+	final []bool
+}
+
+// Returns true if the arc is a general epsilon transition
+func (auto *Automaton) isEpsilonArc(e *edge) bool {
+	return e.inSym == auto.epsilon && !e.tokenend
+}
+
+// Extend the set of states by all states reachable
+// via epsilon transitions.
+func (auto *Automaton) epsilonClosure(n *nfa, set []int) []int {
+	seen := make(map[int]bool, len(set))
+	for _, s := range set {
+		seen[s] = true
+	}
+
+	for i := 0; i < len(set); i++ {
+		for _, e := range n.arcs[set[i]] {
+			if auto.isEpsilonArc(e) && !seen[e.end] {
+				seen[e.end] = true
+				set = append(set, e.end)
+			}
+		}
+	}
+
+	sort.Ints(set)
+	return set
+}
+
+// Serialize a sorted set of states as a map key
+func setKey(set []int) string {
+	buf := make([]byte, 4*len(set))
+	for i, s := range set {
+		binary.LittleEndian.PutUint32(buf[i*4:], uint32(s))
+	}
+	return string(buf)
+}
+
+// determinize turns the collected non-deterministic arcs into
+// a deterministic automaton using the epsilon closure and the
+// subset construction.
+//
+// As the tokenizer chooses transitions based on the input
+// symbol only, arcs with the same input symbol in a subset
+// need to agree in their output (i.e. whether they are nontoken
+// or tokenend transitions or rewrite the character). Otherwise the result of the
+// tokenization would be ambiguous and the determinization fails.
+func (auto *Automaton) determinize(n *nfa) *Automaton {
+
+	start := auto.epsilonClosure(n, []int{1})
+
+	sets := [][]int{nil, start}
+	table := map[string]int{setKey(start): 1}
+	transitions := []map[int]*edge{nil}
+
+	targets := make(map[int][]int)
+	first := make(map[int]*edge)
+	source := make(map[int]int)
+	syms := make([]int, 0, auto.sigmaCount+1)
+
+	for t := 1; t < len(sets); t++ {
+		set := sets[t]
+		trans := make(map[int]*edge)
+
+		for k := range targets {
+			delete(targets, k)
+			delete(first, k)
+			delete(source, k)
+		}
+
+		// Collect all targets per input symbol
+		for _, s := range set {
+			if n.final[s] {
+				trans[auto.final] = &edge{}
+			}
+
+			for _, e := range n.arcs[s] {
+				if auto.isEpsilonArc(e) {
+					continue
+				}
+
+				if f, ok := first[e.inSym]; !ok {
+					first[e.inSym] = e
+					source[e.inSym] = s
+				} else if f.nontoken != e.nontoken || f.tokenend != e.tokenend || f.rewrite != e.rewrite {
+					states := "state " + strconv.Itoa(s-1)
+					if source[e.inSym] != s {
+						states = "states " + strconv.Itoa(source[e.inSym]-1) + " and " + strconv.Itoa(s-1)
+					}
+					log.Println(
+						"Unable to determinize: Ambiguous output for symbol " +
+							strconv.Itoa(e.inSym) +
+							" (" +
+							string(auto.sigmaRev[e.inSym]) +
+							") in " +
+							states)
+					return nil
+				}
+
+				targets[e.inSym] = append(targets[e.inSym], e.end)
+			}
+		}
+
+		// Sort symbols for a stable numbering of states
+		syms = syms[:0]
+		for a := range targets {
+			syms = append(syms, a)
+		}
+		sort.Ints(syms)
+
+		for _, a := range syms {
+			ends := targets[a]
+
+			// Remove duplicates
+			sort.Ints(ends)
+			uniq := ends[:0]
+			for i, e := range ends {
+				if i == 0 || e != ends[i-1] {
+					uniq = append(uniq, e)
+				}
+			}
+
+			closure := auto.epsilonClosure(n, append([]int(nil), uniq...))
+			key := setKey(closure)
+
+			end, ok := table[key]
+			if !ok {
+				end = len(sets)
+				table[key] = end
+				sets = append(sets, closure)
+			}
+
+			f := first[a]
+			trans[a] = &edge{
+				inSym:    f.inSym,
+				outSym:   f.outSym,
+				end:      end,
+				nontoken: f.nontoken,
+				tokenend: f.tokenend,
+				rewrite:  f.rewrite,
+			}
+		}
+
+		transitions = append(transitions, trans)
+	}
+
+	auto.arcCount = 0
+	for _, trans := range transitions {
+		auto.arcCount += len(trans)
+	}
+
+	auto.stateCount = len(sets) - 1
+	auto.transitions = transitions
+	return auto
+}
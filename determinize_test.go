@@ -0,0 +1,34 @@
+package datok
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeterminizeNonDeterministic(t *testing.T) {
+	assert := assert.New(t)
+
+	// Non-deterministic (bau | bauamt) with an epsilon transition
+	tok := LoadFomaFile("testdata/nondet.fst")
+	assert.NotNil(tok)
+
+	// Both branches are merged by subset construction
+	assert.Equal(7, tok.StateCount())
+
+	mat := tok.ToMatrix()
+	assert.Equal("bau\nbauamt", ttokenizeStr(mat, "bau bauamt"))
+	assert.Equal("bauamt\nbau", ttokenizeStr(mat, "bauamt  bau"))
+
+	dat := tok.ToDoubleArray()
+	assert.Equal("bau\nbauamt", ttokenizeStr(dat, "bau bauamt"))
+	assert.Equal("bauamt\nbau", ttokenizeStr(dat, "bauamt  bau"))
+}
+
+func TestDeterminizeAmbiguous(t *testing.T) {
+	assert := assert.New(t)
+
+	// The symbol b is both a nontoken and a token symbol
+	tok := LoadFomaFile("testdata/ambiguous.fst")
+	assert.Nil(tok)
+}
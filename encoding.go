@@ -0,0 +1,276 @@
+package datok
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Supported input encodings
+const (
+	ENC_UTF8    = "utf-8"
+	ENC_UTF16LE = "utf-16le"
+	ENC_UTF16BE = "utf-16be"
+	ENC_LATIN1  = "iso-8859-1"
+	ENC_CP1252  = "windows-1252"
+)
+
+// Number of bytes inspected for the detection of the encoding
+const detectLen = 4096
+
+// Characters of the bytes 0x80 to 0x9F in Windows-1252.
+// Undefined bytes are mapped to the C1 controls like in ISO-8859-1.
+var cp1252 = [32]rune{
+	0x20AC, 0x0081, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021,
+	0x02C6, 0x2030, 0x0160, 0x2039, 0x0152, 0x008D, 0x017D, 0x008F,
+	0x0090, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+	0x02DC, 0x2122, 0x0161, 0x203A, 0x0153, 0x009D, 0x017E, 0x0178,
+}
+
+// Decoder reads input in a legacy or UTF-16 encoding
+// and passes it encoded in UTF-8.
+// A byte order mark at the beginning is removed.
+type Decoder struct {
+	reader   *bufio.Reader
+	encoding string
+	out      []byte
+
+	// Number of bytes read, including
+	// the byte order mark
+	pos int
+
+	// Byte offsets of the decoded characters,
+	// if recorded
+	offsets *byteOffsets
+}
+
+// NewDecoder creates a decoder for the input in the given encoding
+// (utf-8, utf-16, utf-16le, utf-16be, iso-8859-1 or windows-1252).
+// With "auto", the encoding is detected by the byte order mark,
+// or otherwise, input, that is not valid UTF-8, is treated as
+// windows-1252, if it contains bytes in the range of C1 controls
+// in ISO-8859-1, or as iso-8859-1. For utf-16 without a byte order
+// mark, big endian is assumed.
+// Returns nil, if the encoding is not supported.
+func NewDecoder(r io.Reader, encoding string) *Decoder {
+	dec := &Decoder{
+		reader: bufio.NewReader(r),
+		out:    make([]byte, 0, 4096),
+	}
+
+	encoding = strings.ToLower(encoding)
+	switch encoding {
+	case "auto", "utf-16":
+	case "utf8", ENC_UTF8:
+		encoding = ENC_UTF8
+	case ENC_UTF16LE, ENC_UTF16BE:
+	case "latin1", "latin-1", "iso8859-1", ENC_LATIN1:
+		encoding = ENC_LATIN1
+	case "cp1252", ENC_CP1252:
+		encoding = ENC_CP1252
+	default:
+		log.Println("Unsupported encoding:", encoding)
+		return nil
+	}
+
+	// Detect the byte order mark
+	bom, _ := dec.reader.Peek(3)
+	switch {
+	case bytes.HasPrefix(bom, []byte{0xEF, 0xBB, 0xBF}) && (encoding == "auto" || encoding == ENC_UTF8):
+		dec.pos, _ = dec.reader.Discard(3)
+		encoding = ENC_UTF8
+	case bytes.HasPrefix(bom, []byte{0xFF, 0xFE}) && (encoding == "auto" || strings.HasPrefix(encoding, "utf-16")):
+		dec.pos, _ = dec.reader.Discard(2)
+		encoding = ENC_UTF16LE
+	case bytes.HasPrefix(bom, []byte{0xFE, 0xFF}) && (encoding == "auto" || strings.HasPrefix(encoding, "utf-16")):
+		dec.pos, _ = dec.reader.Discard(2)
+		encoding = ENC_UTF16BE
+	case encoding == "utf-16":
+		encoding = ENC_UTF16BE
+	case encoding == "auto":
+		prefix, _ := dec.reader.Peek(detectLen)
+		encoding = detectEncoding(prefix)
+	}
+
+	dec.encoding = encoding
+	return dec
+}
+
+// Guess the encoding of input without a byte order mark
+func detectEncoding(prefix []byte) string {
+
+	// Ignore a character cut off at the end
+	for i := 1; i < utf8.UTFMax && i <= len(prefix); i++ {
+		if utf8.RuneStart(prefix[len(prefix)-i]) {
+			if !utf8.FullRune(prefix[len(prefix)-i:]) {
+				prefix = prefix[:len(prefix)-i]
+			}
+			break
+		}
+	}
+
+	if utf8.Valid(prefix) {
+		return ENC_UTF8
+	}
+	for _, b := range prefix {
+		if b >= 0x80 && b <= 0x9F {
+			return ENC_CP1252
+		}
+	}
+	return ENC_LATIN1
+}
+
+// Encoding returns the (detected) encoding of the input.
+func (dec *Decoder) Encoding() string {
+	return dec.encoding
+}
+
+// Offsets returns the offsets of the decoded characters
+// in bytes of the input, to be passed to NewTokenWriterOffsets().
+// The offsets are recorded, while the input is read,
+// so Offsets() has to be called before reading.
+func (dec *Decoder) Offsets() Offsets {
+	if dec.offsets == nil {
+		dec.offsets = &byteOffsets{dec: dec}
+	}
+	return dec.offsets
+}
+
+// Decode the next character
+// with the number of bytes it is encoded in
+func (dec *Decoder) decode() (rune, int, error) {
+	switch dec.encoding {
+	case ENC_UTF8:
+		return dec.reader.ReadRune()
+
+	case ENC_LATIN1, ENC_CP1252:
+		b, err := dec.reader.ReadByte()
+		if err != nil {
+			return 0, 0, err
+		}
+		if dec.encoding == ENC_CP1252 && b >= 0x80 && b <= 0x9F {
+			return cp1252[b-0x80], 1, nil
+		}
+		return rune(b), 1, nil
+	}
+
+	// UTF-16
+	unit, size, err := dec.readUnit()
+	if err != nil || size < 2 {
+		return unit, size, err
+	}
+	if utf16.IsSurrogate(unit) {
+
+		// Lone surrogates are invalid
+		next, err := dec.reader.Peek(2)
+		if err == nil && unit < 0xDC00 {
+			second := dec.unit(next)
+			if second >= 0xDC00 && second <= 0xDFFF {
+				dec.reader.Discard(2)
+				return utf16.DecodeRune(unit, second), 4, nil
+			}
+		}
+		return utf8.RuneError, 2, nil
+	}
+	return unit, 2, nil
+}
+
+// Get a UTF-16 code unit from two bytes
+func (dec *Decoder) unit(b []byte) rune {
+	if dec.encoding == ENC_UTF16LE {
+		return rune(b[0]) | rune(b[1])<<8
+	}
+	return rune(b[0])<<8 | rune(b[1])
+}
+
+// Read a UTF-16 code unit
+func (dec *Decoder) readUnit() (rune, int, error) {
+	var b [2]byte
+	n, err := io.ReadFull(dec.reader, b[:])
+	if n == 1 {
+
+		// Incomplete code unit at the end
+		return utf8.RuneError, 1, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	return dec.unit(b[:]), 2, nil
+}
+
+// Read decoded input encoded in UTF-8
+func (dec *Decoder) Read(p []byte) (int, error) {
+	var buf [utf8.UTFMax]byte
+	for len(dec.out) < len(p) {
+		char, size, err := dec.decode()
+		if err != nil {
+			if err != io.EOF {
+				return 0, err
+			}
+			break
+		}
+		if dec.offsets != nil {
+			dec.offsets.add(char, dec.pos)
+		}
+		dec.pos += size
+		dec.out = append(dec.out, buf[:utf8.EncodeRune(buf[:], char)]...)
+	}
+
+	if len(dec.out) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, dec.out)
+	dec.out = dec.out[:copy(dec.out, dec.out[n:])]
+	return n, nil
+}
+
+// Offsets of decoded characters in bytes of the input,
+// for the current text and the texts decoded ahead
+type byteOffsets struct {
+	dec *Decoder
+
+	// Offset of the start of the current text
+	base int
+
+	// Offsets of the characters from the
+	// start of the current text on
+	chars []int
+
+	// Indices of the characters starting
+	// the following texts
+	texts []int
+}
+
+// Record the offset of a decoded character
+func (bo *byteOffsets) add(char rune, offset int) {
+	bo.chars = append(bo.chars, offset)
+	if char == EOT {
+		bo.texts = append(bo.texts, len(bo.chars))
+	}
+}
+
+// Offset implements the Offsets interface.
+func (bo *byteOffsets) Offset(pos int) int {
+	if pos < len(bo.chars) {
+		return bo.chars[pos] - bo.base
+	}
+	return bo.dec.pos - bo.base
+}
+
+// NextText implements the Offsets interface.
+func (bo *byteOffsets) NextText() {
+	if len(bo.texts) == 0 {
+		return
+	}
+	next := bo.texts[0]
+	bo.base += bo.Offset(next)
+	bo.chars = bo.chars[:copy(bo.chars, bo.chars[next:])]
+	bo.texts = bo.texts[:copy(bo.texts, bo.texts[1:])]
+	for i := range bo.texts {
+		bo.texts[i] -= next
+	}
+}
@@ -0,0 +1,136 @@
+package datok
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Decode a byte string
+func tdecode(str string, encoding string) (string, string) {
+	dec := NewDecoder(strings.NewReader(str), encoding)
+	if dec == nil {
+		return "", ""
+	}
+	out, _ := ioutil.ReadAll(dec)
+	return string(out), dec.Encoding()
+}
+
+func TestDecoder(t *testing.T) {
+	assert := assert.New(t)
+
+	var out, enc string
+
+	// Explicit encodings
+	out, _ = tdecode("Stra\xdfe", "ISO-8859-1")
+	assert.Equal("Straße", out)
+	out, _ = tdecode("\x93Euro\x94 \x80 \x81", "windows-1252")
+	assert.Equal("“Euro” € \u0081", out)
+	out, _ = tdecode("\x00S\x00t\xd8\x3d\xde\x00", "utf-16be")
+	assert.Equal("St😀", out)
+	out, _ = tdecode("S\x00t\x00=\xd8\x00\xde", "utf-16le")
+	assert.Equal("St😀", out)
+
+	// Invalid input
+	out, _ = tdecode("a\xffb", "utf-8")
+	assert.Equal("a�b", out)
+	out, _ = tdecode("\x00a\xd8\x3d\x00b\x00", "utf-16be")
+	assert.Equal("a�b�", out)
+	assert.Nil(NewDecoder(strings.NewReader(""), "ebcdic"))
+
+	// Byte order marks
+	out, enc = tdecode("\xef\xbb\xbfDer", "auto")
+	assert.Equal("Der", out)
+	assert.Equal(ENC_UTF8, enc)
+	out, enc = tdecode("\xff\xfeD\x00e\x00r\x00", "auto")
+	assert.Equal("Der", out)
+	assert.Equal(ENC_UTF16LE, enc)
+	out, enc = tdecode("\xfe\xff\x00D\x00e\x00r", "UTF-16")
+	assert.Equal("Der", out)
+	assert.Equal(ENC_UTF16BE, enc)
+	out, enc = tdecode("\x00D\x00e\x00r", "utf-16")
+	assert.Equal("Der", out)
+	assert.Equal(ENC_UTF16BE, enc)
+
+	// Heuristics
+	out, enc = tdecode("Straße", "auto")
+	assert.Equal("Straße", out)
+	assert.Equal(ENC_UTF8, enc)
+	out, enc = tdecode("Stra\xdfe", "auto")
+	assert.Equal("Straße", out)
+	assert.Equal(ENC_LATIN1, enc)
+	out, enc = tdecode("\x84Stra\xdfe\x93", "auto")
+	assert.Equal("„Straße“", out)
+	assert.Equal(ENC_CP1252, enc)
+
+	// A character cut off by the detection
+	_, enc = tdecode(strings.Repeat("a", detectLen-1)+"ß", "auto")
+	assert.Equal(ENC_UTF8, enc)
+}
+
+func TestDecoderOffsets(t *testing.T) {
+	assert := assert.New(t)
+
+	mat := LoadMatrixFile("testdata/tokenizer_de.matok")
+	assert.NotNil(mat)
+
+	b := make([]byte, 0, 2048)
+	w := bytes.NewBuffer(b)
+
+	// Offsets in decoded characters
+	dec := NewDecoder(strings.NewReader("\xef\xbb\xbfDie Straße."), "auto")
+	mat.TransduceTokenWriter(dec, NewTokenWriter(w, TOKENS|TOKEN_POS))
+	assert.Equal("Die\nStraße\n.\n0 3 4 10 10 11\n", w.String())
+
+	// Offsets in bytes
+	for _, test := range []struct {
+		input string
+		pos   string
+	}{
+		{"Die Straße.", "0 3 4 11 11 12"},
+		{"Die Stra\xdfe.", "0 3 4 10 10 11"},
+		{"\xef\xbb\xbfDie Straße.", "3 6 7 14 14 15"},
+		{"\xff\xfeD\x00i\x00e\x00 \x00S\x00t\x00r\x00a\x00\xdf\x00e\x00.\x00", "2 8 10 22 22 24"},
+	} {
+		w.Reset()
+		dec = NewDecoder(strings.NewReader(test.input), "auto")
+		mat.TransduceTokenWriter(dec, NewTokenWriterOffsets(w, TOKENS|TOKEN_POS, dec.Offsets()))
+		assert.Equal("Die\nStraße\n.\n"+test.pos+"\n", w.String())
+	}
+
+	// Replacement characters of invalid input
+	// and genuine replacement characters
+	dec = NewDecoder(strings.NewReader("a\xff\xef\xbf\xbd"), "utf-8")
+	offsets := dec.Offsets()
+	out, _ := ioutil.ReadAll(dec)
+	assert.Equal("a��", string(out))
+	assert.Equal(0, offsets.Offset(0))
+	assert.Equal(1, offsets.Offset(1))
+	assert.Equal(2, offsets.Offset(2))
+	assert.Equal(5, offsets.Offset(3))
+
+	dec = NewDecoder(strings.NewReader("\xff\xfe\x3d\xd8\xfd\xff\x00"), "auto")
+	offsets = dec.Offsets()
+	out, _ = ioutil.ReadAll(dec)
+	assert.Equal("���", string(out))
+	assert.Equal(2, offsets.Offset(0))
+	assert.Equal(4, offsets.Offset(1))
+	assert.Equal(6, offsets.Offset(2))
+	assert.Equal(7, offsets.Offset(3))
+
+	// Offsets in bytes with preprocessing
+	w.Reset()
+	dec = NewDecoder(strings.NewReader("<p>Die Stra&szlig;e.</p>"), "auto")
+	NewPreprocessor(NewXMLFilter(false)).TransduceTokenWriter(mat, dec, NewTokenWriterOffsets(w, TOKEN_POS, dec.Offsets()))
+	assert.Equal("3 6 7 19 19 20\n", w.String())
+
+	// Offsets in bytes of following texts, with replacement
+	// characters in markup removed at the end of a text
+	w.Reset()
+	dec = NewDecoder(strings.NewReader("a<x t=\"\xef\xbf\xbd\"/>\x04b\xffc d"), "utf-8")
+	NewPreprocessor(NewXMLFilter(false)).TransduceTokenWriter(mat, dec, NewTokenWriterOffsets(w, TOKEN_POS, dec.Offsets()))
+	assert.Equal("0 1\n0 3 4 5\n", w.String())
+}
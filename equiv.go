@@ -0,0 +1,321 @@
+package datok
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Automatable is implemented by all representations
+// of a tokenizer, that can be converted to an automaton.
+type Automatable interface {
+	Automaton() *Automaton
+}
+
+// Automaton returns the automaton itself.
+func (auto *Automaton) Automaton() *Automaton {
+	return auto
+}
+
+// One automaton of the product construction
+type equivSide struct {
+	auto  *Automaton
+	sigma map[rune]int
+}
+
+func newEquivSide(auto *Automaton) *equivSide {
+	side := &equivSide{
+		auto:  auto,
+		sigma: make(map[rune]int, len(auto.sigmaRev)),
+	}
+	for num, sym := range auto.sigmaRev {
+		side.sigma[sym] = num
+	}
+	return side
+}
+
+// Get the arc for a character in a state, following the
+// tokenizers in falling back from the category symbol to identity
+// and unknown for characters not in sigma
+func (side *equivSide) arc(s int, char rune) *edge {
+	if a, ok := side.sigma[char]; ok {
+		return side.auto.transitions[s][a]
+	}
+	if a := side.auto.categories.fallback(char, 0); a != 0 {
+		if e := side.auto.transitions[s][a]; e != nil {
+			return e
+		}
+	}
+	if e := side.auto.transitions[s][side.auto.identity]; e != nil {
+		return e
+	}
+	return side.auto.transitions[s][side.auto.unknown]
+}
+
+// Get the token bound arc of a state
+func (side *equivSide) bound(s int) *edge {
+	return side.auto.transitions[s][side.auto.epsilon]
+}
+
+// Get the soft bound arc of a category of a state
+func (side *equivSide) softBound(s int, name string) *edge {
+	for _, sb := range side.auto.softBounds {
+		if sb.name == name {
+			return side.auto.transitions[s][sb.sym]
+		}
+	}
+	return nil
+}
+
+// A pair of states in the product automaton
+type equivPair struct {
+	a, b int
+}
+
+// The way a pair was reached first
+type equivStep struct {
+	prev    equivPair
+	char    rune
+	epsilon bool
+}
+
+// Equivalent checks, whether two tokenizers (in any mix of
+// representations) have the same transitions for all inputs,
+// so they tokenize all texts identically.
+// Final states are ignored, as they are not used for tokenization.
+// If the transitions diverge, an input is returned as a
+// counterexample: An input tokenized differently, if found among
+// the shortest inputs leading to the divergence and their
+// extensions by one character (see Differs()), or otherwise
+// the shortest input leading to the divergence.
+func Equivalent(x, y Automatable) (bool, string) {
+	a := newEquivSide(x.Automaton())
+	b := newEquivSide(y.Automaton())
+
+	// All characters known to one of the automata,
+	// and one character representing all others
+	// (per category, in case categories are used)
+	chars := make([]rune, 0, len(a.sigma)+len(b.sigma)+catCount)
+	for char := range a.sigma {
+		chars = append(chars, char)
+	}
+	for char := range b.sigma {
+		if _, ok := a.sigma[char]; !ok {
+			chars = append(chars, char)
+		}
+	}
+	sort.Slice(chars, func(i, j int) bool { return chars[i] < chars[j] })
+	if a.auto.categories.defined() || b.auto.categories.defined() {
+		for cat := catNone; cat < catCount; cat++ {
+			chars = append(chars, unusedCategoryChar(cat, a.sigma, b.sigma))
+		}
+	} else {
+		chars = append(chars, unusedChar(a.sigma, b.sigma))
+	}
+
+	// All soft bound categories known to one of the automata
+	softNames := a.auto.softBounds.names()
+	for _, name := range b.auto.softBounds.names() {
+		if !a.auto.softBounds.known(name) {
+			softNames = append(softNames, name)
+		}
+	}
+	selections := equivSelections(a.auto, b.auto)
+
+	start := equivPair{1, 1}
+	steps := map[equivPair]equivStep{start: {}}
+
+	// Create the counterexample leading to a pair
+	example := func(p equivPair, suffix ...rune) string {
+		var path []rune
+		for p != start {
+			step := steps[p]
+			if !step.epsilon {
+				path = append(path, step.char)
+			}
+			p = step.prev
+		}
+		for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+			path[i], path[j] = path[j], path[i]
+		}
+		return string(append(path, suffix...))
+	}
+
+	// Prefer counterexamples tokenized differently,
+	// i.e. the inputs leading to the divergences
+	// or their extensions by one character
+	counterexample := func(exs []string) string {
+		ta, tb := equivTokenizer(x), equivTokenizer(y)
+		for _, ex := range exs {
+			if equivTransduce(ta, ex, selections) != equivTransduce(tb, ex, selections) {
+				return ex
+			}
+		}
+		for _, ex := range exs {
+			for _, char := range chars {
+				if equivTransduce(ta, ex+string(char), selections) != equivTransduce(tb, ex+string(char), selections) {
+					return ex + string(char)
+				}
+			}
+		}
+		return exs[0]
+	}
+
+	// Search breadth first by the number of consumed characters,
+	// token bounds and soft bounds don't consume characters
+	layer := []equivPair{start}
+	for len(layer) > 0 {
+
+		// Inputs leading to divergences of the transitions
+		var exs []string
+
+		for i := 0; i < len(layer); i++ {
+			p := layer[i]
+			bounds := [][2]*edge{{a.bound(p.a), b.bound(p.b)}}
+			for _, name := range softNames {
+				bounds = append(bounds, [2]*edge{a.softBound(p.a, name), b.softBound(p.b, name)})
+			}
+			for i, bound := range bounds {
+				next, div := equivDiverge(bound[0], bound[1])
+
+				// A soft bound category unknown to one of the
+				// tokenizers can't be selected for both
+				if div && i > 0 && !(a.auto.softBounds.known(softNames[i-1]) &&
+					b.auto.softBounds.known(softNames[i-1])) {
+					return false, example(p)
+				}
+				if div {
+					exs = append(exs, example(p))
+				}
+				if next.a == 0 {
+					continue
+				}
+				if _, ok := steps[next]; !ok {
+					steps[next] = equivStep{prev: p, epsilon: true}
+					layer = append(layer, next)
+				}
+			}
+		}
+
+		var nextLayer []equivPair
+		for _, p := range layer {
+			for _, char := range chars {
+				next, div := equivDiverge(a.arc(p.a, char), b.arc(p.b, char))
+				if div {
+					exs = append(exs, example(p, char))
+				}
+				if next.a == 0 {
+					continue
+				}
+				if _, ok := steps[next]; !ok {
+					steps[next] = equivStep{prev: p, char: char}
+					nextLayer = append(nextLayer, next)
+				}
+			}
+		}
+		if len(exs) > 0 {
+			return false, counterexample(exs)
+		}
+		layer = nextLayer
+	}
+	return true, ""
+}
+
+// Differs checks, whether two tokenizers (in any mix of
+// representations) tokenize an input differently, with no
+// soft bounds and each soft bound category known to both
+// selected in turn. Contrary to Equivalent(), this proves
+// tokenizers to be different, but not to be equivalent.
+func Differs(x, y Automatable, str string) bool {
+	selections := equivSelections(x.Automaton(), y.Automaton())
+	return equivTransduce(equivTokenizer(x), str, selections) !=
+		equivTransduce(equivTokenizer(y), str, selections)
+}
+
+// Get the selections of soft bounds for comparing
+// tokenizations, i.e. none and each category known to both
+func equivSelections(a, b *Automaton) [][]string {
+	selections := [][]string{nil}
+	for _, name := range a.softBounds.names() {
+		if b.softBounds.known(name) {
+			selections = append(selections, []string{name})
+		}
+	}
+	return selections
+}
+
+// Compare two arcs and return the target pair
+func equivDiverge(ea, eb *edge) (equivPair, bool) {
+	if ea == nil || eb == nil {
+		return equivPair{}, ea != eb
+	}
+	return equivPair{ea.end, eb.end}, ea.nontoken != eb.nontoken || ea.rewrite != eb.rewrite
+}
+
+// Get the tokenizer of an automatable
+func equivTokenizer(x Automatable) Tokenizer {
+	if tok, ok := x.(Tokenizer); ok {
+		return tok
+	}
+	return x.Automaton().ToMatrix()
+}
+
+// Transduce a string with all selections of soft bounds,
+// including the normalized forms of the tokens
+func equivTransduce(tok Tokenizer, str string, selections [][]string) string {
+	var sb strings.Builder
+	w := bytes.NewBuffer(make([]byte, 0, 256))
+	for _, names := range selections {
+		w.Reset()
+		tw := NewTokenWriter(w, TOKENS|SENTENCES|NORMALIZED)
+		tw.SoftBounds = names
+		if !tok.TransduceTokenWriter(strings.NewReader(str), tw) {
+			sb.WriteString("FAIL")
+		}
+		tw.Flush()
+		sb.Write(w.Bytes())
+		sb.WriteByte(0)
+	}
+	return sb.String()
+}
+
+// Find a printable character, that is not part of any sigma
+func unusedChar(sigmas ...map[rune]int) rune {
+	used := func(char rune) bool {
+		for _, sigma := range sigmas {
+			if _, ok := sigma[char]; ok {
+				return true
+			}
+		}
+		return false
+	}
+	for _, r := range [][2]rune{{'a', 'z'}, {'A', 'Z'}, {'0', '9'}, {0xC0, 0x10FFFF}} {
+		for char := r[0]; char <= r[1]; char++ {
+			if !used(char) {
+				return char
+			}
+		}
+	}
+	return 0x10FFFF
+}
+
+// Find a character of a category, that is not part of any sigma
+func unusedCategoryChar(cat int, sigmas ...map[rune]int) rune {
+	for char := rune(' '); char <= unicode.MaxRune; char++ {
+		if category(char) != cat {
+			continue
+		}
+		used := false
+		for _, sigma := range sigmas {
+			if _, ok := sigma[char]; ok {
+				used = true
+				break
+			}
+		}
+		if !used {
+			return char
+		}
+	}
+	return unusedChar(sigmas...)
+}
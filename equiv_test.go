@@ -0,0 +1,137 @@
+package datok
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEquivalentRepresentations(t *testing.T) {
+	assert := assert.New(t)
+
+	auto := LoadFomaFile("testdata/simpletok.fst")
+	mat := LoadMatrixFile("testdata/simpletok.matok")
+	dat := LoadDatokFile("testdata/simpletok.datok")
+
+	ok, ex := Equivalent(auto, mat)
+	assert.True(ok)
+	assert.Equal("", ex)
+
+	ok, _ = Equivalent(mat, dat)
+	assert.True(ok)
+
+	ok, _ = Equivalent(tbuildSimpleTokenizer(".?!").Automaton(), dat)
+	assert.True(ok)
+
+	ok, _ = Equivalent(auto.ToDoubleArray(), auto.Minimize().ToMatrix())
+	assert.True(ok)
+}
+
+func TestEquivalentCounterexample(t *testing.T) {
+	assert := assert.New(t)
+
+	mat := LoadMatrixFile("testdata/simpletok.matok")
+
+	b := tbuildSimpleTokenizer(".?!")
+
+	// Don't treat question marks as punctuation
+	b2 := tbuildSimpleTokenizer(".!")
+
+	ok, ex := Equivalent(mat, b.Automaton())
+	assert.True(ok)
+	assert.Equal("", ex)
+
+	ok, ex = Equivalent(mat, b2.Automaton())
+	assert.False(ok)
+	// A single question mark is a token in both cases
+	assert.Equal("??", ex)
+
+	// Differences in the transitions are reported,
+	// even if the counterexample is tokenized identically
+	b = NewBuilder()
+	s := b.AddState()
+	b.AddArc(1, s, 'a')
+	b.AddArc(s, s, 'a')
+	b.AddTokenBound(s, 1)
+
+	b2 = NewBuilder()
+	s = b2.AddState()
+	b2.AddArc(1, s, 'a')
+	b2.AddArc(s, s, 'a')
+
+	ok, ex = Equivalent(b.Automaton(), b2.Automaton())
+	assert.False(ok)
+	assert.Equal("a", ex)
+	assert.False(Differs(b.Automaton(), b2.Automaton(), ex))
+
+	// Token bounds are compared without consuming characters
+	b = NewBuilder()
+	s = b.AddState()
+	u := b.AddState()
+	b.AddArc(1, s, 'a')
+	b.AddArc(s, s, 'a')
+	b.AddTokenBound(s, u)
+	b.AddNonTokenArc(u, 1, 'b')
+
+	b2 = NewBuilder()
+	s = b2.AddState()
+	u = b2.AddState()
+	b2.AddArc(1, s, 'a')
+	b2.AddArc(s, s, 'a')
+	b2.AddTokenBound(s, 1)
+	b2.AddNonTokenArc(u, 1, 'b')
+
+	ok, ex = Equivalent(b.Automaton(), b2.Automaton())
+	assert.False(ok)
+	assert.Equal("ab", ex)
+	assert.True(Differs(b.Automaton(), b2.Automaton(), ex))
+
+	// Differences only visible in longer inputs are reported
+	deep := func(bound bool) *Automaton {
+		b := NewBuilder()
+		s := b.AddState()
+		u := b.AddState()
+		b.AddArc(1, s, 'a')
+		b.AddNonTokenArc(1, u, 'b')
+		b.AddNonTokenArc(1, 1, ' ')
+		b.AddArc(s, u, 'b')
+		b.AddArc(u, 1, 'a')
+		b.AddNonTokenArc(u, 1, 'b')
+		b.AddNonTokenArc(u, u, ' ')
+		b.AddTokenBound(u, 1)
+		if bound {
+			b.AddTokenBound(s, 1)
+		}
+		return b.Automaton()
+	}
+	ok, ex = Equivalent(deep(true), deep(false))
+	assert.False(ok)
+	assert.Equal("a", ex)
+	assert.False(Differs(deep(true), deep(false), ex))
+	assert.True(Differs(deep(true), deep(false), "abaa"))
+
+	// Nontoken flags are compared
+	b = NewBuilder()
+	b.AddArc(1, 1, 'a')
+	b.AddArc(1, 1, 'b')
+	b2 = NewBuilder()
+	b2.AddArc(1, 1, 'a')
+	b2.AddNonTokenArc(1, 1, 'b')
+
+	ok, ex = Equivalent(b.Automaton(), b2.Automaton())
+	assert.False(ok)
+	assert.Equal("b", ex)
+}
+
+func TestEquivalentFullTokenizer(t *testing.T) {
+	assert := assert.New(t)
+
+	if mat_de == nil {
+		mat_de = LoadMatrixFile("testdata/tokenizer_de.matok")
+	}
+	dat := mat_de.Automaton().ToDoubleArray()
+
+	ok, ex := Equivalent(mat_de, dat)
+	assert.True(ok)
+	assert.Equal("", ex)
+}
@@ -0,0 +1,180 @@
+package datok
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Expansions is a table of multi-word tokens, that is applied
+// at runtime on top of the token stream of any tokenizer.
+// Tokens listed in the table (e.g. contractions like "zum")
+// are passed as multi-word tokens with their syntactic
+// words (e.g. "zu dem") to the token writer.
+//
+// Tokens with an uppercase first character are also expanded,
+// if the token with a lowercase first character is listed.
+// The first word is capitalized in that case.
+//
+// The table is meant for tokenizers keeping contractions
+// as single tokens, like the German tokenizer. The English
+// tokenizer already splits clitics into separate tokens
+// (e.g. "don't" into "do" and "n't", see src/en/clitics.xfst),
+// following the tokenization of the English UD treebanks,
+// so there are no multi-word tokens left to expand.
+type Expansions struct {
+	words map[string][][]rune
+}
+
+// NewExpansions creates a new empty expansion table.
+func NewExpansions() *Expansions {
+	return &Expansions{words: make(map[string][][]rune)}
+}
+
+// LoadExpansionsFile reads an expansion table from a file.
+func LoadExpansionsFile(file string) *Expansions {
+	f, err := os.Open(file)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	defer f.Close()
+
+	return ParseExpansions(f)
+}
+
+// ParseExpansions reads an expansion table with one token
+// per line, followed by a tab and the space separated list
+// of words the token is expanded to.
+// Empty lines and lines starting with '#' are ignored.
+func ParseExpansions(ior io.Reader) *Expansions {
+	exp := NewExpansions()
+	scanner := bufio.NewScanner(ior)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		elem := strings.SplitN(line, "\t", 2)
+		if len(elem) < 2 {
+			log.Println("Expansion entry without words:", elem[0])
+			return nil
+		}
+		if !exp.Add(elem[0], strings.Fields(elem[1])...) {
+			return nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		log.Println(err)
+		return nil
+	}
+	return exp
+}
+
+// Add adds a token with the words it is expanded to.
+// A token needs to be expanded to at least two
+// non-empty words.
+func (exp *Expansions) Add(token string, words ...string) bool {
+	if token == "" || strings.ContainsAny(token, " \t") {
+		log.Println("Invalid expansion entry:", token)
+		return false
+	}
+
+	if len(words) < 2 {
+		log.Println("Expansion entry needs at least two words:", token)
+		return false
+	}
+
+	list := make([][]rune, len(words))
+	for i, word := range words {
+		if word == "" || strings.ContainsAny(word, " \t") {
+			log.Println("Invalid word in expansion entry:", token)
+			return false
+		}
+		list[i] = []rune(word)
+	}
+	exp.words[token] = list
+	return true
+}
+
+// Expand returns the words of a multi-word token,
+// or nil, in case the token is not expanded.
+func (exp *Expansions) Expand(token []rune) [][]rune {
+	if len(token) == 0 {
+		return nil
+	}
+
+	if words, ok := exp.words[string(token)]; ok {
+		return words
+	}
+
+	// Try with a lowercase first character
+	first := token[0]
+	if !unicode.IsUpper(first) {
+		return nil
+	}
+	lower := string(unicode.ToLower(first)) + string(token[1:])
+	words, ok := exp.words[lower]
+	if !ok {
+		return nil
+	}
+
+	// Capitalize the first word
+	upper := make([][]rune, len(words))
+	copy(upper, words)
+	upper[0] = append([]rune{unicode.ToUpper(words[0][0])}, words[0][1:]...)
+	return upper
+}
+
+// TokenWriter wraps a token writer, so that all tokens passed
+// are expanded according to the table. In case the token writer
+// doesn't support multi-word tokens, tokens are passed unchanged.
+// Multi-word tokens are forwarded by the lexicon, segmenter and
+// preprocessor token writers, so the table can be applied
+// inside or outside of these.
+func (exp *Expansions) TokenWriter(tw *TokenWriter) *TokenWriter {
+
+	// Pass a multi-word token and return false,
+	// if the token is not expanded
+	expand := func(offset int, buf []rune) bool {
+		if tw.MultiWordToken == nil {
+			return false
+		}
+		words := exp.Expand(buf[offset:])
+		if words == nil {
+			return false
+		}
+		tw.MultiWordToken(offset, buf, words)
+		return true
+	}
+
+	etw := &TokenWriter{
+		Token: func(offset int, buf []rune) {
+			if !expand(offset, buf) {
+				tw.Token(offset, buf)
+			}
+		},
+		SentenceEnd:    tw.SentenceEnd,
+		TextEnd:        tw.TextEnd,
+		Flush:          tw.Flush,
+		MultiWordToken: tw.MultiWordToken,
+		Skip:           tw.Skip,
+		SoftBounds:     tw.SoftBounds,
+		Coverage:       tw.Coverage,
+		Graphemes:      tw.Graphemes,
+	}
+
+	if tw.NormalizedToken != nil {
+		etw.NormalizedToken = func(offset int, buf []rune, norm []rune) {
+			if !expand(offset, buf) {
+				tw.NormalizedToken(offset, buf, norm)
+			}
+		}
+	}
+	return etw
+}
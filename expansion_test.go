@@ -0,0 +1,131 @@
+package datok
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpansionsParse(t *testing.T) {
+	assert := assert.New(t)
+
+	exp := ParseExpansions(strings.NewReader("# Comment\nzum\tzu dem\n\nim\tin  dem\n"))
+	assert.NotNil(exp)
+
+	assert.Equal([][]rune{[]rune("zu"), []rune("dem")}, exp.Expand([]rune("zum")))
+	assert.Equal([][]rune{[]rune("in"), []rune("dem")}, exp.Expand([]rune("im")))
+
+	// The first word is capitalized
+	assert.Equal([][]rune{[]rune("Zu"), []rune("dem")}, exp.Expand([]rune("Zum")))
+
+	assert.Nil(exp.Expand([]rune("ZUM")))
+	assert.Nil(exp.Expand([]rune("zu")))
+	assert.Nil(exp.Expand([]rune("")))
+
+	// Entries need at least two words
+	assert.Nil(ParseExpansions(strings.NewReader("zum\n")))
+	assert.Nil(ParseExpansions(strings.NewReader("zum\tzudem\n")))
+	assert.False(exp.Add("zu m", "zu", "dem"))
+	assert.False(exp.Add("zur", "", "der"))
+	assert.False(exp.Add("zur", "zu", "d er"))
+	assert.Nil(exp.Expand([]rune("Zur")))
+
+	exp = LoadExpansionsFile("src/de/expansions.txt")
+	assert.NotNil(exp)
+	assert.Equal([][]rune{[]rune("Über"), []rune("das")}, exp.Expand([]rune("Übers")))
+}
+
+func TestExpansionsTokenWriter(t *testing.T) {
+	assert := assert.New(t)
+
+	if mat_de == nil {
+		mat_de = LoadMatrixFile("testdata/tokenizer_de.matok")
+	}
+
+	exp := NewExpansions()
+	assert.True(exp.Add("zum", "zu", "dem"))
+	assert.True(exp.Add("im", "in", "dem"))
+
+	b := make([]byte, 0, 2048)
+	w := bytes.NewBuffer(b)
+
+	// Multi-word tokens are written with their words
+	mat_de.TransduceTokenWriter(strings.NewReader("Er ging zum Zug. Im Zug!"), exp.TokenWriter(NewTokenWriter(w, CONLLU)))
+	assert.Equal(
+		"1\tEr\t_\t_\t_\t_\t_\t_\t_\t_\n"+
+			"2\tging\t_\t_\t_\t_\t_\t_\t_\t_\n"+
+			"3-4\tzum\t_\t_\t_\t_\t_\t_\t_\t_\n"+
+			"3\tzu\t_\t_\t_\t_\t_\t_\t_\t_\n"+
+			"4\tdem\t_\t_\t_\t_\t_\t_\t_\t_\n"+
+			"5\tZug\t_\t_\t_\t_\t_\t_\t_\tSpaceAfter=No\n"+
+			"6\t.\t_\t_\t_\t_\t_\t_\t_\t_\n"+
+			"\n"+
+			"1-2\tIm\t_\t_\t_\t_\t_\t_\t_\t_\n"+
+			"1\tIn\t_\t_\t_\t_\t_\t_\t_\t_\n"+
+			"2\tdem\t_\t_\t_\t_\t_\t_\t_\t_\n"+
+			"3\tZug\t_\t_\t_\t_\t_\t_\t_\tSpaceAfter=No\n"+
+			"4\t!\t_\t_\t_\t_\t_\t_\t_\t_\n"+
+			"\n",
+		w.String(),
+	)
+
+	// Multi-word tokens are kept in grapheme clusters
+	w.Reset()
+	mat_de.TransduceTokenWriter(strings.NewReader("zum"), exp.TokenWriter(tgraphemeTokenWriter(w, CONLLU)))
+	assert.Equal("1-2\tzum\t_\t_\t_\t_\t_\t_\t_\t_\n1\tzu\t_\t_\t_\t_\t_\t_\t_\t_\n2\tdem\t_\t_\t_\t_\t_\t_\t_\t_\n\n", w.String())
+
+	// Multi-word tokens are forwarded by other token writers
+	lex := NewLexicon()
+	assert.True(lex.Add("Zug."))
+	for _, wrap := range []func(*TokenWriter) *TokenWriter{
+		func(tw *TokenWriter) *TokenWriter {
+			return lex.TokenWriter(tw)
+		},
+		func(tw *TokenWriter) *TokenWriter {
+			return NewSegmenterTokenWriter(tw, NewMaxMatchSegmenter())
+		},
+	} {
+		w.Reset()
+		mat_de.TransduceTokenWriter(strings.NewReader("zum Zug."), wrap(exp.TokenWriter(NewTokenWriter(w, CONLLU))))
+		str := w.String()
+		w.Reset()
+		mat_de.TransduceTokenWriter(strings.NewReader("zum Zug."), exp.TokenWriter(wrap(NewTokenWriter(w, CONLLU))))
+		assert.Equal(str, w.String())
+		assert.True(strings.HasPrefix(str, "1-2\tzum\t"))
+	}
+
+	// Multi-word tokens are mapped to the original input
+	pp := NewPreprocessor(NewXMLFilter(false))
+	w.Reset()
+	pp.TransduceTokenWriter(mat_de, strings.NewReader("<b>zum</b> Zug"), exp.TokenWriter(NewTokenWriter(w, TOKEN_POS)))
+	assert.Equal("3 6 11 14\n", w.String())
+	w.Reset()
+	pp.TransduceTokenWriter(&expTokenizer{mat_de, exp}, strings.NewReader("<b>zum</b> Zug"), NewTokenWriter(w, CONLLU))
+	assert.True(strings.HasPrefix(w.String(), "1-2\tzum\t"))
+
+	// The English tokenizer splits clitics already
+	mat_en := LoadMatrixFile("testdata/tokenizer_en.matok")
+	assert.NotNil(mat_en)
+	w.Reset()
+	mat_en.TransduceTokenWriter(strings.NewReader("I don't know."), exp.TokenWriter(NewTokenWriter(w, SIMPLE)))
+	assert.Equal("I\ndo\nn't\nknow\n.\n\n\n", w.String())
+
+	// Other token writers ignore expansions
+	w.Reset()
+	mat_de.TransduceTokenWriter(strings.NewReader("Er ging zum Zug."), exp.TokenWriter(NewTokenWriter(w, SIMPLE)))
+	assert.Equal("Er\nging\nzum\nZug\n.\n\n\n", w.String())
+}
+
+// Tokenizer expanding the tokens, so the
+// expansions are applied inside of other token writers
+type expTokenizer struct {
+	Tokenizer
+	exp *Expansions
+}
+
+func (et *expTokenizer) TransduceTokenWriter(r io.Reader, w *TokenWriter) bool {
+	return et.Tokenizer.TransduceTokenWriter(r, et.exp.TokenWriter(w))
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"log"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"unicode/utf8"
@@ -24,6 +25,9 @@ type edge struct {
 	end      int
 	nontoken bool
 	tokenend bool
+
+	// Output of arcs rewriting the character
+	rewrite string
 }
 
 type Tokenizer interface {
@@ -48,6 +52,12 @@ type Automaton struct {
 	identity int
 	final    int
 	tokenend int
+
+	// Symbols for categories of characters not in sigma
+	categories categories
+
+	// Symbols for optional token boundaries
+	softBounds softBounds
 }
 
 // ParseFoma reads the FST from a foma file
@@ -75,23 +85,258 @@ func LoadFomaFile(file string) *Automaton {
 // and creates an internal representation,
 // in case it follows the tokenizer's convention.
 func ParseFoma(ior io.Reader) *Automaton {
-	r := bufio.NewReader(ior)
+	net := readFoma(ior)
+	if net == nil {
+		return nil
+	}
 
 	auto := &Automaton{
-		sigmaRev: make(map[int]rune),
-		sigmaMCS: make(map[int]string),
-		epsilon:  -1,
-		unknown:  -1,
-		identity: -1,
-		final:    -1,
-		tokenend: -1,
+		sigmaRev:   make(map[int]rune),
+		sigmaMCS:   make(map[int]string),
+		arcCount:   net.arcCount,
+		stateCount: net.stateCount,
+		epsilon:    -1,
+		unknown:    -1,
+		identity:   -1,
+		final:      -1,
+		tokenend:   -1,
+	}
+
+	// Sigma in the order of the file
+	numbers := make([]int, 0, len(net.sigma))
+	for number := range net.sigma {
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+
+	for _, number := range numbers {
+		name := net.sigma[number]
+
+		// ID needs to be > 1
+		auto.sigmaCount = number + 1
+		number++
+
+		// Read rune
+		if utf8.RuneCountInString(name) == 1 {
+			auto.sigmaRev[number] = []rune(name)[0]
+			continue
+		}
+
+		// Probably a MCS
+		switch name {
+		case "@_EPSILON_SYMBOL_@":
+			auto.epsilon = number
+
+		case "@_UNKNOWN_SYMBOL_@":
+			auto.unknown = number
+
+		case "@_IDENTITY_SYMBOL_@":
+			auto.identity = number
+
+		// Deprecated
+		case "@_TOKEN_SYMBOL_@":
+			auto.tokenend = number
+
+		case "@_TOKEN_BOUND_@":
+			auto.tokenend = number
+
+		default:
+			if cat := categoryOf(name); cat != catNone {
+				auto.categories[cat] = number
+				continue
+			}
+
+			if name, ok := softBoundOf(name); ok {
+				auto.softBounds = append(auto.softBounds, softBound{sym: number, name: name})
+				continue
+			}
+
+			// MCS not supported as input
+			auto.sigmaMCS[number] = name
+		}
 	}
 
-	var state, inSym, outSym, end, final int
+	// Adds a final transition symbol to sigma
+	// written as '#' in Mizobuchi et al (2000)
+	auto.sigmaCount++
+	auto.final = auto.sigmaCount
+
+	// Non-deterministic FSTs and FSTs with epsilon transitions
+	// are collected first and determinized afterwards.
+	// States start at 1 in Mizobuchi et al (2000),
+	// as the state 0 is associated with a fail.
+	var n *nfa
+	if !net.deterministic || !net.epsilonFree {
+		n = &nfa{
+			arcs:  make([][]*edge, net.stateCount+1),
+			final: make([]bool, net.stateCount+1),
+		}
+	} else {
+		auto.transitions = make([]map[int]*edge, net.stateCount+1)
+	}
+
+	for _, a := range net.arcs {
+		state := a.from
 
-	mode := 0
-	var elem []string
-	var elemint [5]int
+		// Final state that has no outgoing edges
+		if a.in == -1 {
+			if a.final && n != nil {
+				n.final[state+1] = true
+			} else if a.final {
+
+				// Initialize outgoing states
+				if auto.transitions[state+1] == nil {
+					auto.transitions[state+1] = make(map[int]*edge)
+				}
+
+				// TODO:
+				//   Maybe this is less relevant for tokenizers
+				auto.transitions[state+1][auto.final] = &edge{}
+			}
+			continue
+		}
+
+		nontoken := false
+		tokenend := false
+		rewrite := ""
+
+		// While the states in foma start with 0, the states in the
+		// Mizobuchi FSA start with one - so we increase every state by 1.
+		// We also increase sigma by 1, so there are no 0 transitions.
+		inSym := a.in + 1
+		outSym := a.out + 1
+
+		// Only a limited list of transitions are allowed
+		if inSym != outSym {
+			if outSym == auto.tokenend && inSym == auto.epsilon {
+				tokenend = true
+			} else if inSym == auto.epsilon && auto.softBounds.has(outSym) {
+				// Soft bounds are transitions on their own symbol
+				inSym = outSym
+			} else if outSym == auto.epsilon {
+				nontoken = true
+			} else if rewrite = auto.rewrite(inSym, outSym); rewrite == "" {
+				log.Println(
+					"Unsupported transition: " +
+						strconv.Itoa(state) +
+						" -> " + strconv.Itoa(a.to) +
+						" (" +
+						strconv.Itoa(inSym) +
+						":" +
+						strconv.Itoa(outSym) +
+						") (" +
+						string(auto.sigmaRev[inSym]) +
+						":" +
+						string(auto.sigmaRev[outSym]) +
+						")")
+				return nil
+			}
+		} else if inSym == auto.tokenend || auto.softBounds.has(inSym) {
+			// Ignore tokenend and soft bound accepting arcs
+			continue
+		} else if inSym == auto.epsilon && n == nil {
+			log.Println("General epsilon transitions are not supported")
+			return nil
+		} else if auto.sigmaMCS[inSym] != "" {
+			// log.Fatalln("Non supported character", tok.sigmaMCS[inSym])
+			// Ignore MCS transitions
+			continue
+		}
+
+		// Create an edge based on the collected information
+		targetObj := &edge{
+			inSym:    inSym,
+			outSym:   outSym,
+			end:      a.to + 1,
+			tokenend: tokenend,
+			nontoken: nontoken,
+			rewrite:  rewrite,
+		}
+
+		// Collect all arcs for determinization
+		if n != nil {
+			if inSym >= 0 {
+				n.arcs[state+1] = append(n.arcs[state+1], targetObj)
+			}
+			if a.final {
+				n.final[state+1] = true
+			}
+			continue
+		}
+
+		// Initialize outgoing states
+		if auto.transitions[state+1] == nil {
+			auto.transitions[state+1] = make(map[int]*edge)
+		}
+
+		// Ignore transitions with invalid symbols
+		if inSym >= 0 {
+			auto.transitions[state+1][inSym] = targetObj
+		}
+
+		// Add final transition
+		if a.final {
+			// TODO:
+			//   Maybe this is less relevant for tokenizers
+			auto.transitions[state+1][auto.final] = &edge{}
+		}
+
+		if DEBUG {
+			log.Println("Add",
+				state+1, "->", a.to+1,
+				"(",
+				inSym,
+				":",
+				outSym,
+				") (",
+				string(auto.sigmaRev[inSym]),
+				":",
+				string(auto.sigmaRev[outSym]),
+				")",
+				";",
+				"TE:", tokenend,
+				"NT:", nontoken,
+				"FIN:", a.final)
+		}
+	}
+	auto.sigmaMCS = nil
+
+	if n != nil {
+		return auto.determinize(n)
+	}
+	return auto
+}
+
+// An arc of a foma FST using foma's numbering.
+// Final states without outgoing arcs are
+// given as arcs with the input symbol -1.
+type fomaArc struct {
+	from, in, out, to int
+	final             bool
+}
+
+// A foma FST as given in the file
+type fomaNet struct {
+	arcCount      int
+	stateCount    int
+	deterministic bool
+	epsilonFree   bool
+	sigma         map[int]string
+	arcs          []fomaArc
+}
+
+// Read the properties, sigma and states of a foma FST
+// without any conversion, as the base for ParseFoma
+// and LintFoma.
+func readFoma(ior io.Reader) *fomaNet {
+	r := bufio.NewReader(ior)
+	net := &fomaNet{
+		sigma: make(map[int]string),
+	}
+
+	mode := NONE
+	state := 0
+	final := false
 
 	// Iterate over all lines of the file.
 	// This is mainly based on foma2js,
@@ -109,28 +354,18 @@ func ParseFoma(ior io.Reader) *Automaton {
 
 		// Read parser mode for the following lines
 		if strings.HasPrefix(line, "##") {
-			if strings.HasPrefix(line, "##props##") {
+			switch {
+			case strings.HasPrefix(line, "##props##"):
 				mode = PROPS
-
-			} else if strings.HasPrefix(line, "##states##") {
-				mode = STATES
-
-				// Adds a final transition symbol to sigma
-				// written as '#' in Mizobuchi et al (2000)
-				auto.sigmaCount++
-				auto.final = auto.sigmaCount
-
-			} else if strings.HasPrefix(line, "##sigma##") {
-
+			case strings.HasPrefix(line, "##sigma##"):
 				mode = SIGMA
-
-			} else if strings.HasPrefix(line, "##end##") {
-
+			case strings.HasPrefix(line, "##states##"):
+				mode = STATES
+			case strings.HasPrefix(line, "##end##"):
 				mode = NONE
-
-			} else if !strings.HasPrefix(line, "##foma-net") {
+			case !strings.HasPrefix(line, "##foma-net"):
 				log.Print("Unknown input line")
-				break
+				return nil
 			}
 			continue
 		}
@@ -138,315 +373,119 @@ func ParseFoma(ior io.Reader) *Automaton {
 		// Based on the current parser mode, interpret the lines
 		switch mode {
 		case PROPS:
-			{
-				elem = strings.Split(line, " ")
-				/*
-					log.Println("arity:            " + elem[0])
-					log.Println("arccount:         " + elem[1])
-					log.Println("statecount:       " + elem[2])
-					log.Println("linecount:        " + elem[3])
-					log.Println("finalcount:       " + elem[4])
-					log.Println("pathcount:        " + elem[5])
-					log.Println("is_deterministic: " + elem[6])
-					log.Println("is_pruned:        " + elem[7])
-					log.Println("is_minimized:     " + elem[8])
-					log.Println("is_epsilon_free:  " + elem[9])
-					log.Println("is_loop_free:     " + elem[10])
-					log.Println("extras:           " + elem[11])
-					log.Println("name:             " + elem[12])
-				*/
-				if elem[6] != "1" {
-					log.Print("The FST needs to be deterministic")
-					return nil
-				}
-
-				if elem[9] != "1" {
-					log.Print("The FST needs to be epsilon free")
-					return nil
-				}
+			elem := strings.Split(line, " ")
+			/*
+				log.Println("arity:            " + elem[0])
+				log.Println("arccount:         " + elem[1])
+				log.Println("statecount:       " + elem[2])
+				log.Println("linecount:        " + elem[3])
+				log.Println("finalcount:       " + elem[4])
+				log.Println("pathcount:        " + elem[5])
+				log.Println("is_deterministic: " + elem[6])
+				log.Println("is_pruned:        " + elem[7])
+				log.Println("is_minimized:     " + elem[8])
+				log.Println("is_epsilon_free:  " + elem[9])
+				log.Println("is_loop_free:     " + elem[10])
+				log.Println("extras:           " + elem[11])
+				log.Println("name:             " + elem[12])
+			*/
+			if len(elem) < 10 {
+				log.Print("Can't read properties")
+				return nil
+			}
+			if net.arcCount, err = strconv.Atoi(elem[1]); err != nil {
+				log.Print("Can't read arccount")
+				return nil
+			}
+			if net.stateCount, err = strconv.Atoi(elem[2]); err != nil {
+				log.Print("Can't read statecount")
+				return nil
+			}
+			net.deterministic = elem[6] == "1"
+			net.epsilonFree = elem[9] == "1"
 
-				elemint[0], err = strconv.Atoi(elem[1])
-				if err != nil {
-					log.Print("Can't read arccount")
-					return nil
-				}
-				auto.arcCount = elemint[0]
+		case SIGMA:
+			elem := strings.SplitN(line[0:len(line)-1], " ", 2)
+			number, err := strconv.Atoi(elem[0])
+			if err != nil || len(elem) < 2 {
+				log.Println("Can't read sigma", line)
+				return nil
+			}
+			name := elem[1]
 
-				elemint[0], err = strconv.Atoi(elem[2])
+			// Probably a new line symbol
+			if name == "" {
+				line, err = r.ReadString('\n')
 				if err != nil {
-					log.Print("Can't read statecount")
+					log.Println(err)
 					return nil
 				}
-
-				// States start at 1 in Mizobuchi et al (2000),
-				// as the state 0 is associated with a fail.
-				// Initialize states and transitions
-				auto.stateCount = elemint[0]
-				auto.transitions = make([]map[int]*edge, elemint[0]+1)
-				continue
+				name = line
 			}
-		case STATES:
-			{
-				elem = strings.Split(line[0:len(line)-1], " ")
-				if elem[0] == "-1" {
-					if DEBUG {
-						log.Println("Skip", elem)
-					}
-					continue
-				}
-				elemint[0], err = strconv.Atoi(elem[0])
-				if err != nil {
-					log.Println("Unable to translate", elem[0])
-					break
-				}
-
-				if len(elem) > 1 {
-					elemint[1], err = strconv.Atoi(elem[1])
-					if err != nil {
-						log.Println("Unable to translate", elem[1])
-						break
-					}
-					if len(elem) > 2 {
-						elemint[2], err = strconv.Atoi(elem[2])
-						if err != nil {
-							log.Println("Unable to translate", elem[2])
-							break
-						}
-						if len(elem) > 3 {
-							elemint[3], err = strconv.Atoi(elem[3])
-							if err != nil {
-								log.Println("Unable to translate", elem[3])
-								break
-							}
-							if len(elem) > 4 {
-								elemint[4], err = strconv.Atoi(elem[4])
-								if err != nil {
-									log.Println("Unable to translate", elem[4])
-									break
-								}
-							}
-						}
-					}
-				}
-
-				switch len(elem) {
-				case 5:
-					{
-						state = elemint[0]
-						inSym = elemint[1]
-						outSym = elemint[2]
-						end = elemint[3]
-						final = elemint[4]
-					}
-				case 4:
-					{
-						if elemint[1] == -1 {
-							state = elemint[0]
-							final = elemint[3]
-
-							// Final state that has no outgoing edges
-							if final == 1 {
-
-								// Initialize outgoing states
-								if auto.transitions[state+1] == nil {
-									auto.transitions[state+1] = make(map[int]*edge)
-								}
-
-								// TODO:
-								//   Maybe this is less relevant for tokenizers
-								auto.transitions[state+1][auto.final] = &edge{}
-							}
-							continue
-						} else {
-							state = elemint[0]
-							inSym = elemint[1]
-							end = elemint[2]
-							final = elemint[3]
-							outSym = inSym
-						}
-					}
-				case 3:
-					{
-						inSym = elemint[0]
-						outSym = elemint[1]
-						end = elemint[2]
-					}
-				case 2:
-					{
-						inSym = elemint[0]
-						end = elemint[1]
-						outSym = inSym
-					}
-				}
-
-				nontoken := false
-				tokenend := false
-
-				// While the states in foma start with 0, the states in the
-				// Mizobuchi FSA start with one - so we increase every state by 1.
-				// We also increase sigma by 1, so there are no 0 transitions.
-				inSym++
-				outSym++
-
-				// Only a limited list of transitions are allowed
-				if inSym != outSym {
-					if outSym == auto.tokenend && inSym == auto.epsilon {
-						tokenend = true
-					} else if outSym == auto.epsilon {
-						nontoken = true
-					} else {
-						log.Println(
-							"Unsupported transition: " +
-								strconv.Itoa(state) +
-								" -> " + strconv.Itoa(end) +
-								" (" +
-								strconv.Itoa(inSym) +
-								":" +
-								strconv.Itoa(outSym) +
-								") (" +
-								string(auto.sigmaRev[inSym]) +
-								":" +
-								string(auto.sigmaRev[outSym]) +
-								")")
-						return nil
-					}
-				} else if inSym == auto.tokenend {
-					// Ignore tokenend accepting arcs
-					continue
-				} else if inSym == auto.epsilon {
-					log.Println("General epsilon transitions are not supported")
-					return nil
-				} else if auto.sigmaMCS[inSym] != "" {
-					// log.Fatalln("Non supported character", tok.sigmaMCS[inSym])
-					// Ignore MCS transitions
-					continue
-				}
-
-				// Create an edge based on the collected information
-				targetObj := &edge{
-					inSym:    inSym,
-					outSym:   outSym,
-					end:      end + 1,
-					tokenend: tokenend,
-					nontoken: nontoken,
-				}
-
-				// Initialize outgoing states
-				if auto.transitions[state+1] == nil {
-					auto.transitions[state+1] = make(map[int]*edge)
-				}
-
-				// Ignore transitions with invalid symbols
-				if inSym >= 0 {
-					auto.transitions[state+1][inSym] = targetObj
-				}
-
-				// Add final transition
-				if final == 1 {
-					// TODO:
-					//   Maybe this is less relevant for tokenizers
-					auto.transitions[state+1][auto.final] = &edge{}
-				}
+			net.sigma[number] = name
 
+		case STATES:
+			elem := strings.Split(line[0:len(line)-1], " ")
+			if elem[0] == "-1" {
 				if DEBUG {
-					log.Println("Add",
-						state+1, "->", end+1,
-						"(",
-						inSym,
-						":",
-						outSym,
-						") (",
-						string(auto.sigmaRev[inSym]),
-						":",
-						string(auto.sigmaRev[outSym]),
-						")",
-						";",
-						"TE:", tokenend,
-						"NT:", nontoken,
-						"FIN:", final)
+					log.Println("Skip", elem)
 				}
-
 				continue
 			}
-		case SIGMA:
-			{
-				elem = strings.SplitN(line[0:len(line)-1], " ", 2)
-
-				// Turn string into sigma id
-				number, err := strconv.Atoi(elem[0])
-
-				// ID needs to be > 1
-				number++
-
-				if err != nil {
-					log.Println(err)
+			v := make([]int, len(elem))
+			for i, e := range elem {
+				if v[i], err = strconv.Atoi(e); err != nil {
+					log.Println("Unable to translate", e)
 					return nil
 				}
+			}
 
-				auto.sigmaCount = number
-
-				var symbol rune
-
-				// Read rune
-				if utf8.RuneCountInString(elem[1]) == 1 {
-					symbol = []rune(elem[1])[0]
-
-				} else if utf8.RuneCountInString(elem[1]) > 1 {
-
-					// Probably a MCS
-					switch elem[1] {
-					case "@_EPSILON_SYMBOL_@":
-						{
-							auto.epsilon = number
-						}
-					case "@_UNKNOWN_SYMBOL_@":
-						{
-							auto.unknown = number
-						}
-
-					case "@_IDENTITY_SYMBOL_@":
-						{
-							auto.identity = number
-						}
-
-						// Deprecated
-					case "@_TOKEN_SYMBOL_@":
-						{
-							auto.tokenend = number
-						}
-
-					case "@_TOKEN_BOUND_@":
-						{
-							auto.tokenend = number
-						}
-					default:
-						{
-							// MCS not supported
-							auto.sigmaMCS[number] = line
-						}
-					}
-					continue
-
-				} else { // Probably a new line symbol
-					line, err = r.ReadString('\n')
-					if err != nil {
-						log.Println(err)
-						return nil
-					}
-					if len(line) != 1 {
-						// MCS not supported
-						auto.sigmaMCS[number] = line
-						continue
-					}
-					symbol = rune('\n')
+			var a fomaArc
+			switch len(v) {
+			case 5:
+				state = v[0]
+				final = v[4] == 1
+				a = fomaArc{state, v[1], v[2], v[3], final}
+			case 4:
+				state = v[0]
+				final = v[3] == 1
+				if v[1] == -1 {
+					a = fomaArc{state, -1, -1, -1, final}
+				} else {
+					a = fomaArc{state, v[1], v[1], v[2], final}
 				}
-
-				auto.sigmaRev[number] = symbol
+			case 3:
+				a = fomaArc{state, v[0], v[1], v[2], final}
+			case 2:
+				a = fomaArc{state, v[0], v[0], v[1], final}
+			default:
+				continue
 			}
+			net.arcs = append(net.arcs, a)
 		}
 	}
-	auto.sigmaMCS = nil
-	return auto
+	return net
+}
+
+// Get the output of an arc rewriting the input symbol
+// to a character or a multi-character symbol, or an
+// empty string, if the arc is no valid rewrite
+func (auto *Automaton) rewrite(inSym, outSym int) string {
+	if inSym == auto.epsilon || auto.sigmaMCS[inSym] != "" || auto.softBounds.has(inSym) ||
+		outSym == auto.unknown || outSym == auto.identity ||
+		outSym == auto.tokenend || auto.categories.has(outSym) ||
+		auto.softBounds.has(outSym) {
+		return ""
+	}
+	if sym, ok := auto.sigmaRev[outSym]; ok {
+		return string(sym)
+	}
+	return auto.sigmaMCS[outSym]
+}
+
+// StateCount returns the number of states in the automaton.
+func (auto *Automaton) StateCount() int {
+	return auto.stateCount
 }
 
 func LoadTokenizerFile(file string) Tokenizer {
@@ -475,7 +514,7 @@ func LoadTokenizerFile(file string) Tokenizer {
 
 	if string(mstr) == MAMAGIC {
 		return ParseMatrix(r)
-	} else if string(mstr) == DAMAGIC {
+	} else if string(mstr) == DAMAGIC || string(mstr) == DA64MAGIC {
 		return ParseDatok(r)
 	}
 
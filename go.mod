@@ -1,8 +1,15 @@
 module github.com/KorAP/datok
 
-go 1.16
+go 1.18
 
 require (
 	github.com/alecthomas/kong v0.9.0
 	github.com/stretchr/testify v1.9.0
+	golang.org/x/text v0.22.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
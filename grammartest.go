@@ -0,0 +1,409 @@
+package datok
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GrammarTest is a declarative regression test
+// for a tokenizer, defined in a test file like
+//
+//	# Comment
+//	=== Gender forms are not split
+//	tags: gender
+//	input: Nutzer:in
+//	---
+//	Nutzer:in
+//
+// Each test starts with a description line,
+// followed by tags, one or more input lines (joined
+// by newlines and optionally quoted as Go strings),
+// and the expected tokens, one per line, after a separator.
+// Sentence boundaries are checked if the expected tokens
+// contain empty lines, marking the sentence ends,
+// or the test has the header "check: sentences".
+// Tests known to fail are marked with the header
+// "fails", followed by the reason.
+type GrammarTest struct {
+	Description    string
+	Tags           []string
+	Input          string
+	Sentences      [][]string
+	CheckSentences bool
+
+	// Reason why the test is known to fail, if set
+	KnownFailure string
+
+	// Position in the test file
+	File string
+	Line int
+}
+
+// GrammarTestResult is the result of a grammar test.
+type GrammarTestResult struct {
+	Test      *GrammarTest
+	Sentences [][]string
+	Passed    bool
+	Time      time.Duration
+}
+
+// LoadGrammarTestFile reads grammar tests from a file.
+func LoadGrammarTestFile(file string) []*GrammarTest {
+	f, err := os.Open(file)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	defer f.Close()
+	return ParseGrammarTests(f, file)
+}
+
+// ParseGrammarTests reads grammar tests from a reader.
+// The name is used to refer to the position of the tests.
+func ParseGrammarTests(r io.Reader, name string) []*GrammarTest {
+	var tests []*GrammarTest
+	var test *GrammarTest
+	var expected []string
+	inExpected := false
+
+	finish := func() {
+		if test == nil {
+			return
+		}
+
+		// Remove empty lines before the next test
+		for len(expected) > 0 && expected[len(expected)-1] == "" {
+			expected = expected[:len(expected)-1]
+		}
+		test.Sentences = splitSentences(expected)
+		if len(test.Sentences) > 1 {
+			test.CheckSentences = true
+		}
+		tests = append(tests, test)
+		test = nil
+		expected = nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	n := 0
+	for scanner.Scan() {
+		line := scanner.Text()
+		n++
+
+		if strings.HasPrefix(line, "===") {
+			finish()
+			test = &GrammarTest{
+				Description: strings.TrimSpace(line[3:]),
+				File:        name,
+				Line:        n,
+			}
+			inExpected = false
+			continue
+		}
+
+		if inExpected {
+			expected = append(expected, line)
+			continue
+		}
+
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if test == nil {
+			log.Println("Missing test description in", name, "line", n)
+			return nil
+		}
+
+		if line == "---" {
+			inExpected = true
+			continue
+		}
+
+		elem := strings.SplitN(line, ":", 2)
+		if len(elem) != 2 {
+			log.Println("Unknown header in", name, "line", n)
+			return nil
+		}
+		value := strings.TrimSpace(elem[1])
+
+		switch strings.TrimSpace(elem[0]) {
+		case "tags":
+			test.Tags = append(test.Tags, strings.FieldsFunc(value, func(r rune) bool {
+				return r == ',' || r == ' ' || r == '\t'
+			})...)
+		case "input":
+			if strings.HasPrefix(value, "\"") {
+				v, err := strconv.Unquote(value)
+				if err != nil {
+					log.Println("Invalid quoted input in", name, "line", n)
+					return nil
+				}
+				value = v
+			}
+			if test.Input != "" {
+				test.Input += "\n"
+			}
+			test.Input += value
+		case "check":
+			test.CheckSentences = value == "sentences"
+		case "fails":
+			if value == "" {
+				log.Println("Missing reason of known failure in", name, "line", n)
+				return nil
+			}
+			test.KnownFailure = value
+		default:
+			log.Println("Unknown header in", name, "line", n)
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Println(err)
+		return nil
+	}
+	finish()
+	return tests
+}
+
+// HasTag returns true if the test has one of the tags.
+func (test *GrammarTest) HasTag(tags ...string) bool {
+	for _, tag := range tags {
+		for _, t := range test.Tags {
+			if t == tag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Run tokenizes the input of the test and compares
+// the result with the expectation.
+func (test *GrammarTest) Run(tok Tokenizer) *GrammarTestResult {
+	start := time.Now()
+	w := bytes.NewBuffer(make([]byte, 0, 2048))
+	tok.Transduce(strings.NewReader(test.Input), w)
+
+	res := &GrammarTestResult{
+		Test:      test,
+		Sentences: splitSentences(strings.Split(w.String(), "\n")),
+		Time:      time.Since(start),
+	}
+	res.Passed = equalLines(test.lines(test.Sentences), test.lines(res.Sentences))
+	return res
+}
+
+// Failed returns true, if the test failed unexpectedly,
+// or if it is known to fail, but passed.
+func (res *GrammarTestResult) Failed() bool {
+	return res.Passed == (res.Test.KnownFailure != "")
+}
+
+// Diff returns the differences between the expected and
+// the given tokens as lines prefixed by - and +.
+func (res *GrammarTestResult) Diff() string {
+	exp := res.Test.lines(res.Test.Sentences)
+	got := res.Test.lines(res.Sentences)
+
+	// Longest common subsequence
+	lcs := make([][]int, len(exp)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(got)+1)
+	}
+	for i := len(exp) - 1; i >= 0; i-- {
+		for j := len(got) - 1; j >= 0; j-- {
+			if exp[i] == got[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var sb strings.Builder
+	i, j := 0, 0
+	for i < len(exp) || j < len(got) {
+		switch {
+		case i < len(exp) && j < len(got) && exp[i] == got[j]:
+			sb.WriteString(" " + exp[i] + "\n")
+			i++
+			j++
+		case i < len(exp) && (j == len(got) || lcs[i+1][j] >= lcs[i][j+1]):
+			sb.WriteString("-" + exp[i] + "\n")
+			i++
+		default:
+			sb.WriteString("+" + got[j] + "\n")
+			j++
+		}
+	}
+	return sb.String()
+}
+
+// Tokens as lines, with empty lines between sentences
+// in case sentences are checked
+func (test *GrammarTest) lines(sentences [][]string) []string {
+	var lines []string
+	for i, s := range sentences {
+		if i > 0 && test.CheckSentences {
+			lines = append(lines, "")
+		}
+		lines = append(lines, s...)
+	}
+	return lines
+}
+
+// Group lines of tokens into sentences separated by empty lines
+func splitSentences(lines []string) [][]string {
+	var sentences [][]string
+	var sentence []string
+	for _, line := range lines {
+		if line == "" {
+			if len(sentence) > 0 {
+				sentences = append(sentences, sentence)
+				sentence = nil
+			}
+			continue
+		}
+		sentence = append(sentence, line)
+	}
+	if len(sentence) > 0 {
+		sentences = append(sentences, sentence)
+	}
+	return sentences
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// JUnit XML report structure
+type junitSuites struct {
+	XMLName  xml.Name      `xml:"testsuites"`
+	Tests    int           `xml:"tests,attr"`
+	Failures int           `xml:"failures,attr"`
+	Skipped  int           `xml:"skipped,attr"`
+	Suites   []*junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Time     string       `xml:"time,attr"`
+	Cases    []*junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name       string          `xml:"name,attr"`
+	Classname  string          `xml:"classname,attr"`
+	Time       string          `xml:"time,attr"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	Failure    *junitFailure   `xml:"failure,omitempty"`
+	Skipped    *junitSkipped   `xml:"skipped,omitempty"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Diff    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+// WriteJUnit writes the results as a JUnit XML report,
+// with a test suite per test file.
+// Known failures are reported as skipped,
+// and known failures passing unexpectedly as failures.
+func WriteJUnit(w io.Writer, results []*GrammarTestResult) error {
+	report := &junitSuites{}
+	suites := make(map[string]*junitSuite)
+	times := make(map[string]time.Duration)
+
+	for _, res := range results {
+		file := res.Test.File
+		suite, ok := suites[file]
+		if !ok {
+			suite = &junitSuite{Name: file}
+			suites[file] = suite
+			report.Suites = append(report.Suites, suite)
+		}
+
+		tc := &junitCase{
+			Name:      res.Test.Description,
+			Classname: file + ":" + strconv.Itoa(res.Test.Line),
+			Time:      seconds(res.Time),
+		}
+		for _, tag := range res.Test.Tags {
+			tc.Properties = append(tc.Properties, junitProperty{"tag", tag})
+		}
+		if !res.Passed && res.Test.KnownFailure != "" {
+			tc.Skipped = &junitSkipped{
+				Message: "Known failure: " + res.Test.KnownFailure,
+			}
+			suite.Skipped++
+			report.Skipped++
+		} else if res.Passed && res.Test.KnownFailure != "" {
+			tc.Failure = &junitFailure{
+				Message: "Known failure passed unexpectedly: " + res.Test.KnownFailure,
+			}
+			suite.Failures++
+			report.Failures++
+		} else if !res.Passed {
+			tc.Failure = &junitFailure{
+				Message: "Unexpected tokenization of " + strconv.Quote(res.Test.Input),
+				Diff:    res.Diff(),
+			}
+			suite.Failures++
+			report.Failures++
+		}
+		suite.Tests++
+		report.Tests++
+		times[file] += res.Time
+		suite.Cases = append(suite.Cases, tc)
+	}
+
+	for file, suite := range suites {
+		suite.Time = seconds(times[file])
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// Format a duration in seconds
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 6, 64)
+}
@@ -0,0 +1,162 @@
+package datok
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var grammarTests = `# Tests for the german tokenizer
+=== Punctuation is split
+tags: punct, simple
+input: Der Wald!
+---
+Der
+Wald
+!
+
+=== Sentences are split
+input: "Der Wald! Er\tist"
+---
+Der
+Wald
+!
+
+Er
+ist
+
+=== Sentence ends are checked
+tags: punct
+check: sentences
+input: Der Wald!
+input: Er ist
+---
+Der
+Wald
+!
+Er
+ist
+
+=== Sentence ends are known to be missing
+check: sentences
+fails: missing sentence end
+input: Der Wald!
+input: Er ist
+---
+Der
+Wald
+!
+Er
+ist
+
+=== Punctuation is known to stick
+fails: stale marker
+input: Der Wald!
+---
+Der
+Wald
+!
+`
+
+func TestGrammarTestParse(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := ParseGrammarTests(strings.NewReader(grammarTests), "simple.test")
+	assert.Equal(5, len(tests))
+
+	assert.Equal("Punctuation is split", tests[0].Description)
+	assert.Equal([]string{"punct", "simple"}, tests[0].Tags)
+	assert.Equal("Der Wald!", tests[0].Input)
+	assert.Equal([][]string{{"Der", "Wald", "!"}}, tests[0].Sentences)
+	assert.False(tests[0].CheckSentences)
+	assert.Equal(2, tests[0].Line)
+	assert.True(tests[0].HasTag("simple"))
+	assert.False(tests[1].HasTag("simple"))
+
+	assert.Equal("Der Wald! Er\tist", tests[1].Input)
+	assert.Equal(2, len(tests[1].Sentences))
+	assert.True(tests[1].CheckSentences)
+
+	assert.Equal("Der Wald!\nEr ist", tests[2].Input)
+	assert.True(tests[2].CheckSentences)
+	assert.Equal("", tests[2].KnownFailure)
+	assert.Equal("missing sentence end", tests[3].KnownFailure)
+
+	assert.Nil(ParseGrammarTests(strings.NewReader("input: x\n"), "x"))
+	assert.Nil(ParseGrammarTests(strings.NewReader("=== x\nfoo: x\n"), "x"))
+	assert.Nil(ParseGrammarTests(strings.NewReader("=== x\ninput: \"x\n"), "x"))
+	assert.Nil(ParseGrammarTests(strings.NewReader("=== x\nfails:\ninput: x\n"), "x"))
+}
+
+func TestGrammarTestRun(t *testing.T) {
+	assert := assert.New(t)
+
+	if mat_de == nil {
+		mat_de = LoadMatrixFile("testdata/tokenizer_de.matok")
+	}
+	tests := ParseGrammarTests(strings.NewReader(grammarTests), "simple.test")
+
+	var results []*GrammarTestResult
+	for _, test := range tests {
+		results = append(results, test.Run(mat_de))
+	}
+	assert.True(results[0].Passed)
+	assert.True(results[1].Passed)
+	assert.False(results[2].Passed)
+	assert.Equal(" Der\n Wald\n !\n+\n Er\n ist\n", results[2].Diff())
+	assert.False(results[3].Passed)
+	assert.True(results[4].Passed)
+
+	assert.False(results[0].Failed())
+	assert.True(results[2].Failed())
+	assert.False(results[3].Failed())
+	assert.True(results[4].Failed())
+
+	w := bytes.NewBuffer(nil)
+	assert.Nil(WriteJUnit(w, results))
+
+	var report junitSuites
+	assert.Nil(xml.Unmarshal(w.Bytes(), &report))
+	assert.Equal(5, report.Tests)
+	assert.Equal(2, report.Failures)
+	assert.Equal(1, report.Skipped)
+	assert.Equal("simple.test", report.Suites[0].Name)
+	assert.Equal("Punctuation is split", report.Suites[0].Cases[0].Name)
+	assert.Equal("punct", report.Suites[0].Cases[0].Properties[0].Value)
+	assert.Nil(report.Suites[0].Cases[0].Failure)
+	assert.Equal(2, report.Suites[0].Failures)
+
+	failure := report.Suites[0].Cases[2].Failure
+	assert.Equal("Unexpected tokenization of \"Der Wald!\\nEr ist\"", failure.Message)
+	assert.Equal(" Der\n Wald\n !\n+\n Er\n ist\n", failure.Diff)
+	assert.Nil(report.Suites[0].Cases[2].Skipped)
+
+	assert.Nil(report.Suites[0].Cases[3].Failure)
+	assert.Equal("Known failure: missing sentence end", report.Suites[0].Cases[3].Skipped.Message)
+
+	assert.Equal("Known failure passed unexpectedly: stale marker", report.Suites[0].Cases[4].Failure.Message)
+	assert.Nil(report.Suites[0].Cases[4].Skipped)
+}
+
+func TestGrammarTestFile(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := LoadGrammarTestFile("testdata/de/tokenizer.test")
+	assert.Equal(59, len(tests))
+
+	if mat_de == nil {
+		mat_de = LoadMatrixFile("testdata/tokenizer_de.matok")
+	}
+
+	for _, test := range tests {
+		res := test.Run(mat_de)
+		if test.KnownFailure != "" {
+			assert.Falsef(res.Passed, "Known failure passes: %s", test.Description)
+		} else {
+			assert.Truef(res.Passed, "%s\n%s", test.Description, res.Diff())
+		}
+	}
+}
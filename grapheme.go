@@ -0,0 +1,270 @@
+package datok
+
+import (
+	"bufio"
+	"unicode"
+)
+
+// Grapheme cluster break properties following UAX #29
+const (
+	gbOther = iota
+	gbCR
+	gbLF
+	gbControl
+	gbExtend
+	gbZWJ
+	gbRegionalIndicator
+	gbPrepend
+	gbSpacingMark
+	gbL
+	gbV
+	gbT
+	gbLV
+	gbLVT
+)
+
+// Extended_Pictographic characters (as of Unicode 15)
+var extendedPictographic = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{0x00A9, 0x00A9, 1}, {0x00AE, 0x00AE, 1}, {0x203C, 0x203C, 1},
+		{0x2049, 0x2049, 1}, {0x2122, 0x2122, 1}, {0x2139, 0x2139, 1},
+		{0x2194, 0x2199, 1}, {0x21A9, 0x21AA, 1}, {0x231A, 0x231B, 1},
+		{0x2328, 0x2328, 1}, {0x2388, 0x2388, 1}, {0x23CF, 0x23CF, 1},
+		{0x23E9, 0x23F3, 1}, {0x23F8, 0x23FA, 1}, {0x24C2, 0x24C2, 1},
+		{0x25AA, 0x25AB, 1}, {0x25B6, 0x25B6, 1}, {0x25C0, 0x25C0, 1},
+		{0x25FB, 0x25FE, 1}, {0x2600, 0x2605, 1}, {0x2607, 0x2612, 1},
+		{0x2614, 0x2685, 1}, {0x2690, 0x2705, 1}, {0x2708, 0x2712, 1},
+		{0x2714, 0x2714, 1}, {0x2716, 0x2716, 1}, {0x271D, 0x271D, 1},
+		{0x2721, 0x2721, 1}, {0x2728, 0x2728, 1}, {0x2733, 0x2734, 1},
+		{0x2744, 0x2744, 1}, {0x2747, 0x2747, 1}, {0x274C, 0x274C, 1},
+		{0x274E, 0x274E, 1}, {0x2753, 0x2755, 1}, {0x2757, 0x2757, 1},
+		{0x2763, 0x2767, 1}, {0x2795, 0x2797, 1}, {0x27A1, 0x27A1, 1},
+		{0x27B0, 0x27B0, 1}, {0x27BF, 0x27BF, 1}, {0x2934, 0x2935, 1},
+		{0x2B05, 0x2B07, 1}, {0x2B1B, 0x2B1C, 1}, {0x2B50, 0x2B50, 1},
+		{0x2B55, 0x2B55, 1}, {0x3030, 0x3030, 1}, {0x303D, 0x303D, 1},
+		{0x3297, 0x3297, 1}, {0x3299, 0x3299, 1},
+	},
+	R32: []unicode.Range32{
+		{0x1F000, 0x1F0FF, 1}, {0x1F10D, 0x1F10F, 1}, {0x1F12F, 0x1F12F, 1},
+		{0x1F16C, 0x1F171, 1}, {0x1F17E, 0x1F17F, 1}, {0x1F18E, 0x1F18E, 1},
+		{0x1F191, 0x1F19A, 1}, {0x1F1AD, 0x1F1E5, 1}, {0x1F201, 0x1F20F, 1},
+		{0x1F21A, 0x1F21A, 1}, {0x1F22F, 0x1F22F, 1}, {0x1F232, 0x1F23A, 1},
+		{0x1F23C, 0x1F23F, 1}, {0x1F249, 0x1F3FA, 1}, {0x1F400, 0x1F53D, 1},
+		{0x1F546, 0x1F64F, 1}, {0x1F680, 0x1F6FF, 1}, {0x1F774, 0x1F77F, 1},
+		{0x1F7D5, 0x1F7FF, 1}, {0x1F80C, 0x1F80F, 1}, {0x1F848, 0x1F84F, 1},
+		{0x1F85A, 0x1F85F, 1}, {0x1F888, 0x1F88F, 1}, {0x1F8AE, 0x1F8FF, 1},
+		{0x1F90C, 0x1F93A, 1}, {0x1F93C, 0x1F945, 1}, {0x1F947, 0x1FAFF, 1},
+		{0x1FC00, 0x1FFFD, 1},
+	},
+	LatinOffset: 2,
+}
+
+// Spacing marks, that are not treated as SpacingMark
+var notSpacingMark = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{0x102B, 0x102C, 1}, {0x1038, 0x1038, 1}, {0x1062, 0x1064, 1},
+		{0x1067, 0x106D, 1}, {0x1083, 0x1083, 1}, {0x1087, 0x108C, 1},
+		{0x108F, 0x108F, 1}, {0x109A, 0x109C, 1}, {0x1A61, 0x1A61, 1},
+		{0x1A63, 0x1A64, 1}, {0xAA7B, 0xAA7B, 1}, {0xAA7D, 0xAA7D, 1},
+	},
+	R32: []unicode.Range32{
+		{0x11720, 0x11721, 1},
+	},
+}
+
+// Prepended concatenation marks
+var prepend = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{0x0600, 0x0605, 1}, {0x06DD, 0x06DD, 1}, {0x070F, 0x070F, 1},
+		{0x0890, 0x0891, 1}, {0x08E2, 0x08E2, 1}, {0x0D4E, 0x0D4E, 1},
+	},
+	R32: []unicode.Range32{
+		{0x110BD, 0x110BD, 1}, {0x110CD, 0x110CD, 1}, {0x111C2, 0x111C3, 1},
+		{0x1193F, 0x1193F, 1}, {0x11941, 0x11941, 1}, {0x11A3A, 0x11A3A, 1},
+		{0x11A84, 0x11A89, 1}, {0x11D46, 0x11D46, 1},
+	},
+}
+
+// Get the grapheme cluster break property of a character
+func graphemeProperty(r rune) int {
+	switch {
+	case r == '\r':
+		return gbCR
+	case r == '\n':
+		return gbLF
+	case r == 0x200D:
+		return gbZWJ
+	case r < 0x7F && r >= 0x20:
+		return gbOther
+	case r >= 0x1F1E6 && r <= 0x1F1FF:
+		return gbRegionalIndicator
+	case r >= 0x1F3FB && r <= 0x1F3FF, r == 0x200C, r >= 0xE0020 && r <= 0xE007F:
+		return gbExtend
+	case unicode.Is(prepend, r):
+		return gbPrepend
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Other_Grapheme_Extend):
+		return gbExtend
+	case unicode.In(r, unicode.Cc, unicode.Cf, unicode.Zl, unicode.Zp):
+		return gbControl
+	case r == 0x0E33 || r == 0x0EB3:
+		return gbSpacingMark
+	case unicode.Is(unicode.Mc, r) && !unicode.Is(notSpacingMark, r):
+		return gbSpacingMark
+	case r >= 0x1100 && r <= 0x115F, r >= 0xA960 && r <= 0xA97C:
+		return gbL
+	case r >= 0x1160 && r <= 0x11A7, r >= 0xD7B0 && r <= 0xD7C6:
+		return gbV
+	case r >= 0x11A8 && r <= 0x11FF, r >= 0xD7CB && r <= 0xD7FB:
+		return gbT
+	case r >= 0xAC00 && r <= 0xD7A3:
+		if (r-0xAC00)%28 == 0 {
+			return gbLV
+		}
+		return gbLVT
+	}
+	return gbOther
+}
+
+// Segmenter for extended grapheme clusters (UAX #29),
+// reading one character after the other
+type graphemes struct {
+	started bool
+	prev    int  // Property of the previous character
+	pict    bool // Previous characters are ExtPict Extend*
+	pictZWJ bool // Previous characters are ExtPict Extend* ZWJ
+	ri      int  // Number of preceding regional indicators
+}
+
+// Returns true, if there is a grapheme cluster
+// boundary before the character
+func (g *graphemes) next(r rune) bool {
+	p := graphemeProperty(r)
+	pict := unicode.Is(extendedPictographic, r)
+
+	brk := true
+	prev := g.prev
+	switch {
+	case !g.started:
+		g.started = true
+
+	// GB3
+	case prev == gbCR && p == gbLF:
+		brk = false
+
+	// GB4, GB5
+	case prev == gbCR || prev == gbLF || prev == gbControl,
+		p == gbCR || p == gbLF || p == gbControl:
+
+	// GB6 - GB8
+	case prev == gbL && (p == gbL || p == gbV || p == gbLV || p == gbLVT),
+		(prev == gbLV || prev == gbV) && (p == gbV || p == gbT),
+		(prev == gbLVT || prev == gbT) && p == gbT:
+		brk = false
+
+	// GB9, GB9a, GB9b
+	case p == gbExtend || p == gbZWJ || p == gbSpacingMark || prev == gbPrepend:
+		brk = false
+
+	// GB11
+	case g.pictZWJ && pict:
+		brk = false
+
+	// GB12, GB13
+	case prev == gbRegionalIndicator && p == gbRegionalIndicator && g.ri%2 == 1:
+		brk = false
+	}
+
+	// Remember the context
+	if p == gbRegionalIndicator {
+		g.ri++
+	} else {
+		g.ri = 0
+	}
+	g.pictZWJ = p == gbZWJ && g.pict
+	if p != gbExtend {
+		g.pict = pict
+	}
+	g.prev = p
+
+	return brk
+}
+
+// Boundaries of extended grapheme clusters in the buffer
+// of a transduction, so tokens are never ended inside
+// of a cluster
+type clusterBuffer struct {
+	seg    graphemes
+	breaks []bool // Boundary before the character in the buffer
+}
+
+// Create a new cluster buffer for a transduction buffer
+// of the given size
+func newClusterBuffer(size int) *clusterBuffer {
+	return &clusterBuffer{
+		breaks: make([]bool, size),
+	}
+}
+
+// Remember the boundary before a character,
+// read into the buffer at position i
+func (cb *clusterBuffer) read(i int, r rune) {
+	cb.breaks[i] = cb.seg.next(r)
+}
+
+// Rewind the boundaries along with the buffer
+func (cb *clusterBuffer) rewind(buffc, buffi int) {
+	copy(cb.breaks[0:], cb.breaks[buffc:buffi])
+}
+
+// Get the start of the cluster of the first character
+// of a token, including skipped characters of the cluster
+func (cb *clusterBuffer) start(bufft int) int {
+	for bufft > 0 && !cb.breaks[bufft] {
+		bufft--
+	}
+	return bufft
+}
+
+// Get the end of the cluster of the last character
+// of a token, reading further characters into the buffer
+// as long as the cluster is continued.
+// Returns the end, the new buffer length and
+// if the reader has no more runes.
+func (cb *clusterBuffer) end(reader *bufio.Reader, buffer []rune, buffc, buffi int, eof bool) (int, int, bool) {
+	for {
+		if buffc == buffi {
+			if eof {
+				return buffc, buffi, true
+			}
+			char, _, err := reader.ReadRune()
+			if err != nil {
+				return buffc, buffi, true
+			}
+			buffer[buffi] = char
+			cb.read(buffi, char)
+			buffi++
+		}
+		if cb.breaks[buffc] {
+			return buffc, buffi, false
+		}
+		buffc++
+	}
+}
+
+// Extend a token to the boundaries of the clusters
+// of its first and last characters.
+// Characters added to the token are passed unchanged
+// as part of the normalized form.
+func (cb *clusterBuffer) extend(reader *bufio.Reader, buffer []rune, outs [][]rune, bufft, buffc, buffi int, eof bool) (int, int, int, bool) {
+	start := cb.start(bufft)
+	end, buffi, eof := cb.end(reader, buffer, buffc, buffi, eof)
+	if outs != nil {
+		for i := start; i < bufft; i++ {
+			outs[i] = nil
+		}
+		for i := buffc; i < end; i++ {
+			outs[i] = nil
+		}
+	}
+	return start, end, buffi, eof
+}
@@ -0,0 +1,105 @@
+package datok
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Split a string into extended grapheme clusters
+func tgraphemes(str string) []string {
+	var seg graphemes
+	var clusters []string
+	for _, r := range str {
+		if seg.next(r) {
+			clusters = append(clusters, "")
+		}
+		clusters[len(clusters)-1] += string(r)
+	}
+	return clusters
+}
+
+func TestGraphemeClusters(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal([]string{"a", "b", "c"}, tgraphemes("abc"))
+
+	// Combining diacritics
+	assert.Equal([]string{"é", "x"}, tgraphemes("éx"))
+
+	// Skin tone modifiers and variation selectors
+	assert.Equal([]string{"👍🏽", "❤️"}, tgraphemes("👍🏽❤️"))
+
+	// ZWJ sequences
+	assert.Equal([]string{"👨‍👩‍👧", "!"}, tgraphemes("👨‍👩‍👧!"))
+	assert.Equal([]string{"x‍", ":"}, tgraphemes("x‍:"))
+
+	// Flag pairs
+	assert.Equal([]string{"🇩🇪", "🇫🇷", "🇮"}, tgraphemes("🇩🇪🇫🇷🇮"))
+
+	// Keycaps
+	assert.Equal([]string{"1️⃣"}, tgraphemes("1️⃣"))
+
+	// Hangul syllables
+	assert.Equal([]string{"각", "한"}, tgraphemes("각한"))
+
+	// Line breaks
+	assert.Equal([]string{"a", "\r\n", "́"}, tgraphemes("a\r\ń"))
+}
+
+// Create a token writer, that never ends tokens
+// inside of grapheme clusters
+func tgraphemeTokenWriter(w io.Writer, flags Bits) *TokenWriter {
+	tw := NewTokenWriter(w, flags)
+	tw.Graphemes = true
+	return tw
+}
+
+func TestGraphemeTransduce(t *testing.T) {
+	assert := assert.New(t)
+
+	if mat_de == nil {
+		mat_de = LoadMatrixFile("testdata/tokenizer_de.matok")
+	}
+	if dat == nil {
+		dat = LoadDatokFile("testdata/tokenizer_de.datok")
+	}
+
+	b := make([]byte, 0, 2048)
+	w := bytes.NewBuffer(b)
+
+	for _, tok := range []Tokenizer{mat_de, dat} {
+
+		// Emoticons followed by modifiers are split by the tokenizer
+		w.Reset()
+		tok.TransduceTokenWriter(strings.NewReader("Super :-)🏽 toll :-)️ ;)😂"), NewTokenWriter(w, SIMPLE))
+		assert.Equal("Super\n:-)\n🏽\ntoll\n:-)\n️\n;)\n😂\n\n\n", w.String())
+
+		w.Reset()
+		tok.TransduceTokenWriter(strings.NewReader("Super :-)🏽 toll :-)️ ;)😂"), tgraphemeTokenWriter(w, SIMPLE))
+		assert.Equal("Super\n:-)🏽\ntoll\n:-)️\n;)\n😂\n\n\n", w.String())
+
+		// Emoji sequences and emoticons next to emoji
+		w.Reset()
+		tok.TransduceTokenWriter(strings.NewReader("Hi :)👍🏽 👨‍👩‍👧 x‍:) (👍🏽)"), tgraphemeTokenWriter(w, SIMPLE))
+		assert.Equal("Hi\n:)\n👍🏽\n👨‍👩‍👧\nx‍\n:)\n(\n👍🏽\n)\n\n\n", w.String())
+
+		// Positions are kept
+		w.Reset()
+		tok.TransduceTokenWriter(strings.NewReader("a :-)🏽 b"), tgraphemeTokenWriter(w, TOKEN_POS))
+		assert.Equal("0 1 2 6 7 8\n", w.String())
+
+		// Skipped characters in a cluster are part of the token
+		w.Reset()
+		tok.TransduceTokenWriter(strings.NewReader("a ́b"), tgraphemeTokenWriter(w, TOKENS|TOKEN_POS))
+		assert.Equal("a\n ́b\n0 1 1 4\n", w.String())
+
+		// Sentence ends are moved behind the cluster
+		w.Reset()
+		tok.TransduceTokenWriter(strings.NewReader("Er ging.🏽 Sie kam."), tgraphemeTokenWriter(w, SIMPLE))
+		assert.Equal("Er\nging\n.🏽\n\nSie\nkam\n.\n\n\n", w.String())
+	}
+}
@@ -0,0 +1,325 @@
+package datok
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Lexicon is a list of protected strings, that is applied
+// at runtime on top of the token stream of any tokenizer.
+// Matches of listed strings, that start at the beginning
+// of a token and end at the end of a token, are kept as
+// single tokens, or, in case a split is given, are split
+// into the listed parts.
+//
+// Whitespace in entries matches any sequence of whitespace
+// characters between two tokens.
+type Lexicon struct {
+	root *lexNode
+}
+
+// A node in the character trie of the lexicon
+type lexNode struct {
+	next  map[rune]*lexNode
+	final bool
+	split []string
+}
+
+// Kind of a buffered token stream event
+const (
+	evToken = iota
+	evSentenceEnd
+	evTextEnd
+	evSkip
+	evMultiWord
+)
+
+// A buffered token stream event
+type lexEvent struct {
+	kind   int
+	offset int
+	buf    []rune
+	norm   []rune
+	words  [][]rune
+	arg    int
+}
+
+// NewLexicon creates a new empty lexicon.
+func NewLexicon() *Lexicon {
+	return &Lexicon{root: &lexNode{}}
+}
+
+// LoadLexiconFile reads a lexicon from a file.
+func LoadLexiconFile(file string) *Lexicon {
+	f, err := os.Open(file)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	defer f.Close()
+
+	return ParseLexicon(f)
+}
+
+// ParseLexicon reads a lexicon with one entry per line.
+// An entry may be followed by a tab and a space separated
+// list of parts the entry should be split into.
+// Empty lines and lines starting with '#' are ignored.
+func ParseLexicon(ior io.Reader) *Lexicon {
+	lex := NewLexicon()
+	scanner := bufio.NewScanner(ior)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		elem := strings.SplitN(line, "\t", 2)
+		var split []string
+		if len(elem) > 1 {
+			split = strings.Fields(elem[1])
+		}
+		if !lex.Add(elem[0], split...) {
+			return nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		log.Println(err)
+		return nil
+	}
+	return lex
+}
+
+// Add adds a protected string to the lexicon.
+// If parts are given, matches are split into these parts,
+// that need to concatenate to the entry.
+func (lex *Lexicon) Add(entry string, parts ...string) bool {
+	entry = strings.Join(strings.Fields(entry), " ")
+	if entry == "" {
+		log.Println("Empty lexicon entry")
+		return false
+	}
+
+	if len(parts) > 0 && strings.Join(parts, "") != entry {
+		log.Println("Split of lexicon entry does not match:", entry)
+		return false
+	}
+
+	node := lex.root
+	for _, r := range entry {
+		if node.next == nil {
+			node.next = make(map[rune]*lexNode)
+		}
+		n, ok := node.next[r]
+		if !ok {
+			n = &lexNode{}
+			node.next[r] = n
+		}
+		node = n
+	}
+	node.final = true
+	node.split = parts
+	return true
+}
+
+// Walk the trie along the runes
+func (node *lexNode) walk(runes []rune) *lexNode {
+	for _, r := range runes {
+		if node == nil {
+			return nil
+		}
+		node = node.next[r]
+	}
+	return node
+}
+
+// Returns true if all runes are whitespace characters
+func isSpace(runes []rune) bool {
+	for _, r := range runes {
+		if !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// TokenWriter wraps a token writer, so that all tokens passed
+// are adjusted according to the lexicon.
+// Sentence ends inside of protected matches are dropped.
+// Normalized forms are only passed for tokens
+// not adjusted by the lexicon. Multi-word tokens
+// are passed unchanged and end all matches.
+func (lex *Lexicon) TokenWriter(tw *TokenWriter) *TokenWriter {
+
+	pending := make([]lexEvent, 0, 16)
+	merged := make([]rune, 0, 64)
+	space := []rune{' '}
+
+	// Emit all pending events that can't be part
+	// of a longer match. If final is true,
+	// no more tokens are expected.
+	resolve := func(final bool) {
+		for len(pending) > 0 {
+			ev := pending[0]
+
+			if ev.kind == evSentenceEnd {
+				tw.SentenceEnd(ev.arg)
+				pending = pending[1:]
+				continue
+			} else if ev.kind == evTextEnd {
+				tw.TextEnd(ev.arg)
+				pending = pending[1:]
+				continue
+			} else if ev.kind == evSkip {
+				tw.Skip(SkipKind(ev.arg), ev.buf)
+				pending = pending[1:]
+				continue
+			} else if ev.kind == evMultiWord {
+				tw.MultiWordToken(ev.offset, ev.buf, ev.words)
+				pending = pending[1:]
+				continue
+			}
+
+			// Find the longest match ending at a token end
+			node := lex.root
+			match := -1
+			var matchNode *lexNode
+			i := 0
+			for ; i < len(pending) && node != nil; i++ {
+				ev := pending[i]
+				if ev.kind == evTextEnd || ev.kind == evSkip || ev.kind == evMultiWord {
+					break
+				} else if ev.kind != evToken {
+					continue
+				}
+
+				if i > 0 {
+					gap := ev.buf[:ev.offset]
+					if len(gap) > 0 && isSpace(gap) {
+						gap = space
+					}
+					node = node.walk(gap)
+				}
+				node = node.walk(ev.buf[ev.offset:])
+
+				if node != nil && node.final {
+					match = i
+					matchNode = node
+				}
+			}
+
+			// The match may be continued by following tokens
+			if !final && i == len(pending) && node != nil && len(node.next) > 0 {
+				return
+			}
+
+			// No protected match
+			if match == -1 || (match == 0 && matchNode.split == nil) {
+				if ev.norm != nil {
+					tw.NormalizedToken(ev.offset, ev.buf, ev.norm)
+				} else {
+					tw.Token(ev.offset, ev.buf)
+				}
+				pending = pending[1:]
+				continue
+			}
+
+			// Split the match into the listed parts
+			if matchNode.split != nil {
+				merged = append(merged[:0], ev.buf[:ev.offset]...)
+				offset := ev.offset
+				for _, part := range matchNode.split {
+					merged = append(merged[:offset], []rune(part)...)
+					tw.Token(offset, merged)
+					offset = 0
+				}
+
+				// Merge all tokens of the match
+			} else {
+				merged = merged[:0]
+				for _, ev := range pending[:match+1] {
+					if ev.kind == evToken {
+						merged = append(merged, ev.buf...)
+					}
+				}
+				tw.Token(ev.offset, merged)
+			}
+			pending = pending[match+1:]
+		}
+	}
+
+	ltw := &TokenWriter{
+		Token: func(offset int, buf []rune) {
+			pending = append(pending, lexEvent{
+				kind:   evToken,
+				offset: offset,
+				buf:    append([]rune(nil), buf...),
+			})
+			resolve(false)
+		},
+		SentenceEnd: func(arg int) {
+			if len(pending) == 0 {
+				tw.SentenceEnd(arg)
+				return
+			}
+			pending = append(pending, lexEvent{kind: evSentenceEnd, arg: arg})
+		},
+		TextEnd: func(arg int) {
+			pending = append(pending, lexEvent{kind: evTextEnd, arg: arg})
+			resolve(true)
+		},
+		Flush: func() error {
+			resolve(true)
+			return tw.Flush()
+		},
+		SoftBounds: tw.SoftBounds,
+		Coverage:   tw.Coverage,
+		Graphemes:  tw.Graphemes,
+	}
+
+	if tw.NormalizedToken != nil {
+		ltw.NormalizedToken = func(offset int, buf []rune, norm []rune) {
+			pending = append(pending, lexEvent{
+				kind:   evToken,
+				offset: offset,
+				buf:    append([]rune(nil), buf...),
+				norm:   append([]rune{}, norm...),
+			})
+			resolve(false)
+		}
+	}
+
+	// Multi-word tokens end all matches
+	if tw.MultiWordToken != nil {
+		ltw.MultiWordToken = func(offset int, buf []rune, words [][]rune) {
+			pending = append(pending, lexEvent{
+				kind:   evMultiWord,
+				offset: offset,
+				buf:    append([]rune(nil), buf...),
+				words:  words,
+			})
+			resolve(false)
+		}
+	}
+
+	// Skipped characters end all matches
+	if tw.Skip != nil {
+		ltw.Skip = func(kind SkipKind, buf []rune) {
+			if len(pending) == 0 {
+				tw.Skip(kind, buf)
+				return
+			}
+			pending = append(pending, lexEvent{
+				kind: evSkip,
+				buf:  append([]rune(nil), buf...),
+				arg:  int(kind),
+			})
+		}
+	}
+	return ltw
+}
@@ -0,0 +1,77 @@
+package datok
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLexiconParse(t *testing.T) {
+	assert := assert.New(t)
+
+	lex := ParseLexicon(strings.NewReader("# Comment\nNew York\n\nC#\tC #\n"))
+	assert.NotNil(lex)
+
+	assert.True(lex.root.walk([]rune("New York")).final)
+	assert.Nil(lex.root.walk([]rune("New  York")))
+	assert.Equal([]string{"C", "#"}, lex.root.walk([]rune("C#")).split)
+
+	// Split doesn't match the entry
+	assert.Nil(ParseLexicon(strings.NewReader("C#\tC +\n")))
+}
+
+func TestLexiconTokenWriter(t *testing.T) {
+	assert := assert.New(t)
+
+	mat := LoadMatrixFile("testdata/tokenizer_de.matok")
+	assert.NotNil(mat)
+
+	lex := NewLexicon()
+	assert.True(lex.Add("New York"))
+	assert.True(lex.Add("New York Times"))
+	assert.True(lex.Add("Lehrer:innen"))
+	assert.True(lex.Add("iPhone 15 Pro"))
+	assert.True(lex.Add("C#", "C", "#"))
+
+	b := make([]byte, 0, 2048)
+	w := bytes.NewBuffer(b)
+
+	tws := lex.TokenWriter(NewTokenWriter(w, TOKENS|SENTENCES|TOKEN_POS))
+
+	assert.True(mat.TransduceTokenWriter(
+		strings.NewReader("Wir fliegen nach New   York."), tws),
+	)
+	assert.Equal("Wir\nfliegen\nnach\nNew   York\n.\n\n0 3 4 11 12 16 17 27 27 28\n", w.String())
+
+	// Longest match
+	w.Reset()
+	assert.True(mat.TransduceTokenWriter(
+		strings.NewReader("Die New York Times und New York City"), tws),
+	)
+	assert.Equal("Die\nNew York Times\nund\nNew York\nCity\n\n0 3 4 18 19 22 23 31 32 36\n", w.String())
+
+	// Matches need to be at token boundaries
+	w.Reset()
+	assert.True(mat.TransduceTokenWriter(
+		strings.NewReader("Die Lehrer:innen und das iPhone 15 Prototyp."), tws),
+	)
+	assert.Equal("Die\nLehrer:innen\nund\ndas\niPhone\n15\nPrototyp\n.\n\n0 3 4 16 17 20 21 24 25 31 32 34 35 43 43 44\n", w.String())
+
+	// Forced splits
+	w.Reset()
+	assert.True(mat.TransduceTokenWriter(
+		strings.NewReader("Ich mag C#. Und du?"), tws),
+	)
+	assert.Equal("Ich\nmag\nC\n#\n.\n\nUnd\ndu\n?\n\n0 3 4 7 8 9 9 10 10 11 12 15 16 18 18 19\n", w.String())
+
+	// Incomplete match at the end of the text
+	w.Reset()
+	tws = lex.TokenWriter(NewTokenWriter(w, SIMPLE))
+	assert.True(mat.TransduceTokenWriter(
+		strings.NewReader("Ich mag New"), tws),
+	)
+	tws.Flush()
+	assert.Equal("Ich\nmag\nNew\n\n\n", w.String())
+}
@@ -0,0 +1,502 @@
+package datok
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Types of convention violations
+const (
+	LINT_UNSUPPORTED   = "Unsupported transition"
+	LINT_EPSILON       = "General epsilon transition in an epsilon free FST"
+	LINT_AMBIGUOUS     = "Ambiguous output"
+	LINT_MCS           = "Unknown multi-character symbol will be dropped"
+	LINT_EPSILON_LOOP  = "Potential endless epsilon loop"
+	LINT_UNREACHABLE   = "Unreachable state"
+	LINT_SYMBOL_NUMBER = "Unknown symbol"
+)
+
+// LintMessage describes a violation of the tokenizer's
+// conventions in a foma FST. States are numbered as in foma.
+type LintMessage struct {
+	Type    string
+	Warning bool
+	Detail  string
+
+	// Quoted input reaching the violation,
+	// empty if the violation is not reachable
+	Example string
+}
+
+// LintReport collects all violations of the tokenizer's
+// conventions in a foma FST.
+type LintReport struct {
+	Messages []*LintMessage
+}
+
+// An arc of a foma FST using foma's numbering
+type lintArc struct {
+	from, in, out, to int
+}
+
+// The raw foma FST
+type lintNet struct {
+	sigma         map[int]string
+	arcs          [][]lintArc
+	deterministic bool
+	epsilonFree   bool
+	tokenend      int
+
+	// Shortest known path per state for examples
+	prev []*lintArc
+}
+
+// LintFomaFile checks a gzipped foma FST file
+// for violations of the tokenizer's conventions.
+func LintFomaFile(file string) *LintReport {
+	f, err := os.Open(file)
+	if err != nil {
+		log.Print(err)
+		return nil
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		log.Print(err)
+		return nil
+	}
+	defer gz.Close()
+
+	return LintFoma(gz)
+}
+
+// LintFoma checks a foma FST for violations of the
+// tokenizer's conventions. In contrast to ParseFoma,
+// all violations are reported, including those
+// ParseFoma ignores silently.
+func LintFoma(ior io.Reader) *LintReport {
+	fn := readFoma(ior)
+	if fn == nil {
+		return nil
+	}
+	net := newLintNet(fn)
+	if net == nil {
+		return nil
+	}
+	rep := &LintReport{}
+	net.paths()
+
+	const epsilon = 0
+
+	// Arcs kept by ParseFoma per state
+	kept := make([][]lintArc, len(net.arcs))
+
+	// Collected dropped multi-character symbols
+	mcs := make(map[int][]lintArc)
+
+	for s, arcs := range net.arcs {
+		for _, a := range arcs {
+			_, known := net.sigma[a.in]
+			_, knownOut := net.sigma[a.out]
+			switch {
+			case !known || !knownOut:
+				rep.add(LINT_SYMBOL_NUMBER, false, net.arc(a), net.example(a))
+			case a.in != a.out:
+				if a.in == epsilon && (a.out == net.tokenend || net.isSoftBound(a.out)) {
+					kept[s] = append(kept[s], a)
+				} else if a.out == epsilon && net.isMCS(a.in) {
+					mcs[a.in] = append(mcs[a.in], a)
+				} else if a.out == epsilon || net.isRewrite(a) {
+					kept[s] = append(kept[s], a)
+				} else {
+					rep.add(LINT_UNSUPPORTED, false, net.arc(a), net.example(a))
+				}
+			case a.in == net.tokenend || net.isSoftBound(a.in):
+				// Token bounds and soft bounds on the input side
+				// are ignored by convention
+			case a.in == epsilon:
+				if net.deterministic && net.epsilonFree {
+					rep.add(LINT_EPSILON, false, net.arc(a), net.example(a))
+				}
+				kept[s] = append(kept[s], a)
+			case net.isMCS(a.in):
+				mcs[a.in] = append(mcs[a.in], a)
+			default:
+				kept[s] = append(kept[s], a)
+			}
+		}
+	}
+
+	syms := make([]int, 0, len(mcs))
+	for sym := range mcs {
+		syms = append(syms, sym)
+	}
+	sort.Ints(syms)
+	for _, sym := range syms {
+		rep.add(LINT_MCS, true,
+			strconv.Quote(net.sigma[sym])+" on "+strconv.Itoa(len(mcs[sym]))+" arcs, e.g. "+net.arc(mcs[sym][0]),
+			net.example(mcs[sym][0]),
+		)
+	}
+
+	net.checkAmbiguity(rep, kept)
+	net.checkEpsilonLoops(rep, kept)
+
+	// Check reachability based on the kept arcs
+	reached := make([]bool, len(net.arcs))
+	reached[0] = true
+	queue := []int{0}
+	for i := 0; i < len(queue); i++ {
+		for _, a := range kept[queue[i]] {
+			if !reached[a.to] {
+				reached[a.to] = true
+				queue = append(queue, a.to)
+			}
+		}
+	}
+	for s, ok := range reached {
+		if !ok {
+			rep.add(LINT_UNREACHABLE, true, "State "+strconv.Itoa(s), "")
+		}
+	}
+
+	return rep
+}
+
+// Add a message to the report
+func (rep *LintReport) add(typ string, warning bool, detail, example string) {
+	rep.Messages = append(rep.Messages, &LintMessage{
+		Type:    typ,
+		Warning: warning,
+		Detail:  detail,
+		Example: example,
+	})
+}
+
+// Errors returns the number of violations,
+// that prevent the conversion of the FST.
+func (rep *LintReport) Errors() int {
+	n := 0
+	for _, msg := range rep.Messages {
+		if !msg.Warning {
+			n++
+		}
+	}
+	return n
+}
+
+// Warnings returns the number of violations,
+// that change the behaviour of the FST.
+func (rep *LintReport) Warnings() int {
+	return len(rep.Messages) - rep.Errors()
+}
+
+// WriteTo writes all messages grouped by type,
+// errors first.
+func (rep *LintReport) WriteTo(w io.Writer) (n int64, err error) {
+	groups := make(map[string][]*LintMessage)
+	var types []string
+	for _, msg := range rep.Messages {
+		if _, ok := groups[msg.Type]; !ok {
+			types = append(types, msg.Type)
+		}
+		groups[msg.Type] = append(groups[msg.Type], msg)
+	}
+	sort.SliceStable(types, func(i, j int) bool {
+		return !groups[types[i]][0].Warning && groups[types[j]][0].Warning
+	})
+
+	wb := bufio.NewWriter(w)
+	write := func(format string, a ...interface{}) {
+		if err == nil {
+			var m int
+			m, err = fmt.Fprintf(wb, format, a...)
+			n += int64(m)
+		}
+	}
+
+	for _, typ := range types {
+		level := "Error"
+		if groups[typ][0].Warning {
+			level = "Warning"
+		}
+		write("%s: %s (%d)\n", level, typ, len(groups[typ]))
+		for _, msg := range groups[typ] {
+			if msg.Example != "" {
+				write("  %s at %s\n", msg.Detail, msg.Example)
+			} else {
+				write("  %s\n", msg.Detail)
+			}
+		}
+	}
+	write("%d errors, %d warnings\n", rep.Errors(), rep.Warnings())
+
+	if err == nil {
+		err = wb.Flush()
+	}
+	return n, err
+}
+
+// Check for arcs with the same input symbol, that
+// differ in their output, taking epsilon closures into account
+func (net *lintNet) checkAmbiguity(rep *LintReport, kept [][]lintArc) {
+	reported := make(map[[2]lintArc]bool)
+	for s := range kept {
+		closure := []int{s}
+		seen := map[int]bool{s: true}
+		for i := 0; i < len(closure); i++ {
+			for _, a := range kept[closure[i]] {
+				if a.in == 0 && a.out == 0 && !seen[a.to] {
+					seen[a.to] = true
+					closure = append(closure, a.to)
+				}
+			}
+		}
+
+		first := make(map[int]lintArc)
+		for _, t := range closure {
+			for _, a := range kept[t] {
+				if a.in == 0 && a.out == 0 {
+					continue
+				}
+				// Soft bounds are transitions on their own symbol
+				in := a.in
+				if in == 0 && net.isSoftBound(a.out) {
+					in = a.out
+				}
+				f, ok := first[in]
+				if !ok {
+					first[in] = a
+				} else if net.output(f) != net.output(a) && !reported[[2]lintArc{f, a}] {
+					reported[[2]lintArc{f, a}] = true
+					rep.add(LINT_AMBIGUOUS, false,
+						net.arc(f)+" and "+net.arc(a), net.example(f))
+				}
+			}
+		}
+	}
+}
+
+// Check for cycles of epsilon transitions containing a token bound
+// or a soft bound, that may not consume any input during tokenization
+func (net *lintNet) checkEpsilonLoops(rep *LintReport, kept [][]lintArc) {
+
+	// Tarjan's algorithm for strongly connected components
+	index := make([]int, len(kept))
+	low := make([]int, len(kept))
+	onStack := make([]bool, len(kept))
+	var stack []int
+	counter := 0
+
+	var visit func(s int)
+	visit = func(s int) {
+		counter++
+		index[s] = counter
+		low[s] = counter
+		stack = append(stack, s)
+		onStack[s] = true
+
+		for _, a := range kept[s] {
+			if a.in != 0 {
+				continue
+			}
+			if index[a.to] == 0 {
+				visit(a.to)
+				if low[a.to] < low[s] {
+					low[s] = low[a.to]
+				}
+			} else if onStack[a.to] && index[a.to] < low[s] {
+				low[s] = index[a.to]
+			}
+		}
+
+		if low[s] != index[s] {
+			return
+		}
+
+		// Collect the component
+		comp := make(map[int]bool)
+		for {
+			t := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[t] = false
+			comp[t] = true
+			if t == s {
+				break
+			}
+		}
+
+		// Report a token bound inside the component
+		for t := range comp {
+			for _, a := range kept[t] {
+				if a.in == 0 && (a.out == net.tokenend || net.isSoftBound(a.out)) && comp[a.to] {
+					rep.add(LINT_EPSILON_LOOP, true, net.arc(a), net.example(a))
+					return
+				}
+			}
+		}
+	}
+
+	for s := range kept {
+		if index[s] == 0 {
+			visit(s)
+		}
+	}
+}
+
+// Returns true if the symbol is a multi-character
+// symbol not supported by the tokenizer
+func (net *lintNet) isMCS(sym int) bool {
+	return sym > 2 && sym != net.tokenend && len([]rune(net.sigma[sym])) > 1 &&
+		categoryOf(net.sigma[sym]) == catNone && !net.isSoftBound(sym)
+}
+
+// Returns true if the symbol is a soft bound
+func (net *lintNet) isSoftBound(sym int) bool {
+	_, ok := softBoundOf(net.sigma[sym])
+	return sym > 2 && ok
+}
+
+// Returns true if the arc rewrites a character
+// to another character or multi-character symbol
+func (net *lintNet) isRewrite(a lintArc) bool {
+	return a.in != 0 && !net.isMCS(a.in) && !net.isSoftBound(a.in) &&
+		a.out > 2 && a.out != net.tokenend && !net.isSoftBound(a.out) &&
+		categoryOf(net.sigma[a.out]) == catNone
+}
+
+// Classify the output of an arc
+func (net *lintNet) output(a lintArc) int {
+	switch {
+	case a.out == net.tokenend && a.in == 0:
+		return 1
+	case a.out == 0:
+		return 2
+	case a.out != a.in:
+		// Rewrites are distinguished by their output
+		return a.out + 2
+	}
+	return 0
+}
+
+// Describe an arc
+func (net *lintNet) arc(a lintArc) string {
+	return strconv.Itoa(a.from) + " -> " + strconv.Itoa(a.to) +
+		" (" + net.symbol(a.in) + ":" + net.symbol(a.out) + ")"
+}
+
+// Describe a symbol
+func (net *lintNet) symbol(sym int) string {
+	switch sym {
+	case 0:
+		return "0"
+	case 1:
+		return "?"
+	case 2:
+		return "@"
+	}
+	if sym == net.tokenend {
+		return "TOKEN"
+	}
+	if name, ok := net.sigma[sym]; ok {
+		if len([]rune(name)) == 1 {
+			return strconv.QuoteRuneToGraphic([]rune(name)[0])
+		}
+		return name
+	}
+	return "#" + strconv.Itoa(sym)
+}
+
+// Remember the shortest paths to all states
+func (net *lintNet) paths() {
+	net.prev = make([]*lintArc, len(net.arcs))
+	seen := make([]bool, len(net.arcs))
+	seen[0] = true
+	queue := []int{0}
+	for i := 0; i < len(queue); i++ {
+		for j := range net.arcs[queue[i]] {
+			a := &net.arcs[queue[i]][j]
+			if !seen[a.to] {
+				seen[a.to] = true
+				net.prev[a.to] = a
+				queue = append(queue, a.to)
+			}
+		}
+	}
+}
+
+// Create an example input reaching an arc.
+// Characters not in sigma are shown as {?},
+// multi-character symbols in braces.
+func (net *lintNet) example(a lintArc) string {
+	path := []lintArc{a}
+	for s := a.from; s != 0; {
+		p := net.prev[s]
+		if p == nil {
+			return ""
+		}
+		path = append(path, *p)
+		s = p.from
+	}
+
+	var sb strings.Builder
+	for i := len(path) - 1; i >= 0; i-- {
+		switch in := path[i].in; {
+		case in == 0 || in == net.tokenend:
+		case in == 1 || in == 2:
+			sb.WriteString("{?}")
+		case len([]rune(net.sigma[in])) > 1:
+			sb.WriteString("{" + net.sigma[in] + "}")
+		default:
+			sb.WriteString(net.sigma[in])
+		}
+	}
+	return strconv.Quote(sb.String())
+}
+
+// Create the net to check from the arcs of
+// a foma FST read without any conversion
+func newLintNet(fn *fomaNet) *lintNet {
+	net := &lintNet{
+		sigma:         fn.sigma,
+		arcs:          make([][]lintArc, fn.stateCount),
+		deterministic: fn.deterministic,
+		epsilonFree:   fn.epsilonFree,
+		tokenend:      -1,
+	}
+
+	for number, name := range fn.sigma {
+		if name == "@_TOKEN_SYMBOL_@" || name == "@_TOKEN_BOUND_@" {
+			net.tokenend = number
+		}
+	}
+
+	for _, fa := range fn.arcs {
+
+		// Final states without outgoing arcs
+		if fa.in == -1 {
+			continue
+		}
+		a := lintArc{fa.from, fa.in, fa.out, fa.to}
+		for _, s := range []int{a.from, a.to} {
+			for s >= len(net.arcs) {
+				net.arcs = append(net.arcs, nil)
+			}
+		}
+		net.arcs[a.from] = append(net.arcs[a.from], a)
+	}
+
+	if len(net.arcs) == 0 {
+		log.Println("No states defined")
+		return nil
+	}
+	return net
+}
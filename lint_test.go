@@ -0,0 +1,84 @@
+package datok
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var lintFoma = `##foma-net 1.0##
+##props##
+2 9 4 10 1 -1 1 1 1 1 0 2 LINT
+##sigma##
+0 @_EPSILON_SYMBOL_@
+1 @_UNKNOWN_SYMBOL_@
+2 @_IDENTITY_SYMBOL_@
+3 a
+4 b
+5 +MCS
+6 @_TOKEN_BOUND_@
+##states##
+0 3 1 0
+3 1 2
+5 0 1
+1 0 6 2 0
+3 2
+3 0 2
+2 0 6 1 1
+4 0 1
+3 3 3 0
+-1 -1 -1 -1 -1
+##end##
+`
+
+func TestLintFoma(t *testing.T) {
+	assert := assert.New(t)
+
+	rep := LintFoma(strings.NewReader(lintFoma))
+	assert.NotNil(rep)
+	assert.Equal(2, rep.Errors())
+	assert.Equal(3, rep.Warnings())
+
+	msgs := make(map[string]*LintMessage)
+	for _, msg := range rep.Messages {
+		msgs[msg.Type] = msg
+	}
+
+	assert.Equal("0 -> 2 ('a':?)", msgs[LINT_UNSUPPORTED].Detail)
+	assert.Equal(`"a"`, msgs[LINT_UNSUPPORTED].Example)
+	assert.Equal(`"+MCS" on 1 arcs, e.g. 0 -> 1 (+MCS:0)`, msgs[LINT_MCS].Detail)
+	assert.Equal(`"{+MCS}"`, msgs[LINT_MCS].Example)
+	assert.Equal("1 -> 2 ('a':'a') and 1 -> 2 ('a':0)", msgs[LINT_AMBIGUOUS].Detail)
+	assert.Equal(`"aa"`, msgs[LINT_AMBIGUOUS].Example)
+	assert.True(msgs[LINT_EPSILON_LOOP].Warning)
+	assert.Equal("State 3", msgs[LINT_UNREACHABLE].Detail)
+	assert.Equal("", msgs[LINT_UNREACHABLE].Example)
+
+	// Errors are written first
+	w := bytes.NewBuffer(nil)
+	_, err := rep.WriteTo(w)
+	assert.Nil(err)
+	assert.True(strings.HasPrefix(w.String(), "Error: "+LINT_UNSUPPORTED+" (1)\n"))
+	assert.True(strings.HasSuffix(w.String(), "2 errors, 3 warnings\n"))
+}
+
+func TestLintFomaFile(t *testing.T) {
+	assert := assert.New(t)
+
+	rep := LintFomaFile("testdata/simpletok.fst")
+	assert.Equal(0, len(rep.Messages))
+
+	rep = LintFomaFile("testdata/ambiguous.fst")
+	assert.Equal(1, rep.Errors())
+	assert.Equal(LINT_AMBIGUOUS, rep.Messages[0].Type)
+
+	rep = LintFomaFile("testdata/tokenizer_de.fst")
+	assert.Equal(0, rep.Errors())
+	for _, msg := range rep.Messages {
+		assert.Equal(LINT_UNREACHABLE, msg.Type)
+	}
+
+	assert.Nil(LintFomaFile("testdata/simpletok.matok"))
+}
@@ -0,0 +1,147 @@
+package datok
+
+import (
+	"strings"
+	"unicode"
+)
+
+// SkipKind is the kind of a span of skipped characters
+type SkipKind uint8
+
+// Kinds of skipped characters
+const (
+	SKIP_SPACE SkipKind = iota
+	SKIP_NEWLINE
+	SKIP_OTHER
+)
+
+// Get the kind of a skipped character
+func skipKindOf(char rune) SkipKind {
+	switch char {
+	case '\n', '\r', '\v', '\f', 0x85, 0x2028, 0x2029:
+		return SKIP_NEWLINE
+	}
+	if unicode.IsSpace(char) {
+		return SKIP_SPACE
+	}
+	return SKIP_OTHER
+}
+
+// Pass skipped characters to the token writer
+// in spans of the same kind
+func passSkipped(w *TokenWriter, buf []rune) {
+	start := 0
+	for i := 1; i <= len(buf); i++ {
+		if i == len(buf) || skipKindOf(buf[i]) != skipKindOf(buf[start]) {
+			w.Skip(skipKindOf(buf[start]), buf[start:i])
+			start = i
+		}
+	}
+}
+
+// Escape skipped characters following the convention
+// of the SpacesAfter attribute in CoNLL-U files
+func escapeSkipped(buf []rune) string {
+	var sb strings.Builder
+	for _, char := range buf {
+		switch char {
+		case ' ':
+			sb.WriteString(`\s`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '|':
+			sb.WriteString(`\p`)
+		case '\\':
+			sb.WriteString(`\\`)
+		default:
+			sb.WriteRune(char)
+		}
+	}
+	return sb.String()
+}
+
+// Create a token writer, that passes every token not before
+// the characters following the token are known.
+// These characters are set to after, while the token is passed.
+// Characters skipped before the first token of a text
+// are passed to leading.
+func losslessTokenWriter(tw *TokenWriter, after *[]rune, leading func([]rune)) *TokenWriter {
+	var offset int
+	buf := make([]rune, 0, 64)
+	var norm []rune
+	hasToken := false
+
+	// Sentence ends following the pending token
+	var sentences []int
+
+	// Characters skipped since the pending token
+	skipped := make([]rune, 0, 64)
+
+	flush := func() {
+		if hasToken {
+			*after = skipped
+			if norm != nil {
+				tw.NormalizedToken(offset, buf, norm)
+			} else {
+				tw.Token(offset, buf)
+			}
+			*after = nil
+			hasToken = false
+		} else if len(skipped) > 0 {
+			leading(skipped)
+		}
+		skipped = skipped[:0]
+
+		for _, arg := range sentences {
+			tw.SentenceEnd(arg)
+		}
+		sentences = sentences[:0]
+	}
+
+	token := func(o int, b []rune, n []rune) {
+		skipped = append(skipped, b[:o]...)
+		flush()
+		offset = o
+		buf = append(buf[:0], b...)
+		norm = nil
+		if n != nil {
+			norm = append([]rune{}, n...)
+		}
+		hasToken = true
+	}
+
+	ltw := &TokenWriter{
+		Token: func(o int, b []rune) {
+			token(o, b, nil)
+		},
+		SentenceEnd: func(arg int) {
+			if !hasToken {
+				tw.SentenceEnd(arg)
+				return
+			}
+			sentences = append(sentences, arg)
+		},
+		TextEnd: func(arg int) {
+			flush()
+			tw.TextEnd(arg)
+		},
+		Flush: func() error {
+			flush()
+			return tw.Flush()
+		},
+		Skip: func(_ SkipKind, b []rune) {
+			skipped = append(skipped, b...)
+		},
+	}
+
+	if tw.NormalizedToken != nil {
+		ltw.NormalizedToken = func(o int, b []rune, n []rune) {
+			token(o, b, n)
+		}
+	}
+	return ltw
+}
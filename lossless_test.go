@@ -0,0 +1,107 @@
+package datok
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var losslessStr = []string{
+	"Der alte Mann.",
+	"  Der alte\tMann ging.\n\nÜber die Straße.  \n",
+	"Der Vorsitzende der F.D.P. hat\u00a0Recht.\x04\nNoch ein Text.\x04\n  Und \x04",
+	"\x04\n",
+	"Hallo.Welt :-) ",
+}
+
+// Reconstruct the input from all passed characters
+func tlosslessEvents(tok Tokenizer, str string) (string, []SkipKind) {
+	var sb strings.Builder
+	kinds := make([]SkipKind, 0)
+	w := &TokenWriter{
+		Token: func(_ int, buf []rune) {
+			sb.WriteString(string(buf))
+		},
+		SentenceEnd: func(_ int) {},
+		TextEnd:     func(_ int) {},
+		Flush:       func() error { return nil },
+		Skip: func(kind SkipKind, buf []rune) {
+			sb.WriteString(string(buf))
+			kinds = append(kinds, kind)
+		},
+	}
+	tok.TransduceTokenWriter(strings.NewReader(str), w)
+	return sb.String(), kinds
+}
+
+func TestLosslessReconstruction(t *testing.T) {
+	assert := assert.New(t)
+
+	mat := LoadMatrixFile("testdata/tokenizer_de.matok")
+	dat := LoadDatokFile("testdata/tokenizer_de.datok")
+	dat64 := LoadFomaFile("testdata/simpletok.fst").toDoubleArray(nil, true)
+	assert.NotNil(mat)
+	assert.NotNil(dat)
+	assert.NotNil(dat64)
+
+	for _, tok := range []Tokenizer{mat, dat, dat64} {
+		for _, str := range losslessStr {
+			rec, _ := tlosslessEvents(tok, str)
+			assert.Equal(str, rec)
+		}
+
+		// Skipped spans are passed by kind
+		_, kinds := tlosslessEvents(tok, "Mann \n\t")
+		assert.Equal([]SkipKind{SKIP_SPACE, SKIP_NEWLINE, SKIP_SPACE}, kinds)
+		_, kinds = tlosslessEvents(tok, "Mann\x04")
+		assert.Equal([]SkipKind{SKIP_OTHER}, kinds)
+	}
+}
+
+func TestLosslessTokenWriter(t *testing.T) {
+	assert := assert.New(t)
+
+	mat := LoadMatrixFile("testdata/tokenizer_de.matok")
+	assert.NotNil(mat)
+
+	b := make([]byte, 0, 2048)
+	w := bytes.NewBuffer(b)
+
+	mat.TransduceTokenWriter(
+		strings.NewReader("  Der Mann\tging.\nEr sah.  "),
+		NewTokenWriter(w, SIMPLE|LOSSLESS),
+	)
+	assert.Equal(
+		"\t\\s\\s\nDer\t\\s\nMann\t\\t\nging\t\n.\t\\n\n\nEr\t\\s\nsah\t\n.\t\\s\\s\n\n\n",
+		w.String(),
+	)
+
+	assert.Equal("\\s\\t\\n\\r\\p\\\\\u00a0", escapeSkipped([]rune(" \t\n\r|\\\u00a0")))
+
+	// Tokens without lossless mode are unchanged
+	w.Reset()
+	mat.TransduceTokenWriter(strings.NewReader("Der Mann. "), NewTokenWriter(w, SIMPLE))
+	assert.Equal("Der\nMann\n.\n\n\n", w.String())
+
+	// Text ends
+	w.Reset()
+	mat.TransduceTokenWriter(strings.NewReader("Der Mann.\x04\n Er"), NewTokenWriter(w, TOKENS|LOSSLESS))
+	assert.Equal("Der\t\\s\nMann\t\n.\t\x04\n\n\t\\n\\s\nEr\t\n\n", w.String())
+
+	// CoNLL-U with whitespace information
+	w.Reset()
+	mat.TransduceTokenWriter(strings.NewReader(" Der Mann.Er  ging.\n"), NewTokenWriter(w, CONLLU|LOSSLESS))
+	assert.Equal(
+		"1\tDer\t_\t_\t_\t_\t_\t_\t_\tSpacesBefore=\\s\n"+
+			"2\tMann\t_\t_\t_\t_\t_\t_\t_\tSpaceAfter=No\n"+
+			"3\t.\t_\t_\t_\t_\t_\t_\t_\tSpaceAfter=No\n"+
+			"\n"+
+			"1\tEr\t_\t_\t_\t_\t_\t_\t_\tSpacesAfter=\\s\\s\n"+
+			"2\tging\t_\t_\t_\t_\t_\t_\t_\tSpaceAfter=No\n"+
+			"3\t.\t_\t_\t_\t_\t_\t_\t_\tSpacesAfter=\\n\n"+
+			"\n",
+		w.String(),
+	)
+}
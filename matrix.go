@@ -6,11 +6,32 @@ import (
 	"io"
 	"log"
 	"os"
+	"sort"
 )
 
 const (
-	MAMAGIC = "MATOK"
-	EOT     = 4
+	MAMAGIC   = "MATOK"
+	MAVERSION = uint16(2)
+	EOT       = 4
+
+	// Version of files with category symbols or rewrites
+	MAEXTVERSION = uint16(3)
+)
+
+// Layout defines the order of the transitions in the matrix.
+type Layout uint16
+
+const (
+	// Transitions are grouped by symbols, i.e. the
+	// matrix is indexed as (a-1)*stateCount + t.
+	// This is the layout of all matrix files of version 1.
+	LAYOUT_SYMBOL_MAJOR Layout = iota
+
+	// Transitions are grouped by states, i.e. the
+	// matrix is indexed as t*sigmaCount + (a-1),
+	// so all outgoing transitions of a state are
+	// close together in memory.
+	LAYOUT_STATE_MAJOR
 )
 
 type MatrixTokenizer struct {
@@ -19,10 +40,25 @@ type MatrixTokenizer struct {
 	array      []uint32
 	stateCount int
 
+	// The layout of the array and the
+	// resulting strides for symbols and states
+	layout      Layout
+	symStride   int
+	stateStride int
+
 	// Special symbols in sigma
 	epsilon  int
 	unknown  int
 	identity int
+
+	// Symbols for categories of characters not in sigma
+	categories categories
+
+	// Outputs of transitions rewriting characters
+	rewrites rewrites
+
+	// Symbols for optional token boundaries
+	softBounds softBounds
 }
 
 // ToMatrix turns the intermediate tokenizer into a
@@ -35,18 +71,32 @@ func (auto *Automaton) ToMatrix() *MatrixTokenizer {
 		identity:   auto.identity,
 		epsilon:    auto.epsilon,
 		stateCount: auto.stateCount,
+		categories: auto.categories,
+		softBounds: auto.softBounds,
 	}
 
 	max := 0
 
-	// Init with identity
+	// Init with identity or category symbols
 	if mat.identity != -1 {
 		for i := 0; i < 256; i++ {
-			mat.sigmaASCII[i] = mat.identity
+			mat.sigmaASCII[i] = mat.categories.fallback(rune(i), mat.identity)
 		}
 		max = mat.identity
 	}
 
+	// Category and soft bound symbols need columns
+	for _, num := range mat.categories {
+		if num > max {
+			max = num
+		}
+	}
+	for _, sb := range mat.softBounds {
+		if sb.sym > max {
+			max = sb.sym
+		}
+	}
+
 	for num, sym := range auto.sigmaRev {
 		if int(sym) < 256 {
 			mat.sigmaASCII[int(sym)] = num
@@ -89,15 +139,186 @@ func (auto *Automaton) ToMatrix() *MatrixTokenizer {
 				matrix[(alpha-1)*auto.stateCount+start] |= FIRSTBIT
 			}
 
+			// Remember rewrites
+			if t.rewrite != "" {
+				if mat.rewrites == nil {
+					mat.rewrites = make(rewrites)
+				}
+				mat.rewrites[matrixKey(uint32(start), alpha)] = []rune(t.rewrite)
+			}
+
 			toMatrix(matrix, t.end)
 		}
 	}
 
 	toMatrix(mat.array, 1)
 
+	mat.layout = LAYOUT_SYMBOL_MAJOR
+	mat.symStride = mat.stateCount
+	mat.stateStride = 1
+
 	return mat
 }
 
+// Number of symbol columns in the matrix
+func (mat *MatrixTokenizer) columns() int {
+	return len(mat.array) / (mat.stateCount + 1)
+}
+
+// Layout returns the layout of the transition matrix.
+func (mat *MatrixTokenizer) Layout() Layout {
+	return mat.layout
+}
+
+// SetLayout reorders the transition matrix to the
+// requested layout.
+func (mat *MatrixTokenizer) SetLayout(layout Layout) {
+	if layout == mat.layout {
+		return
+	}
+
+	cols := mat.columns()
+	symStride, stateStride := mat.stateCount, 1
+	if layout == LAYOUT_STATE_MAJOR {
+		symStride, stateStride = 1, cols
+	}
+
+	array := make([]uint32, len(mat.array))
+
+	// Final transitions are not part of the sigma
+	// and can be ignored in the matrix representation
+	for a := 1; a < cols; a++ {
+		for t := 1; t <= mat.stateCount; t++ {
+			array[(a-1)*symStride+t*stateStride] =
+				mat.array[(a-1)*mat.symStride+t*mat.stateStride]
+		}
+	}
+
+	mat.array = array
+	mat.layout = layout
+	mat.symStride = symStride
+	mat.stateStride = stateStride
+}
+
+// StateVisits counts how often each state is visited when
+// walking the input greedily through the matrix.
+// This is an approximation of the transduction,
+// as it ignores backtracking, but it is sufficient
+// to identify frequently used states.
+func (mat *MatrixTokenizer) StateVisits(r io.Reader) []int {
+	visits := make([]int, mat.stateCount+1)
+
+	reader := bufio.NewReader(r)
+
+	var a int
+	var ok bool
+	var t1 uint32
+	t := uint32(1)
+	visits[t]++
+
+	for {
+		char, _, err := reader.ReadRune()
+		if err != nil {
+			break
+		}
+
+		if int(char) < 256 {
+			a, ok = mat.sigmaASCII[int(char)], true
+		} else {
+			a, ok = mat.sigma[char]
+			if !ok && mat.identity != -1 {
+				a = mat.identity
+			}
+		}
+
+	RETRY:
+		t1 = 0
+		if a > 0 {
+			t1 = mat.array[(a-1)*mat.symStride+int(t)*mat.stateStride]
+		}
+
+		if t1 == 0 {
+
+			// Try again with unknown symbol
+			if !ok && a == mat.identity {
+				a = mat.unknown
+				goto RETRY
+			}
+
+			// Follow an epsilon transition
+			t1 = mat.array[(mat.epsilon-1)*mat.symStride+int(t)*mat.stateStride]
+			if t1 != 0 {
+				t = t1 &^ FIRSTBIT
+				visits[t]++
+				goto RETRY
+			}
+
+			// Restart at the root state
+			if t != 1 {
+				t = 1
+				visits[t]++
+				goto RETRY
+			}
+
+			// Ignore the character
+			continue
+		}
+
+		t = t1 &^ FIRSTBIT
+		visits[t]++
+	}
+
+	return visits
+}
+
+// RenumberStates renumbers the states of the matrix
+// by descending visit frequency (e.g. based on StateVisits),
+// so frequently used states are close together in memory.
+// The initial state always remains the first state.
+func (mat *MatrixTokenizer) RenumberStates(visits []int) {
+	order := make([]int, 0, mat.stateCount)
+	for t := 2; t <= mat.stateCount; t++ {
+		order = append(order, t)
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return visits[order[i]] > visits[order[j]]
+	})
+
+	// Mapping from old state to new state
+	perm := make([]uint32, mat.stateCount+1)
+	perm[1] = 1
+	for i, t := range order {
+		perm[t] = uint32(i + 2)
+	}
+
+	array := make([]uint32, len(mat.array))
+	cols := mat.columns()
+	var t1 uint32
+
+	for a := 1; a < cols; a++ {
+		for t := 1; t <= mat.stateCount; t++ {
+			t1 = mat.array[(a-1)*mat.symStride+t*mat.stateStride]
+			if t1 == 0 {
+				continue
+			}
+			array[(a-1)*mat.symStride+int(perm[t])*mat.stateStride] =
+				perm[t1&^FIRSTBIT] | (t1 & FIRSTBIT)
+		}
+	}
+
+	mat.array = array
+
+	// Rewrites are indexed by states
+	if mat.rewrites != nil {
+		rw := make(rewrites, len(mat.rewrites))
+		for key, out := range mat.rewrites {
+			rw[matrixKey(perm[key>>16], int(key&0xffff))] = out
+		}
+		mat.rewrites = rw
+	}
+}
+
 // Type of tokenizer
 func (MatrixTokenizer) Type() string {
 	return MAMAGIC
@@ -150,17 +371,29 @@ func (mat *MatrixTokenizer) WriteTo(w io.Writer) (n int64, err error) {
 		// }
 	}
 
+	// The sigma list needs to cover all columns of the matrix
+	if cols := mat.columns(); max < cols-1 {
+		sigmalist = append(sigmalist, make([]rune, cols)...)
+		max = cols - 1
+	}
+
 	// Add final entry to the list (maybe not necessary actually)
 	sigmalist = sigmalist[:max+1]
 
-	buf := make([]byte, 0, 14)
-	bo.PutUint16(buf[0:2], VERSION)
+	version := MAVERSION
+	if mat.categories.defined() || len(mat.rewrites) > 0 || len(mat.softBounds) > 0 {
+		version = MAEXTVERSION
+	}
+
+	buf := make([]byte, 0, 16)
+	bo.PutUint16(buf[0:2], version)
 	bo.PutUint16(buf[2:4], uint16(mat.epsilon))
 	bo.PutUint16(buf[4:6], uint16(mat.unknown))
 	bo.PutUint16(buf[6:8], uint16(mat.identity))
 	bo.PutUint32(buf[8:12], uint32(mat.stateCount))
 	bo.PutUint16(buf[12:14], uint16(len(sigmalist)))
-	more, err := wb.Write(buf[0:14])
+	bo.PutUint16(buf[14:16], uint16(mat.layout))
+	more, err := wb.Write(buf[0:16])
 	if err != nil {
 		log.Println(err)
 		return int64(all), err
@@ -168,6 +401,29 @@ func (mat *MatrixTokenizer) WriteTo(w io.Writer) (n int64, err error) {
 
 	all += more
 
+	if version == MAEXTVERSION {
+		more, err = mat.categories.writeTo(wb)
+		if err != nil {
+			log.Println(err)
+			return int64(all), err
+		}
+		all += more
+
+		more, err = mat.rewrites.writeTo(wb)
+		if err != nil {
+			log.Println(err)
+			return int64(all), err
+		}
+		all += more
+
+		more, err = mat.softBounds.writeTo(wb)
+		if err != nil {
+			log.Println(err)
+			return int64(all), err
+		}
+		all += more
+	}
+
 	// Write sigma
 	for _, sym := range sigmalist {
 
@@ -273,7 +529,7 @@ func ParseMatrix(ior io.Reader) *MatrixTokenizer {
 
 	version := bo.Uint16(buf[0:2])
 
-	if version != VERSION {
+	if version != VERSION && version != MAVERSION && version != MAEXTVERSION {
 		log.Println("Version not compatible")
 		return nil
 	}
@@ -285,10 +541,49 @@ func ParseMatrix(ior io.Reader) *MatrixTokenizer {
 	sigmaCount := int(bo.Uint16(buf[12:14]))
 	arraySize := (mat.stateCount + 1) * sigmaCount
 
-	// Init with identity
+	// Version 1 files have no layout information
+	// and are always symbol major
+	mat.layout = LAYOUT_SYMBOL_MAJOR
+	if version != VERSION {
+		more, err = io.ReadFull(r, buf[0:2])
+		if err != nil || more != 2 {
+			log.Println("Read bytes do not fit")
+			return nil
+		}
+		mat.layout = Layout(bo.Uint16(buf[0:2]))
+	}
+
+	if version == MAEXTVERSION {
+		if err = mat.categories.readFrom(r); err != nil {
+			log.Println(err)
+			return nil
+		}
+		if err = mat.rewrites.readFrom(r); err != nil {
+			log.Println(err)
+			return nil
+		}
+		if err = mat.softBounds.readFrom(r); err != nil {
+			log.Println(err)
+			return nil
+		}
+	}
+
+	switch mat.layout {
+	case LAYOUT_SYMBOL_MAJOR:
+		mat.symStride = mat.stateCount
+		mat.stateStride = 1
+	case LAYOUT_STATE_MAJOR:
+		mat.symStride = 1
+		mat.stateStride = sigmaCount
+	default:
+		log.Println("Unknown matrix layout")
+		return nil
+	}
+
+	// Init with identity or category symbols
 	if mat.identity != -1 {
 		for i := 0; i < 256; i++ {
-			mat.sigmaASCII[i] = mat.identity
+			mat.sigmaASCII[i] = mat.categories.fallback(rune(i), mat.identity)
 		}
 	}
 
@@ -348,6 +643,7 @@ func (mat *MatrixTokenizer) Transduce(r io.Reader, w io.Writer) bool {
 func (mat *MatrixTokenizer) TransduceTokenWriter(r io.Reader, w *TokenWriter) bool {
 	var a int
 	var t0 uint32
+	var ti int     // Index of the transition
 	t := uint32(1) // Initial state
 	var ok, rewindBuffer bool
 
@@ -356,6 +652,37 @@ func (mat *MatrixTokenizer) TransduceTokenWriter(r io.Reader, w *TokenWriter) bo
 	epsilonState := uint32(0)
 	epsilonOffset := 0
 
+	// Remember the last position of a soft bound,
+	// in case the automaton fails.
+	softState := uint32(0)
+	softOffset := 0
+	softSplits := 0
+	softNext := 0
+	softSym := -1
+	softActive := false
+
+	// The soft bounds ending tokens, selected per transduction
+	softSelected, valid := mat.softBounds.activate(w.SoftBounds)
+	if !valid {
+		return false
+	}
+
+	// Keep the per character loop free of unused features
+	if len(mat.softBounds) == 0 && w.NormalizedToken == nil && !w.Graphemes && w.Coverage == nil && w.Skip == nil {
+		return mat.transducePlain(r, w)
+	}
+
+	// Counts for coverage reports, if requested
+	cov := w.Coverage
+	if cov != nil && cov.Transitions == nil {
+		cov.Transitions = make([]uint64, len(mat.array))
+	}
+
+	// Remember the positions of active soft bounds
+	// passed in the current token
+	var splits []int
+	epsilonSplits := 0
+
 	// Remember if the last transition was epsilon
 	sentenceEnd := false
 
@@ -367,6 +694,23 @@ func (mat *MatrixTokenizer) TransduceTokenWriter(r io.Reader, w *TokenWriter) bo
 	buffc := 0 // Buffer current symbol
 	buffi := 0 // Buffer length
 
+	// Remember the outputs of the characters in the buffer,
+	// in case the normalized forms are requested
+	normalized := w.NormalizedToken != nil
+	var outs [][]rune
+	var norm []rune
+	if normalized {
+		outs = make([][]rune, 1024)
+		norm = make([]rune, 0, 1024)
+	}
+
+	// Remember the boundaries of grapheme clusters,
+	// in case tokens should not end inside of clusters
+	var clusters *clusterBuffer
+	if w.Graphemes {
+		clusters = newClusterBuffer(len(buffer))
+	}
+
 	// The buffer is organized as follows:
 	// [   t[....c..]..i]
 
@@ -404,6 +748,9 @@ PARSECHARM:
 				}
 
 				buffer[buffi] = char
+				if clusters != nil {
+					clusters.read(buffi, char)
+				}
 				buffi++
 			}
 
@@ -422,15 +769,16 @@ PARSECHARM:
 				eot = int(char) == EOT
 
 				// mat.SigmaASCII[] is initialized with mat.identity
+				// or the category symbols
 				a = mat.sigmaASCII[int(char)]
 			} else {
 				a, ok = mat.sigma[char]
 
-				// Use identity symbol if character is not in sigma
+				// Use category or identity symbol if character is not in sigma
 				if !ok && mat.identity != -1 {
 
 					// TODO: Maybe use unknown?
-					a = mat.identity
+					a = mat.categories.fallback(char, mat.identity)
 				}
 			}
 
@@ -439,7 +787,7 @@ PARSECHARM:
 			// Check for epsilon transitions and remember
 
 			// TODO: Can t0 be negative here?
-			if mat.array[(mat.epsilon-1)*mat.stateCount+int(t0)] != 0 {
+			if mat.array[(mat.epsilon-1)*mat.symStride+int(t0)*mat.stateStride] != 0 {
 				// Remember state for backtracking to last tokenend state
 
 				// Maybe not necessary - and should be simpler!
@@ -447,11 +795,23 @@ PARSECHARM:
 				// t0 &= ^FIRSTBIT
 				epsilonState = t0
 				epsilonOffset = buffc
+				epsilonSplits = len(splits)
 
 				if DEBUG {
 					log.Println("epsilonOffset is set to", buffc)
 				}
 			}
+
+			// Check for soft bound transitions and remember
+			for i, sb := range mat.softBounds {
+				if mat.array[(sb.sym-1)*mat.symStride+int(t0)*mat.stateStride] != 0 {
+					softState = t0
+					softOffset = buffc
+					softSplits = len(splits)
+					softNext = i
+					break
+				}
+			}
 		}
 
 		// can happen when no identity is defined.
@@ -460,7 +820,8 @@ PARSECHARM:
 			t = 0
 		} else {
 			// Checks a transition based on t0, a and buffo
-			t = mat.array[(int(a)-1)*mat.stateCount+int(t0)]
+			ti = (int(a)-1)*mat.symStride + int(t0)*mat.stateStride
+			t = mat.array[ti]
 		}
 
 		if DEBUG {
@@ -475,7 +836,12 @@ PARSECHARM:
 				log.Println("Match is not fine!")
 			}
 
-			if !ok && a == mat.identity {
+			if mat.categories.has(a) {
+
+				// Try again with identity symbol, in case the category failed
+				a = mat.identity
+
+			} else if !ok && a == mat.identity {
 
 				// Try again with unknown symbol, in case identity failed
 				// Char is only relevant when set
@@ -484,14 +850,48 @@ PARSECHARM:
 				}
 				a = mat.unknown
 
+				if cov != nil {
+					cov.Unknown++
+				}
+
+			} else if a != mat.epsilon && softState != 0 && (epsilonState == 0 || softOffset >= epsilonOffset) {
+
+				// Try again with the soft bound symbols, in case everything
+				// else failed, before the token is ended at the same position
+				t0 = softState
+				buffc = softOffset
+				splits = splits[:softSplits]
+				softSym = mat.softBounds[softNext].sym
+				softActive = softSelected[softNext]
+				a = softSym
+
+				// Try further soft bounds of the state, if this fails
+				softNext++
+				if softNext == len(mat.softBounds) {
+					softState = 0 // reset
+				}
+
+				if cov != nil {
+					cov.Backtracks++
+				}
+
+				if DEBUG {
+					log.Println("Get from soft bound and set buffo!", showBufferNew(buffer, bufft, buffc, buffi))
+				}
+
 			} else if a != mat.epsilon && epsilonState != 0 {
 
 				// Try again with epsilon symbol, in case everything else failed
 				t0 = epsilonState
 				epsilonState = 0 // reset
 				buffc = epsilonOffset
+				splits = splits[:epsilonSplits]
 				a = mat.epsilon
 
+				if cov != nil {
+					cov.Backtracks++
+				}
+
 				if DEBUG {
 					log.Println("Get from epsilon stack and set buffo!", showBufferNew(buffer, bufft, buffc, buffi))
 				}
@@ -502,6 +902,10 @@ PARSECHARM:
 					log.Println("Fail!")
 				}
 
+				if cov != nil {
+					cov.Fails++
+				}
+
 				// w.Fail(bufft)
 
 				// The following procedure means the automaton fails to consume a certain character.
@@ -518,14 +922,27 @@ PARSECHARM:
 						eof = true
 						break
 					}
+					if normalized {
+						outs[buffc-1] = nil
+					}
+				}
+
+				// Never end the token inside of a grapheme cluster
+				if clusters != nil {
+					bufft, buffc, buffi, eof = clusters.extend(reader, buffer, outs, bufft, buffc, buffi, eof)
 				}
-				// This will hopefully be branchless by the compiler
 
+				// This will hopefully be branchless by the compiler
 				if DEBUG {
 					log.Println("-> Flush buffer: [", string(buffer[bufft:buffc]), "]", showBufferNew(buffer, bufft, buffc, buffi))
 				}
 
-				w.Token(bufft, buffer[:buffc])
+				if normalized {
+					norm = normalize(norm, buffer[bufft:buffc], outs[bufft:buffc])
+					w.NormalizedToken(bufft, buffer[:buffc], norm)
+				} else {
+					w.Token(bufft, buffer[:buffc])
+				}
 
 				sentenceEnd = false
 				textEnd = false
@@ -535,9 +952,14 @@ PARSECHARM:
 				}
 
 				copy(buffer[0:], buffer[buffc:buffi])
+				if clusters != nil {
+					clusters.rewind(buffc, buffi)
+				}
 
 				buffi -= buffc
 				epsilonState = 0
+				softState = 0
+				splits = splits[:0]
 
 				buffc = 0
 				bufft = 0
@@ -559,14 +981,31 @@ PARSECHARM:
 		// Transition was successful
 		rewindBuffer = false
 
+		if cov != nil {
+			cov.Transitions[ti]++
+		}
+
 		// Transition consumes no character
 		if a == mat.epsilon {
 			// Transition marks the end of a token - so flush the buffer
 			if buffc-bufft > 0 {
+				// Never end the token inside of a grapheme cluster
+				if clusters != nil {
+					bufft, buffc, buffi, eof = clusters.extend(reader, buffer, outs, bufft, buffc, buffi, eof)
+				}
+
 				if DEBUG {
 					log.Println("-> Flush buffer: [", string(buffer[bufft:buffc]), "]", showBufferNew(buffer, bufft, buffc, buffi))
 				}
-				w.Token(bufft, buffer[:buffc])
+				if len(splits) > 0 {
+					norm = splitToken(w, buffer, bufft, buffc, splits, outs, norm)
+					splits = splits[:0]
+				} else if normalized {
+					norm = normalize(norm, buffer[bufft:buffc], outs[bufft:buffc])
+					w.NormalizedToken(bufft, buffer[:buffc], norm)
+				} else {
+					w.Token(bufft, buffer[:buffc])
+				}
 				rewindBuffer = true
 				sentenceEnd = false
 				textEnd = false
@@ -575,10 +1014,28 @@ PARSECHARM:
 				w.SentenceEnd(buffc)
 			}
 
+			// Transition passes a soft bound
+		} else if a == softSym {
+
+			// Active soft bounds split the token,
+			// once the token is ended by a token bound
+			if softActive && buffc > bufft && (clusters == nil || clusters.breaks[buffc]) {
+				splits = append(splits, buffc)
+			}
+
 			// Transition consumes a character
 		} else {
 			buffc++
 
+			// Remember the output of the character
+			if normalized {
+				if t&FIRSTBIT != 0 {
+					outs[buffc-1] = deleted
+				} else {
+					outs[buffc-1] = mat.rewrites[matrixKey(t0, a)]
+				}
+			}
+
 			// Transition does not produce a character
 			// Hopefully generated branchless code
 			if buffc-bufft == 1 && (t&FIRSTBIT) != 0 {
@@ -592,6 +1049,11 @@ PARSECHARM:
 
 		if eot {
 			eot = false
+
+			// Pass the skipped characters including EOT in lossless mode
+			if w.Skip != nil {
+				passSkipped(w, buffer[:buffc])
+			}
 			if !sentenceEnd {
 				sentenceEnd = true
 				w.SentenceEnd(buffc)
@@ -612,11 +1074,16 @@ PARSECHARM:
 			}
 
 			copy(buffer[0:], buffer[buffc:buffi])
+			if clusters != nil {
+				clusters.rewind(buffc, buffi)
+			}
 
 			buffi -= buffc
 			// epsilonOffset -= buffo
 			epsilonOffset = 0
 			epsilonState = 0
+			softState = 0
+			splits = splits[:0]
 
 			buffc = 0
 			bufft = 0
@@ -649,7 +1116,363 @@ PARSECHARM:
 
 	// Check epsilon transitions as long as possible
 	t0 = t
-	t = mat.array[(int(mat.epsilon)-1)*mat.stateCount+int(t0)]
+	t = mat.array[(int(mat.epsilon)-1)*mat.symStride+int(t0)*mat.stateStride]
+	a = mat.epsilon
+	newchar = false
+	// t can't be < 0
+	if t != 0 {
+		// Remember state for backtracking to last tokenend state
+		goto PARSECHARM
+
+	} else if epsilonState != 0 {
+		t0 = epsilonState
+		epsilonState = 0 // reset
+		buffc = epsilonOffset
+		splits = splits[:epsilonSplits]
+		if cov != nil {
+			cov.Backtracks++
+		}
+		if DEBUG {
+			log.Println("Get from epsilon stack and set buffo!", showBufferNew(buffer, bufft, buffc, buffi))
+		}
+		goto PARSECHARM
+	}
+
+	// something left in buffer
+	if buffc-bufft > 0 {
+		// Never end the token inside of a grapheme cluster
+		if clusters != nil {
+			bufft, buffc, buffi, eof = clusters.extend(reader, buffer, outs, bufft, buffc, buffi, eof)
+		}
+
+		if DEBUG {
+			log.Println("-> Flush buffer: [", string(buffer[bufft:buffc]), "]", showBufferNew(buffer, bufft, buffc, buffi))
+		}
+		if normalized {
+			norm = normalize(norm, buffer[bufft:buffc], outs[bufft:buffc])
+			w.NormalizedToken(bufft, buffer[:buffc], norm)
+		} else {
+			w.Token(bufft, buffer[:buffc])
+		}
+		sentenceEnd = false
+		textEnd = false
+
+		// Pass the remaining skipped characters in lossless mode
+	} else if w.Skip != nil && buffc > 0 {
+		passSkipped(w, buffer[:buffc])
+	}
+
+	// Add an additional sentence ending, if the file is over but no explicit
+	// sentence split was reached. This may be controversial and therefore
+	// optional via parameter.
+	if !sentenceEnd {
+		w.SentenceEnd(buffc)
+		if DEBUG {
+			log.Println("Sentence end")
+		}
+	}
+
+	if !textEnd {
+		w.TextEnd(buffc)
+		if DEBUG {
+			log.Println("Text end")
+		}
+	}
+
+	return true
+}
+
+// Transduce an input string against the matrix FSA
+// without soft bounds, normalized forms, grapheme
+// clusters, coverage counts or skipped characters.
+// This keeps the features out of the most common path.
+func (mat *MatrixTokenizer) transducePlain(r io.Reader, w *TokenWriter) bool {
+	var a int
+	var t0 uint32
+	t := uint32(1) // Initial state
+	var ok, rewindBuffer bool
+
+	// Remember the last position of a possible tokenend,
+	// in case the automaton fails.
+	epsilonState := uint32(0)
+	epsilonOffset := 0
+
+	// Remember if the last transition was epsilon
+	sentenceEnd := false
+
+	// Remember if a text end was already set
+	textEnd := false
+
+	buffer := make([]rune, 1024)
+	bufft := 0 // Buffer token offset
+	buffc := 0 // Buffer current symbol
+	buffi := 0 // Buffer length
+
+	// The buffer is organized as follows:
+	// [   t[....c..]..i]
+
+	reader := bufio.NewReader(r)
+	defer w.Flush()
+
+	var char rune
+
+	var err error
+	eof := false
+	eot := false
+	newchar := true
+
+PARSECHARM:
+	for {
+
+		if newchar {
+			// Get from reader if buffer is empty
+			if buffc >= buffi {
+				if eof {
+					break
+				}
+				char, _, err = reader.ReadRune()
+
+				// No more runes to read
+				if err != nil {
+					if err == io.EOF {
+						eof = true
+						break
+					}
+
+					log.Fatalln(err)
+					os.Exit(1)
+					return false
+				}
+
+				buffer[buffi] = char
+				buffi++
+			}
+
+			char = buffer[buffc]
+
+			if DEBUG {
+				log.Println("Current char", string(char), int(char), showBufferNew(buffer, bufft, buffc, buffi))
+			}
+
+			eot = false
+
+			if int(char) < 256 {
+				eot = int(char) == EOT
+
+				// mat.SigmaASCII[] is initialized with mat.identity
+				// or the category symbols
+				a = mat.sigmaASCII[int(char)]
+			} else {
+				a, ok = mat.sigma[char]
+
+				// Use category or identity symbol if character is not in sigma
+				if !ok && mat.identity != -1 {
+					a = mat.categories.fallback(char, mat.identity)
+				}
+			}
+
+			t0 = t
+
+			// Check for epsilon transitions and remember
+			if mat.array[(mat.epsilon-1)*mat.symStride+int(t0)*mat.stateStride] != 0 {
+				// Remember state for backtracking to last tokenend state
+				epsilonState = t0
+				epsilonOffset = buffc
+
+				if DEBUG {
+					log.Println("epsilonOffset is set to", buffc)
+				}
+			}
+		}
+
+		// can happen when no identity is defined.
+		// This shouldn't be tested in every loop
+		if a == 0 {
+			t = 0
+		} else {
+			// Checks a transition based on t0, a and buffo
+			t = mat.array[(int(a)-1)*mat.symStride+int(t0)*mat.stateStride]
+		}
+
+		if DEBUG {
+			// Char is only relevant if set
+			log.Println("Check", t0, "-", a, "(", string(char), ")", "->", t)
+		}
+
+		// Check if the transition is invalid according to the matrix
+		if t == 0 {
+
+			if DEBUG {
+				log.Println("Match is not fine!")
+			}
+
+			if mat.categories.has(a) {
+
+				// Try again with identity symbol, in case the category failed
+				a = mat.identity
+
+			} else if !ok && a == mat.identity {
+
+				// Try again with unknown symbol, in case identity failed
+				// Char is only relevant when set
+				if DEBUG {
+					log.Println("UNKNOWN symbol", string(char), "->", mat.unknown)
+				}
+				a = mat.unknown
+
+			} else if a != mat.epsilon && epsilonState != 0 {
+
+				// Try again with epsilon symbol, in case everything else failed
+				t0 = epsilonState
+				epsilonState = 0 // reset
+				buffc = epsilonOffset
+				a = mat.epsilon
+
+				if DEBUG {
+					log.Println("Get from epsilon stack and set buffo!", showBufferNew(buffer, bufft, buffc, buffi))
+				}
+
+			} else {
+
+				if DEBUG {
+					log.Println("Fail!")
+				}
+
+				// The automaton fails to consume a certain character,
+				// so the old or current data is dropped as a token
+				// (see TransduceTokenWriter)
+				if buffc-bufft <= 0 {
+					buffc++
+					if buffc == 0 {
+						eof = true
+						break
+					}
+				}
+
+				if DEBUG {
+					log.Println("-> Flush buffer: [", string(buffer[bufft:buffc]), "]", showBufferNew(buffer, bufft, buffc, buffi))
+				}
+
+				w.Token(bufft, buffer[:buffc])
+
+				sentenceEnd = false
+				textEnd = false
+
+				if DEBUG {
+					log.Println("-> Rewind buffer", bufft, buffc, buffi, epsilonOffset)
+				}
+
+				copy(buffer[0:], buffer[buffc:buffi])
+
+				buffi -= buffc
+				epsilonState = 0
+
+				buffc = 0
+				bufft = 0
+
+				a = mat.epsilon
+
+				// Restart from root state
+				t = uint32(1)
+				newchar = true
+				// goto PARSECHARM
+				continue
+			}
+
+			newchar = false
+			eot = false
+			continue
+		}
+
+		// Transition was successful
+		rewindBuffer = false
+
+		// Transition consumes no character
+		if a == mat.epsilon {
+			// Transition marks the end of a token - so flush the buffer
+			if buffc-bufft > 0 {
+				if DEBUG {
+					log.Println("-> Flush buffer: [", string(buffer[bufft:buffc]), "]", showBufferNew(buffer, bufft, buffc, buffi))
+				}
+				w.Token(bufft, buffer[:buffc])
+				rewindBuffer = true
+				sentenceEnd = false
+				textEnd = false
+			} else {
+				sentenceEnd = true
+				w.SentenceEnd(buffc)
+			}
+
+			// Transition consumes a character
+		} else {
+			buffc++
+
+			// Transition does not produce a character
+			// Hopefully generated branchless code
+			if buffc-bufft == 1 && (t&FIRSTBIT) != 0 {
+				if DEBUG {
+					log.Println("Nontoken forward", showBufferNew(buffer, bufft, buffc, buffi))
+				}
+				bufft++
+			}
+		}
+
+		if eot {
+			eot = false
+			if !sentenceEnd {
+				sentenceEnd = true
+				w.SentenceEnd(buffc)
+			}
+			textEnd = true
+			w.TextEnd(buffc)
+			rewindBuffer = true
+			if DEBUG {
+				log.Println("END OF TEXT")
+			}
+		}
+
+		// Rewind the buffer if necessary
+		if rewindBuffer {
+
+			if DEBUG {
+				log.Println("-> Rewind buffer", bufft, buffc, buffi, epsilonOffset)
+			}
+
+			copy(buffer[0:], buffer[buffc:buffi])
+
+			buffi -= buffc
+			epsilonOffset = 0
+			epsilonState = 0
+
+			buffc = 0
+			bufft = 0
+
+			if DEBUG {
+				log.Println("Remaining:", showBufferNew(buffer, bufft, buffc, buffi))
+			}
+		}
+
+		t &= ^FIRSTBIT
+
+		newchar = true
+	}
+
+	// Input reader is not yet finished
+	if !eof {
+		if DEBUG {
+			log.Println("Not at the end")
+		}
+		// This should never happen
+		return false
+	}
+
+	if DEBUG {
+		log.Println("Entering final check")
+	}
+
+	// Check epsilon transitions as long as possible
+	t0 = t
+	t = mat.array[(int(mat.epsilon)-1)*mat.symStride+int(t0)*mat.stateStride]
 	a = mat.epsilon
 	newchar = false
 	// t can't be < 0
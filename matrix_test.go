@@ -164,7 +164,7 @@ func TestMatrixReadWriteTokenizer(t *testing.T) {
 	buf := bytes.NewBuffer(b)
 	n, err := mat.WriteTo(buf)
 	assert.Nil(err)
-	assert.Equal(int64(230), n)
+	assert.Equal(int64(232), n)
 	mat2 := ParseMatrix(buf)
 	assert.NotNil(mat2)
 	assert.Equal(mat.sigma, mat2.sigma)
@@ -179,6 +179,67 @@ func TestMatrixReadWriteTokenizer(t *testing.T) {
 	assert.Equal(ttokenizeStr(mat2, "wald gehen"), "wald\ngehen")
 }
 
+func TestMatrixLayout(t *testing.T) {
+	assert := assert.New(t)
+	foma := LoadFomaFile("testdata/simpletok.fst")
+	assert.NotNil(foma)
+
+	mat := foma.ToMatrix()
+	assert.Equal(LAYOUT_SYMBOL_MAJOR, mat.Layout())
+
+	mat.SetLayout(LAYOUT_STATE_MAJOR)
+	assert.Equal(LAYOUT_STATE_MAJOR, mat.Layout())
+	assert.Equal(ttokenizeStr(mat, "bau"), "bau")
+	assert.Equal(ttokenizeStr(mat, "bad"), "bad")
+	assert.Equal(ttokenizeStr(mat, "wald gehen"), "wald\ngehen")
+
+	b := make([]byte, 0, 1024)
+	buf := bytes.NewBuffer(b)
+	_, err := mat.WriteTo(buf)
+	assert.Nil(err)
+	mat2 := ParseMatrix(buf)
+	assert.NotNil(mat2)
+	assert.Equal(LAYOUT_STATE_MAJOR, mat2.Layout())
+	assert.Equal(mat.array, mat2.array)
+	assert.Equal(ttokenizeStr(mat2, "wald gehen"), "wald\ngehen")
+
+	mat2.SetLayout(LAYOUT_SYMBOL_MAJOR)
+	assert.Equal(LAYOUT_SYMBOL_MAJOR, mat2.Layout())
+	assert.Equal(ttokenizeStr(mat2, "wald gehen"), "wald\ngehen")
+}
+
+func TestMatrixRenumberStates(t *testing.T) {
+	assert := assert.New(t)
+
+	mat := LoadMatrixFile("testdata/tokenizer_de.matok")
+	assert.NotNil(mat)
+
+	b := make([]byte, 0, 2048)
+	w := bytes.NewBuffer(b)
+
+	assert.True(mat.Transduce(strings.NewReader(s), w))
+	matStr := w.String()
+
+	visits := mat.StateVisits(strings.NewReader(s))
+	assert.Equal(mat.stateCount+1, len(visits))
+	assert.True(visits[1] > 0)
+
+	mat.RenumberStates(visits)
+	w.Reset()
+	assert.True(mat.Transduce(strings.NewReader(s), w))
+	assert.Equal(matStr, w.String())
+
+	// Frequent states are now at the beginning
+	visits = mat.StateVisits(strings.NewReader(s))
+	assert.True(visits[2] >= visits[3])
+	assert.True(visits[3] >= visits[mat.stateCount])
+
+	mat.SetLayout(LAYOUT_STATE_MAJOR)
+	w.Reset()
+	assert.True(mat.Transduce(strings.NewReader(s), w))
+	assert.Equal(matStr, w.String())
+}
+
 func TestMatrixIgnorableMCS(t *testing.T) {
 	assert := assert.New(t)
 
@@ -1376,6 +1437,22 @@ func TestMatrixTrimming(t *testing.T) {
 }
 
 func BenchmarkMatrixTransduce(b *testing.B) {
+	benchmarkMatrixTransduce(b, LAYOUT_SYMBOL_MAJOR, false)
+}
+
+func BenchmarkMatrixTransduceStateMajor(b *testing.B) {
+	benchmarkMatrixTransduce(b, LAYOUT_STATE_MAJOR, false)
+}
+
+func BenchmarkMatrixTransduceProfiled(b *testing.B) {
+	benchmarkMatrixTransduce(b, LAYOUT_SYMBOL_MAJOR, true)
+}
+
+func BenchmarkMatrixTransduceStateMajorProfiled(b *testing.B) {
+	benchmarkMatrixTransduce(b, LAYOUT_STATE_MAJOR, true)
+}
+
+func benchmarkMatrixTransduce(b *testing.B, layout Layout, profile bool) {
 	bu := make([]byte, 0, 2048)
 	w := bytes.NewBuffer(bu)
 
@@ -1383,6 +1460,11 @@ func BenchmarkMatrixTransduce(b *testing.B) {
 
 	mat := LoadMatrixFile("testdata/tokenizer_de.matok")
 
+	if profile {
+		mat.RenumberStates(mat.StateVisits(strings.NewReader(s)))
+	}
+	mat.SetLayout(layout)
+
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
@@ -0,0 +1,265 @@
+package datok
+
+// The minimization is based on Hopcroft (1971),
+// using the refinable partition data structure
+// as described in Valmari & Lehtinen (2008).
+//
+// As final states are ignored by the tokenizer, two states
+// are only equivalent, if they have the same outgoing
+// transitions (including the nontoken and tokenend flags
+// and rewrites)
+// to equivalent states. Missing transitions are treated as
+// failures and are therefore distinguishable from
+// transitions to states without outgoing transitions.
+
+// The label of a transition in the automaton
+type label struct {
+	sym      int
+	nontoken bool
+	tokenend bool
+	rewrite  string
+}
+
+// A refinable partition of states
+type partition struct {
+	elems []int // States ordered by blocks
+	loc   []int // Position of a state in elems
+	block []int // Block of a state
+	first []int // First position of a block in elems
+	end   []int // End position of a block in elems
+	mid   []int // End position of marked states of a block
+
+	touched []int
+}
+
+// Create a new partition with a single block
+// containing all the given states
+func newPartition(states []int, max int) *partition {
+	p := &partition{
+		elems: make([]int, len(states)),
+		loc:   make([]int, max+1),
+		block: make([]int, max+1),
+		first: []int{0},
+		end:   []int{len(states)},
+		mid:   []int{0},
+	}
+	copy(p.elems, states)
+	for i, s := range p.elems {
+		p.loc[s] = i
+	}
+	return p
+}
+
+// Number of blocks in the partition
+func (p *partition) size() int {
+	return len(p.first)
+}
+
+// Mark a state for splitting
+func (p *partition) mark(s int) {
+	b := p.block[s]
+	i := p.loc[s]
+	j := p.mid[b]
+
+	// Already marked
+	if i < j {
+		return
+	}
+
+	if j == p.first[b] {
+		p.touched = append(p.touched, b)
+	}
+
+	// Move the state to the marked section of the block
+	p.elems[i], p.elems[j] = p.elems[j], p.elems[i]
+	p.loc[p.elems[i]] = i
+	p.loc[p.elems[j]] = j
+	p.mid[b]++
+}
+
+// Split all touched blocks into marked and unmarked states
+// and call the handler for every new block with its origin.
+func (p *partition) split(handle func(b, nb int)) {
+	for _, b := range p.touched {
+		m := p.mid[b]
+
+		// All states are marked - nothing to split
+		if m == p.end[b] {
+			p.mid[b] = p.first[b]
+			continue
+		}
+
+		// Create a new block for the marked states
+		nb := len(p.first)
+		p.first = append(p.first, p.first[b])
+		p.end = append(p.end, m)
+		p.mid = append(p.mid, p.first[b])
+		p.first[b] = m
+		p.mid[b] = m
+
+		for i := p.first[nb]; i < p.end[nb]; i++ {
+			p.block[p.elems[i]] = nb
+		}
+
+		handle(b, nb)
+	}
+	p.touched = p.touched[:0]
+}
+
+// Minimize returns an automaton with a minimal number
+// of states, that behaves identical to the given automaton
+// in terms of tokenization.
+// States not reachable from the start state are removed.
+func (auto *Automaton) Minimize() *Automaton {
+
+	// Collect all reachable states
+	reachable := make([]bool, auto.stateCount+1)
+	states := make([]int, 0, auto.stateCount)
+	reachable[1] = true
+	states = append(states, 1)
+	for i := 0; i < len(states); i++ {
+		for a, e := range auto.transitions[states[i]] {
+			if a != auto.final && !reachable[e.end] {
+				reachable[e.end] = true
+				states = append(states, e.end)
+			}
+		}
+	}
+
+	// Collect all labels and inverse transitions
+	labels := make(map[label]int)
+	type inverse struct {
+		label  int
+		source int
+	}
+	inv := make([][]inverse, auto.stateCount+1)
+
+	for _, s := range states {
+		for a, e := range auto.transitions[s] {
+			if a == auto.final {
+				continue
+			}
+			l := label{sym: a, nontoken: e.nontoken, tokenend: e.tokenend, rewrite: e.rewrite}
+			id, ok := labels[l]
+			if !ok {
+				id = len(labels)
+				labels[l] = id
+			}
+			inv[e.end] = append(inv[e.end], inverse{label: id, source: s})
+		}
+	}
+
+	// The initial partition distinguishes final states
+	p := newPartition(states, auto.stateCount)
+	for _, s := range states {
+		if _, ok := auto.transitions[s][auto.final]; ok {
+			p.mark(s)
+		}
+	}
+
+	// All blocks are splitters initially, as missing
+	// transitions don't lead to an explicit fail state
+	work := make([]int, 0, 1024)
+	inWork := make([]bool, 0, 1024)
+	p.split(func(_, nb int) {})
+	for b := 0; b < p.size(); b++ {
+		work = append(work, b)
+		inWork = append(inWork, true)
+	}
+
+	// Sources of a splitter grouped by labels
+	bylabel := make([][]int, len(labels))
+	used := make([]int, 0, len(labels))
+
+	for len(work) > 0 {
+		s := work[len(work)-1]
+		work = work[:len(work)-1]
+		inWork[s] = false
+
+		for i := p.first[s]; i < p.end[s]; i++ {
+			for _, in := range inv[p.elems[i]] {
+				if len(bylabel[in.label]) == 0 {
+					used = append(used, in.label)
+				}
+				bylabel[in.label] = append(bylabel[in.label], in.source)
+			}
+		}
+
+		for _, l := range used {
+			for _, src := range bylabel[l] {
+				p.mark(src)
+			}
+			bylabel[l] = bylabel[l][:0]
+
+			p.split(func(b, nb int) {
+				inWork = append(inWork, false)
+
+				// Following Hopcroft, only the smaller part needs
+				// to be added, if the origin is no splitter anymore
+				if inWork[b] || p.end[nb]-p.first[nb] <= p.end[b]-p.first[b] {
+					work = append(work, nb)
+					inWork[nb] = true
+				} else {
+					work = append(work, b)
+					inWork[b] = true
+				}
+			})
+		}
+		used = used[:0]
+	}
+
+	// Create the minimized automaton, with the
+	// block of the start state being the start state
+	min := &Automaton{
+		sigmaRev:   make(map[int]rune, len(auto.sigmaRev)),
+		sigmaCount: auto.sigmaCount,
+		stateCount: p.size(),
+		epsilon:    auto.epsilon,
+		unknown:    auto.unknown,
+		identity:   auto.identity,
+		final:      auto.final,
+		tokenend:   auto.tokenend,
+		categories: auto.categories,
+		softBounds: auto.softBounds,
+	}
+
+	for num, sym := range auto.sigmaRev {
+		min.sigmaRev[num] = sym
+	}
+
+	// Number the blocks in the order of the reachable states
+	num := make([]int, p.size())
+	next := 1
+	for _, s := range states {
+		if b := p.block[s]; num[b] == 0 {
+			num[b] = next
+			next++
+		}
+	}
+
+	min.transitions = make([]map[int]*edge, min.stateCount+1)
+	for b := 0; b < p.size(); b++ {
+
+		// Take the first state as the representative of the block
+		s := p.elems[p.first[b]]
+		t := make(map[int]*edge, len(auto.transitions[s]))
+		for a, e := range auto.transitions[s] {
+			if a == auto.final {
+				t[a] = &edge{}
+			} else {
+				t[a] = &edge{
+					inSym:    e.inSym,
+					outSym:   e.outSym,
+					end:      num[p.block[e.end]],
+					nontoken: e.nontoken,
+					tokenend: e.tokenend,
+					rewrite:  e.rewrite,
+				}
+			}
+			min.arcCount++
+		}
+		min.transitions[num[b]] = t
+	}
+
+	return min
+}
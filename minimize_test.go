@@ -0,0 +1,71 @@
+package datok
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMinimizeSimple(t *testing.T) {
+	assert := assert.New(t)
+
+	// bau | bauamt
+	tok := LoadFomaFile("testdata/bauamt.fst")
+	assert.NotNil(tok)
+
+	min := tok.Minimize()
+	assert.True(min.StateCount() <= tok.StateCount())
+
+	mat := min.ToMatrix()
+	assert.Equal("i\nbauamt", ttokenizeStr(mat, "ibauamt"))
+	assert.Equal("bau\nm", ttokenizeStr(mat, "baum"))
+	assert.Equal("bau\nd\ni\nbauamt", ttokenizeStr(mat, "baudibauamt"))
+
+	dat := min.ToDoubleArray()
+	assert.Equal("bau\nd\ni\nbauamt", ttokenizeStr(dat, "baudibauamt"))
+
+	// Minimizing is idempotent
+	assert.Equal(min.StateCount(), min.Minimize().StateCount())
+}
+
+func TestMinimizeFlags(t *testing.T) {
+	assert := assert.New(t)
+
+	tok := LoadFomaFile("testdata/simpletok.fst")
+	assert.NotNil(tok)
+
+	min := tok.Minimize()
+	assert.True(min.StateCount() <= tok.StateCount())
+
+	str := "  wald   gehen Da kann\t man was \"erleben\"!"
+	assert.Equal(
+		ttokenizeStr(tok.ToMatrix(), str),
+		ttokenizeStr(min.ToMatrix(), str),
+	)
+	assert.Equal(
+		ttokenizeStr(tok.ToDoubleArray(), str),
+		ttokenizeStr(min.ToDoubleArray(), str),
+	)
+}
+
+func TestMinimizeFullTokenizer(t *testing.T) {
+	assert := assert.New(t)
+
+	tok := LoadFomaFile("testdata/tokenizer_de.fst")
+	assert.NotNil(tok)
+
+	min := tok.Minimize()
+	assert.True(min.StateCount() <= tok.StateCount())
+
+	b := make([]byte, 0, 2048)
+	w := bytes.NewBuffer(b)
+
+	assert.True(tok.ToMatrix().Transduce(strings.NewReader(s), w))
+	matStr := w.String()
+
+	w.Reset()
+	assert.True(min.ToMatrix().Transduce(strings.NewReader(s), w))
+	assert.Equal(matStr, w.String())
+}
@@ -0,0 +1,465 @@
+package datok
+
+import (
+	"bufio"
+	"html"
+	"io"
+	"log"
+	"unicode/utf8"
+)
+
+// Emit passes a character with its span in the original input
+type Emit func(char rune, start, end int)
+
+// Filter transforms the characters of the input before
+// tokenization. Every character is passed with its span
+// in the original input, and filters pass on characters
+// with the span of the characters they are derived from.
+type Filter interface {
+	// Receives a character with its span
+	Char(char rune, start, end int, emit Emit)
+
+	// Passes all buffered characters at the end of the input
+	Flush(emit Emit)
+}
+
+// Preprocessor applies a chain of filters to the input
+// of a tokenizer, while tokens and sentences are reported
+// with offsets into the original input.
+type Preprocessor struct {
+	filters []Filter
+}
+
+// A span of a filtered character in the original input
+type origSpan struct {
+	start int
+	end   int
+}
+
+// A character with its span in the original input
+type spanChar struct {
+	char  rune
+	start int
+	end   int
+}
+
+// NewPreprocessor creates a new preprocessor applying
+// the filters in the given order.
+func NewPreprocessor(filters ...Filter) *Preprocessor {
+	return &Preprocessor{filters: filters}
+}
+
+// The state of a preprocessed transduction, shared by
+// the filtered input and the mapping token writer
+type preprocessing struct {
+	reader *bufio.Reader
+	emit   Emit
+	flush  func()
+	eof    bool
+
+	// Encoded filtered characters not yet read
+	out []byte
+
+	// Original characters from origBase on
+	orig     []rune
+	origBase int
+
+	// Spans of the filtered characters from spanBase on
+	spans    []origSpan
+	spanBase int
+
+	// Positions of forced sentence boundaries
+	// in the original input
+	boundaries []int
+}
+
+// Read filtered characters
+func (pp *preprocessing) Read(p []byte) (int, error) {
+	for len(pp.out) < len(p) && !pp.eof {
+		char, _, err := pp.reader.ReadRune()
+		if err != nil {
+			if err != io.EOF {
+				log.Println(err)
+			}
+			pp.flush()
+			pp.eof = true
+			break
+		}
+		pos := pp.origBase + len(pp.orig)
+		pp.orig = append(pp.orig, char)
+		pp.emit(char, pos, pos+1)
+	}
+
+	if len(pp.out) == 0 && pp.eof {
+		return 0, io.EOF
+	}
+	n := copy(p, pp.out)
+	pp.out = pp.out[:copy(pp.out, pp.out[n:])]
+	return n, nil
+}
+
+// Add a filtered character
+func (pp *preprocessing) record(char rune, start, end int) {
+	var buf [utf8.UTFMax]byte
+	pp.out = append(pp.out, buf[:utf8.EncodeRune(buf[:], char)]...)
+	pp.spans = append(pp.spans, origSpan{start, end})
+}
+
+// Add a forced sentence boundary
+func (pp *preprocessing) boundary(pos int) {
+	pp.boundaries = append(pp.boundaries, pos)
+}
+
+// Forget all forced sentence boundaries up to the
+// given position and check if there were any
+func (pp *preprocessing) passBoundaries(pos int) bool {
+	i := 0
+	for i < len(pp.boundaries) && pp.boundaries[i] <= pos {
+		i++
+	}
+	pp.boundaries = pp.boundaries[:copy(pp.boundaries, pp.boundaries[i:])]
+	return i > 0
+}
+
+// Forget filtered and original characters
+// before the given positions
+func (pp *preprocessing) trim(pos, origPos int) {
+	if n := pos - pp.spanBase; n >= 1024 {
+		pp.spans = pp.spans[:copy(pp.spans, pp.spans[n:])]
+		pp.spanBase = pos
+	}
+	if n := origPos - pp.origBase; n >= 1024 {
+		pp.orig = pp.orig[:copy(pp.orig, pp.orig[n:])]
+		pp.origBase = origPos
+	}
+}
+
+// TransduceTokenWriter transduces the filtered input with the
+// tokenizer. Tokens are passed to the token writer with their
+// original characters, so all offsets refer to the original input.
+// The filtered forms are passed as normalized forms, if requested.
+func (pr *Preprocessor) TransduceTokenWriter(tok Tokenizer, r io.Reader, w *TokenWriter) bool {
+	pp := &preprocessing{
+		reader: bufio.NewReader(r),
+		out:    make([]byte, 0, 4096),
+		orig:   make([]rune, 0, 4096),
+		spans:  make([]origSpan, 0, 4096),
+	}
+
+	// Chain the filters
+	emits := make([]Emit, len(pr.filters)+1)
+	emits[len(pr.filters)] = pp.record
+	for i := len(pr.filters) - 1; i >= 0; i-- {
+		filter, next := pr.filters[i], emits[i+1]
+		emits[i] = func(char rune, start, end int) {
+			filter.Char(char, start, end, next)
+		}
+	}
+	pp.emit = emits[0]
+	for _, filter := range pr.filters {
+		if bf, ok := filter.(boundaryFilter); ok {
+			bf.setBoundary(pp.boundary)
+		}
+	}
+	pp.flush = func() {
+		for i, filter := range pr.filters {
+			filter.Flush(emits[i+1])
+		}
+	}
+
+	return tok.TransduceTokenWriter(pp, pp.tokenWriter(w))
+}
+
+// Create a token writer, that maps the filtered tokens
+// and skipped characters to the original characters
+func (pp *preprocessing) tokenWriter(tw *TokenWriter) *TokenWriter {
+
+	// Consumed filtered and original characters
+	pos := 0
+	origPos := 0
+
+	// A token was passed since the last sentence end
+	inSentence := false
+
+	// Get the end of the filtered characters in the original input
+	endOf := func(n int) int {
+		end := origPos
+		if n > 0 {
+			end = pp.spans[pos+n-1-pp.spanBase].end
+		}
+		if end < origPos {
+			end = origPos
+		}
+		return end
+	}
+
+	token := func(offset int, buf []rune, norm []rune, words [][]rune) {
+		start := pp.spans[pos+offset-pp.spanBase].start
+		if start < origPos {
+			start = origPos
+		}
+		end := endOf(len(buf))
+		if end < start {
+			end = start
+		}
+
+		// Force a sentence boundary
+		if pp.passBoundaries(start) && inSentence {
+			tw.SentenceEnd(0)
+		}
+		inSentence = true
+
+		orig := pp.orig[origPos-pp.origBase : end-pp.origBase]
+		if words != nil {
+			tw.MultiWordToken(start-origPos, orig, words)
+		} else if tw.NormalizedToken != nil {
+			if norm == nil {
+				norm = buf[offset:]
+			}
+			tw.NormalizedToken(start-origPos, orig, norm)
+		} else {
+			tw.Token(start-origPos, orig)
+		}
+
+		pos += len(buf)
+		origPos = end
+		pp.trim(pos, origPos)
+	}
+
+	// Original characters are skipped like the filtered characters
+	skip := func(end int) {
+		if tw.Skip != nil && end > origPos {
+			passSkipped(tw, pp.orig[origPos-pp.origBase:end-pp.origBase])
+		}
+		origPos = end
+		pp.trim(pos, origPos)
+	}
+
+	ptw := &TokenWriter{
+		Token: func(offset int, buf []rune) {
+			token(offset, buf, nil, nil)
+		},
+		SentenceEnd: func(arg int) {
+			inSentence = false
+			tw.SentenceEnd(arg)
+		},
+		TextEnd: func(arg int) {
+			inSentence = false
+			pp.passBoundaries(origPos)
+
+			// Original characters removed at the end of the input
+			if pp.eof && pos == pp.spanBase+len(pp.spans) {
+				skip(pp.origBase + len(pp.orig))
+			}
+			tw.TextEnd(arg)
+		},
+		Flush: func() error {
+
+			// Original characters removed at the end of the input,
+			// following the last text end
+			if pp.eof {
+				skip(pp.origBase + len(pp.orig))
+			}
+			return tw.Flush()
+		},
+		Skip: func(_ SkipKind, buf []rune) {
+			end := endOf(len(buf))
+			pos += len(buf)
+			skip(end)
+		},
+		SoftBounds: tw.SoftBounds,
+		Coverage:   tw.Coverage,
+		Graphemes:  tw.Graphemes,
+	}
+
+	if tw.NormalizedToken != nil {
+		ptw.NormalizedToken = func(offset int, buf []rune, norm []rune) {
+			token(offset, buf, norm, nil)
+		}
+	}
+
+	if tw.MultiWordToken != nil {
+		ptw.MultiWordToken = func(offset int, buf []rune, words [][]rune) {
+			token(offset, buf, nil, words)
+		}
+	}
+	return ptw
+}
+
+// A node in the character trie of replacements
+type replaceNode struct {
+	next  map[rune]*replaceNode
+	final bool
+	repl  []rune
+}
+
+// Filter replacing strings, preferring the longest match
+type replaceFilter struct {
+	root    *replaceNode
+	pending []spanChar
+}
+
+// NewReplaceFilter creates a filter replacing all occurrences
+// of the keys by their values, preferring the longest match.
+// Replaced characters are derived from all matched characters.
+func NewReplaceFilter(replacements map[string]string) Filter {
+	rf := &replaceFilter{root: &replaceNode{}}
+	for from, to := range replacements {
+		if from == "" {
+			log.Println("Empty replacement pattern")
+			return nil
+		}
+		node := rf.root
+		for _, r := range from {
+			if node.next == nil {
+				node.next = make(map[rune]*replaceNode)
+			}
+			n, ok := node.next[r]
+			if !ok {
+				n = &replaceNode{}
+				node.next[r] = n
+			}
+			node = n
+		}
+		node.final = true
+		node.repl = []rune(to)
+	}
+	return rf
+}
+
+// NewCRLFFilter creates a filter normalizing
+// CRLF and CR line breaks to LF.
+func NewCRLFFilter() Filter {
+	return NewReplaceFilter(map[string]string{"\r\n": "\n", "\r": "\n"})
+}
+
+// NewSoftHyphenFilter creates a filter removing soft hyphens.
+func NewSoftHyphenFilter() Filter {
+	return NewReplaceFilter(map[string]string{"\u00ad": ""})
+}
+
+// NewWikiFilter creates a filter removing the brackets of
+// internal links and the quotes of bold and italic text
+// in wiki markup.
+func NewWikiFilter() Filter {
+	return NewReplaceFilter(map[string]string{
+		"[[":  "",
+		"]]":  "",
+		"''":  "",
+		"'''": "",
+	})
+}
+
+// Emit all pending characters, that can't be part
+// of a longer match. If final is true,
+// no more characters are expected.
+func (rf *replaceFilter) resolve(emit Emit, final bool) {
+	for len(rf.pending) > 0 {
+
+		// Find the longest match
+		node := rf.root
+		match := 0
+		var matchNode *replaceNode
+		i := 0
+		for ; i < len(rf.pending) && node != nil; i++ {
+			node = node.next[rf.pending[i].char]
+			if node != nil && node.final {
+				match = i + 1
+				matchNode = node
+			}
+		}
+
+		// The match may be continued by following characters
+		if !final && i == len(rf.pending) && node != nil && len(node.next) > 0 {
+			return
+		}
+
+		if match == 0 {
+			c := rf.pending[0]
+			emit(c.char, c.start, c.end)
+			match = 1
+		} else {
+			start, end := rf.pending[0].start, rf.pending[match-1].end
+			for _, r := range matchNode.repl {
+				emit(r, start, end)
+			}
+		}
+		rf.pending = rf.pending[:copy(rf.pending, rf.pending[match:])]
+	}
+}
+
+// Char implements the Filter interface.
+func (rf *replaceFilter) Char(char rune, start, end int, emit Emit) {
+	rf.pending = append(rf.pending, spanChar{char, start, end})
+	rf.resolve(emit, false)
+}
+
+// Flush implements the Filter interface.
+func (rf *replaceFilter) Flush(emit Emit) {
+	rf.resolve(emit, true)
+}
+
+// Filter decoding HTML character references
+type entityFilter struct {
+	pending []spanChar
+	name    []rune
+}
+
+// Maximum length of a character reference
+const maxEntityLen = 32
+
+// NewEntityFilter creates a filter decoding named and
+// numeric HTML character references, e.g. &amp; or &#x41;.
+func NewEntityFilter() Filter {
+	return &entityFilter{}
+}
+
+// Check if a character may be part of a character reference
+func isEntityChar(char rune) bool {
+	return char == '#' ||
+		(char >= '0' && char <= '9') ||
+		(char >= 'a' && char <= 'z') ||
+		(char >= 'A' && char <= 'Z')
+}
+
+// Char implements the Filter interface.
+func (ef *entityFilter) Char(char rune, start, end int, emit Emit) {
+	if len(ef.pending) == 0 {
+		if char == '&' {
+			ef.pending = append(ef.pending, spanChar{char, start, end})
+		} else {
+			emit(char, start, end)
+		}
+		return
+	}
+
+	if char == ';' {
+		ef.name = ef.name[:0]
+		for _, c := range ef.pending {
+			ef.name = append(ef.name, c.char)
+		}
+		ref := string(ef.name) + ";"
+		if dec := html.UnescapeString(ref); dec != ref {
+			for _, r := range dec {
+				emit(r, ef.pending[0].start, end)
+			}
+			ef.pending = ef.pending[:0]
+			return
+		}
+	} else if isEntityChar(char) && len(ef.pending) < maxEntityLen {
+		ef.pending = append(ef.pending, spanChar{char, start, end})
+		return
+	}
+
+	// No character reference
+	ef.Flush(emit)
+	ef.Char(char, start, end, emit)
+}
+
+// Flush implements the Filter interface.
+func (ef *entityFilter) Flush(emit Emit) {
+	for _, c := range ef.pending {
+		emit(c.char, c.start, c.end)
+	}
+	ef.pending = ef.pending[:0]
+}
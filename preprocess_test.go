@@ -0,0 +1,125 @@
+package datok
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Apply the filters to a string
+func tfilter(str string, filters ...Filter) string {
+	var sb strings.Builder
+	emits := make([]Emit, len(filters)+1)
+	emits[len(filters)] = func(char rune, _, _ int) {
+		sb.WriteRune(char)
+	}
+	for i := len(filters) - 1; i >= 0; i-- {
+		filter, next := filters[i], emits[i+1]
+		emits[i] = func(char rune, start, end int) {
+			filter.Char(char, start, end, next)
+		}
+	}
+	for i, char := range []rune(str) {
+		emits[0](char, i, i+1)
+	}
+	for i, filter := range filters {
+		filter.Flush(emits[i+1])
+	}
+	return sb.String()
+}
+
+func TestFilters(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("a\nb\nc\n", tfilter("a\r\nb\rc\n", NewCRLFFilter()))
+	assert.Equal("Silbentrennung", tfilter("Silben­tren­nung", NewSoftHyphenFilter()))
+	assert.Equal("Berlin ist fett", tfilter("[[Berlin]] ist '''fett'''", NewWikiFilter()))
+	assert.Equal("A & B < C AA &foo; &amp &", tfilter("A &amp; B &lt; C &#65;&#x41; &foo; &amp &", NewEntityFilter()))
+
+	// Longest match
+	assert.Equal("xyb", tfilter("aaab", NewReplaceFilter(map[string]string{"aa": "x", "a": "y"})))
+	assert.Nil(NewReplaceFilter(map[string]string{"": "x"}))
+
+	// Filters are composable
+	assert.Equal("a&b\n", tfilter("a&amp;amp;b\r", NewEntityFilter(), NewEntityFilter(), NewCRLFFilter()))
+}
+
+func TestPreprocessor(t *testing.T) {
+	assert := assert.New(t)
+
+	mat := LoadMatrixFile("testdata/tokenizer_de.matok")
+	dat := LoadDatokFile("testdata/tokenizer_de.datok")
+	assert.NotNil(mat)
+	assert.NotNil(dat)
+
+	pp := NewPreprocessor(NewCRLFFilter(), NewEntityFilter(), NewSoftHyphenFilter(), NewWikiFilter())
+
+	b := make([]byte, 0, 2048)
+	w := bytes.NewBuffer(b)
+
+	for _, tok := range []Tokenizer{mat, dat} {
+
+		// Tokens are passed with the original characters
+		w.Reset()
+		str := "Die [[Silben­trennung]] &amp; mehr.\r\nNoch&nbsp;ein Satz."
+		assert.True(pp.TransduceTokenWriter(tok, strings.NewReader(str), NewTokenWriter(w, TOKENS|SENTENCES|TOKEN_POS|SENTENCE_POS)))
+		assert.Equal(
+			"Die\nSilben­trennung\n&amp;\nmehr\n.\n\nNoch\nein\nSatz\n.\n\n"+
+				"0 3 6 21 24 29 30 34 34 35 37 41 47 50 51 55 55 56\n0 35 37 56\n",
+			w.String(),
+		)
+
+		// Filtered forms are passed as normalized forms
+		w.Reset()
+		assert.True(pp.TransduceTokenWriter(tok, strings.NewReader(str), NewTokenWriter(w, TOKENS|NORMALIZED)))
+		assert.Equal(
+			"Die\tDie\nSilben­trennung\tSilbentrennung\n&amp;\t&\nmehr\tmehr\n.\t.\n"+
+				"Noch\tNoch\nein\tein\nSatz\tSatz\n.\t.\n\n",
+			w.String(),
+		)
+
+		// Texts
+		w.Reset()
+		str = "''Der'' Mann.\x04\n[[Er]]\x04[[Sie]]"
+		assert.True(pp.TransduceTokenWriter(tok, strings.NewReader(str), NewTokenWriter(w, TOKEN_POS)))
+		assert.Equal("2 5 8 12 12 13\n3 5\n2 5\n", w.String())
+
+		// The original input can be reconstructed in lossless mode
+		for _, str := range append(losslessStr, "[[Er]] &amp; ''sie''­\r\n]]", ".\x04 \u00ad") {
+			rec, _ := tlosslessEvents(&ppTokenizer{pp, tok}, str)
+			assert.Equal(str, rec)
+		}
+	}
+
+	// Offsets are mapped for large inputs
+	w.Reset()
+	str := strings.Repeat("Der &amp; Mann ", 2000)
+	assert.True(pp.TransduceTokenWriter(mat, strings.NewReader(str), NewTokenWriter(w, TOKEN_POS)))
+	pos := strings.Fields(w.String())
+	assert.Equal(12000, len(pos))
+	assert.Equal("29989", pos[11996])
+	assert.Equal("29994", pos[11997])
+	assert.Equal("29995", pos[11998])
+	assert.Equal("29999", pos[11999])
+}
+
+// Tokenizer applying a preprocessor
+type ppTokenizer struct {
+	pp  *Preprocessor
+	tok Tokenizer
+}
+
+func (ppt *ppTokenizer) Transduce(r io.Reader, w io.Writer) bool {
+	return ppt.TransduceTokenWriter(r, NewTokenWriter(w, SIMPLE))
+}
+
+func (ppt *ppTokenizer) TransduceTokenWriter(r io.Reader, w *TokenWriter) bool {
+	return ppt.pp.TransduceTokenWriter(ppt.tok, r, w)
+}
+
+func (ppt *ppTokenizer) Type() string {
+	return ppt.tok.Type()
+}
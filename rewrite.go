@@ -0,0 +1,112 @@
+package datok
+
+import (
+	"io"
+	"sort"
+)
+
+// rewrites are the outputs of transitions, that neither map
+// characters to themselves nor to ε (e.g. ’:'), indexed by
+// the transition. In the matrix, the transition is identified
+// by its state and symbol, in the double array by its cell.
+type rewrites map[uint64][]rune
+
+// Output of characters removed from the normalized form
+var deleted = []rune{}
+
+// Key of a transition in the matrix
+func matrixKey(s uint32, a int) uint64 {
+	return uint64(s)<<16 | uint64(a)
+}
+
+// Write the rewrites as part of the header
+// of a tokenizer file
+func (rw rewrites) writeTo(w io.Writer) (int, error) {
+	keys := make([]uint64, 0, len(rw))
+	for key := range rw {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
+
+	buf := make([]byte, 10)
+	bo.PutUint32(buf[0:4], uint32(len(keys)))
+	all, err := w.Write(buf[0:4])
+	if err != nil {
+		return all, err
+	}
+
+	for _, key := range keys {
+		out := string(rw[key])
+		bo.PutUint64(buf[0:8], key)
+		bo.PutUint16(buf[8:10], uint16(len(out)))
+		more, err := w.Write(buf[0:10])
+		all += more
+		if err != nil {
+			return all, err
+		}
+		more, err = io.WriteString(w, out)
+		all += more
+		if err != nil {
+			return all, err
+		}
+	}
+	return all, nil
+}
+
+// Read the rewrites from the header
+// of a tokenizer file
+func (rw *rewrites) readFrom(r io.Reader) error {
+	buf := make([]byte, 10)
+	if _, err := io.ReadFull(r, buf[0:4]); err != nil {
+		return err
+	}
+
+	n := int(bo.Uint32(buf[0:4]))
+	if n == 0 {
+		*rw = nil
+		return nil
+	}
+
+	*rw = make(rewrites, n)
+	for i := 0; i < n; i++ {
+		if _, err := io.ReadFull(r, buf[0:10]); err != nil {
+			return err
+		}
+		out := make([]byte, bo.Uint16(buf[8:10]))
+		if _, err := io.ReadFull(r, out); err != nil {
+			return err
+		}
+		(*rw)[bo.Uint64(buf[0:8])] = []rune(string(out))
+	}
+	return nil
+}
+
+// Check if two sets of rewrites are identical
+func (rw rewrites) equal(other rewrites) bool {
+	if len(rw) != len(other) {
+		return false
+	}
+	for key, out := range rw {
+		if string(other[key]) != string(out) {
+			return false
+		}
+	}
+	return true
+}
+
+// Create the normalized form of a token based on
+// the outputs of its characters, with nil
+// meaning the character maps to itself
+func normalize(norm []rune, token []rune, outs [][]rune) []rune {
+	norm = norm[:0]
+	for i, char := range token {
+		if outs[i] == nil {
+			norm = append(norm, char)
+		} else {
+			norm = append(norm, outs[i]...)
+		}
+	}
+	return norm
+}
@@ -0,0 +1,88 @@
+package datok
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var rewriteStr = "Er sagt „ﬁne’s“ Hal­lo. ­es"
+
+func TestRewrites(t *testing.T) {
+	assert := assert.New(t)
+
+	auto := LoadFomaFile("testdata/rewrites.fst")
+	assert.NotNil(auto)
+
+	b := make([]byte, 0, 2048)
+	w := bytes.NewBuffer(b)
+
+	for _, tok := range []Tokenizer{
+		auto.ToMatrix(),
+		auto.ToDoubleArray(),
+		auto.toDoubleArray(nil, true),
+	} {
+		// Surface forms are kept
+		assert.Equal("Er\nsagt\n„\nﬁne’s\n“\nHal­lo\n.\nes", ttokenizeStr(tok, rewriteStr))
+
+		// Normalized forms are written next to the surface forms
+		w.Reset()
+		tok.TransduceTokenWriter(strings.NewReader(rewriteStr), NewTokenWriter(w, TOKENS|TOKEN_POS|NORMALIZED))
+		assert.Equal("Er\tEr\nsagt\tsagt\n„\t\"\nﬁne’s\tfine's\n“\t\"\nHal­lo\tHallo\n.\t.\nes\tes\n"+
+			"0 2 3 7 8 9 9 14 14 15 16 22 22 23 25 27\n", w.String())
+
+		// Normalized forms are passed when joining grapheme clusters
+		w.Reset()
+		tok.TransduceTokenWriter(strings.NewReader("„ﬁné"), tgraphemeTokenWriter(w, TOKENS|NORMALIZED))
+		assert.Equal("„\t\"\nﬁné\tfiné\n\n", w.String())
+
+		// Only tokens not adjusted by the lexicon keep their normalized forms
+		lex := NewLexicon()
+		assert.True(lex.Add("sagt „ﬁne’s“"))
+		w.Reset()
+		tok.TransduceTokenWriter(strings.NewReader("Er sagt „ﬁne’s“ Hal­lo"), lex.TokenWriter(NewTokenWriter(w, TOKENS|NORMALIZED)))
+		assert.Equal("Er\tEr\nsagt „ﬁne’s“\tsagt „ﬁne’s“\nHal­lo\tHallo\n\n", w.String())
+
+		// Only tokens not split by the segmenter keep their normalized forms
+		seg := SegmenterFunc(func(token []rune) []int {
+			if string(token) == "ﬁne’s" {
+				return []int{4, 1}
+			}
+			return nil
+		})
+		w.Reset()
+		tok.TransduceTokenWriter(strings.NewReader("„ﬁne’s“"), NewSegmenterTokenWriter(NewTokenWriter(w, TOKENS|NORMALIZED), seg))
+		assert.Equal("„\t\"\nﬁne’\tﬁne’\ns\ts\n“\t\"\n\n", w.String())
+	}
+}
+
+func TestRewritesReadWrite(t *testing.T) {
+	assert := assert.New(t)
+
+	norm := "Er\tEr\nsagt\tsagt\n„\t\"\nﬁne’s\tfine's\n“\t\"\nHal­lo\tHallo\n.\t.\n\nes\tes\n\n\n"
+	normalize := func(tok Tokenizer) string {
+		w := &bytes.Buffer{}
+		tok.TransduceTokenWriter(strings.NewReader(rewriteStr), NewTokenWriter(w, SIMPLE|NORMALIZED))
+		return w.String()
+	}
+
+	// Rewrites are kept and no unsupported transitions
+	auto, mat, _ := treadWrite(t, "testdata/rewrites.fst", normalize, norm)
+	assert.NotZero(len(mat.rewrites))
+
+	// Renumbered states keep their rewrites
+	mat.SetLayout(LAYOUT_STATE_MAJOR)
+	mat.RenumberStates(mat.StateVisits(strings.NewReader(rewriteStr)))
+	assert.Equal(norm, normalize(mat))
+
+	// Rewrites are compared
+	for key := range mat.rewrites {
+		mat.rewrites[key] = []rune("x")
+		break
+	}
+	ok, ex := Equivalent(auto, mat)
+	assert.False(ok)
+	assert.NotEqual("", ex)
+}
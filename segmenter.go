@@ -0,0 +1,249 @@
+package datok
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Segmenter splits tokens after tokenization,
+// e.g. for scripts without whitespace between words.
+// Segment returns the lengths of the parts in characters,
+// or nil, in case the token is kept.
+type Segmenter interface {
+	Segment(token []rune) []int
+}
+
+// SegmenterFunc is a function usable as a Segmenter.
+type SegmenterFunc func(token []rune) []int
+
+// Segment calls the function.
+func (f SegmenterFunc) Segment(token []rune) []int {
+	return f(token)
+}
+
+// NewSegmenterTokenWriter wraps a token writer, so that
+// all tokens passed are split by the segmenter.
+// Offsets are kept. Parts of split tokens
+// have no normalized forms. Multi-word tokens
+// are passed unchanged.
+func NewSegmenterTokenWriter(tw *TokenWriter, seg Segmenter) *TokenWriter {
+
+	// Split a token and return false, if the token is kept
+	split := func(offset int, buf []rune) bool {
+		parts := seg.Segment(buf[offset:])
+		if len(parts) < 2 {
+			return false
+		}
+
+		// The first part keeps the skipped characters
+		start := 0
+		end := offset
+		for _, l := range parts {
+			end += l
+			if end > len(buf) {
+				break
+			}
+			tw.Token(offset, buf[start:end])
+			start = end
+			offset = 0
+		}
+		if start < len(buf) {
+			tw.Token(offset, buf[start:])
+		}
+		return true
+	}
+
+	stw := &TokenWriter{
+		Token: func(offset int, buf []rune) {
+			if !split(offset, buf) {
+				tw.Token(offset, buf)
+			}
+		},
+		SentenceEnd:    tw.SentenceEnd,
+		TextEnd:        tw.TextEnd,
+		Flush:          tw.Flush,
+		MultiWordToken: tw.MultiWordToken,
+		Skip:           tw.Skip,
+		SoftBounds:     tw.SoftBounds,
+		Coverage:       tw.Coverage,
+		Graphemes:      tw.Graphemes,
+	}
+
+	if tw.NormalizedToken != nil {
+		stw.NormalizedToken = func(offset int, buf []rune, norm []rune) {
+			if !split(offset, buf) {
+				tw.NormalizedToken(offset, buf, norm)
+			}
+		}
+	}
+	return stw
+}
+
+// Classes of characters in CJK runs
+const (
+	cjkNone = iota
+	cjkHan
+	cjkHiragana
+	cjkKatakana
+	cjkHangul
+	cjkPunct
+)
+
+// Get the class of a character in CJK runs
+func cjkClass(r rune) int {
+	switch {
+	case r < 0x1100:
+		return cjkNone
+	case unicode.Is(unicode.Han, r):
+		return cjkHan
+	case unicode.Is(unicode.Hiragana, r):
+		return cjkHiragana
+	case unicode.Is(unicode.Katakana, r) || r == 0x30FC || r == 0xFF70:
+		return cjkKatakana
+	case unicode.Is(unicode.Hangul, r):
+		return cjkHangul
+	case (r >= 0x3000 && r <= 0x303F || r >= 0xFF00 && r <= 0xFFEF) && !unicode.IsLetter(r) && !unicode.IsDigit(r):
+		return cjkPunct
+	}
+	return cjkNone
+}
+
+// MaxMatchSegmenter splits tokens consisting of Han, Kana
+// and Hangul characters into words of a word list, preferring
+// the longest matching word (forward maximum matching).
+// Tokens with other characters are kept, except for CJK punctuation,
+// which becomes single tokens.
+// Characters not covered by the word list are single tokens,
+// except for Katakana and Hangul, which are kept together.
+type MaxMatchSegmenter struct {
+	root *lexNode
+}
+
+// NewMaxMatchSegmenter creates a new segmenter with an empty word list.
+func NewMaxMatchSegmenter() *MaxMatchSegmenter {
+	return &MaxMatchSegmenter{root: &lexNode{}}
+}
+
+// LoadMaxMatchSegmenterFile reads a word list from a file.
+func LoadMaxMatchSegmenterFile(file string) *MaxMatchSegmenter {
+	f, err := os.Open(file)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	defer f.Close()
+
+	return ParseMaxMatchSegmenter(f)
+}
+
+// ParseMaxMatchSegmenter reads a word list with one word per line.
+// Further fields separated by whitespace (e.g. frequencies)
+// are ignored, as well as empty lines and lines starting with '#'.
+func ParseMaxMatchSegmenter(ior io.Reader) *MaxMatchSegmenter {
+	seg := NewMaxMatchSegmenter()
+	scanner := bufio.NewScanner(ior)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		seg.Add(fields[0])
+	}
+
+	if err := scanner.Err(); err != nil {
+		log.Println(err)
+		return nil
+	}
+	return seg
+}
+
+// Add adds a word to the word list.
+func (seg *MaxMatchSegmenter) Add(word string) {
+	node := seg.root
+	for _, r := range word {
+		if node.next == nil {
+			node.next = make(map[rune]*lexNode)
+		}
+		n, ok := node.next[r]
+		if !ok {
+			n = &lexNode{}
+			node.next[r] = n
+		}
+		node = n
+	}
+	node.final = true
+}
+
+// Segment splits a token consisting of CJK characters.
+func (seg *MaxMatchSegmenter) Segment(token []rune) []int {
+
+	// Tokens mixed with other scripts are kept
+	for _, r := range token {
+		if cjkClass(r) == cjkNone {
+			return nil
+		}
+	}
+
+	var parts []int
+	for i := 0; i < len(token); {
+		j := i + 1
+
+		if cjkClass(token[i]) == cjkPunct {
+			parts = append(parts, 1)
+		} else {
+			for j < len(token) && cjkClass(token[j]) != cjkPunct {
+				j++
+			}
+			parts = seg.match(parts, token[i:j])
+		}
+		i = j
+	}
+
+	if len(parts) < 2 {
+		return nil
+	}
+	return parts
+}
+
+// Split a run by forward maximum matching
+func (seg *MaxMatchSegmenter) match(parts []int, run []rune) []int {
+	unmatched := 0
+	for i := 0; i < len(run); {
+
+		// Find the longest word
+		l := 0
+		node := seg.root
+		for j := i; j < len(run) && node != nil; j++ {
+			node = node.next[run[j]]
+			if node != nil && node.final {
+				l = j - i + 1
+			}
+		}
+
+		if l > 0 {
+			unmatched = 0
+			parts = append(parts, l)
+			i += l
+			continue
+		}
+
+		// Keep unmatched Katakana and Hangul together
+		class := cjkClass(run[i])
+		if unmatched > 0 && (class == cjkKatakana || class == cjkHangul) && cjkClass(run[i-1]) == class {
+			parts[len(parts)-1]++
+		} else {
+			parts = append(parts, 1)
+		}
+		unmatched++
+		i++
+	}
+	return parts
+}
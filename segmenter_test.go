@@ -0,0 +1,86 @@
+package datok
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var segmenterWords = `# Chinese
+我们 1200
+中国人 300
+中国 900
+
+# Japanese
+日本語
+勉強
+します
+`
+
+func TestMaxMatchSegmenter(t *testing.T) {
+	assert := assert.New(t)
+
+	seg := ParseMaxMatchSegmenter(strings.NewReader(segmenterWords))
+	assert.NotNil(seg)
+
+	// Longest words are preferred
+	assert.Equal([]int{2, 1, 3, 1}, seg.Segment([]rune("我们是中国人。")))
+	assert.Equal([]int{2, 1}, seg.Segment([]rune("中国是")))
+
+	assert.Equal([]int{3, 1, 2, 3, 1}, seg.Segment([]rune("日本語を勉強します。")))
+
+	// Tokens mixed with other scripts are kept
+	assert.Nil(seg.Segment([]rune("„我们是中国人")))
+	assert.Nil(seg.Segment([]rune("Beijing中国人")))
+	assert.Nil(seg.Segment([]rune("日本語を勉強します。Ende")))
+
+	// Unmatched Katakana and Hangul are kept together
+	assert.Equal([]int{7, 1, 1}, seg.Segment([]rune("コンピューター日本")))
+	assert.Equal([]int{2, 3}, seg.Segment([]rune("我们한국어")))
+
+	// Other tokens are kept
+	assert.Nil(seg.Segment([]rune("Baum")))
+	assert.Nil(seg.Segment([]rune("中国")))
+	assert.Nil(seg.Segment([]rune("한국어")))
+}
+
+func TestSegmenterTokenWriter(t *testing.T) {
+	assert := assert.New(t)
+
+	if mat_de == nil {
+		mat_de = LoadMatrixFile("testdata/tokenizer_de.matok")
+	}
+
+	seg := ParseMaxMatchSegmenter(strings.NewReader(segmenterWords))
+
+	b := make([]byte, 0, 2048)
+	w := bytes.NewBuffer(b)
+
+	// Han runs are one token by identity
+	mat_de.TransduceTokenWriter(strings.NewReader("Er sagte „我们是中国人。“"), NewTokenWriter(w, TOKENS|TOKEN_POS))
+	assert.Equal("Er\nsagte\n„我们是中国人。\n“\n0 2 3 8 9 17 17 18\n", w.String())
+
+	w.Reset()
+	tw := NewSegmenterTokenWriter(NewTokenWriter(w, TOKENS|TOKEN_POS), seg)
+	mat_de.TransduceTokenWriter(strings.NewReader("Er sagte: 我们是中国人。"), tw)
+	assert.Equal("Er\nsagte\n:\n我们\n是\n中国人\n。\n0 2 3 8 8 9 10 12 12 13 13 16 16 17\n", w.String())
+
+	// Tokens mixed with other scripts are kept
+	w.Reset()
+	tw = NewSegmenterTokenWriter(NewTokenWriter(w, TOKENS|TOKEN_POS), seg)
+	mat_de.TransduceTokenWriter(strings.NewReader("Er sagte „我们是中国人。“"), tw)
+	assert.Equal("Er\nsagte\n„我们是中国人。\n“\n0 2 3 8 9 17 17 18\n", w.String())
+
+	// Custom segmenters
+	w.Reset()
+	tw = NewSegmenterTokenWriter(NewTokenWriter(w, SIMPLE), SegmenterFunc(func(token []rune) []int {
+		if string(token) == "Baumhaus" {
+			return []int{4, 4}
+		}
+		return nil
+	}))
+	mat_de.TransduceTokenWriter(strings.NewReader("Das Baumhaus."), tw)
+	assert.Equal("Das\nBaum\nhaus\n.\n\n\n", w.String())
+}
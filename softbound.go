@@ -0,0 +1,212 @@
+package datok
+
+import (
+	"io"
+	"log"
+	"strings"
+)
+
+// Reserved multi-character symbols for soft token bounds,
+// e.g. @_SOFT_BOUND_GENDER_@ for the category "gender".
+// The unnamed @_SOFT_BOUND_@ has the category "default".
+const (
+	softBoundPrefix  = "@_SOFT_BOUND_"
+	softBoundSuffix  = "_@"
+	softBoundDefault = "default"
+)
+
+// A soft bound is a symbol of an optional token boundary.
+// Inactive soft bounds are passed silently, active soft
+// bounds end the current token like a token bound.
+// The soft bounds are activated per transduction
+// by their categories.
+type softBound struct {
+	sym  int
+	name string
+}
+
+// Soft bounds of a tokenizer
+type softBounds []softBound
+
+// Get the category of a reserved multi-character symbol
+// for soft token bounds
+func softBoundOf(name string) (string, bool) {
+	if name == "@_SOFT_BOUND_@" {
+		return softBoundDefault, true
+	}
+	if !strings.HasPrefix(name, softBoundPrefix) ||
+		!strings.HasSuffix(name, softBoundSuffix) ||
+		len(name) <= len(softBoundPrefix)+len(softBoundSuffix) {
+		return "", false
+	}
+	return strings.ToLower(name[len(softBoundPrefix) : len(name)-len(softBoundSuffix)]), true
+}
+
+// Check if a symbol is a soft bound symbol
+func (sbs softBounds) has(a int) bool {
+	for _, sb := range sbs {
+		if sb.sym == a {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the category of a soft bound symbol
+func (sbs softBounds) name(a int) string {
+	for _, sb := range sbs {
+		if sb.sym == a {
+			return sb.name
+		}
+	}
+	return ""
+}
+
+// Get the categories of the soft bounds
+func (sbs softBounds) names() []string {
+	names := make([]string, len(sbs))
+	for i, sb := range sbs {
+		names[i] = sb.name
+	}
+	return names
+}
+
+// Get the soft bounds of the categories that end tokens.
+// All other soft bounds are passed silently.
+func (sbs softBounds) activate(names []string) ([]bool, bool) {
+	active := make([]bool, len(sbs))
+	for _, name := range names {
+		if !sbs.known(strings.ToLower(name)) {
+			log.Println("Unknown soft bound category:", name)
+			return nil, false
+		}
+		for i := range sbs {
+			if strings.ToLower(name) == sbs[i].name {
+				active[i] = true
+			}
+		}
+	}
+	return active, true
+}
+
+// Check if a soft bound category is defined
+func (sbs softBounds) known(name string) bool {
+	for _, sb := range sbs {
+		if sb.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Write the soft bounds as part of the header
+// of a tokenizer file
+func (sbs softBounds) writeTo(w io.Writer) (int, error) {
+	buf := make([]byte, 4)
+	bo.PutUint16(buf[0:2], uint16(len(sbs)))
+	all, err := w.Write(buf[0:2])
+	if err != nil {
+		return all, err
+	}
+
+	for _, sb := range sbs {
+		bo.PutUint16(buf[0:2], uint16(sb.sym))
+		bo.PutUint16(buf[2:4], uint16(len(sb.name)))
+		more, err := w.Write(buf[0:4])
+		all += more
+		if err != nil {
+			return all, err
+		}
+		more, err = io.WriteString(w, sb.name)
+		all += more
+		if err != nil {
+			return all, err
+		}
+	}
+	return all, nil
+}
+
+// Read the soft bounds from the header
+// of a tokenizer file
+func (sbs *softBounds) readFrom(r io.Reader) error {
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(r, buf[0:2]); err != nil {
+		return err
+	}
+
+	n := int(bo.Uint16(buf[0:2]))
+	if n == 0 {
+		*sbs = nil
+		return nil
+	}
+
+	*sbs = make(softBounds, n)
+	for i := 0; i < n; i++ {
+		if _, err := io.ReadFull(r, buf[0:4]); err != nil {
+			return err
+		}
+		name := make([]byte, bo.Uint16(buf[2:4]))
+		if _, err := io.ReadFull(r, name); err != nil {
+			return err
+		}
+		(*sbs)[i] = softBound{
+			sym:  int(bo.Uint16(buf[0:2])),
+			name: string(name),
+		}
+	}
+	return nil
+}
+
+// Check if two lists of soft bounds define the
+// same symbols and categories
+func (sbs softBounds) equal(other softBounds) bool {
+	if len(sbs) != len(other) {
+		return false
+	}
+	for i, sb := range sbs {
+		if sb.sym != other[i].sym || sb.name != other[i].name {
+			return false
+		}
+	}
+	return true
+}
+
+// SoftBounds returns the categories of the soft bounds
+// defined in the tokenizer.
+func (mat *MatrixTokenizer) SoftBounds() []string {
+	return mat.softBounds.names()
+}
+
+// SoftBounds returns the categories of the soft bounds
+// defined in the tokenizer.
+func (dat *DaTokenizer) SoftBounds() []string {
+	return dat.softBounds.names()
+}
+
+// Pass the token in the buffer between start and end to the
+// token writer, split at the positions of passed active soft bounds.
+// The outputs of the characters are only given for normalized tokens.
+func splitToken(w *TokenWriter, buffer []rune, start, end int, splits []int, outs [][]rune, norm []rune) []rune {
+
+	// Each token is passed with the characters following
+	// the previous token, like in a rewound buffer
+	from := 0
+	for i := 0; i <= len(splits); i++ {
+		to := end
+		if i < len(splits) && splits[i] < end {
+			to = splits[i]
+		}
+		if to <= start {
+			continue
+		}
+		if outs != nil {
+			norm = normalize(norm, buffer[start:to], outs[start:to])
+			w.NormalizedToken(start-from, buffer[from:to], norm)
+		} else {
+			w.Token(start-from, buffer[from:to])
+		}
+		from = to
+		start = to
+	}
+	return norm
+}
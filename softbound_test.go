@@ -0,0 +1,103 @@
+package datok
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var softBoundStr = "Lehrer:innen lesen E-Mails."
+
+// Tokenize a string, splitting tokens at the selected soft bounds
+func tsoftTokenize(tok Tokenizer, str string, names ...string) string {
+	w := &bytes.Buffer{}
+	tw := NewTokenWriter(w, SIMPLE)
+	tw.SoftBounds = names
+	if !tok.TransduceTokenWriter(strings.NewReader(str), tw) {
+		return ""
+	}
+	return strings.TrimRight(regexp.MustCompile("\n+").ReplaceAllString(w.String(), "\n"), "\n")
+}
+
+func TestSoftBounds(t *testing.T) {
+	assert := assert.New(t)
+
+	auto := LoadFomaFile("testdata/softbounds.fst")
+	assert.NotNil(auto)
+
+	mat := auto.ToMatrix()
+	dat := auto.ToDoubleArray()
+	dat64 := auto.toDoubleArray(nil, true)
+
+	assert.Equal([]string{"gender", "hyphen"}, mat.SoftBounds())
+	assert.Equal([]string{"gender", "hyphen"}, dat.SoftBounds())
+
+	for _, tok := range []Tokenizer{mat, dat, dat64} {
+
+		// Soft bounds are passed silently by default
+		assert.Equal("Lehrer:innen\nlesen\nE-Mails\n.", ttokenizeStr(tok, softBoundStr))
+		assert.Equal("Lehrer\n:\ninn", ttokenizeStr(tok, "Lehrer:inn"))
+
+		assert.Equal("Lehrer\n:innen\nlesen\nE-Mails\n.", tsoftTokenize(tok, softBoundStr, "gender"))
+
+		// Soft bounds only split tokens, that are completed
+		assert.Equal("Lehrer\n:\ninn", tsoftTokenize(tok, "Lehrer:inn", "gender"))
+
+		assert.Equal("Lehrer:innen\nlesen\nE\n-\nMails\n.", tsoftTokenize(tok, softBoundStr, "HYPHEN"))
+		assert.Equal("E\n-\nM\n-\nails", tsoftTokenize(tok, "E-M-ails", "hyphen"))
+
+		assert.Equal("Lehrer\n:innen\nlesen\nE\n-\nMails\n.", tsoftTokenize(tok, softBoundStr, "gender", "hyphen"))
+
+		// The selection is not kept by the tokenizer
+		assert.Equal("Lehrer:innen\nlesen\nE-Mails\n.", ttokenizeStr(tok, softBoundStr))
+
+		// Offsets refer to the split tokens
+		b := make([]byte, 0, 2048)
+		w := bytes.NewBuffer(b)
+		tw := NewTokenWriter(w, TOKEN_POS)
+		tw.SoftBounds = []string{"gender", "hyphen"}
+		tok.TransduceTokenWriter(strings.NewReader(softBoundStr), tw)
+		assert.Equal("0 6 6 12 13 18 19 20 20 21 21 26 26 27\n", w.String())
+
+		// Split tokens keep their normalized forms
+		w.Reset()
+		tw = NewTokenWriter(w, TOKENS|NORMALIZED)
+		tw.SoftBounds = []string{"gender"}
+		tok.TransduceTokenWriter(strings.NewReader("Lehrer:innen"), tw)
+		assert.Equal("Lehrer\tLehrer\n:innen\t:innen\n\n", w.String())
+
+		// Unknown categories are rejected
+		w.Reset()
+		tw = NewTokenWriter(w, SIMPLE)
+		tw.SoftBounds = []string{"clitic"}
+		assert.False(tok.TransduceTokenWriter(strings.NewReader(softBoundStr), tw))
+		assert.Equal("", w.String())
+	}
+}
+
+func TestSoftBoundsReadWrite(t *testing.T) {
+	assert := assert.New(t)
+
+	split := func(tok Tokenizer) string {
+		return tsoftTokenize(tok, softBoundStr, "gender")
+	}
+
+	auto, mat, rep := treadWrite(t, "testdata/softbounds.fst", split, "Lehrer\n:innen\nlesen\nE-Mails\n.")
+	assert.Len(mat.softBounds, 2)
+
+	// Soft bounds are no unsupported transitions
+	assert.Equal(0, rep.Warnings())
+
+	// Decompiled tokenizers keep their soft bounds
+	assert.Equal("Lehrer:innen\nlesen\nE-Mails\n.", ttokenizeStr(mat.Automaton().ToDoubleArray(), softBoundStr))
+
+	// Soft bounds are compared
+	other := LoadFomaFile("testdata/softbounds.fst")
+	other.softBounds[0].name = "clitic"
+	ok, ex := Equivalent(auto, other)
+	assert.False(ok)
+	assert.Equal("E", ex)
+}
@@ -6,7 +6,7 @@ import (
 	"strconv"
 )
 
-type Bits uint8
+type Bits uint16
 
 // TODO-Perf:
 // - TokenWriter may support AvailableBuffer(), so tokens can be written
@@ -20,6 +20,9 @@ const (
 	TOKEN_POS
 	SENTENCE_POS
 	NEWLINE_AFTER_EOT
+	NORMALIZED
+	CONLLU
+	LOSSLESS
 
 	SIMPLE = TOKENS | SENTENCES
 )
@@ -30,12 +33,57 @@ type TokenWriter struct {
 	Flush       func() error
 	Token       func(int, []rune)
 	// Fail        func(int)
+
+	// Receives tokens with their normalized form
+	// instead of Token, if set
+	NormalizedToken func(int, []rune, []rune)
+
+	// Receives multi-word tokens with their
+	// words instead of Token, if set
+	MultiWordToken func(int, []rune, [][]rune)
+
+	// Receives spans of skipped characters not passed
+	// as part of a token buffer, with their kind,
+	// in lossless mode, if set
+	Skip func(SkipKind, []rune)
+
+	// Categories of soft bounds that end tokens.
+	// All other soft bounds are passed silently
+	SoftBounds []string
+
+	// Counts the usage of the automaton
+	// for coverage reports, if set
+	Coverage *Coverage
+
+	// Never end tokens inside of extended
+	// grapheme clusters (UAX #29)
+	Graphemes bool
+}
+
+// Offsets maps positions of characters in a text
+// to offsets in the input, e.g. in bytes of the input encoding
+type Offsets interface {
+
+	// Offset of the character at the position in the
+	// current text (or of the end of the text)
+	Offset(pos int) int
+
+	// Continue with the next text
+	NextText()
 }
 
 // Create a new token writer based on the options
 func NewTokenWriter(w io.Writer, flags Bits) *TokenWriter {
+	return NewTokenWriterOffsets(w, flags, nil)
+}
+
+// Create a new token writer based on the options,
+// with positions written as offsets in the input
+// (e.g. in bytes of the input encoding)
+func NewTokenWriterOffsets(w io.Writer, flags Bits, offsets Offsets) *TokenWriter {
 	writer := bufio.NewWriter(w)
 	posC := 0
+	textStart := 0
 	pos := make([]int, 0, 1024)
 	sentB := true
 	sent := make([]int, 0, 1024)
@@ -43,6 +91,42 @@ func NewTokenWriter(w io.Writer, flags Bits) *TokenWriter {
 
 	tw := &TokenWriter{}
 
+	// The normalized form of the current token, if given
+	var norm []rune
+
+	// The characters following the current token in lossless mode
+	var after []rune
+
+	// Write a position relative to the start of the text
+	writePos := func(x int) {
+		if offsets != nil {
+			x = offsets.Offset(x)
+			if textStart > 0 {
+				x -= offsets.Offset(textStart)
+			}
+		} else {
+			x -= textStart
+		}
+		writer.WriteString(strconv.Itoa(x))
+	}
+
+	// Write a token and maybe its normalized form
+	writeToken := func(token []rune) {
+		writer.WriteString(string(token))
+		if flags&NORMALIZED != 0 {
+			writer.WriteByte('\t')
+			if norm != nil {
+				token = norm
+			}
+			writer.WriteString(string(token))
+		}
+		if flags&LOSSLESS != 0 {
+			writer.WriteByte('\t')
+			writer.WriteString(escapeSkipped(after))
+		}
+		writer.WriteByte('\n')
+	}
+
 	// tw.Fail = func(_ int) {}
 
 	// Collect token positions and maybe tokens
@@ -64,7 +148,7 @@ func NewTokenWriter(w io.Writer, flags Bits) *TokenWriter {
 
 			// Accept newline after EOT
 			if posC == 0 && flags&NEWLINE_AFTER_EOT != 0 && buf[0] == '\n' && !init {
-				posC--
+				textStart = 1
 			}
 
 			init = false
@@ -82,16 +166,14 @@ func NewTokenWriter(w io.Writer, flags Bits) *TokenWriter {
 
 			// Collect tokens also
 			if flags&TOKENS != 0 {
-				writer.WriteString(string(buf[offset:]))
-				writer.WriteByte('\n')
+				writeToken(buf[offset:])
 			}
 		}
 
 		// Collect tokens
 	} else if flags&TOKENS != 0 {
 		tw.Token = func(offset int, buf []rune) {
-			writer.WriteString(string(buf[offset:]))
-			writer.WriteByte('\n')
+			writeToken(buf[offset:])
 		}
 
 		// Ignore tokens
@@ -132,20 +214,20 @@ func NewTokenWriter(w io.Writer, flags Bits) *TokenWriter {
 
 			// Write token positions
 			if flags&TOKEN_POS != 0 {
-				writer.WriteString(strconv.Itoa(pos[0]))
+				writePos(pos[0])
 				for _, x := range pos[1:] {
 					writer.WriteByte(' ')
-					writer.WriteString(strconv.Itoa(x))
+					writePos(x)
 				}
 				writer.WriteByte('\n')
 			}
 
 			// Write sentence positions
 			if flags&SENTENCE_POS != 0 {
-				writer.WriteString(strconv.Itoa(sent[0]))
+				writePos(sent[0])
 				for _, x := range sent[1:] {
 					writer.WriteByte(' ')
-					writer.WriteString(strconv.Itoa(x))
+					writePos(x)
 				}
 				writer.WriteByte('\n')
 				sent = sent[:0]
@@ -154,7 +236,11 @@ func NewTokenWriter(w io.Writer, flags Bits) *TokenWriter {
 
 			writer.Flush()
 
+			if offsets != nil {
+				offsets.NextText()
+			}
 			posC = 0
+			textStart = 0
 			pos = pos[:0]
 		}
 
@@ -171,5 +257,30 @@ func NewTokenWriter(w io.Writer, flags Bits) *TokenWriter {
 		return writer.Flush()
 	}
 
+	// Write tokens in the CoNLL-U format
+	if flags&CONLLU != 0 {
+		tw = conlluTokenWriter(writer, flags&LOSSLESS != 0)
+
+		// Write normalized forms next to the tokens
+	} else if flags&NORMALIZED != 0 {
+		token := tw.Token
+		tw.NormalizedToken = func(offset int, buf []rune, normalized []rune) {
+			norm = normalized
+			token(offset, buf)
+			norm = nil
+		}
+	}
+
+	// Write the characters following each token,
+	// and the characters preceding the first token
+	// of a text in a separate line
+	if flags&LOSSLESS != 0 && flags&TOKENS != 0 && flags&CONLLU == 0 {
+		tw = losslessTokenWriter(tw, &after, func(buf []rune) {
+			writer.WriteByte('\t')
+			writer.WriteString(escapeSkipped(buf))
+			writer.WriteByte('\n')
+		})
+	}
+
 	return tw
 }
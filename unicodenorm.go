@@ -0,0 +1,89 @@
+package datok
+
+import (
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Maximum number of characters normalized together,
+// following the stream-safe text format
+const maxNormBuffer = 30
+
+// A character with its span in the original input
+type normChar struct {
+	char  rune
+	start int
+	end   int
+}
+
+// Filter normalizing characters to NFC or NFKC.
+// The characters are normalized in segments starting
+// at normalization boundaries. Characters changed by
+// the normalization get the span of their segment.
+type normFilter struct {
+	form norm.Form
+	buf  []normChar
+	seg  []byte
+}
+
+// NewNFCFilter creates a filter normalizing the input
+// to the Unicode normalization form NFC, so e.g. decomposed
+// umlauts are composed.
+func NewNFCFilter() Filter {
+	return &normFilter{form: norm.NFC}
+}
+
+// NewNFKCFilter creates a filter normalizing the input
+// to the Unicode normalization form NFKC, so additionally
+// e.g. ligatures and fullwidth forms are replaced.
+func NewNFKCFilter() Filter {
+	return &normFilter{form: norm.NFKC}
+}
+
+// Char implements the Filter interface.
+func (nf *normFilter) Char(char rune, start, end int, emit Emit) {
+	var b [utf8.UTFMax]byte
+	n := utf8.EncodeRune(b[:], char)
+
+	// The character starts a new segment
+	if nf.form.Properties(b[:n]).BoundaryBefore() || len(nf.buf) >= maxNormBuffer {
+		nf.Flush(emit)
+	}
+	nf.buf = append(nf.buf, normChar{char, start, end})
+}
+
+// Flush implements the Filter interface.
+func (nf *normFilter) Flush(emit Emit) {
+	if len(nf.buf) == 0 {
+		return
+	}
+
+	nf.seg = nf.seg[:0]
+	for _, c := range nf.buf {
+		nf.seg = utf8.AppendRune(nf.seg, c.char)
+	}
+
+	// Characters already normalized keep their spans
+	if nf.form.IsNormal(nf.seg) {
+		for _, c := range nf.buf {
+			emit(c.char, c.start, c.end)
+		}
+		nf.buf = nf.buf[:0]
+		return
+	}
+
+	start, end := nf.buf[0].start, nf.buf[0].end
+	for _, c := range nf.buf[1:] {
+		if c.start < start {
+			start = c.start
+		}
+		if c.end > end {
+			end = c.end
+		}
+	}
+	for _, char := range string(nf.form.Bytes(nf.seg)) {
+		emit(char, start, end)
+	}
+	nf.buf = nf.buf[:0]
+}
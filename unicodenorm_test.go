@@ -0,0 +1,108 @@
+package datok
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormFilters(t *testing.T) {
+	assert := assert.New(t)
+
+	// Composition
+	assert.Equal("Mädchen über", tfilter("Mädchen über", NewNFCFilter()))
+	assert.Equal("Mädchen über", tfilter("Mädchen über", NewNFCFilter()))
+	assert.Equal("ṩ", tfilter("ṩ", NewNFCFilter()))
+	assert.Equal("ḍ̇", tfilter("ḍ̇", NewNFCFilter()))
+
+	// Blocked composition
+	assert.Equal("á́", tfilter("á́", NewNFCFilter()))
+
+	// Hangul
+	assert.Equal("한", tfilter("한", NewNFCFilter()))
+	assert.Equal("한", tfilter("한", NewNFCFilter()))
+
+	// Compatibility forms are only replaced in NFKC
+	assert.Equal("ﬁx²", tfilter("ﬁx²", NewNFCFilter()))
+	assert.Equal("fix2", tfilter("ﬁx²", NewNFKCFilter()))
+	assert.Equal("ABC", tfilter("ＡＢＣ", NewNFKCFilter()))
+
+	// Combining characters at the beginning
+	assert.Equal("̈a", tfilter("̈a", NewNFCFilter()))
+
+	// Long sequences of combining characters
+	str := "a" + strings.Repeat("́", 100)
+	assert.Equal("á"+strings.Repeat("́", 99), tfilter(str, NewNFCFilter()))
+}
+
+// Get the normalized forms of the tokens
+func normColumn(str string) string {
+	lines := strings.Split(str, "\n")
+	for i, line := range lines {
+		if j := strings.IndexByte(line, '\t'); j >= 0 {
+			lines[i] = line[j+1:]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestNormalization(t *testing.T) {
+	assert := assert.New(t)
+
+	mat := LoadMatrixFile("testdata/tokenizer_de.matok")
+	dat := LoadDatokFile("testdata/tokenizer_de.datok")
+	assert.NotNil(mat)
+	assert.NotNil(dat)
+
+	pp := NewPreprocessor(NewNFCFilter())
+
+	b := make([]byte, 0, 2048)
+	w := bytes.NewBuffer(b)
+
+	composed := "Der Präs. fährt über die Straße."
+	decomposed := "Der Präs. fährt über die Straße."
+
+	for _, tok := range []Tokenizer{mat, dat} {
+
+		// Without normalization, decomposed abbreviations are not recognized
+		w.Reset()
+		assert.True(tok.TransduceTokenWriter(strings.NewReader(decomposed), NewTokenWriter(w, TOKENS)))
+		assert.Equal("Der\nPräs\n.\nfährt\nüber\ndie\nStraße\n.\n\n", w.String())
+
+		// Composed and decomposed input is tokenized identically
+		w.Reset()
+		assert.True(tok.TransduceTokenWriter(strings.NewReader(composed), NewTokenWriter(w, TOKENS|SENTENCES)))
+		expected := w.String()
+		assert.Equal("Der\nPräs.\nfährt\nüber\ndie\nStraße\n.\n\n\n", expected)
+
+		w.Reset()
+		assert.True(pp.TransduceTokenWriter(tok, strings.NewReader(composed), NewTokenWriter(w, TOKENS|SENTENCES)))
+		assert.Equal(expected, w.String())
+
+		w.Reset()
+		assert.True(pp.TransduceTokenWriter(tok, strings.NewReader(decomposed), NewTokenWriter(w, TOKENS|NORMALIZED|SENTENCES)))
+		assert.Equal(expected, normColumn(w.String()))
+
+		// Tokens are passed with the original characters
+		w.Reset()
+		assert.True(pp.TransduceTokenWriter(tok, strings.NewReader(decomposed), NewTokenWriter(w, TOKENS|NORMALIZED)))
+		assert.Equal(
+			"Der\tDer\nPräs.\tPräs.\nfährt\tfährt\n"+
+				"über\tüber\ndie\tdie\nStraße\tStraße\n.\t.\n\n",
+			w.String(),
+		)
+
+		// Offsets refer to the original input
+		w.Reset()
+		assert.True(pp.TransduceTokenWriter(tok, strings.NewReader(decomposed), NewTokenWriter(w, TOKEN_POS|SENTENCE_POS)))
+		assert.Equal("0 3 4 10 11 17 18 23 24 27 28 34 34 35\n0 35\n", w.String())
+
+		// The original input can be reconstructed in lossless mode
+		for _, str := range append(losslessStr, decomposed) {
+			rec, _ := tlosslessEvents(&ppTokenizer{pp, tok}, str)
+			assert.Equal(str, rec)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package datok
+
+import (
+	"io"
+	"log"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Number and maximum length of random strings
+// used for verification
+const (
+	verifyStrings = 1000
+	verifyLength  = 80
+)
+
+// VerifyTokenizerFile checks a tokenizer file converted from an
+// automaton before it gets deployed. The file is reloaded and compared
+// structurally with the tokenizer in memory and checked for equivalence
+// with the automaton (see Equivalent). Random strings and an optional
+// corpus are run through the automaton and the reloaded tokenizer,
+// comparing all transitions the tokenizer may use for them with
+// the transitions of the automaton.
+// Mismatches are logged and false is returned.
+func VerifyTokenizerFile(file string, auto *Automaton, tok Tokenizer, corpus io.Reader) bool {
+	loaded := LoadTokenizerFile(file)
+	if loaded == nil {
+		log.Println("Unable to reload", file)
+		return false
+	}
+
+	// Check the serialization
+	switch t := tok.(type) {
+	case *MatrixTokenizer:
+		if mat, ok := loaded.(*MatrixTokenizer); !ok || !t.equal(mat) {
+			log.Println("Reloaded matrix differs from the converted matrix")
+			return false
+		}
+	case *DaTokenizer:
+		if dat, ok := loaded.(*DaTokenizer); !ok || !t.equal(dat) {
+			log.Println("Reloaded double array differs from the converted double array")
+			return false
+		}
+	default:
+		log.Println("Unknown tokenizer type")
+		return false
+	}
+
+	// Check the conversion
+	conv := loaded.(Automatable).Automaton()
+	if ok, ex := Equivalent(auto, conv); !ok {
+		log.Println("Tokenizer differs from the automaton for", strconv.Quote(ex))
+		return false
+	}
+
+	// Check the transitions used for strings
+	a, b := newEquivSide(auto), newEquivSide(conv)
+	softNames := auto.softBounds.names()
+	for _, name := range conv.softBounds.names() {
+		if !auto.softBounds.known(name) {
+			softNames = append(softNames, name)
+		}
+	}
+	valid := true
+	compare := func(str string) {
+		if !verifyTransitions(a, b, softNames, str) {
+			log.Println("Transitions for", strconv.Quote(str), "differ from the automaton")
+			valid = false
+		}
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	chars := auto.chars()
+	for i := 0; i < verifyStrings && valid; i++ {
+		compare(auto.randomString(rnd, chars, verifyLength))
+	}
+
+	if corpus != nil && valid {
+		text, err := io.ReadAll(corpus)
+		if err != nil {
+			log.Println(err)
+			return false
+		}
+		for _, line := range strings.Split(string(text), "\n") {
+			compare(line)
+		}
+		compare(string(text))
+	}
+	return valid
+}
+
+// Compare the transitions of the tokenizer, that may be used
+// for a string, with the transitions of the automaton, in the
+// product construction of both restricted to the string.
+// As the tokenizer restarts at the initial state after tokens,
+// the product is restarted at every position.
+func verifyTransitions(a, b *equivSide, softNames []string, str string) bool {
+	start := equivPair{1, 1}
+	pairs := []equivPair{start}
+	seen := map[equivPair]bool{start: true}
+	chars := []rune(str)
+	for i := 0; ; i++ {
+
+		// Follow token bounds and soft bounds
+		for j := 0; j < len(pairs); j++ {
+			p := pairs[j]
+			bounds := [][2]*edge{{a.bound(p.a), b.bound(p.b)}}
+			for _, name := range softNames {
+				bounds = append(bounds, [2]*edge{a.softBound(p.a, name), b.softBound(p.b, name)})
+			}
+			for _, bound := range bounds {
+				next, div := equivDiverge(bound[0], bound[1])
+				if div {
+					return false
+				}
+				if next.a != 0 && !seen[next] {
+					seen[next] = true
+					pairs = append(pairs, next)
+				}
+			}
+		}
+
+		if i == len(chars) {
+			break
+		}
+
+		next := []equivPair{start}
+		seen = map[equivPair]bool{start: true}
+		for _, p := range pairs {
+			n, div := equivDiverge(a.arc(p.a, chars[i]), b.arc(p.b, chars[i]))
+			if div {
+				return false
+			}
+			if n.a != 0 && !seen[n] {
+				seen[n] = true
+				next = append(next, n)
+			}
+		}
+		pairs = next
+	}
+	return true
+}
+
+// All characters in sigma and some characters not in sigma,
+// including one character per category symbol.
+// The end of text character is excluded, as it separates texts.
+func (auto *Automaton) chars() []rune {
+	sigma := make(map[rune]int, len(auto.sigmaRev))
+	chars := make([]rune, 0, len(auto.sigmaRev)+catCount+2)
+	for num, char := range auto.sigmaRev {
+		sigma[char] = num
+		if char != EOT {
+			chars = append(chars, char)
+		}
+	}
+
+	// Sort for reproducible random strings
+	sort.Slice(chars, func(i, j int) bool { return chars[i] < chars[j] })
+
+	for cat := catLetter; cat < catCount; cat++ {
+		if auto.categories[cat] != 0 {
+			chars = append(chars, unusedCategoryChar(cat, sigma))
+		}
+	}
+	other := unusedChar(sigma)
+	sigma[other] = 0
+	return append(chars, other, unusedChar(sigma))
+}
+
+// Generate a random string by walking the automaton,
+// with random characters interspersed
+func (auto *Automaton) randomString(rnd *rand.Rand, chars []rune, max int) string {
+	var sb strings.Builder
+	s := 1
+	for n := 0; n < max; {
+		var arcs []*edge
+		for a, e := range auto.transitions[s] {
+			if a != auto.final {
+				arcs = append(arcs, e)
+			}
+		}
+		if len(arcs) == 0 || rnd.Intn(10) == 0 {
+			sb.WriteRune(chars[rnd.Intn(len(chars))])
+			n++
+			s = 1
+			continue
+		}
+
+		// Map iteration order is random
+		sort.Slice(arcs, func(i, j int) bool { return arcs[i].inSym < arcs[j].inSym })
+		e := arcs[rnd.Intn(len(arcs))]
+
+		switch e.inSym {
+		case auto.epsilon:
+		case auto.identity, auto.unknown:
+			sb.WriteRune(chars[len(chars)-1-rnd.Intn(2)])
+			n++
+		default:
+			if auto.softBounds.has(e.inSym) {
+				break
+			}
+			if cat := auto.categories.of(e.inSym); cat != catNone {
+				sb.WriteRune(categoryChar(chars, cat))
+				n++
+				break
+			}
+			char, ok := auto.sigmaRev[e.inSym]
+			if !ok || char == EOT {
+				s = 1
+				continue
+			}
+			sb.WriteRune(char)
+			n++
+		}
+		s = e.end
+	}
+	return sb.String()
+}
+
+// Get the last character of a category in the list of characters,
+// being the character not in sigma added for the category symbol
+func categoryChar(chars []rune, cat int) rune {
+	for i := len(chars) - 1; i >= 0; i-- {
+		if category(chars[i]) == cat {
+			return chars[i]
+		}
+	}
+	return chars[len(chars)-1]
+}
+
+// Structural equality of two matrix tokenizers
+func (mat *MatrixTokenizer) equal(other *MatrixTokenizer) bool {
+	if mat.stateCount != other.stateCount ||
+		mat.layout != other.layout ||
+		mat.epsilon != other.epsilon ||
+		mat.unknown != other.unknown ||
+		mat.identity != other.identity ||
+		mat.categories != other.categories ||
+		mat.sigmaASCII != other.sigmaASCII ||
+		!equalSigma(mat.sigma, other.sigma) ||
+		!mat.rewrites.equal(other.rewrites) ||
+		!mat.softBounds.equal(other.softBounds) ||
+		len(mat.array) != len(other.array) {
+		return false
+	}
+	for i, v := range mat.array {
+		if other.array[i] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Structural equality of two double array tokenizers
+func (dat *DaTokenizer) equal(other *DaTokenizer) bool {
+	if dat.epsilon != other.epsilon ||
+		dat.unknown != other.unknown ||
+		dat.identity != other.identity ||
+		dat.final != other.final ||
+		dat.categories != other.categories ||
+		dat.sigmaASCII != other.sigmaASCII ||
+		!equalSigma(dat.sigma, other.sigma) ||
+		!dat.rewrites.equal(other.rewrites) ||
+		!dat.softBounds.equal(other.softBounds) ||
+		len(dat.array) != len(other.array) ||
+		len(dat.array64) != len(other.array64) {
+		return false
+	}
+	for i, v := range dat.array {
+		if other.array[i] != v {
+			return false
+		}
+	}
+	for i, v := range dat.array64 {
+		if other.array64[i] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func equalSigma(a, b map[rune]int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for char, num := range a {
+		if b[char] != num {
+			return false
+		}
+	}
+	return true
+}
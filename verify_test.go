@@ -0,0 +1,158 @@
+package datok
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Write and parse the tokenizers of a foma file in the extended
+// format, checking that they are kept and tokenize the input as
+// expected, that decompiled tokenizers are equivalent and that
+// the foma file has no lint errors.
+// Returns the automaton, the parsed matrix tokenizer and the lint report.
+func treadWrite(t *testing.T, file string, tokenize func(Tokenizer) string, expected string) (*Automaton, *MatrixTokenizer, *LintReport) {
+	assert := assert.New(t)
+
+	auto := LoadFomaFile(file)
+	assert.NotNil(auto)
+
+	mat := auto.ToMatrix()
+	buf := &bytes.Buffer{}
+	_, err := mat.WriteTo(buf)
+	assert.Nil(err)
+	assert.Equal(MAEXTVERSION, bo.Uint16(buf.Bytes()[len(MAMAGIC):]))
+	mat2 := ParseMatrix(buf)
+	assert.NotNil(mat2)
+	assert.True(mat.equal(mat2))
+	assert.Equal(expected, tokenize(mat2))
+
+	for _, dat := range []*DaTokenizer{auto.ToDoubleArray(), auto.toDoubleArray(nil, true)} {
+		buf.Reset()
+		_, err = dat.WriteTo(buf)
+		assert.Nil(err)
+		assert.Equal(EXTVERSION, bo.Uint16(buf.Bytes()[len(DAMAGIC):]))
+		dat2 := ParseDatok(buf)
+		assert.NotNil(dat2)
+		assert.True(dat.equal(dat2))
+		assert.Equal(expected, tokenize(dat2))
+	}
+
+	// Decompiled tokenizers are equivalent
+	ok, ex := Equivalent(auto, mat2.Automaton().ToDoubleArray())
+	assert.True(ok)
+	assert.Equal("", ex)
+
+	rep := LintFomaFile(file)
+	assert.NotNil(rep)
+	assert.Equal(0, rep.Errors())
+
+	return auto, mat2, rep
+}
+
+func TestVerifyTokenizerFile(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	auto := LoadFomaFile("testdata/simpletok.fst")
+	corpus := "Der Vorsitzende der Abk. hat gewählt!\nEr sagt: \"Ja?\""
+
+	mat := auto.ToMatrix()
+	_, err := mat.Save(dir + "/simpletok.matok")
+	assert.Nil(err)
+	assert.True(VerifyTokenizerFile(dir+"/simpletok.matok", auto, mat, strings.NewReader(corpus)))
+
+	dat := auto.ToDoubleArray()
+	_, err = dat.Save(dir + "/simpletok.datok")
+	assert.Nil(err)
+	assert.True(VerifyTokenizerFile(dir+"/simpletok.datok", auto, dat, nil))
+
+	// Wrong representation
+	assert.False(VerifyTokenizerFile(dir+"/simpletok.datok", auto, mat, nil))
+
+	// Different automaton
+	other := LoadFomaFile("testdata/bauamt.fst")
+	assert.False(VerifyTokenizerFile(dir+"/simpletok.matok", other, other.ToMatrix(), nil))
+
+	// Broken file
+	assert.Nil(os.WriteFile(dir+"/broken.matok", []byte("MATOK"), 0644))
+	assert.False(VerifyTokenizerFile(dir+"/broken.matok", auto, mat, nil))
+}
+
+func TestVerifyConversionMismatch(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	auto := LoadFomaFile("testdata/simpletok.fst")
+	mat := auto.ToMatrix()
+	_, err := mat.Save(dir + "/simpletok.matok")
+	assert.Nil(err)
+
+	// Drop a transition in the automaton, the file
+	// is consistent with the matrix but not with the source
+	broken := mat.Automaton()
+	delete(broken.transitions[1], broken.identity)
+	assert.False(VerifyTokenizerFile(dir+"/simpletok.matok", broken, mat, nil))
+
+	// The transitions used for strings are compared, even if
+	// the difference doesn't change the tokenization
+	bx := NewBuilder()
+	s := bx.AddState()
+	bx.AddArc(1, s, 'a')
+	bx.AddArc(s, s, 'a')
+	bx.AddTokenBound(s, 1)
+	by := NewBuilder()
+	s = by.AddState()
+	by.AddArc(1, s, 'a')
+	by.AddArc(s, s, 'a')
+	x, y := newEquivSide(bx.Automaton()), newEquivSide(by.Automaton())
+	assert.True(verifyTransitions(x, x, nil, "aa"))
+	assert.False(verifyTransitions(x, y, nil, "aa"))
+	assert.True(verifyTransitions(x, y, nil, "bb"))
+
+	// The random strings are reproducible
+	chars := auto.chars()
+	assert.Equal(
+		auto.randomString(rand.New(rand.NewSource(7)), chars, 40),
+		auto.randomString(rand.New(rand.NewSource(7)), chars, 40),
+	)
+}
+
+func TestVerifyDeepTokenBound(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	auto := LoadFomaFile("testdata/abbr_bench.fst")
+
+	// Find the state with a token bound,
+	// that is farthest from the initial state
+	broken := auto.ToMatrix().Automaton()
+	dist := map[int]int{1: 0}
+	queue := []int{1}
+	deepest := 0
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		if broken.transitions[s][broken.epsilon] != nil && (deepest == 0 || dist[s] > dist[deepest]) {
+			deepest = s
+		}
+		for _, e := range broken.transitions[s] {
+			if _, ok := dist[e.end]; !ok {
+				dist[e.end] = dist[s] + 1
+				queue = append(queue, e.end)
+			}
+		}
+	}
+	assert.True(dist[deepest] > 4)
+
+	// A file with the token bound removed is rejected
+	delete(broken.transitions[deepest], broken.epsilon)
+	mat := broken.ToMatrix()
+	_, err := mat.Save(dir + "/broken.matok")
+	assert.Nil(err)
+	assert.False(VerifyTokenizerFile(dir+"/broken.matok", auto, mat, nil))
+}
@@ -0,0 +1,170 @@
+package xfst
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/KorAP/datok"
+)
+
+// Net is a compiled network.
+type Net struct {
+	f *fsm
+}
+
+// StateCount returns the number of states of the network.
+func (n *Net) StateCount() int {
+	return n.f.stateCount()
+}
+
+// ArcCount returns the number of arcs of the network.
+func (n *Net) ArcCount() int {
+	return n.f.arcCount()
+}
+
+// Automaton converts the network into the intermediate
+// representation of the tokenizer.
+func (n *Net) Automaton() *datok.Automaton {
+	var buf bytes.Buffer
+	if _, err := n.WriteTo(&buf); err != nil {
+		log.Println(err)
+		return nil
+	}
+	return datok.ParseFoma(&buf)
+}
+
+// Save stores the network in a gzipped foma file.
+func (n *Net) Save(file string) (int64, error) {
+	f, err := os.Create(file)
+	if err != nil {
+		log.Println(err)
+		return 0, err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	defer gz.Close()
+	return n.WriteTo(gz)
+}
+
+// WriteTo stores the network in the foma text format.
+func (n *Net) WriteTo(w io.Writer) (int64, error) {
+	f := n.f
+
+	// Number the symbols in use consecutively
+	used := map[int]bool{}
+	for _, sym := range f.known {
+		used[sym] = true
+	}
+	for _, as := range f.arcs {
+		for _, a := range as {
+			used[a.in] = true
+			used[a.out] = true
+		}
+	}
+	var syms []int
+	for sym := range used {
+		if sym > IDENTITY && (sym != BOUNDARY || f.hasSymbol(BOUNDARY)) {
+			syms = append(syms, sym)
+		}
+	}
+	sort.Ints(syms)
+	num := map[int]int{EPSILON: 0, UNKNOWN: 1, IDENTITY: 2}
+	for i, sym := range syms {
+		num[sym] = i + 3
+	}
+
+	finals, lines := 0, 0
+	for s, as := range f.arcs {
+		if f.final[s] {
+			finals++
+		}
+		if len(as) == 0 {
+			lines++
+		}
+		lines += len(as)
+	}
+
+	bw := bufio.NewWriter(w)
+	cw := &countWriter{w: bw}
+
+	cw.WriteString("##foma-net 1.0##\n##props##\n")
+	cw.WriteString("2 " + strconv.Itoa(f.arcCount()) +
+		" " + strconv.Itoa(f.stateCount()) +
+		" " + strconv.Itoa(lines) +
+		" " + strconv.Itoa(finals) +
+		" -1 1 1 1 1 0 2 tokenizer\n")
+
+	cw.WriteString("##sigma##\n")
+	for i, sym := range []int{EPSILON, UNKNOWN, IDENTITY} {
+		cw.WriteString(strconv.Itoa(i) + " " + f.sigma.symbols[sym] + "\n")
+	}
+	for _, sym := range syms {
+		cw.WriteString(strconv.Itoa(num[sym]) + " " + f.sigma.symbols[sym] + "\n")
+	}
+
+	cw.WriteString("##states##\n")
+	for s, as := range f.arcs {
+		final := "0"
+		if f.final[s] {
+			final = "1"
+		}
+		state := strconv.Itoa(s)
+		if len(as) == 0 {
+			cw.WriteString(state + " -1 -1 " + final + "\n")
+			continue
+		}
+		for i, a := range as {
+			line := strconv.Itoa(num[a.in])
+			if a.in != a.out {
+				line += " " + strconv.Itoa(num[a.out])
+			}
+			line += " " + strconv.Itoa(a.to)
+			if i == 0 {
+				line = state + " " + line + " " + final
+			}
+			cw.WriteString(line + "\n")
+		}
+	}
+	cw.WriteString("-1 -1 -1 -1 -1\n##end##\n")
+
+	if cw.err != nil {
+		log.Println(cw.err)
+		return cw.n, cw.err
+	}
+	return cw.n, bw.Flush()
+}
+
+// Returns true, if any arc uses the symbol
+func (f *fsm) hasSymbol(sym int) bool {
+	for _, as := range f.arcs {
+		for _, a := range as {
+			if a.in == sym || a.out == sym {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// countWriter counts the written bytes and
+// keeps the first error
+type countWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (cw *countWriter) WriteString(s string) {
+	if cw.err != nil {
+		return
+	}
+	n, err := io.WriteString(cw.w, s)
+	cw.n += int64(n)
+	cw.err = err
+}
@@ -0,0 +1,338 @@
+package xfst
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// Special symbols in sigma, following the conventions of foma
+const (
+	EPSILON  = 0
+	UNKNOWN  = 1
+	IDENTITY = 2
+	BOUNDARY = 3
+)
+
+// Sigma is the symbol table shared by all networks
+// of a compilation.
+type Sigma struct {
+	symbols []string
+	ids     map[string]int
+}
+
+// Create a new symbol table with the special symbols
+func newSigma() *Sigma {
+	s := &Sigma{ids: make(map[string]int)}
+	s.add("@_EPSILON_SYMBOL_@")
+	s.add("@_UNKNOWN_SYMBOL_@")
+	s.add("@_IDENTITY_SYMBOL_@")
+	s.add(".#.")
+	return s
+}
+
+// Return the id of a symbol, adding it if not yet known
+func (s *Sigma) add(sym string) int {
+	if id, ok := s.ids[sym]; ok {
+		return id
+	}
+	id := len(s.symbols)
+	s.symbols = append(s.symbols, sym)
+	s.ids[sym] = id
+	return id
+}
+
+// An arc of the network with an input (upper)
+// and output (lower) symbol
+type arc struct {
+	in, out int
+	to      int
+}
+
+// Pack the label of an arc into a single comparable value
+func (a arc) label() int64 {
+	return int64(a.in)<<32 | int64(a.out)
+}
+
+type arcs []arc
+
+func (as arcs) Len() int      { return len(as) }
+func (as arcs) Swap(i, j int) { as[i], as[j] = as[j], as[i] }
+func (as arcs) Less(i, j int) bool {
+	if as[i].in != as[j].in {
+		return as[i].in < as[j].in
+	}
+	if as[i].out != as[j].out {
+		return as[i].out < as[j].out
+	}
+	return as[i].to < as[j].to
+}
+
+// fsm is a finite state transducer with the start state 0.
+//
+// The known symbols are the symbols of sigma the network
+// was defined with - the identity and unknown symbols
+// stand for all other symbols. Networks without sigma
+// are used internally with plain symbols only.
+type fsm struct {
+	sigma *Sigma
+	known []int
+	arcs  [][]arc
+	final []bool
+
+	// The network is known to be minimal and deterministic
+	minimal bool
+}
+
+// Create a network with a single non-final state
+func newFSM(sigma *Sigma, known []int) *fsm {
+	return &fsm{
+		sigma: sigma,
+		known: known,
+		arcs:  make([][]arc, 1),
+		final: make([]bool, 1),
+	}
+}
+
+// Add a new state and return its number
+func (f *fsm) addState() int {
+	f.minimal = false
+	f.arcs = append(f.arcs, nil)
+	f.final = append(f.final, false)
+	return len(f.arcs) - 1
+}
+
+// Add an arc to a state
+func (f *fsm) addArc(s, in, out, to int) {
+	f.minimal = false
+	f.arcs[s] = append(f.arcs[s], arc{in: in, out: out, to: to})
+}
+
+// Number of states
+func (f *fsm) stateCount() int {
+	return len(f.arcs)
+}
+
+// Number of arcs
+func (f *fsm) arcCount() int {
+	n := 0
+	for _, as := range f.arcs {
+		n += len(as)
+	}
+	return n
+}
+
+// Network accepting the empty string only
+func epsilonFSM(sigma *Sigma) *fsm {
+	f := newFSM(sigma, nil)
+	f.final[0] = true
+	return f
+}
+
+// Network accepting a single symbol pair
+func symbolFSM(sigma *Sigma, in, out int) *fsm {
+	f := newFSM(sigma, knownOf(in, out))
+	t := f.addState()
+	f.final[t] = true
+	f.addArc(0, in, out, t)
+	if in == UNKNOWN && out == UNKNOWN {
+		f.addArc(0, IDENTITY, IDENTITY, t)
+	}
+	return f
+}
+
+// Network accepting a sequence of symbols
+func stringFSM(sigma *Sigma, syms []int) *fsm {
+	f := newFSM(sigma, knownOf(syms...))
+	s := 0
+	for _, sym := range syms {
+		t := f.addState()
+		f.addArc(s, sym, sym, t)
+		s = t
+	}
+	f.final[s] = true
+	return f
+}
+
+// Network accepting any single symbol
+func anyFSM(sigma *Sigma, known []int) *fsm {
+	f := newFSM(sigma, known)
+	t := f.addState()
+	f.final[t] = true
+	f.addArc(0, IDENTITY, IDENTITY, t)
+	for _, sym := range known {
+		if sym != BOUNDARY {
+			f.addArc(0, sym, sym, t)
+		}
+	}
+	return f
+}
+
+// Network accepting any string
+func universalFSM(sigma *Sigma, known []int) *fsm {
+	f := newFSM(sigma, known)
+	f.final[0] = true
+	f.addArc(0, IDENTITY, IDENTITY, 0)
+	for _, sym := range known {
+		if sym != BOUNDARY {
+			f.addArc(0, sym, sym, 0)
+		}
+	}
+	return f
+}
+
+// Return the sorted set of non special symbols
+func knownOf(syms ...int) []int {
+	var known []int
+	for _, sym := range syms {
+		if sym == EPSILON || sym == UNKNOWN || sym == IDENTITY {
+			continue
+		}
+		known = unionSet(known, []int{sym})
+	}
+	return known
+}
+
+// Union of two sorted sets
+func unionSet(a, b []int) []int {
+	res := make([]int, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if a[i] < b[j] {
+			res = append(res, a[i])
+			i++
+		} else if a[i] > b[j] {
+			res = append(res, b[j])
+			j++
+		} else {
+			res = append(res, a[i])
+			i++
+			j++
+		}
+	}
+	res = append(res, a[i:]...)
+	return append(res, b[j:]...)
+}
+
+// Elements of the sorted set a, that are not in the sorted set b
+func diffSet(a, b []int) []int {
+	var res []int
+	j := 0
+	for _, x := range a {
+		for j < len(b) && b[j] < x {
+			j++
+		}
+		if j >= len(b) || b[j] != x {
+			res = append(res, x)
+		}
+	}
+	return res
+}
+
+// Copy the network
+func (f *fsm) copy() *fsm {
+	g := &fsm{
+		sigma: f.sigma,
+		known: f.known,
+		arcs:  make([][]arc, len(f.arcs)),
+		final: make([]bool, len(f.final)),
+	}
+	for s, as := range f.arcs {
+		g.arcs[s] = append([]arc(nil), as...)
+	}
+	copy(g.final, f.final)
+	return g
+}
+
+// expand returns a copy of the network knowing the
+// additional symbols. Arcs with the identity or unknown
+// symbol are extended to the new symbols, as these are
+// no longer covered by them.
+func (f *fsm) expand(known []int) *fsm {
+	add := diffSet(known, f.known)
+	if len(add) == 0 {
+		return f
+	}
+
+	g := f.copy()
+	g.known = unionSet(f.known, add)
+
+	for s, as := range f.arcs {
+		for _, a := range as {
+			switch {
+			case a.in == IDENTITY:
+				for _, x := range add {
+					if x != BOUNDARY {
+						g.addArc(s, x, x, a.to)
+					}
+				}
+			case a.in == UNKNOWN && a.out == UNKNOWN:
+				for _, x := range add {
+					if x == BOUNDARY {
+						continue
+					}
+					g.addArc(s, x, UNKNOWN, a.to)
+					g.addArc(s, UNKNOWN, x, a.to)
+					for _, y := range add {
+						if y != x && y != BOUNDARY {
+							g.addArc(s, x, y, a.to)
+						}
+					}
+				}
+			case a.in == UNKNOWN:
+				for _, x := range add {
+					if x != BOUNDARY {
+						g.addArc(s, x, a.out, a.to)
+					}
+				}
+			case a.out == UNKNOWN:
+				for _, x := range add {
+					if x != BOUNDARY {
+						g.addArc(s, a.in, x, a.to)
+					}
+				}
+			}
+		}
+	}
+	for _, as := range g.arcs {
+		sort.Sort(arcs(as))
+	}
+	return g
+}
+
+// Expand all networks to the union of their known symbols
+func harmonize(nets ...*fsm) []*fsm {
+	var known []int
+	for _, f := range nets {
+		known = unionSet(known, f.known)
+	}
+	res := make([]*fsm, len(nets))
+	for i, f := range nets {
+		if f.sigma == nil {
+			res[i] = f
+		} else {
+			res[i] = f.expand(known)
+		}
+	}
+	return res
+}
+
+// Serialize a sorted set of states as a map key
+func setKey(set []int) string {
+	buf := make([]byte, 4*len(set))
+	for i, s := range set {
+		binary.LittleEndian.PutUint32(buf[i*4:], uint32(s))
+	}
+	return string(buf)
+}
+
+// Sort and remove duplicates
+func uniqInts(set []int) []int {
+	sort.Ints(set)
+	res := set[:0]
+	for i, s := range set {
+		if i == 0 || s != set[i-1] {
+			res = append(res, s)
+		}
+	}
+	return res
+}
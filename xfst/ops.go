@@ -0,0 +1,966 @@
+package xfst
+
+import (
+	"sort"
+	"strconv"
+)
+
+// Return the epsilon closure of a set of states
+// regarding arcs that neither consume nor produce symbols.
+// The marks are stamped with the generation to avoid
+// clearing them for every closure.
+func (f *fsm) closure(set []int, mark []int, gen int) []int {
+	for _, s := range set {
+		mark[s] = gen
+	}
+	for i := 0; i < len(set); i++ {
+		for _, a := range f.arcs[set[i]] {
+			if a.in == EPSILON && a.out == EPSILON && mark[a.to] != gen {
+				mark[a.to] = gen
+				set = append(set, a.to)
+			}
+		}
+	}
+	sort.Ints(set)
+	return set
+}
+
+// Returns true, if the network has arcs neither consuming
+// nor producing symbols
+func (f *fsm) hasEpsilonArcs() bool {
+	for _, as := range f.arcs {
+		for _, a := range as {
+			if a.in == EPSILON && a.out == EPSILON {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Mark all final states, that loop on every label of the network.
+// Any set of states containing such a state accepts all strings
+// the network can accept after reaching it.
+func (f *fsm) universalStates() []bool {
+	labels := make(map[int64]bool)
+	for _, as := range f.arcs {
+		for _, a := range as {
+			if a.in != EPSILON || a.out != EPSILON {
+				labels[a.label()] = true
+			}
+		}
+	}
+
+	var univ []bool
+	for s, as := range f.arcs {
+		if !f.final[s] {
+			continue
+		}
+		loops := make(map[int64]bool)
+		for _, a := range as {
+			if a.to == s && (a.in != EPSILON || a.out != EPSILON) {
+				loops[a.label()] = true
+			}
+		}
+		if len(loops) == len(labels) {
+			if univ == nil {
+				univ = make([]bool, len(f.arcs))
+			}
+			univ[s] = true
+		}
+	}
+	return univ
+}
+
+// determinize returns an epsilon free network, that is
+// deterministic regarding the symbol pairs of the arcs,
+// using the subset construction.
+func (f *fsm) determinize() *fsm {
+	eps := f.hasEpsilonArcs()
+	univ := f.universalStates()
+	mark := make([]int, f.stateCount())
+	gen := 0
+
+	// Reduce a set of states to a universal state
+	reduce := func(set []int) []int {
+		if univ != nil {
+			for _, s := range set {
+				if univ[s] {
+					return []int{s}
+				}
+			}
+		}
+		return set
+	}
+
+	start := []int{0}
+	if eps {
+		gen++
+		start = f.closure(start, mark, gen)
+	}
+	start = reduce(start)
+
+	g := newFSM(f.sigma, f.known)
+	sets := [][]int{start}
+	table := map[string]int{setKey(start): 0}
+
+	collect := make(arcs, 0, 64)
+	targets := make([]int, 0, 64)
+
+	for t := 0; t < len(sets); t++ {
+		collect = collect[:0]
+		for _, s := range sets[t] {
+			if f.final[s] {
+				g.final[t] = true
+			}
+			for _, a := range f.arcs[s] {
+				if a.in == EPSILON && a.out == EPSILON {
+					continue
+				}
+				collect = append(collect, a)
+			}
+		}
+		sort.Sort(collect)
+
+		for i := 0; i < len(collect); {
+			j := i
+			targets = targets[:0]
+			for j < len(collect) && collect[j].in == collect[i].in && collect[j].out == collect[i].out {
+				if j == i || collect[j].to != collect[j-1].to {
+					targets = append(targets, collect[j].to)
+				}
+				j++
+			}
+
+			set := append([]int(nil), targets...)
+			if eps {
+				gen++
+				set = f.closure(set, mark, gen)
+			}
+			set = reduce(set)
+			key := setKey(set)
+			end, ok := table[key]
+			if !ok {
+				end = g.addState()
+				table[key] = end
+				sets = append(sets, set)
+			}
+			g.addArc(t, collect[i].in, collect[i].out, end)
+			i = j
+		}
+	}
+	return g
+}
+
+// trim removes all states, that can't reach a final state.
+// All states are expected to be reachable from the start state.
+func (f *fsm) trim() *fsm {
+	n := f.stateCount()
+	rev := make([][]int, n)
+	for s, as := range f.arcs {
+		for _, a := range as {
+			rev[a.to] = append(rev[a.to], s)
+		}
+	}
+
+	live := make([]bool, n)
+	queue := make([]int, 0, n)
+	for s := 0; s < n; s++ {
+		if f.final[s] {
+			live[s] = true
+			queue = append(queue, s)
+		}
+	}
+	for i := 0; i < len(queue); i++ {
+		for _, s := range rev[queue[i]] {
+			if !live[s] {
+				live[s] = true
+				queue = append(queue, s)
+			}
+		}
+	}
+
+	if !live[0] {
+		return newFSM(f.sigma, f.known)
+	}
+	if len(queue) == n {
+		return f
+	}
+
+	num := make([]int, n)
+	g := newFSM(f.sigma, f.known)
+	for s := 0; s < n; s++ {
+		if !live[s] {
+			continue
+		}
+		if s == 0 {
+			num[s] = 0
+		} else {
+			num[s] = g.addState()
+		}
+	}
+	for s := 0; s < n; s++ {
+		if !live[s] {
+			continue
+		}
+		g.final[num[s]] = f.final[s]
+		for _, a := range f.arcs[s] {
+			if live[a.to] {
+				g.addArc(num[s], a.in, a.out, num[a.to])
+			}
+		}
+	}
+	return g
+}
+
+// minimize returns the minimal deterministic network
+// (regarding symbol pairs) using the algorithm of Hopcroft (1971)
+// with the refinable partition of Valmari & Lehtinen (2008).
+// The network is expected to be deterministic and trimmed.
+func (f *fsm) minimize() *fsm {
+	n := f.stateCount()
+
+	// Collect labels and inverse transitions
+	labels := make(map[int64]int)
+	type inverse struct {
+		label  int
+		source int
+	}
+	inv := make([][]inverse, n)
+	for s, as := range f.arcs {
+		for _, a := range as {
+			id, ok := labels[a.label()]
+			if !ok {
+				id = len(labels)
+				labels[a.label()] = id
+			}
+			inv[a.to] = append(inv[a.to], inverse{label: id, source: s})
+		}
+	}
+
+	states := make([]int, n)
+	for s := range states {
+		states[s] = s
+	}
+
+	p := newPartition(states, n)
+	for s := 0; s < n; s++ {
+		if f.final[s] {
+			p.mark(s)
+		}
+	}
+	p.split(func(_, _ int) {})
+
+	work := make([]int, 0, 1024)
+	inWork := make([]bool, 0, 1024)
+	for b := 0; b < p.size(); b++ {
+		work = append(work, b)
+		inWork = append(inWork, true)
+	}
+
+	bylabel := make([][]int, len(labels))
+	used := make([]int, 0, len(labels))
+
+	for len(work) > 0 {
+		s := work[len(work)-1]
+		work = work[:len(work)-1]
+		inWork[s] = false
+
+		for i := p.first[s]; i < p.end[s]; i++ {
+			for _, in := range inv[p.elems[i]] {
+				if len(bylabel[in.label]) == 0 {
+					used = append(used, in.label)
+				}
+				bylabel[in.label] = append(bylabel[in.label], in.source)
+			}
+		}
+
+		for _, l := range used {
+			for _, src := range bylabel[l] {
+				p.mark(src)
+			}
+			bylabel[l] = bylabel[l][:0]
+
+			p.split(func(b, nb int) {
+				inWork = append(inWork, false)
+				if inWork[b] || p.end[nb]-p.first[nb] <= p.end[b]-p.first[b] {
+					work = append(work, nb)
+					inWork[nb] = true
+				} else {
+					work = append(work, b)
+					inWork[b] = true
+				}
+			})
+		}
+		used = used[:0]
+	}
+
+	// Create the quotient network
+	g := newFSM(f.sigma, f.known)
+	num := make([]int, p.size())
+	for b := range num {
+		num[b] = -1
+	}
+	num[p.block[0]] = 0
+	for b := 0; b < p.size(); b++ {
+		if num[b] == -1 {
+			num[b] = g.addState()
+		}
+	}
+	for b := 0; b < p.size(); b++ {
+		s := p.elems[p.first[b]]
+		g.final[num[b]] = f.final[s]
+		for _, a := range f.arcs[s] {
+			g.addArc(num[b], a.in, a.out, num[p.block[a.to]])
+		}
+	}
+	return g.canonical()
+}
+
+// canonical renumbers the states in breadth first order
+// and sorts the arcs, so equivalent minimal networks
+// are identical.
+func (f *fsm) canonical() *fsm {
+	n := f.stateCount()
+	num := make([]int, n)
+	for s := range num {
+		num[s] = -1
+	}
+	for _, as := range f.arcs {
+		sort.Sort(arcs(as))
+	}
+
+	order := make([]int, 0, n)
+	num[0] = 0
+	order = append(order, 0)
+	for i := 0; i < len(order); i++ {
+		for _, a := range f.arcs[order[i]] {
+			if num[a.to] == -1 {
+				num[a.to] = len(order)
+				order = append(order, a.to)
+			}
+		}
+	}
+
+	g := &fsm{
+		sigma: f.sigma,
+		known: f.known,
+		arcs:  make([][]arc, len(order)),
+		final: make([]bool, len(order)),
+	}
+	for i, s := range order {
+		g.final[i] = f.final[s]
+		as := make([]arc, len(f.arcs[s]))
+		for j, a := range f.arcs[s] {
+			as[j] = arc{in: a.in, out: a.out, to: num[a.to]}
+		}
+		g.arcs[i] = as
+	}
+	return g
+}
+
+// clean returns the minimal deterministic network
+func (f *fsm) clean() *fsm {
+	if f.minimal {
+		return f
+	}
+	g := f.determinize().trim().minimize()
+	g.minimal = true
+	return g
+}
+
+// Union of networks
+func union(nets ...*fsm) *fsm {
+	return unionNFA(nets...).clean()
+}
+
+// Union of networks without determinization
+func unionNFA(nets ...*fsm) *fsm {
+	nets = harmonize(nets...)
+	g := newFSM(nets[0].sigma, nets[0].known)
+	for _, f := range nets {
+		off := g.embed(f)
+		g.addArc(0, EPSILON, EPSILON, off)
+	}
+	return g
+}
+
+// Concatenation of networks
+func concat(nets ...*fsm) *fsm {
+	return concatNFA(nets...).clean()
+}
+
+// Concatenation of networks without determinization
+func concatNFA(nets ...*fsm) *fsm {
+	nets = harmonize(nets...)
+	g := newFSM(nets[0].sigma, nets[0].known)
+	g.final[0] = true
+	for _, f := range nets {
+		off := g.embed(f)
+		for s := range g.final[:off] {
+			if g.final[s] {
+				g.final[s] = false
+				g.addArc(s, EPSILON, EPSILON, off)
+			}
+		}
+	}
+	return g
+}
+
+// Kleene star of a network
+func star(f *fsm) *fsm {
+	g := newFSM(f.sigma, f.known)
+	g.final[0] = true
+	off := g.embed(f)
+	g.addArc(0, EPSILON, EPSILON, off)
+	for s := off; s < g.stateCount(); s++ {
+		if g.final[s] {
+			g.addArc(s, EPSILON, EPSILON, 0)
+		}
+	}
+	return g.clean()
+}
+
+// Kleene plus of a network
+func plus(f *fsm) *fsm {
+	return concat(f, star(f))
+}
+
+// Optionality of a network
+func optional(f *fsm) *fsm {
+	return union(f, epsilonFSM(f.sigma))
+}
+
+// Copy all states of a network into this network
+// and return the offset of the states
+func (g *fsm) embed(f *fsm) int {
+	off := g.stateCount()
+	for s, as := range f.arcs {
+		g.arcs = append(g.arcs, nil)
+		g.final = append(g.final, f.final[s])
+		for _, a := range as {
+			g.arcs[off+s] = append(g.arcs[off+s], arc{in: a.in, out: a.out, to: a.to + off})
+		}
+	}
+	return off
+}
+
+// Find the target of a labeled arc in a deterministic
+// network with sorted arcs
+func (f *fsm) next(s, in, out int) int {
+	as := f.arcs[s]
+	i := sort.Search(len(as), func(i int) bool {
+		return as[i].in > in || (as[i].in == in && as[i].out >= out)
+	})
+	if i < len(as) && as[i].in == in && as[i].out == out {
+		return as[i].to
+	}
+	return -1
+}
+
+// product builds the product of two deterministic networks
+// regarding symbol pairs. If diff is true, the difference is
+// returned, otherwise the intersection.
+func product(a, b *fsm, diff bool) *fsm {
+	nets := harmonize(a.clean(), b.clean())
+	a, b = nets[0], nets[1]
+
+	g := newFSM(a.sigma, a.known)
+	type pair struct{ p, q int }
+	table := map[pair]int{{0, 0}: 0}
+	queue := []pair{{0, 0}}
+
+	for i := 0; i < len(queue); i++ {
+		c := queue[i]
+		if diff {
+			g.final[i] = a.final[c.p] && (c.q == -1 || !b.final[c.q])
+		} else {
+			g.final[i] = a.final[c.p] && b.final[c.q]
+		}
+
+		for _, x := range a.arcs[c.p] {
+			q := -1
+			if c.q != -1 {
+				q = b.next(c.q, x.in, x.out)
+			}
+			if q == -1 && !diff {
+				continue
+			}
+			t := pair{x.to, q}
+			end, ok := table[t]
+			if !ok {
+				end = g.addState()
+				table[t] = end
+				queue = append(queue, t)
+			}
+			g.addArc(i, x.in, x.out, end)
+		}
+	}
+	return g.clean()
+}
+
+// Intersection of two networks
+func intersect(a, b *fsm) *fsm {
+	return product(a, b, false)
+}
+
+// Difference of two networks
+func minus(a, b *fsm) *fsm {
+	return product(a, b, true)
+}
+
+// Complement of a network
+func complement(f *fsm) *fsm {
+	return minus(universalFSM(f.sigma, f.known), f)
+}
+
+// Term complement of a network,
+// i.e. all single symbols not in the network
+func termComplement(f *fsm) *fsm {
+	return minus(anyFSM(f.sigma, f.known), f)
+}
+
+// Projection of the upper (input) or lower (output) side
+func project(f *fsm, upper bool) *fsm {
+	g := f.copy()
+	for _, as := range g.arcs {
+		for i, a := range as {
+			sym := a.out
+			if upper {
+				sym = a.in
+			}
+
+			// Unknown symbols become any symbol
+			if sym == UNKNOWN {
+				sym = IDENTITY
+			}
+			as[i].in, as[i].out = sym, sym
+		}
+	}
+	return g.clean()
+}
+
+// Inversion of a network
+func invert(f *fsm) *fsm {
+	g := f.copy()
+	for _, as := range g.arcs {
+		for i, a := range as {
+			as[i].in, as[i].out = a.out, a.in
+		}
+	}
+	return g.clean()
+}
+
+// Reversal of a network
+func reverse(f *fsm) *fsm {
+	g := newFSM(f.sigma, f.known)
+	for range f.arcs {
+		g.addState()
+	}
+	for s, as := range f.arcs {
+		if f.final[s] {
+			g.addArc(0, EPSILON, EPSILON, s+1)
+		}
+		for _, a := range as {
+			g.addArc(a.to+1, a.in, a.out, s+1)
+		}
+	}
+	g.final[1] = true
+	return g.clean()
+}
+
+// Returns true, if the network is an identity relation
+func (f *fsm) isIdentity() bool {
+	for _, as := range f.arcs {
+		for _, a := range as {
+			if a.in != a.out || a.in == UNKNOWN {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Returns true, if the network accepts the empty string
+// (as a deterministic network)
+func (f *fsm) acceptsEpsilon() bool {
+	return f.final[0]
+}
+
+// Cross the labels of two languages
+func crossLabels(x, y int, fn func(in, out int)) {
+	switch {
+	case x == IDENTITY && y == IDENTITY:
+		fn(IDENTITY, IDENTITY)
+		fn(UNKNOWN, UNKNOWN)
+	case x == IDENTITY:
+		fn(UNKNOWN, y)
+	case y == IDENTITY:
+		fn(x, UNKNOWN)
+	default:
+		fn(x, y)
+	}
+}
+
+// crossProduct returns the relation mapping all strings of the
+// upper side of a to all strings of the lower side of b,
+// aligning the symbols from the left.
+func crossProduct(a, b *fsm) *fsm {
+	nets := harmonize(project(a, true), project(b, false))
+	a, b = nets[0], nets[1]
+
+	g := newFSM(a.sigma, a.known)
+	type triple struct{ p, q, mode int }
+	table := map[triple]int{{0, 0, 0}: 0}
+	queue := []triple{{0, 0, 0}}
+
+	target := func(t triple) int {
+		end, ok := table[t]
+		if !ok {
+			end = g.addState()
+			table[t] = end
+			queue = append(queue, t)
+		}
+		return end
+	}
+
+	for i := 0; i < len(queue); i++ {
+		c := queue[i]
+		g.final[i] = a.final[c.p] && b.final[c.q]
+
+		// Both sides consume symbols
+		if c.mode == 0 {
+			for _, x := range a.arcs[c.p] {
+				for _, y := range b.arcs[c.q] {
+					end := target(triple{x.to, y.to, 0})
+					crossLabels(x.in, y.in, func(in, out int) {
+						g.addArc(i, in, out, end)
+					})
+				}
+			}
+		}
+
+		// Upper side is complete
+		if c.mode != 2 && a.final[c.p] {
+			for _, y := range b.arcs[c.q] {
+				end := target(triple{c.p, y.to, 1})
+				crossLabels(EPSILON, y.in, func(in, out int) {
+					g.addArc(i, in, out, end)
+				})
+			}
+		}
+
+		// Lower side is complete
+		if c.mode != 1 && b.final[c.q] {
+			for _, x := range a.arcs[c.p] {
+				end := target(triple{x.to, c.q, 2})
+				crossLabels(x.in, EPSILON, func(in, out int) {
+					g.addArc(i, in, out, end)
+				})
+			}
+		}
+	}
+	return g.clean()
+}
+
+// compose returns the composition of two networks.
+// Deletions of the upper network are taken before
+// insertions of the lower network.
+func compose(a, b *fsm) *fsm {
+	special := a.sigma != nil
+	nets := harmonize(a.clean(), b.clean())
+	a, b = nets[0], nets[1]
+
+	g := newFSM(a.sigma, a.known)
+	type triple struct{ p, q, filter int }
+	table := map[triple]int{{0, 0, 0}: 0}
+	queue := []triple{{0, 0, 0}}
+
+	target := func(t triple) int {
+		end, ok := table[t]
+		if !ok {
+			end = g.addState()
+			table[t] = end
+			queue = append(queue, t)
+		}
+		return end
+	}
+
+	// Find the arcs of b with a given input symbol range
+	arcsFrom := func(q, lo, hi int) []arc {
+		as := b.arcs[q]
+		i := sort.Search(len(as), func(i int) bool { return as[i].in >= lo })
+		j := sort.Search(len(as), func(i int) bool { return as[i].in > hi })
+		return as[i:j]
+	}
+
+	for i := 0; i < len(queue); i++ {
+		c := queue[i]
+		g.final[i] = a.final[c.p] && b.final[c.q]
+
+		for _, x := range a.arcs[c.p] {
+
+			// Deletion in the upper network
+			if x.out == EPSILON {
+				if c.filter == 0 {
+					g.addArc(i, x.in, EPSILON, target(triple{x.to, c.q, 0}))
+				}
+				continue
+			}
+
+			lo, hi := x.out, x.out
+			if special && (x.out == UNKNOWN || x.out == IDENTITY) {
+				lo, hi = UNKNOWN, IDENTITY
+			}
+
+			for _, y := range arcsFrom(c.q, lo, hi) {
+				end := target(triple{x.to, y.to, 0})
+				if !special || (x.out != UNKNOWN && x.out != IDENTITY) {
+					g.addArc(i, x.in, y.out, end)
+					continue
+				}
+
+				// Resolve the identity of the unknown symbols
+				switch {
+				case x.out == IDENTITY && y.in == IDENTITY:
+					g.addArc(i, IDENTITY, IDENTITY, end)
+				case x.out == IDENTITY:
+					g.addArc(i, UNKNOWN, y.out, end)
+				case y.in == IDENTITY:
+					g.addArc(i, x.in, UNKNOWN, end)
+				case x.in == UNKNOWN && y.out == UNKNOWN:
+					g.addArc(i, UNKNOWN, UNKNOWN, end)
+					g.addArc(i, IDENTITY, IDENTITY, end)
+				default:
+					g.addArc(i, x.in, y.out, end)
+				}
+			}
+		}
+
+		// Insertion in the lower network
+		for _, y := range arcsFrom(c.q, EPSILON, EPSILON) {
+			g.addArc(i, EPSILON, y.out, target(triple{c.p, y.to, 1}))
+		}
+	}
+	return g.clean()
+}
+
+// subtract returns the network a without the strings of the
+// network b. In contrast to minus, b may be non-deterministic
+// and is only determinized along the paths of a.
+func subtract(a, b *fsm) *fsm {
+	nets := harmonize(a.clean(), b.trim())
+	a, b = nets[0], nets[1].copy()
+	for _, as := range b.arcs {
+		sort.Sort(arcs(as))
+	}
+	sub := newSubsets(b)
+
+	// Paths of a reaching a universal state of b are rejected,
+	// in case b loops on all labels of a
+	dead := sub.univ != nil
+	if dead {
+		labels := make(map[int64]bool)
+		for _, as := range b.arcs {
+			for _, x := range as {
+				labels[x.label()] = true
+			}
+		}
+		for _, as := range a.arcs {
+			for _, x := range as {
+				if !labels[x.label()] {
+					dead = false
+				}
+			}
+		}
+	}
+
+	type pair struct {
+		p   int
+		key string
+	}
+	start := sub.reduce(sub.closure([]int{0}))
+	g := newFSM(a.sigma, a.known)
+	table := map[pair]int{{0, setKey(start)}: 0}
+	states := []int{0}
+	sets := [][]int{start}
+
+	for i := 0; i < len(states); i++ {
+		p, set := states[i], sub.closure(append([]int(nil), sets[i]...))
+		g.final[i] = a.final[p] && !sub.final(set)
+
+		for _, x := range a.arcs[p] {
+			next := sub.reduce(sub.step(set, x.in, x.out))
+			if dead && len(next) == 1 && sub.univ[next[0]] {
+				continue
+			}
+			t := pair{x.to, setKey(next)}
+			end, ok := table[t]
+			if !ok {
+				end = g.addState()
+				table[t] = end
+				states = append(states, x.to)
+				sets = append(sets, next)
+			}
+			g.addArc(i, x.in, x.out, end)
+		}
+	}
+	return g.trim().minimize()
+}
+
+// subsets supports the determinization of a network with
+// sorted arcs along the paths of another network. Sets of
+// states are reduced to an antichain, i.e. states, whose
+// language is included in the language of another state
+// of the same set, are removed.
+type subsets struct {
+	f       *fsm
+	eps     bool
+	univ    []bool
+	mark    []int
+	gen     int
+	reduced map[string][]int
+	incl    map[[2]int]bool
+}
+
+// Maximum number of state pairs to explore for
+// an inclusion check
+const inclusionLimit = 5000
+
+func newSubsets(f *fsm) *subsets {
+	return &subsets{
+		f:       f,
+		eps:     f.hasEpsilonArcs(),
+		univ:    f.universalStates(),
+		mark:    make([]int, f.stateCount()),
+		reduced: make(map[string][]int),
+		incl:    make(map[[2]int]bool),
+	}
+}
+
+// Epsilon closure of a set, reduced to a universal state
+func (sub *subsets) closure(set []int) []int {
+	if sub.eps {
+		sub.gen++
+		set = sub.f.closure(set, sub.mark, sub.gen)
+	}
+	if sub.univ != nil {
+		for _, s := range set {
+			if sub.univ[s] {
+				return []int{s}
+			}
+		}
+	}
+	return set
+}
+
+// Returns true, if any state of the set is final
+func (sub *subsets) final(set []int) bool {
+	for _, s := range set {
+		if sub.f.final[s] {
+			return true
+		}
+	}
+	return false
+}
+
+// Closed set of states reached from a closed set
+// with a label
+func (sub *subsets) step(set []int, in, out int) []int {
+	var targets []int
+	for _, s := range set {
+		as := sub.f.arcs[s]
+		j := sort.Search(len(as), func(j int) bool {
+			return as[j].in > in || (as[j].in == in && as[j].out >= out)
+		})
+		for ; j < len(as) && as[j].in == in && as[j].out == out; j++ {
+			targets = append(targets, as[j].to)
+		}
+	}
+	return sub.closure(uniqInts(targets))
+}
+
+// Remove all states from a closed set, that are
+// subsumed by another state of the set. The result
+// accepts the same language, but may not be closed.
+func (sub *subsets) reduce(set []int) []int {
+	if len(set) < 2 {
+		return set
+	}
+	key := setKey(set)
+	if res, ok := sub.reduced[key]; ok {
+		return res
+	}
+	keep := make([]bool, len(set))
+	for i := range keep {
+		keep[i] = true
+	}
+	for i, s := range set {
+		for j, t := range set {
+			if i != j && keep[j] && sub.includes(t, s) {
+				keep[i] = false
+				break
+			}
+		}
+	}
+	res := make([]int, 0, len(set))
+	for i, s := range set {
+		if keep[i] {
+			res = append(res, s)
+		}
+	}
+	sub.reduced[key] = res
+	return res
+}
+
+// Returns true, if the language of state t includes the
+// language of state s. The check is limited in size and
+// returns false, if the limit is exceeded.
+func (sub *subsets) includes(t, s int) bool {
+	pair := [2]int{t, s}
+	if res, ok := sub.incl[pair]; ok {
+		return res
+	}
+
+	// Follow all paths of s with the subsets of t
+	type item struct {
+		s   int
+		set []int
+	}
+	seen := map[string]bool{}
+	queue := []item{{s, sub.closure([]int{t})}}
+	res := true
+	for len(queue) > 0 && res {
+		if len(seen) > inclusionLimit {
+			res = false
+			break
+		}
+		it := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		if len(it.set) == 1 && sub.univ != nil && sub.univ[it.set[0]] {
+			continue
+		}
+		k := strconv.Itoa(it.s) + ":" + setKey(it.set)
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		if sub.f.final[it.s] && !sub.final(it.set) {
+			res = false
+			break
+		}
+		for _, a := range sub.f.arcs[it.s] {
+			next := it.set
+			if a.in != EPSILON || a.out != EPSILON {
+				next = sub.step(it.set, a.in, a.out)
+				if len(next) == 0 {
+					res = false
+					break
+				}
+			}
+			queue = append(queue, item{a.to, next})
+		}
+	}
+	sub.incl[pair] = res
+	return res
+}
@@ -0,0 +1,88 @@
+package xfst
+
+// A refinable partition of states,
+// following Valmari & Lehtinen (2008)
+type partition struct {
+	elems []int // States ordered by blocks
+	loc   []int // Position of a state in elems
+	block []int // Block of a state
+	first []int // First position of a block in elems
+	end   []int // End position of a block in elems
+	mid   []int // End position of marked states of a block
+
+	touched []int
+}
+
+// Create a new partition with a single block
+// containing all the given states
+func newPartition(states []int, max int) *partition {
+	p := &partition{
+		elems: make([]int, len(states)),
+		loc:   make([]int, max+1),
+		block: make([]int, max+1),
+		first: []int{0},
+		end:   []int{len(states)},
+		mid:   []int{0},
+	}
+	copy(p.elems, states)
+	for i, s := range p.elems {
+		p.loc[s] = i
+	}
+	return p
+}
+
+// Number of blocks in the partition
+func (p *partition) size() int {
+	return len(p.first)
+}
+
+// Mark a state for splitting
+func (p *partition) mark(s int) {
+	b := p.block[s]
+	i := p.loc[s]
+	j := p.mid[b]
+
+	// Already marked
+	if i < j {
+		return
+	}
+
+	if j == p.first[b] {
+		p.touched = append(p.touched, b)
+	}
+
+	// Move the state to the marked section of the block
+	p.elems[i], p.elems[j] = p.elems[j], p.elems[i]
+	p.loc[p.elems[i]] = i
+	p.loc[p.elems[j]] = j
+	p.mid[b]++
+}
+
+// Split all touched blocks into marked and unmarked states
+// and call the handler for every new block with its origin.
+func (p *partition) split(handle func(b, nb int)) {
+	for _, b := range p.touched {
+		m := p.mid[b]
+
+		// All states are marked - nothing to split
+		if m == p.end[b] {
+			p.mid[b] = p.first[b]
+			continue
+		}
+
+		// Create a new block for the marked states
+		nb := len(p.first)
+		p.first = append(p.first, p.first[b])
+		p.end = append(p.end, m)
+		p.mid = append(p.mid, p.first[b])
+		p.first[b] = m
+		p.mid[b] = m
+
+		for i := p.first[nb]; i < p.end[nb]; i++ {
+			p.block[p.elems[i]] = nb
+		}
+
+		handle(b, nb)
+	}
+	p.touched = p.touched[:0]
+}
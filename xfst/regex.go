@@ -0,0 +1,684 @@
+package xfst
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Token types of the regular expression lexer
+type tokenType int
+
+const (
+	tEOF        tokenType = iota
+	tName                 // Bare symbol or definition name
+	tSymbol               // Quoted or escaped symbol
+	tString               // Symbols in curly braces
+	tTxt                  // Word list file
+	tEpsilon              // 0
+	tAny                  // ?
+	tBoundary             // .#.
+	tLBracket             // [
+	tRBracket             // ]
+	tLParen               // (
+	tRParen               // )
+	tUnion                // |
+	tIntersect            // &
+	tMinus                // -
+	tComplement           // ~
+	tTermComp             // \
+	tContains             // $
+	tStar                 // *
+	tPlus                 // +
+	tRepeat               // ^n, ^{n,m}
+	tColon                // :
+	tCompose              // .o.
+	tCross                // .x.
+	tUpper                // .u
+	tLower                // .l
+	tInvert               // .i
+	tReverse              // .r
+	tComma                // ,
+	tSemicolon            // ;
+	tUnderscore           // _
+	tDots                 // ...
+	tArrow                // ->, (->), @->, @>, ->@, >@
+	tContext              // ||, //, \\, \/
+)
+
+// A token of a regular expression
+type token struct {
+	typ   tokenType
+	val   string
+	arrow arrow
+	side  contextSide
+	min   int
+	max   int // -1 for unbounded
+	line  int
+}
+
+// Error in a regular expression or script
+type parseError struct {
+	file string
+	line int
+	msg  string
+}
+
+func (e *parseError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.file, e.line, e.msg)
+}
+
+// lexer splits a script into tokens
+type lexer struct {
+	file string
+	src  []rune
+	pos  int
+	line int
+	peek *token
+}
+
+func newLexer(file, src string) *lexer {
+	return &lexer{file: file, src: []rune(src), line: 1}
+}
+
+// Raise a parse error
+func (l *lexer) fail(format string, args ...interface{}) {
+	panic(&parseError{file: l.file, line: l.line, msg: fmt.Sprintf(format, args...)})
+}
+
+// Returns true, if the position starts with the string
+func (l *lexer) has(s string) bool {
+	i := l.pos
+	for _, r := range s {
+		if i >= len(l.src) || l.src[i] != r {
+			return false
+		}
+		i++
+	}
+	return true
+}
+
+// Characters that can't be part of a bare symbol
+func isSpecial(r rune) bool {
+	return unicode.IsSpace(r) || strings.ContainsRune("[]()|&-~\\$+*^:;,_.?\"{}%!@<>/=#", r)
+}
+
+// Skip whitespace and comments
+func (l *lexer) skip() {
+	lineStart := l.pos == 0 || l.src[l.pos-1] == '\n'
+	for l.pos < len(l.src) {
+		r := l.src[l.pos]
+		if r == '\n' {
+			l.line++
+			l.pos++
+			lineStart = true
+		} else if unicode.IsSpace(r) {
+			l.pos++
+		} else if r == '!' || (r == '#' && lineStart) {
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		} else {
+			return
+		}
+	}
+}
+
+// Returns the rest of the current line
+func (l *lexer) restOfLine() string {
+	start := l.pos
+	for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+		l.pos++
+	}
+	return strings.TrimSpace(string(l.src[start:l.pos]))
+}
+
+// Peek at the next token
+func (l *lexer) lookahead() *token {
+	if l.peek == nil {
+		t := l.scan()
+		l.peek = &t
+	}
+	return l.peek
+}
+
+// Return the next token
+func (l *lexer) next() token {
+	if l.peek != nil {
+		t := *l.peek
+		l.peek = nil
+		return t
+	}
+	return l.scan()
+}
+
+// Expect a token of a certain type
+func (l *lexer) expect(typ tokenType, desc string) token {
+	t := l.next()
+	if t.typ != typ {
+		l.fail("Expected %s", desc)
+	}
+	return t
+}
+
+// Read a number
+func (l *lexer) number() int {
+	start := l.pos
+	for l.pos < len(l.src) && unicode.IsDigit(l.src[l.pos]) {
+		l.pos++
+	}
+	if start == l.pos {
+		l.fail("Expected number")
+	}
+	n, _ := strconv.Atoi(string(l.src[start:l.pos]))
+	return n
+}
+
+// Read a quoted string with escape sequences
+func (l *lexer) quoted() string {
+	var sb strings.Builder
+	l.pos++
+	for {
+		if l.pos >= len(l.src) || l.src[l.pos] == '\n' {
+			l.fail("Unterminated quote")
+		}
+		r := l.src[l.pos]
+		l.pos++
+		if r == '"' {
+			return sb.String()
+		}
+		if r == '\\' && l.pos < len(l.src) {
+			e := l.src[l.pos]
+			l.pos++
+			switch e {
+			case 'u':
+				if l.pos+4 > len(l.src) {
+					l.fail("Invalid unicode escape")
+				}
+				n, err := strconv.ParseUint(string(l.src[l.pos:l.pos+4]), 16, 32)
+				if err != nil {
+					l.fail("Invalid unicode escape")
+				}
+				l.pos += 4
+				sb.WriteRune(rune(n))
+			case 'n':
+				sb.WriteRune('\n')
+			case 't':
+				sb.WriteRune('\t')
+			default:
+				sb.WriteRune(e)
+			}
+			continue
+		}
+		sb.WriteRune(r)
+	}
+}
+
+// Scan the next token
+func (l *lexer) scan() token {
+	l.skip()
+	t := token{line: l.line}
+	if l.pos >= len(l.src) {
+		t.typ = tEOF
+		return t
+	}
+
+	// Multi character operators
+	ops := []struct {
+		s     string
+		typ   tokenType
+		arrow arrow
+		side  contextSide
+	}{
+		{"(@->)", tArrow, arrowLeftLongest, 0},
+		{"(->)", tArrow, arrowOptional, 0},
+		{"@->", tArrow, arrowLeftLongest, 0},
+		{"->@", tArrow, arrowRightLongest, 0},
+		{"->", tArrow, arrowObligatory, 0},
+		{"@>", tArrow, arrowLeftShortest, 0},
+		{">@", tArrow, arrowRightShortest, 0},
+		{"||", tContext, 0, sideUpper},
+		{"//", tContext, 0, sideLeftLower},
+		{"\\\\", tContext, 0, sideRightLower},
+		{"\\/", tContext, 0, sideLower},
+		{".#.", tBoundary, 0, 0},
+		{".o.", tCompose, 0, 0},
+		{".x.", tCross, 0, 0},
+		{"...", tDots, 0, 0},
+		{".u", tUpper, 0, 0},
+		{".l", tLower, 0, 0},
+		{".i", tInvert, 0, 0},
+		{".r", tReverse, 0, 0},
+	}
+	for _, op := range ops {
+		if l.has(op.s) {
+			if op.s == "(@->)" {
+				l.fail("Optional directed replacement is not supported")
+			}
+			l.pos += len([]rune(op.s))
+			t.typ, t.arrow, t.side, t.val = op.typ, op.arrow, op.side, op.s
+			return t
+		}
+	}
+
+	r := l.src[l.pos]
+	single := map[rune]tokenType{
+		'[': tLBracket, ']': tRBracket, '(': tLParen, ')': tRParen,
+		'|': tUnion, '&': tIntersect, '-': tMinus, '~': tComplement,
+		'\\': tTermComp, '$': tContains, '*': tStar, '+': tPlus,
+		':': tColon, ',': tComma, ';': tSemicolon, '_': tUnderscore,
+		'?': tAny,
+	}
+	if typ, ok := single[r]; ok {
+		l.pos++
+		t.typ = typ
+		return t
+	}
+
+	switch r {
+	case '^':
+		l.pos++
+		t.typ = tRepeat
+		if l.pos < len(l.src) && l.src[l.pos] == '{' {
+			l.pos++
+			t.min = l.number()
+			t.max = t.min
+			if l.has(",") {
+				l.pos++
+				if l.has("}") {
+					t.max = -1
+				} else {
+					t.max = l.number()
+				}
+			}
+			if !l.has("}") {
+				l.fail("Expected }")
+			}
+			l.pos++
+		} else {
+			t.min = l.number()
+			t.max = t.min
+		}
+		return t
+
+	case '%':
+		if l.pos+1 >= len(l.src) {
+			l.fail("Unterminated escape")
+		}
+		t.typ = tSymbol
+		t.val = string(l.src[l.pos+1])
+		l.pos += 2
+		return t
+
+	case '"':
+		t.typ = tSymbol
+		t.val = l.quoted()
+		if t.val == "" {
+			l.fail("Empty symbol")
+		}
+		return t
+
+	case '{':
+		var sb strings.Builder
+		l.pos++
+		for {
+			if l.pos >= len(l.src) {
+				l.fail("Unterminated braces")
+			}
+			c := l.src[l.pos]
+			l.pos++
+			if c == '}' {
+				break
+			} else if c == '%' && l.pos < len(l.src) {
+				c = l.src[l.pos]
+				l.pos++
+			}
+			sb.WriteRune(c)
+		}
+		t.typ = tString
+		t.val = sb.String()
+		return t
+
+	case '@':
+		if l.has("@txt") {
+			l.pos += 4
+			l.skip()
+			if !l.has("\"") {
+				l.fail("Expected file name")
+			}
+			t.typ = tTxt
+			t.val = l.quoted()
+			return t
+		}
+	}
+
+	if isSpecial(r) {
+		l.fail("Unexpected character '%c'", r)
+	}
+
+	start := l.pos
+	for l.pos < len(l.src) && !isSpecial(l.src[l.pos]) {
+		l.pos++
+	}
+	t.val = string(l.src[start:l.pos])
+	if t.val == "0" {
+		t.typ = tEpsilon
+	} else {
+		t.typ = tName
+	}
+	return t
+}
+
+// parser compiles regular expressions to networks
+type parser struct {
+	*lexer
+	c *Compiler
+}
+
+// Returns true, if the token can start an expression
+func startsExpr(t *token) bool {
+	switch t.typ {
+	case tName, tSymbol, tString, tTxt, tEpsilon, tAny, tBoundary,
+		tLBracket, tLParen, tComplement, tTermComp, tContains:
+		return true
+	}
+	return false
+}
+
+// Parse a regular expression up to the semicolon
+func (p *parser) regex() *fsm {
+	f := p.compose()
+	p.expect(tSemicolon, ";")
+	return f
+}
+
+// Composition and cross product have the lowest precedence
+func (p *parser) compose() *fsm {
+	f := p.rule()
+	for {
+		switch p.lookahead().typ {
+		case tCompose:
+			p.next()
+			f = compose(f, p.rule())
+		case tCross:
+			p.next()
+			f = crossProduct(f, p.rule())
+		default:
+			return f
+		}
+	}
+}
+
+// Parse replacement rules
+func (p *parser) rule() *fsm {
+	upper := p.union()
+	if p.lookahead().typ != tArrow {
+		return upper
+	}
+
+	var reps []*replacement
+	kind := p.lookahead().arrow
+	for {
+		t := p.next()
+		if t.arrow != kind {
+			p.fail("Parallel rules need to have the same arrow")
+		}
+
+		r := &replacement{upper: upper}
+		if p.lookahead().typ == tDots {
+			p.next()
+			r.markup = true
+			if startsExpr(p.lookahead()) {
+				r.post = p.union()
+			}
+		} else {
+			r.lower = p.union()
+			if p.lookahead().typ == tDots {
+				p.next()
+				r.markup = true
+				r.pre, r.lower = r.lower, nil
+				if startsExpr(p.lookahead()) {
+					r.post = p.union()
+				}
+			}
+		}
+		reps = append(reps, r)
+
+		if p.lookahead().typ != tComma {
+			break
+		}
+		p.next()
+		upper = p.union()
+		if p.lookahead().typ != tArrow {
+			p.fail("Expected replacement arrow")
+		}
+	}
+
+	// Parse contexts
+	var ctxs []*context
+	side := sideUpper
+	if p.lookahead().typ == tContext {
+		side = p.next().side
+		for {
+			c := &context{}
+			if startsExpr(p.lookahead()) {
+				c.left = p.union()
+			}
+			if p.lookahead().typ == tUnderscore {
+				p.next()
+				if startsExpr(p.lookahead()) {
+					c.right = p.union()
+				}
+			} else {
+
+				// A context without placeholder restricts the right side
+				c.left, c.right = nil, c.left
+			}
+			ctxs = append(ctxs, c)
+			if p.lookahead().typ != tComma {
+				break
+			}
+			p.next()
+		}
+	}
+
+	return replace(p.c.sigma, kind, reps, ctxs, side)
+}
+
+// Union, intersection and difference share the same precedence
+func (p *parser) union() *fsm {
+	f := p.concat()
+	var alts []*fsm
+	for {
+		switch p.lookahead().typ {
+		case tUnion:
+			p.next()
+			alts = append(alts, p.concat())
+			continue
+		case tIntersect, tMinus:
+		default:
+			if len(alts) > 0 {
+				f = union(append([]*fsm{f}, alts...)...)
+			}
+			return f
+		}
+
+		if len(alts) > 0 {
+			f = union(append([]*fsm{f}, alts...)...)
+			alts = nil
+		}
+		if p.next().typ == tIntersect {
+			f = intersect(f, p.concat())
+		} else {
+			f = minus(f, p.concat())
+		}
+	}
+}
+
+// Concatenation of expressions
+func (p *parser) concat() *fsm {
+	parts := []*fsm{p.prefix()}
+	for startsExpr(p.lookahead()) {
+		parts = append(parts, p.prefix())
+	}
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	return concat(parts...)
+}
+
+// Complement and containment
+func (p *parser) prefix() *fsm {
+	switch p.lookahead().typ {
+	case tComplement:
+		p.next()
+		return complement(p.prefix())
+	case tContains:
+		p.next()
+		f := p.prefix()
+		u := universalFSM(p.c.sigma, nil)
+		return concat(u, f, u)
+	}
+	return p.postfix()
+}
+
+// Postfix operators
+func (p *parser) postfix() *fsm {
+	f := p.colon()
+	for {
+		t := p.lookahead()
+		switch t.typ {
+		case tStar:
+			f = star(f)
+		case tPlus:
+			f = plus(f)
+		case tRepeat:
+			f = repeat(f, t.min, t.max)
+		case tUpper:
+			f = project(f, true)
+		case tLower:
+			f = project(f, false)
+		case tInvert:
+			f = invert(f)
+		case tReverse:
+			f = reverse(f)
+		default:
+			return f
+		}
+		p.next()
+	}
+}
+
+// Repeat a network between min and max times
+func repeat(f *fsm, min, max int) *fsm {
+	parts := []*fsm{epsilonFSM(f.sigma)}
+	for i := 0; i < min; i++ {
+		parts = append(parts, f)
+	}
+	if max == -1 {
+		parts = append(parts, star(f))
+	} else {
+		opt := optional(f)
+		for i := min; i < max; i++ {
+			parts = append(parts, opt)
+		}
+	}
+	return concat(parts...)
+}
+
+// Cross product of symbols
+func (p *parser) colon() *fsm {
+	start := p.lookahead().typ
+	upper := p.termComplement()
+	if p.lookahead().typ != tColon {
+		return upper
+	}
+	p.next()
+	end := p.lookahead().typ
+	lower := p.termComplement()
+
+	// Cross product of single symbols keeps unknown symbols
+	if (start == tAny || start == tEpsilon) && (end == tAny || end == tEpsilon) {
+		in, out := EPSILON, EPSILON
+		if start == tAny {
+			in = UNKNOWN
+		}
+		if end == tAny {
+			out = UNKNOWN
+		}
+		if in == EPSILON && out == EPSILON {
+			return epsilonFSM(p.c.sigma)
+		}
+		return symbolFSM(p.c.sigma, in, out)
+	}
+	return crossProduct(upper, lower)
+}
+
+// Term complement has the highest precedence
+func (p *parser) termComplement() *fsm {
+	if p.lookahead().typ == tTermComp {
+		p.next()
+		return termComplement(p.termComplement())
+	}
+	return p.atom()
+}
+
+// Parse an atomic expression
+func (p *parser) atom() *fsm {
+	t := p.next()
+	sigma := p.c.sigma
+	switch t.typ {
+	case tLBracket:
+		if p.lookahead().typ == tRBracket {
+			p.next()
+			return epsilonFSM(sigma)
+		}
+		f := p.compose()
+		p.expect(tRBracket, "]")
+		return f
+
+	case tLParen:
+		f := p.compose()
+		p.expect(tRParen, ")")
+		return optional(f)
+
+	case tName:
+		if f, ok := p.c.defs[t.val]; ok {
+			return f
+		}
+		return symbolFSM(sigma, sigma.add(t.val), sigma.add(t.val))
+
+	case tSymbol:
+		id := sigma.add(t.val)
+		return symbolFSM(sigma, id, id)
+
+	case tString:
+		syms := make([]int, 0, len(t.val))
+		for _, r := range t.val {
+			syms = append(syms, sigma.add(string(r)))
+		}
+		return stringFSM(sigma, syms)
+
+	case tTxt:
+		f := p.c.wordList(t.val)
+		if f == nil {
+			p.fail("Unable to read word list %s", t.val)
+		}
+		return f
+
+	case tEpsilon:
+		return epsilonFSM(sigma)
+
+	case tAny:
+		return symbolFSM(sigma, IDENTITY, IDENTITY)
+
+	case tBoundary:
+		return symbolFSM(sigma, BOUNDARY, BOUNDARY)
+
+	case tEOF:
+		p.fail("Unexpected end of file")
+	}
+	p.fail("Unexpected token")
+	return nil
+}
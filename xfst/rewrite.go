@@ -0,0 +1,594 @@
+package xfst
+
+// The replacement rules are compiled following the general
+// approach of Hulden (2009): All possible segmentations of a
+// string into replaced and unchanged parts are generated as
+// "parse" strings with markers around replaced segments.
+// Parses violating the contexts are removed and, depending on
+// the kind of the rule, all parses that are worse than another
+// valid parse for the same input (e.g. not leftmost-longest)
+// are filtered out. Like in foma, a parse is only worse
+// regarding the segments of the same rule: A match of a rule
+// may not start inside of the segment of another rule and is
+// only longer or shorter than a segment of the same rule.
+
+// Kinds of replacement arrows
+type arrow int
+
+const (
+	arrowObligatory    arrow = iota // ->
+	arrowOptional                   // (->)
+	arrowLeftLongest                // @->
+	arrowLeftShortest               // @>
+	arrowRightLongest               // ->@
+	arrowRightShortest              // >@
+)
+
+// Sides the contexts of a rule are checked on
+type contextSide int
+
+const (
+	sideUpper      contextSide = iota // ||
+	sideLeftLower                     // //
+	sideRightLower                    // \\
+	sideLower                         // \/
+)
+
+// A single replacement of a (parallel) rule.
+// Markup rules have no lower side, but may have
+// a prefix and a suffix inserted around the match.
+type replacement struct {
+	upper     *fsm
+	lower     *fsm
+	pre, post *fsm
+	markup    bool
+}
+
+// A context of a rule. Nil means no restriction.
+type context struct {
+	left, right *fsm
+}
+
+// The alphabet of parse strings
+type parseAlphabet struct {
+	pairs  map[int64]int
+	labels [][2]int // Symbol pair of a parse symbol
+	marker []bool   // Parse symbol is a marker
+	close  int      // Marker for the end of a segment
+	open   []int    // Markers for the start of a segment per rule and context
+}
+
+// Create the parse alphabet with the markers
+func newParseAlphabet(markers int) *parseAlphabet {
+	pa := &parseAlphabet{
+		pairs:  make(map[int64]int),
+		labels: make([][2]int, 4),
+		marker: make([]bool, 4),
+	}
+	pa.close = pa.add(EPSILON, EPSILON, true)
+	for i := 0; i < markers; i++ {
+		pa.open = append(pa.open, pa.add(EPSILON, EPSILON, true))
+	}
+	return pa
+}
+
+// Add a new parse symbol
+func (pa *parseAlphabet) add(in, out int, marker bool) int {
+	id := len(pa.labels)
+	pa.labels = append(pa.labels, [2]int{in, out})
+	pa.marker = append(pa.marker, marker)
+	return id
+}
+
+// Return the parse symbol of a symbol pair
+func (pa *parseAlphabet) label(in, out int) int {
+	key := arc{in: in, out: out}.label()
+	if id, ok := pa.pairs[key]; ok {
+		return id
+	}
+	id := pa.add(in, out, false)
+	pa.pairs[key] = id
+	return id
+}
+
+// All symbols of the alphabet
+func (pa *parseAlphabet) symbols() []int {
+	syms := make([]int, 0, len(pa.labels)-4)
+	for p := 4; p < len(pa.labels); p++ {
+		syms = append(syms, p)
+	}
+	return syms
+}
+
+// Return the symbol on one side of a parse symbol,
+// with unknown symbols being represented by the identity
+// symbol and markers being epsilon
+func (pa *parseAlphabet) side(p int, upper bool) int {
+	if pa.marker[p] {
+		return EPSILON
+	}
+	sym := pa.labels[p][1]
+	if upper {
+		sym = pa.labels[p][0]
+	}
+	if sym == UNKNOWN {
+		return IDENTITY
+	}
+	return sym
+}
+
+// Encode a transducer as an acceptor of parse symbols
+func (pa *parseAlphabet) encode(f *fsm) *fsm {
+	g := &fsm{
+		arcs:  make([][]arc, len(f.arcs)),
+		final: append([]bool(nil), f.final...),
+	}
+	for s, as := range f.arcs {
+		for _, a := range as {
+			p := pa.label(a.in, a.out)
+			g.arcs[s] = append(g.arcs[s], arc{in: p, out: p, to: a.to})
+		}
+	}
+	return g.clean()
+}
+
+// Decode an acceptor of parse symbols as a transducer
+func (pa *parseAlphabet) decode(f *fsm, sigma *Sigma, known []int) *fsm {
+	g := &fsm{
+		sigma: sigma,
+		known: known,
+		arcs:  make([][]arc, len(f.arcs)),
+		final: append([]bool(nil), f.final...),
+	}
+	for s, as := range f.arcs {
+		for _, a := range as {
+			l := pa.labels[a.in]
+			g.arcs[s] = append(g.arcs[s], arc{in: l[0], out: l[1], to: a.to})
+		}
+	}
+	return g.clean()
+}
+
+// Acceptor of a single parse symbol
+func parseSymbol(syms ...int) *fsm {
+	f := &fsm{arcs: make([][]arc, 2), final: []bool{false, true}}
+	for _, p := range syms {
+		f.addArc(0, p, p, 1)
+	}
+	return f
+}
+
+// Acceptor of all strings of the given parse symbols
+func parseStar(syms []int) *fsm {
+	f := &fsm{arcs: make([][]arc, 1), final: []bool{true}}
+	for _, p := range syms {
+		f.addArc(0, p, p, 0)
+	}
+	return f
+}
+
+// lift turns a deterministic network over symbols into a complete
+// deterministic network over parse symbols, by following the
+// projection of every parse symbol. Parse symbols projected to
+// epsilon don't change the state.
+func lift(d *fsm, start int, alphabet []int, proj func(int) int, final func(int) bool) *fsm {
+	n := d.stateCount()
+	dead := n
+
+	// Swap the start state to the front
+	num := func(s int) int {
+		if s == -1 {
+			s = dead
+		}
+		if s == start {
+			return 0
+		} else if s == 0 {
+			return start
+		}
+		return s
+	}
+
+	g := &fsm{
+		arcs:  make([][]arc, n+1),
+		final: make([]bool, n+1),
+	}
+	for s := 0; s <= n; s++ {
+		g.final[num(s)] = s != dead && final(s)
+		for _, p := range alphabet {
+			t := dead
+			if s != dead {
+				if sym := proj(p); sym == EPSILON {
+					t = s
+				} else if next := d.next(s, sym, sym); next != -1 {
+					t = next
+				}
+			}
+			g.addArc(num(s), p, p, num(t))
+		}
+	}
+	return g
+}
+
+// Flip the final states of a complete deterministic network
+func flip(f *fsm) *fsm {
+	g := f.copy()
+	for s := range g.final {
+		g.final[s] = !g.final[s]
+	}
+	return g.clean()
+}
+
+// Universal language including the word boundary symbol
+func boundaryUniversal(sigma *Sigma, known []int) *fsm {
+	f := universalFSM(sigma, known)
+	f.addArc(0, BOUNDARY, BOUNDARY, 0)
+	return f
+}
+
+// replace compiles a (parallel) replacement rule
+// with optional contexts.
+func replace(sigma *Sigma, kind arrow, reps []*replacement, ctxs []*context, side contextSide) *fsm {
+
+	// Harmonize the symbols of all operands
+	var nets []*fsm
+	for _, r := range reps {
+		nets = append(nets, r.upper, r.lower, r.pre, r.post)
+	}
+	for _, c := range ctxs {
+		nets = append(nets, c.left, c.right)
+	}
+	var all []*fsm
+	for _, f := range nets {
+		if f != nil {
+			all = append(all, f)
+		}
+	}
+	all = harmonize(all...)
+	known := all[0].known
+
+	// Operands are used as languages,
+	// using the lower side of transducers
+	lang := func(f *fsm) *fsm {
+		if f == nil {
+			return nil
+		}
+		f = project(f.expand(known), false)
+		f.known = known
+		return f
+	}
+
+	rs := make([]*replacement, len(reps))
+	for i, r := range reps {
+		upper := lang(r.upper)
+
+		// Empty matches are ignored
+		if upper.acceptsEpsilon() {
+			upper = minus(upper, epsilonFSM(sigma))
+			upper.known = known
+		}
+		rs[i] = &replacement{
+			upper:  upper,
+			lower:  lang(r.lower),
+			pre:    lang(r.pre),
+			post:   lang(r.post),
+			markup: r.markup,
+		}
+	}
+
+	cs := make([]*context, len(ctxs))
+	for i, c := range ctxs {
+		cs[i] = &context{left: lang(c.left), right: lang(c.right)}
+	}
+
+	// Right-to-left rules are compiled as left-to-right
+	// rules on reversed strings
+	if kind == arrowRightLongest || kind == arrowRightShortest {
+		rev := func(f *fsm) *fsm {
+			if f == nil {
+				return nil
+			}
+			return reverse(f)
+		}
+		for _, r := range rs {
+			r.upper, r.lower = rev(r.upper), rev(r.lower)
+			r.pre, r.post = rev(r.post), rev(r.pre)
+		}
+		for _, c := range cs {
+			c.left, c.right = rev(c.right), rev(c.left)
+		}
+		if side == sideLeftLower {
+			side = sideRightLower
+		} else if side == sideRightLower {
+			side = sideLeftLower
+		}
+		if kind == arrowRightLongest {
+			kind = arrowLeftLongest
+		} else {
+			kind = arrowLeftShortest
+		}
+		return reverse(replace(sigma, kind, rs, cs, side))
+	}
+
+	if len(cs) == 0 {
+		cs = []*context{{}}
+	}
+
+	pa := newParseAlphabet(len(rs) * len(cs))
+
+	// Unchanged symbols
+	id := &fsm{arcs: make([][]arc, 2), final: []bool{false, true}}
+	id.addArc(0, pa.label(IDENTITY, IDENTITY), pa.label(IDENTITY, IDENTITY), 1)
+	for _, sym := range known {
+		if sym != BOUNDARY {
+			id.addArc(0, pa.label(sym, sym), pa.label(sym, sym), 1)
+		}
+	}
+
+	// Replaced segments
+	segs := []*fsm{id}
+	for i, r := range rs {
+		var seg *fsm
+		if r.markup {
+			parts := []*fsm{}
+			if r.pre != nil {
+				parts = append(parts, crossProduct(epsilonFSM(sigma), r.pre))
+			}
+			parts = append(parts, r.upper)
+			if r.post != nil {
+				parts = append(parts, crossProduct(epsilonFSM(sigma), r.post))
+			}
+			seg = concat(parts...)
+		} else {
+			seg = crossProduct(r.upper, r.lower)
+		}
+		seg = pa.encode(seg)
+
+		for k := range cs {
+			segs = append(segs, concat(
+				parseSymbol(pa.open[i*len(cs)+k]),
+				seg,
+				parseSymbol(pa.close),
+			))
+		}
+	}
+	valid := star(union(segs...))
+
+	alphabet := pa.symbols()
+	anything := parseStar(alphabet)
+	var nonmarker []int
+	for _, p := range alphabet {
+		if !pa.marker[p] {
+			nonmarker = append(nonmarker, p)
+		}
+	}
+
+	// Remove all parses violating the contexts
+	var bad, worse []*fsm
+	for k, c := range cs {
+		var opens []int
+		for i := range rs {
+			opens = append(opens, pa.open[i*len(cs)+k])
+		}
+
+		// Prefixes of parses, that satisfy the left context
+		left := anything
+		if c.left != nil && !(c.left.acceptsEpsilon() && c.left.arcCount() == 0) {
+			upper := side == sideUpper || side == sideRightLower
+			d := concat(boundaryUniversal(sigma, known), c.left)
+			start := d.next(0, BOUNDARY, BOUNDARY)
+			if start == -1 {
+				start = d.stateCount()
+			}
+			left = lift(d, start, alphabet, func(p int) int {
+				return pa.side(p, upper)
+			}, func(s int) bool {
+				return d.final[s]
+			})
+			bad = append(bad, concat(flip(left), parseSymbol(opens...), anything))
+		}
+
+		// Suffixes of parses, that satisfy the right context
+		// on the upper side
+		right := anything
+		if c.right != nil && !(c.right.acceptsEpsilon() && c.right.arcCount() == 0) {
+			d := concat(c.right, boundaryUniversal(sigma, known))
+			rightOf := func(upper bool) *fsm {
+				return lift(d, 0, alphabet, func(p int) int {
+					return pa.side(p, upper)
+				}, func(s int) bool {
+					t := d.next(s, BOUNDARY, BOUNDARY)
+					return t != -1 && d.final[t]
+				})
+			}
+			right = rightOf(true)
+			ok := right
+			if side == sideLeftLower || side == sideLower {
+				ok = rightOf(false)
+			}
+			bad = append(bad, concat(
+				anything,
+				parseSymbol(opens...),
+				parseStar(nonmarker),
+				parseSymbol(pa.close),
+				flip(ok),
+			))
+		}
+
+		if kind == arrowOptional {
+			continue
+		}
+
+		// A parse is worse than another parse, if both are identical
+		// up to a position, where the better parse has a segment
+		// matching any rule in the context. It suffices to check
+		// the better parses, that don't have further segments.
+		// The rules are checked separately with their own markers,
+		// which also keeps the intermediate networks small.
+		prefix := intersect(pa.outside(), left)
+
+		var modes []matchMode
+		switch kind {
+		case arrowLeftLongest:
+			modes = []matchMode{matchStart, matchLonger}
+		case arrowLeftShortest:
+			modes = []matchMode{matchStart, matchShorter}
+		case arrowObligatory:
+			modes = []matchMode{matchMissed}
+		}
+		for _, mode := range modes {
+			rest := right
+			if mode == matchShorter {
+				rest = intersect(rest, pa.stillInside())
+			}
+			for i, r := range rs {
+				worse = append(worse, concatNFA(prefix, pa.match(r.upper, mode, opens[i]), rest))
+			}
+		}
+	}
+	if len(bad) > 0 {
+		valid = minus(valid, union(bad...))
+	}
+	for _, w := range worse {
+		valid = subtract(valid, w)
+	}
+
+	return pa.decode(valid, sigma, known)
+}
+
+// Modes of how a segment of a better parse is matched
+// against a worse parse
+type matchMode int
+
+const (
+	matchStart   matchMode = iota // The worse parse has no segment of the rule starting here
+	matchLonger                   // The worse parse has a shorter segment
+	matchShorter                  // The worse parse has a longer segment
+	matchMissed                   // The worse parse is unchanged here
+)
+
+// Parses ending outside of segments
+func (pa *parseAlphabet) outside() *fsm {
+	f := &fsm{arcs: make([][]arc, 2), final: []bool{true, false}}
+	for p := 4; p < len(pa.labels); p++ {
+		switch {
+		case p == pa.close:
+			f.addArc(1, p, p, 0)
+		case pa.marker[p]:
+			f.addArc(0, p, p, 1)
+		default:
+			f.addArc(0, p, p, 0)
+			f.addArc(1, p, p, 1)
+		}
+	}
+	return f
+}
+
+// Parses continuing a segment with at least
+// one upper symbol before the segment ends
+func (pa *parseAlphabet) stillInside() *fsm {
+	f := &fsm{arcs: make([][]arc, 3), final: []bool{false, false, true}}
+	for p := 4; p < len(pa.labels); p++ {
+		switch {
+		case p == pa.close:
+			f.addArc(1, p, p, 2)
+		case pa.marker[p]:
+		case pa.side(p, true) == EPSILON:
+			f.addArc(0, p, p, 0)
+			f.addArc(1, p, p, 1)
+		default:
+			f.addArc(0, p, p, 1)
+			f.addArc(1, p, p, 1)
+		}
+		f.addArc(2, p, p, 2)
+	}
+	return f
+}
+
+// match returns the parses, whose upper side starts with a match
+// of the deterministic network, regarding the mode.
+// Segments of the worse parse are only compared, if they
+// start with the open marker of the matching rule.
+// The parses end with the match.
+func (pa *parseAlphabet) match(d *fsm, mode matchMode, open int) *fsm {
+	const (
+		initial = iota // Before the segment
+		inside         // Inside the segment of the worse parse
+		after          // After the segment of the worse parse
+		beyond         // The match extends the segment of the worse parse
+	)
+
+	f := &fsm{}
+	type key struct{ s, phase int }
+	states := make(map[key]int)
+	var queue []key
+	state := func(s, phase int) int {
+		k := key{s, phase}
+		if id, ok := states[k]; ok {
+			return id
+		}
+		id := f.addState()
+		states[k] = id
+		queue = append(queue, k)
+		f.final[id] = d.final[s] && ((phase == beyond) ||
+			(phase == inside && mode != matchLonger))
+		return id
+	}
+
+	start := initial
+	if mode == matchStart || mode == matchMissed {
+		start = inside
+	}
+	state(0, initial)
+
+	for i := 0; i < len(queue); i++ {
+		k := queue[i]
+		from := states[k]
+		for p := 4; p < len(pa.labels); p++ {
+			to := -1
+			sym := pa.side(p, true)
+
+			switch {
+			case k.phase == initial && start == inside:
+				if !pa.marker[p] && sym != EPSILON {
+					if t := d.next(k.s, sym, sym); t != -1 {
+						to = state(t, inside)
+					}
+				}
+
+			case k.phase == initial:
+				if p == open {
+					to = state(k.s, inside)
+				}
+
+			case pa.marker[p]:
+				switch {
+				case mode == matchShorter || mode == matchMissed:
+				case mode == matchStart && p != open && p != pa.close:
+				case k.phase == inside && mode == matchLonger:
+					if p == pa.close {
+						to = state(k.s, after)
+					}
+				default:
+					to = from
+				}
+
+			case sym == EPSILON:
+				to = from
+
+			default:
+				phase := k.phase
+				if phase == after {
+					phase = beyond
+				}
+				if t := d.next(k.s, sym, sym); t != -1 {
+					to = state(t, phase)
+				}
+			}
+
+			if to != -1 {
+				f.addArc(from, p, p, to)
+			}
+		}
+	}
+	return f
+}
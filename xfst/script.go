@@ -0,0 +1,201 @@
+package xfst
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Compiler compiles xfst scripts as used by the
+// tokenizer sources. It supports the commands
+// define, source, echo and read regex, operating
+// on a shared symbol table.
+type Compiler struct {
+
+	// Dir is the base directory for relative paths
+	// in source commands and word lists. Defaults to
+	// the working directory, like in foma.
+	Dir string
+
+	// Echo receives the output of echo commands
+	Echo io.Writer
+
+	sigma *Sigma
+	defs  map[string]*fsm
+	stack []*fsm
+}
+
+// NewCompiler creates a new compiler with an empty
+// set of definitions.
+func NewCompiler() *Compiler {
+	return &Compiler{
+		sigma: newSigma(),
+		defs:  make(map[string]*fsm),
+	}
+}
+
+// Resolve a path relative to the base directory
+func (c *Compiler) path(file string) string {
+	if c.Dir == "" || filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(c.Dir, file)
+}
+
+// SourceFile compiles a script from a file.
+// The path is resolved relative to the base directory.
+func (c *Compiler) SourceFile(file string) bool {
+	f, err := os.Open(c.path(file))
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	defer f.Close()
+
+	return c.Source(file, f)
+}
+
+// Source compiles a script from a reader.
+// The name is used for error messages.
+func (c *Compiler) Source(name string, r io.Reader) (ok bool) {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			perr, isParseErr := r.(*parseError)
+			if !isParseErr {
+				panic(r)
+			}
+			log.Println(perr)
+			ok = false
+		}
+	}()
+
+	c.script(newLexer(name, string(src)))
+	return true
+}
+
+// Run the commands of a script
+func (c *Compiler) script(l *lexer) {
+	p := &parser{lexer: l, c: c}
+	for {
+		t := l.next()
+		switch t.typ {
+		case tEOF:
+			return
+		case tSemicolon:
+			continue
+		case tName:
+		default:
+			l.fail("Expected command")
+		}
+
+		switch t.val {
+		case "define":
+			name := l.expect(tName, "definition name")
+			if l.has("(") {
+				l.fail("Function definitions are not supported")
+			}
+			c.defs[name.val] = p.regex().clean()
+
+		case "source":
+			file := l.restOfLine()
+			if !c.SourceFile(file) {
+				l.fail("Unable to source %s", file)
+			}
+
+		case "echo":
+			text := l.restOfLine()
+			if c.Echo != nil {
+				fmt.Fprintln(c.Echo, text)
+			}
+
+		case "read":
+			if w := l.expect(tName, "regex"); w.val != "regex" {
+				l.fail("Unsupported command read %s", w.val)
+			}
+			fallthrough
+
+		case "regex":
+			c.stack = append(c.stack, p.regex().clean())
+
+		default:
+			l.fail("Unsupported command %s", t.val)
+		}
+	}
+}
+
+// Compile a word list with one word per line
+func (c *Compiler) wordList(file string) *fsm {
+	f, err := os.Open(c.path(file))
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	defer f.Close()
+
+	net := newFSM(c.sigma, nil)
+	children := []map[int]int{{}}
+	var known []int
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		word := strings.TrimRight(scanner.Text(), "\r")
+		if word == "" {
+			continue
+		}
+
+		// Add the word as a path to the trie
+		s := 0
+		for _, r := range word {
+			sym := c.sigma.add(string(r))
+			known = append(known, sym)
+			t, ok := children[s][sym]
+			if !ok {
+				t = net.addState()
+				children = append(children, map[int]int{})
+				children[s][sym] = t
+				net.addArc(s, sym, sym, t)
+			}
+			s = t
+		}
+		net.final[s] = true
+	}
+	if err := scanner.Err(); err != nil {
+		log.Println(err)
+		return nil
+	}
+	net.known = uniqInts(known)
+	return net.clean()
+}
+
+// Net returns the network on top of the stack,
+// or nil if no regex was read.
+func (c *Compiler) Net() *Net {
+	if len(c.stack) == 0 {
+		return nil
+	}
+	return &Net{f: c.stack[len(c.stack)-1]}
+}
+
+// Compile compiles an xfst script file and returns
+// the network of the last read regex.
+func Compile(file, dir string) *Net {
+	c := NewCompiler()
+	c.Dir = dir
+	if !c.SourceFile(file) {
+		return nil
+	}
+	net := c.Net()
+	if net == nil {
+		log.Println("No regex defined in", file)
+	}
+	return net
+}
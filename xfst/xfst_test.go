@@ -0,0 +1,229 @@
+package xfst
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/KorAP/datok"
+	"github.com/stretchr/testify/assert"
+)
+
+// Compile a script and return the top network
+func tmatch(t *testing.T, script string) *fsm {
+	c := NewCompiler()
+	assert.True(t, c.Source("test", strings.NewReader(script)))
+	net := c.Net()
+	assert.NotNil(t, net)
+	return net.f
+}
+
+// Apply a string to the upper side of the network
+// and return all lower side results
+func applyDown(f *fsm, input string) []string {
+	var syms []int
+	var chars []string
+	for _, r := range input {
+		id, ok := f.sigma.ids[string(r)]
+		if !ok || !containsInt(f.known, id) {
+			id = -1
+		}
+		syms = append(syms, id)
+		chars = append(chars, string(r))
+	}
+
+	res := map[string]bool{}
+	var walk func(s, pos int, out string, depth int)
+	walk = func(s, pos int, out string, depth int) {
+		if depth > 4*len(syms)+20 {
+			return
+		}
+		if pos == len(syms) && f.final[s] {
+			res[out] = true
+		}
+		for _, a := range f.arcs[s] {
+			lower := ""
+			if a.out > IDENTITY {
+				lower = f.sigma.symbols[a.out]
+			}
+			if a.in == EPSILON {
+				walk(a.to, pos, out+lower, depth+1)
+				continue
+			}
+			if pos >= len(syms) {
+				continue
+			}
+			x := syms[pos]
+			switch {
+			case x == -1 && a.in == IDENTITY:
+				walk(a.to, pos+1, out+chars[pos], depth+1)
+			case x == -1 && a.in == UNKNOWN:
+				if a.out == UNKNOWN {
+					lower = "?"
+				}
+				walk(a.to, pos+1, out+lower, depth+1)
+			case x != -1 && a.in == x:
+				walk(a.to, pos+1, out+lower, depth+1)
+			}
+		}
+	}
+	walk(0, 0, "", 0)
+
+	var list []string
+	for s := range res {
+		list = append(list, s)
+	}
+	sort.Strings(list)
+	return list
+}
+
+func containsInt(set []int, x int) bool {
+	i := sort.SearchInts(set, x)
+	return i < len(set) && set[i] == x
+}
+
+func TestRegexBasics(t *testing.T) {
+	assert := assert.New(t)
+
+	f := tmatch(t, "read regex [a|b]+ c ;")
+	assert.Equal([]string{"abc"}, applyDown(f, "abc"))
+	assert.Empty(applyDown(f, "c"))
+
+	f = tmatch(t, "define A a | b ;\nread regex ?* - [?* A ?*] ;")
+	assert.Equal([]string{"xyz"}, applyDown(f, "xyz"))
+	assert.Empty(applyDown(f, "xaz"))
+
+	f = tmatch(t, "read regex {ab} | %! | \"\\u00e4\" ;")
+	assert.Equal([]string{"ab"}, applyDown(f, "ab"))
+	assert.Equal([]string{"!"}, applyDown(f, "!"))
+	assert.Equal([]string{"ä"}, applyDown(f, "ä"))
+
+	f = tmatch(t, "read regex a:b c:0 ;")
+	assert.Equal([]string{"b"}, applyDown(f, "ac"))
+
+	f = tmatch(t, "read regex [a:b] .o. [b:c] ;")
+	assert.Equal([]string{"c"}, applyDown(f, "a"))
+
+	f = tmatch(t, "read regex a^{2,3} ;")
+	assert.Empty(applyDown(f, "a"))
+	assert.Equal([]string{"aaa"}, applyDown(f, "aaa"))
+	assert.Empty(applyDown(f, "aaaa"))
+
+	f = tmatch(t, "read regex \\a* ;")
+	assert.Equal([]string{"bcx"}, applyDown(f, "bcx"))
+	assert.Empty(applyDown(f, "bax"))
+
+	f = tmatch(t, "read regex ~$a & [b|c]* ;")
+	assert.Equal([]string{"bc"}, applyDown(f, "bc"))
+}
+
+func TestRegexReplace(t *testing.T) {
+	assert := assert.New(t)
+
+	f := tmatch(t, "read regex a -> b ;")
+	assert.Equal([]string{"bxb"}, applyDown(f, "axa"))
+
+	f = tmatch(t, "read regex a -> b || x _ ;")
+	assert.Equal([]string{"axb"}, applyDown(f, "axa"))
+
+	f = tmatch(t, "read regex a -> b || .#. _ ;")
+	assert.Equal([]string{"bxa"}, applyDown(f, "axa"))
+
+	f = tmatch(t, "read regex a -> b || _ .#. ;")
+	assert.Equal([]string{"axb"}, applyDown(f, "axa"))
+
+	f = tmatch(t, "read regex a (->) b ;")
+	assert.Equal([]string{"a", "b"}, applyDown(f, "a"))
+
+	f = tmatch(t, "read regex a+ @-> x ;")
+	assert.Equal([]string{"bxbx"}, applyDown(f, "baaba"))
+
+	f = tmatch(t, "read regex a+ @> x ;")
+	assert.Equal([]string{"xxbx"}, applyDown(f, "aaba"))
+
+	f = tmatch(t, "read regex [a b | b c] @-> x ;")
+	assert.Equal([]string{"xc"}, applyDown(f, "abc"))
+
+	f = tmatch(t, "read regex [a b | b c] ->@ x ;")
+	assert.Equal([]string{"ax"}, applyDown(f, "abc"))
+
+	f = tmatch(t, "read regex [a | a b] @-> %[ ... %] ;")
+	assert.Equal([]string{"[ab]c[a]"}, applyDown(f, "abca"))
+
+	// Like in foma, matches of parallel rules are only
+	// compared with the segments of the same rule
+	f = tmatch(t, "read regex {ab} @-> x , {bcd} @-> y , {abc} @-> z ;")
+	assert.Equal([]string{"ay", "xcd", "zd"}, applyDown(f, "abcd"))
+	assert.Equal([]string{"xy"}, applyDown(f, "abbcd"))
+
+	f = tmatch(t, "read regex a -> b, b -> a ;")
+	assert.Equal([]string{"ba"}, applyDown(f, "ab"))
+
+	f = tmatch(t, "read regex a -> b || x _ , _ y ;")
+	assert.Equal([]string{"xbbya"}, applyDown(f, "xaaya"))
+}
+
+// Tokenize a text with token and sentence boundaries
+func ttokenize(tok datok.Tokenizer, text string) string {
+	var w bytes.Buffer
+	tw := datok.NewTokenWriter(&w, datok.TOKENS|datok.SENTENCES|datok.TOKEN_POS)
+	tok.TransduceTokenWriter(strings.NewReader(text), tw)
+	tw.Flush()
+	return w.String()
+}
+
+// Compare the compiled tokenizer with a shipped tokenizer
+func tcompare(t *testing.T, file, dir, matok string, texts []string) {
+	net := Compile(file, dir)
+	if !assert.NotNil(t, net) {
+		return
+	}
+	auto := net.Automaton()
+	if !assert.NotNil(t, auto) {
+		return
+	}
+	mat := auto.ToMatrix()
+	ref := datok.LoadMatrixFile(matok)
+	assert.NotNil(t, ref)
+
+	for _, text := range texts {
+		assert.Equal(t, ttokenize(ref, text), ttokenize(mat, text), text)
+	}
+}
+
+func TestCompileClitics(t *testing.T) {
+	tcompare(t, "../testdata/clitic_test.xfst", "", "../testdata/clitic_test.matok", []string{
+		"ibauamt",
+		"dead. ",
+		"they're They're their don't wouldn't",
+		"I'll ... We'd've! Isn't it? Doesn't it. Nan't",
+		"'t don dt. hadn't'n",
+	})
+}
+
+func TestCompileTokenizerDe(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping full tokenizer build in short mode")
+	}
+	tcompare(t, "de/tokenizer.xfst", "../src", "../testdata/tokenizer_de.matok", []string{
+		"Der alte Mann ging über die Straße. Er war 3.5 km weit gekommen!",
+		"Mach's gut, z.B. am 01.01.2022 um 12:30 Uhr.",
+		"Die URL ist https://korap.ids-mannheim.de/?q=Baum&ql=poliqarp und die Adresse info@example.org.",
+		"Er sagte: \"Das ist ja (nicht) alles...\"",
+		"Wir zahlen 12,50 € bzw. $3 pro Stück #hashtag @user :-)",
+	})
+}
+
+func TestCompileTokenizerEn(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping full tokenizer build in short mode")
+	}
+	tcompare(t, "en/tokenizer.xfst", "../src", "../testdata/tokenizer_en.matok", []string{
+		"It's a U.S. based company, isn't it?",
+		"Mr. Smith doesn't live at 123 Main St. anymore.",
+		"Visit http://example.com or mail john.doe@example.com!",
+		"I'd've paid $3.50 for that... (maybe).",
+		"The 1990s weren't bad :)",
+	})
+}
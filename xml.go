@@ -0,0 +1,254 @@
+package datok
+
+import (
+	"strings"
+	"unicode"
+)
+
+// States of the XML filter
+const (
+	xmlText = iota
+	xmlMarkup
+	xmlTag
+	xmlComment
+	xmlCDATA
+	xmlPI
+	xmlDecl
+	xmlRaw
+)
+
+// Elements separating blocks of text
+var xmlBlocks = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true,
+	"body": true, "caption": true, "dd": true, "div": true, "dl": true,
+	"dt": true, "figcaption": true, "figure": true, "footer": true,
+	"form": true, "h1": true, "h2": true, "h3": true, "h4": true,
+	"h5": true, "h6": true, "head": true, "header": true, "hr": true,
+	"html": true, "item": true, "l": true, "lg": true, "li": true,
+	"list": true, "main": true, "nav": true, "note": true, "ol": true,
+	"p": true, "pre": true, "section": true, "table": true, "tbody": true,
+	"td": true, "text": true, "tfoot": true, "th": true, "thead": true,
+	"title": true, "tr": true, "ul": true,
+}
+
+// Elements separating text without separating blocks
+var xmlBreaks = map[string]bool{
+	"br": true, "lb": true, "cell": true, "row": true,
+}
+
+// Elements with content, that is no text
+var xmlRawText = map[string]bool{
+	"script": true, "style": true,
+}
+
+// Filter passing only the character data of XML
+// or HTML documents
+type xmlFilter struct {
+	state     int
+	markup    []rune
+	start     int
+	quote     rune
+	depth     int
+	raw       string
+	ent       entityFilter
+	pending   []spanChar
+	sentences bool
+	boundary  func(int)
+}
+
+// A filter, that forces sentence boundaries
+// at positions in the original input
+type boundaryFilter interface {
+	Filter
+	setBoundary(func(int))
+}
+
+// NewXMLFilter creates a filter passing only the character data
+// of XML or HTML documents, with character references decoded.
+// Markup, comments, processing instructions and the content of
+// script and style elements are removed.
+// Block-level elements (e.g. <p>, <div> or <head>) are replaced
+// by a newline, and, if sentences is true, force sentence
+// boundaries.
+func NewXMLFilter(sentences bool) Filter {
+	return &xmlFilter{sentences: sentences}
+}
+
+// Set the function receiving forced sentence boundaries
+func (xf *xmlFilter) setBoundary(boundary func(int)) {
+	xf.boundary = boundary
+}
+
+// Check if a character may start an element name
+func isNameStart(char rune) bool {
+	return char == '_' || char == ':' || unicode.IsLetter(char)
+}
+
+// Get the lowercased local name of a tag and
+// check if it is an end tag or an empty element
+func tagName(tag []rune) (string, bool, bool) {
+	end := len(tag) > 1 && tag[1] == '/'
+	empty := len(tag) > 2 && tag[len(tag)-2] == '/'
+	i := 1
+	if end {
+		i++
+	}
+	j := i
+	for j < len(tag) && !unicode.IsSpace(tag[j]) && tag[j] != '/' && tag[j] != '>' {
+		j++
+	}
+	name := strings.ToLower(string(tag[i:j]))
+	if k := strings.LastIndexByte(name, ':'); k >= 0 {
+		name = name[k+1:]
+	}
+	return name, end, empty
+}
+
+// Handle a complete tag
+func (xf *xmlFilter) tag(end int, emit Emit) {
+	name, isEnd, isEmpty := tagName(xf.markup)
+	xf.state = xmlText
+
+	if xmlBlocks[name] {
+		if xf.sentences && xf.boundary != nil {
+			xf.boundary(xf.start)
+		}
+		emit('\n', xf.start, end)
+	} else if xmlBreaks[name] {
+		emit('\n', xf.start, end)
+	} else if xmlRawText[name] && !isEnd && !isEmpty {
+		xf.state = xmlRaw
+		xf.raw = "</" + name
+		xf.markup = xf.markup[:0]
+	}
+}
+
+// Pass the pending characters of a CDATA section
+func (xf *xmlFilter) flushPending(emit Emit) {
+	for _, c := range xf.pending {
+		emit(c.char, c.start, c.end)
+	}
+	xf.pending = xf.pending[:0]
+}
+
+// Char implements the Filter interface.
+func (xf *xmlFilter) Char(char rune, start, end int, emit Emit) {
+	switch xf.state {
+
+	case xmlText:
+		if char == '<' {
+			xf.ent.Flush(emit)
+			xf.state = xmlMarkup
+			xf.start = start
+			xf.markup = append(xf.markup[:0], char)
+			return
+		}
+		xf.ent.Char(char, start, end, emit)
+
+	case xmlMarkup:
+		xf.markup = append(xf.markup, char)
+		m := string(xf.markup)
+
+		if len(xf.markup) == 2 {
+			if char == '/' || isNameStart(char) {
+				xf.state = xmlTag
+				return
+			} else if char == '?' {
+				xf.state = xmlPI
+				return
+			} else if char != '!' {
+
+				// No markup, e.g. in "a < b"
+				xf.state = xmlText
+				xf.ent.Char('<', xf.start, xf.start+1, emit)
+				xf.Char(char, start, end, emit)
+				return
+			}
+		}
+
+		if m == "<!--" {
+			xf.state = xmlComment
+		} else if m == "<![CDATA[" {
+			xf.state = xmlCDATA
+		} else if !strings.HasPrefix("<!--", m) && !strings.HasPrefix("<![CDATA[", m) {
+			xf.state = xmlDecl
+			xf.depth = 0
+			xf.markup = xf.markup[:len(xf.markup)-1]
+			xf.Char(char, start, end, emit)
+		}
+
+	case xmlTag:
+		xf.markup = append(xf.markup, char)
+		if xf.quote != 0 {
+			if char == xf.quote {
+				xf.quote = 0
+			}
+		} else if char == '"' || char == '\'' {
+			xf.quote = char
+		} else if char == '>' {
+			xf.tag(end, emit)
+		}
+
+	case xmlComment:
+		xf.markup = append(xf.markup, char)
+		if char == '>' && len(xf.markup) >= 7 && string(xf.markup[len(xf.markup)-3:]) == "-->" {
+			xf.state = xmlText
+		}
+
+	case xmlPI:
+		xf.markup = append(xf.markup, char)
+		if char == '>' && xf.markup[len(xf.markup)-2] == '?' {
+			xf.state = xmlText
+		}
+
+	case xmlDecl:
+		xf.markup = append(xf.markup, char)
+		if char == '[' {
+			xf.depth++
+		} else if char == ']' {
+			xf.depth--
+		} else if char == '>' && xf.depth <= 0 {
+			xf.state = xmlText
+		}
+
+	case xmlCDATA:
+
+		// Hold back brackets, that may end the section
+		if char == '>' && len(xf.pending) == 2 {
+			xf.pending = xf.pending[:0]
+			xf.state = xmlText
+			return
+		}
+		if char == ']' {
+			if len(xf.pending) == 2 {
+				emit(xf.pending[0].char, xf.pending[0].start, xf.pending[0].end)
+				xf.pending = xf.pending[:copy(xf.pending, xf.pending[1:])]
+			}
+			xf.pending = append(xf.pending, spanChar{char, start, end})
+			return
+		}
+		xf.flushPending(emit)
+		emit(char, start, end)
+
+	case xmlRaw:
+		xf.markup = append(xf.markup, unicode.ToLower(char))
+		if len(xf.markup) > len(xf.raw) {
+			xf.markup = xf.markup[:copy(xf.markup, xf.markup[1:])]
+		}
+		if string(xf.markup) == xf.raw {
+			xf.state = xmlTag
+		}
+	}
+}
+
+// Flush implements the Filter interface.
+// Incomplete markup at the end of the input is removed.
+func (xf *xmlFilter) Flush(emit Emit) {
+	if xf.state == xmlText {
+		xf.ent.Flush(emit)
+	} else if xf.state == xmlCDATA {
+		xf.flushPending(emit)
+	}
+	xf.state = xmlText
+	xf.quote = 0
+}
@@ -0,0 +1,71 @@
+package datok
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestXMLFilter(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("Der alte Mann", tfilter("Der <b>alte</b> Mann", NewXMLFilter(false)))
+	assert.Equal("\nDer & Mann\n", tfilter(`<p class="x>y">Der &amp; Mann</p>`, NewXMLFilter(false)))
+	assert.Equal("AB", tfilter("A<!-- <p>C</p> -->B", NewXMLFilter(false)))
+	assert.Equal("\nText\n", tfilter(`<?xml version="1.0"?><!DOCTYPE x [<!ENTITY y "z">]><text>Text</text>`, NewXMLFilter(false)))
+	assert.Equal("a <b> &amp;]] c", tfilter("a <![CDATA[<b> &amp;]]]]> c", NewXMLFilter(false)))
+	assert.Equal("a < b", tfilter("a < b", NewXMLFilter(false)))
+	assert.Equal("Titel A", tfilter("Titel<script>if (a<b) { x = '</p>' }</SCRIPT> A", NewXMLFilter(false)))
+	assert.Equal("A\nB", tfilter("A<br/>B", NewXMLFilter(false)))
+	assert.Equal("\nA", tfilter("<tei:p>A</tei:", NewXMLFilter(false)))
+}
+
+func TestXMLTokenization(t *testing.T) {
+	assert := assert.New(t)
+
+	mat := LoadMatrixFile("testdata/tokenizer_de.matok")
+	dat := LoadDatokFile("testdata/tokenizer_de.datok")
+	assert.NotNil(mat)
+	assert.NotNil(dat)
+
+	str := "<TEI><head>Der alte Mann</head><p>Er ging <hi rend=\"b\">über</hi> die Stra&szlig;e.</p></TEI>"
+
+	b := make([]byte, 0, 2048)
+	w := bytes.NewBuffer(b)
+
+	for _, tok := range []Tokenizer{mat, dat} {
+
+		// Offsets refer to the original input
+		w.Reset()
+		pp := NewPreprocessor(NewXMLFilter(false))
+		assert.True(pp.TransduceTokenWriter(tok, strings.NewReader(str), NewTokenWriter(w, TOKENS|SENTENCES|TOKEN_POS)))
+		assert.Equal(
+			"Der\nalte\nMann\nEr\nging\nüber\ndie\nStra&szlig;e\n.\n\n"+
+				"11 14 15 19 20 24 34 36 37 41 55 59 65 68 69 81 81 82\n",
+			w.String(),
+		)
+
+		// Sentence boundaries at block-level elements
+		w.Reset()
+		pp = NewPreprocessor(NewXMLFilter(true))
+		assert.True(pp.TransduceTokenWriter(tok, strings.NewReader(str), NewTokenWriter(w, TOKENS|SENTENCES|SENTENCE_POS)))
+		assert.Equal(
+			"Der\nalte\nMann\n\nEr\nging\nüber\ndie\nStra&szlig;e\n.\n\n"+
+				"11 24 34 82\n",
+			w.String(),
+		)
+
+		// No duplicate sentence boundaries
+		w.Reset()
+		assert.True(pp.TransduceTokenWriter(tok, strings.NewReader("<p>Ende.</p><p>Anfang</p>"), NewTokenWriter(w, SIMPLE)))
+		assert.Equal("Ende\n.\n\nAnfang\n\n\n", w.String())
+
+		// The original input can be reconstructed in lossless mode
+		for _, str := range []string{str, "<p>\x04<p><"} {
+			rec, _ := tlosslessEvents(&ppTokenizer{pp, tok}, str)
+			assert.Equal(str, rec)
+		}
+	}
+}